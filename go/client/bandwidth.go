@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BandwidthLimiter is a token bucket which limits the rate at which response
+// bodies are read by the LogClients sharing it. Tokens are bytes; the bucket
+// holds at most one second's worth.
+type BandwidthLimiter struct {
+	rate float64 // bytes per second
+
+	mu     sync.Mutex
+	tokens float64 // may be negative while readers are waiting
+	last   time.Time
+}
+
+// Returns a BandwidthLimiter which allows |bytesPerSecond| bytes to be read
+// per second, on average.
+func NewBandwidthLimiter(bytesPerSecond int64) *BandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		bytesPerSecond = 1
+	}
+	return &BandwidthLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// Returns the largest number of bytes which should be read at once.
+func (b *BandwidthLimiter) burst() int {
+	return int(b.rate)
+}
+
+// Takes |n| tokens from the bucket, waiting until they've been earned.
+// Returns ctx.Err() if |ctx| is done before then.
+func (b *BandwidthLimiter) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	// Go into debt, so that concurrent readers queue up behind this one.
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limitedReader is an io.Reader which throttles reads from |r| using a
+// BandwidthLimiter. Time spent waiting for the limiter doesn't count towards
+// |idle|, which only runs while reading from |r|.
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *BandwidthLimiter
+	idle    *idleTimeout
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if burst := l.limiter.burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	l.idle.restart()
+	n, err := l.r.Read(p)
+	l.idle.pause()
+	if n > 0 {
+		if werr := l.limiter.wait(l.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// idleTimeout abandons a request, by calling |cancel|, if it's left running
+// for |timeout| without being restarted or paused.
+type idleTimeout struct {
+	timeout time.Duration
+	timer   *time.Timer
+	fired   int32
+}
+
+// Returns a running idleTimeout.
+func newIdleTimeout(timeout time.Duration, cancel context.CancelFunc) *idleTimeout {
+	t := &idleTimeout{timeout: timeout}
+	t.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&t.fired, 1)
+		cancel()
+	})
+	return t
+}
+
+// Starts the timeout again from now.
+func (t *idleTimeout) restart() {
+	t.timer.Reset(t.timeout)
+}
+
+// Stops the timeout until it's restarted.
+func (t *idleTimeout) pause() {
+	t.timer.Stop()
+}
+
+// Returns true if the timeout has expired, cancelling the request.
+func (t *idleTimeout) expired() bool {
+	return atomic.LoadInt32(&t.fired) != 0
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBandwidthLimiterThrottlesResponses(t *testing.T) {
+	const rate = 200 * 1024
+	padding := strings.Repeat("A", 300*1024)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"tree_size": %d, "timestamp": %d, "sha256_root_hash": "%s", "tree_head_signature": "%s", "padding": "%s"}`,
+			ValidSTHResponse_TreeSize, int64(ValidSTHResponse_Timestamp), ValidSTHResponse_SHA256RootHash,
+			ValidSTHResponse_TreeHeadSignature, padding)
+	}))
+	defer ts.Close()
+
+	// Two clients sharing the limiter transfer 600KiB between them; the
+	// first 200KiB are allowed immediately, and the rest take two seconds.
+	limiter := NewBandwidthLimiter(rate)
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		c := NewWithOptions(ts.URL, LogClientOptions{Bandwidth: limiter})
+		if _, err := c.GetSTH(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	elapsed := time.Since(start)
+	if elapsed < 1800*time.Millisecond || elapsed > 4*time.Second {
+		t.Fatalf("Expected transfer to take about 2s, took %s", elapsed)
+	}
+}
+
+func TestBandwidthLimiterWaitRespectsContext(t *testing.T) {
+	limiter := NewBandwidthLimiter(1024)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.wait(ctx, 10*1024); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestNewLeavesBandwidthUnlimited(t *testing.T) {
+	if c := New("https://ct.example.com"); c.bandwidth != nil {
+		t.Fatal("Expected no bandwidth limit by default")
+	}
+}
+
+// Sets requestTimeout and readIdleTimeout to |timeout|, returning a func
+// which restores them.
+func setTimeouts(timeout time.Duration) func() {
+	request, idle := requestTimeout, readIdleTimeout
+	requestTimeout, readIdleTimeout = timeout, timeout
+	return func() {
+		requestTimeout, readIdleTimeout = request, idle
+	}
+}
+
+func TestBandwidthLimitedResponseOutlastsRequestTimeout(t *testing.T) {
+	defer setTimeouts(200 * time.Millisecond)()
+	padding := strings.Repeat("A", 300*1024)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"tree_size": %d, "timestamp": %d, "sha256_root_hash": "%s", "tree_head_signature": "%s", "padding": "%s"}`,
+			ValidSTHResponse_TreeSize, int64(ValidSTHResponse_Timestamp), ValidSTHResponse_SHA256RootHash,
+			ValidSTHResponse_TreeHeadSignature, padding)
+	}))
+	defer ts.Close()
+
+	// Reading the response takes about 2s, far longer than requestTimeout,
+	// but data keeps arriving, so the request shouldn't be abandoned.
+	c := NewWithOptions(ts.URL, LogClientOptions{Bandwidth: NewBandwidthLimiter(100 * 1024)})
+	if _, err := c.GetSTH(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBandwidthLimitedRequestAbandonedWhenIdle(t *testing.T) {
+	defer setTimeouts(200 * time.Millisecond)()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"tree_size": `))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer ts.Close()
+
+	c := NewWithOptions(ts.URL, LogClientOptions{Bandwidth: NewBandwidthLimiter(100 * 1024)})
+	start := time.Now()
+	_, err := c.GetSTH()
+	if err == nil || !strings.Contains(err.Error(), "no data received") {
+		t.Fatalf("Expected the stalled request to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Expected the stalled request to be abandoned after about 200ms, took %s", elapsed)
+	}
+}
@@ -4,16 +4,19 @@
 package client
 
 import (
+	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
-
-	"github.com/mreiferson/go-httpclient"
 )
 
 // URI paths for CT Log endpoints
@@ -26,10 +29,83 @@ const (
 	IssuerKeyHashLength = 32
 )
 
+// Timeouts for requests to the log. With a BandwidthLimiter, reading a large
+// response may legitimately take longer than requestTimeout, so a request is
+// instead abandoned if no data arrives for readIdleTimeout.
+var (
+	requestTimeout  = 30 * time.Second
+	readIdleTimeout = 30 * time.Second
+)
+
+// Default limit on the size of a response body read from a log.
+const DefaultMaxResponseBytes = 64 * 1024 * 1024
+
 // LogClient represents a client for a given CT Log instance
 type LogClient struct {
 	uri        string       // the base URI of the log. e.g. http://ct.googleapis/pilot
 	httpClient *http.Client // used to interact with the log via HTTP
+	publicKey  []byte       // DER encoded SubjectPublicKeyInfo of the log, if known
+
+	maxResponseBytes int64             // the largest response body which will be read from the log
+	bandwidth        *BandwidthLimiter // limits the rate at which response bodies are read, if non-nil
+}
+
+// ResponseTooLargeError is returned when a log's response body exceeds the
+// client's maximum response size.
+type ResponseTooLargeError struct {
+	URI   string // the URI which was requested
+	Limit int64  // the maximum response size, in bytes
+}
+
+func (e ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response from %s exceeds maximum size of %d bytes", e.URI, e.Limit)
+}
+
+// TooManyEntriesError is returned when a log responds to get-entries with
+// more entries than were requested. Such a log is misbehaving: the entries
+// can't be trusted to have the indices they'd appear to have, so retrying the
+// request won't help.
+type TooManyEntriesError struct {
+	Start, End int64 // the range which was requested
+	Returned   int   // the number of entries the log returned
+}
+
+func (e TooManyEntriesError) Error() string {
+	return fmt.Sprintf("log returned %d entries for range [%d, %d]", e.Returned, e.Start, e.End)
+}
+
+// HTTPError is returned when a log responds with an HTTP status other than
+// 200 OK.
+type HTTPError struct {
+	URI        string // the URI which was requested
+	StatusCode int    // the HTTP status code of the response
+	Body       string // the body of the response
+	// How long the log asked the client to wait before retrying, from the
+	// response's Retry-After header; zero if there was none.
+	RetryAfter time.Duration
+}
+
+// Returns the delay requested by the Retry-After header value |value|, which
+// is either a number of seconds or an HTTP date, relative to |now|. Returns
+// zero if |value| is empty, malformed or in the past.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+func (e HTTPError) Error() string {
+	return fmt.Sprintf("%s returned HTTP status %d: %q", e.URI, e.StatusCode, e.Body)
 }
 
 //////////////////////////////////////////////////////////////////////////////////
@@ -59,6 +135,7 @@ type getSTHResponse struct {
 // base64LeafEntry respresents a Base64 encoded leaf entry
 type base64LeafEntry struct {
 	LeafInput string `json:"leaf_input"`
+	ExtraData string `json:"extra_data"`
 }
 
 // getEntriesReponse respresents the JSON response to the CT get-entries method
@@ -89,36 +166,132 @@ type getEntryAndProofResponse struct {
 	AuditPath []string `json:"audit_path"` // the corresponding proof
 }
 
+// LogClientOptions holds configuration options for a LogClient
+type LogClientOptions struct {
+	// Force HTTP/1.1 when talking to the log. Some logs (or the CDNs in front
+	// of them) return corrupt or stalled responses over HTTP/2.
+	DisableHTTP2 bool
+
+	// DER encoded SubjectPublicKeyInfo of the log's signing key, used to
+	// verify the log's signatures. May be nil if the key isn't known.
+	PublicKey []byte
+
+	// Maximum size, in bytes, of a response body read from the log. Larger
+	// responses cause a ResponseTooLargeError. Defaults to
+	// DefaultMaxResponseBytes if <= 0.
+	MaxResponseBytes int64
+
+	// Limits the rate at which response bodies are read from the log. A
+	// single BandwidthLimiter may be shared between LogClients to cap their
+	// combined bandwidth. If nil, reads aren't limited.
+	Bandwidth *BandwidthLimiter
+}
+
 // Constructs a new LogClient instance.
 // |uri| is the base URI of the CT log instance to interact with, e.g.
 // http://ct.googleapis.com/pilot
 func New(uri string) *LogClient {
+	return NewWithOptions(uri, LogClientOptions{})
+}
+
+// Constructs a new LogClient instance for the log at |uri|, taking
+// configuration options from |opts|.
+func NewWithOptions(uri string, opts LogClientOptions) *LogClient {
 	var c LogClient
 	c.uri = uri
+	c.publicKey = opts.PublicKey
+	c.maxResponseBytes = opts.MaxResponseBytes
+	c.bandwidth = opts.Bandwidth
+	if c.maxResponseBytes <= 0 {
+		c.maxResponseBytes = DefaultMaxResponseBytes
+	}
 	// TODO(alcutter): make these timeouts modifiable
-	transport := &httpclient.Transport{
-		ConnectTimeout:        10 * time.Second,
-		RequestTimeout:        30 * time.Second,
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		// DialContext, unlike Dial, abandons the dial if the request's
+		// context is cancelled.
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+		}).DialContext,
 		ResponseHeaderTimeout: 30 * time.Second,
 		MaxIdleConnsPerHost:   10,
 		DisableKeepAlives:     false,
 	}
+	if opts.DisableHTTP2 {
+		// A non-nil, empty TLSNextProto map stops the transport from
+		// negotiating h2 via ALPN.
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
 	c.httpClient = &http.Client{Transport: transport}
+	if c.bandwidth == nil {
+		// A whole-request timeout would also cover the time spent waiting
+		// for the BandwidthLimiter, so fetchAndParse enforces
+		// readIdleTimeout instead when there is one.
+		c.httpClient.Timeout = requestTimeout
+	}
 	return &c
 }
 
+// Returns the DER encoded SubjectPublicKeyInfo of the log's signing key, or
+// nil if it wasn't provided when the client was created.
+func (c *LogClient) PublicKey() []byte {
+	return c.publicKey
+}
+
 // Makes a HTTP call to |uri|, and attempts to parse the response as a JSON
 // representation of the structure in |res|.
-// Returns a non-nil |error| if there was a problem.
-func (c *LogClient) fetchAndParse(uri string, res interface{}) error {
+// The request is abandoned if |ctx| is cancelled or its deadline passes.
+// Returns a non-nil |error| if there was a problem; if |ctx| is done, the
+// error is ctx.Err(), if the response was too large it's a
+// ResponseTooLargeError, and if the log returned an HTTP error it's an
+// HTTPError.
+func (c *LogClient) fetchAndParse(ctx context.Context, uri string, res interface{}) error {
 	req, _ := http.NewRequest("GET", uri, nil)
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
+	reqCtx := ctx
+	var idle *idleTimeout
+	if c.bandwidth != nil {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		idle = newIdleTimeout(readIdleTimeout, cancel)
+		defer idle.pause()
+	}
+	// Returns the error to report for |err|, a failure of the request.
+	requestErr := func(err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if idle != nil && idle.expired() {
+			return fmt.Errorf("no data received from %s for %s", uri, readIdleTimeout)
+		}
 		return err
 	}
-	body, err := ioutil.ReadAll(resp.Body)
+	resp, err := c.httpClient.Do(req.WithContext(reqCtx))
 	if err != nil {
-		return err
+		return requestErr(err)
+	}
+	defer resp.Body.Close()
+	// Read at most one byte more than the limit, so we can tell if it was
+	// exceeded.
+	var r io.Reader = resp.Body
+	if c.bandwidth != nil {
+		idle.pause()
+		r = &limitedReader{ctx: ctx, r: r, limiter: c.bandwidth, idle: idle}
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r, c.maxResponseBytes+1))
+	if err != nil {
+		return requestErr(err)
+	}
+	if int64(len(body)) > c.maxResponseBytes {
+		return ResponseTooLargeError{URI: uri, Limit: c.maxResponseBytes}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return HTTPError{
+			URI:        uri,
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
 	}
 	if err = json.Unmarshal(body, &res); err != nil {
 		return err
@@ -129,8 +302,15 @@ func (c *LogClient) fetchAndParse(uri string, res interface{}) error {
 // Retrieves the current STH from the log.
 // Returns a populated SignedTreeHead, or a non-nil error.
 func (c *LogClient) GetSTH() (sth *SignedTreeHead, err error) {
+	return c.GetSTHCtx(context.Background())
+}
+
+// Retrieves the current STH from the log, abandoning the request if |ctx| is
+// cancelled or its deadline passes.
+// Returns a populated SignedTreeHead, or a non-nil error.
+func (c *LogClient) GetSTHCtx(ctx context.Context) (sth *SignedTreeHead, err error) {
 	var resp getSTHResponse
-	if err = c.fetchAndParse(c.uri+GetSTHPath, &resp); err != nil {
+	if err = c.fetchAndParse(ctx, c.uri+GetSTHPath, &resp); err != nil {
 		return
 	}
 	sth = &SignedTreeHead{
@@ -150,10 +330,50 @@ func (c *LogClient) GetSTH() (sth *SignedTreeHead, err error) {
 	return
 }
 
+// Checks that the timestamp of |sth| is plausible: an STH dated more than
+// |maxSkew| in the future, or more than |maxAge| in the past, indicates a
+// misbehaving log.
+// Returns a non-nil error describing the problem if the STH fails either check.
+func CheckSTHFreshness(sth *SignedTreeHead, maxSkew, maxAge time.Duration) error {
+	now := time.Now()
+	ts := time.Unix(0, int64(sth.Timestamp)*int64(time.Millisecond))
+	if ts.After(now.Add(maxSkew)) {
+		return fmt.Errorf("STH timestamp %s is %s in the future (max skew %s)", ts, ts.Sub(now), maxSkew)
+	}
+	if ts.Before(now.Add(-maxAge)) {
+		return fmt.Errorf("STH timestamp %s is %s old (max age %s)", ts, now.Sub(ts), maxAge)
+	}
+	return nil
+}
+
 // Attempts to retrieve the entries in the sequence [|start|, |end|] from the CT
 // log server. (see section 4.6.)
 // Returns a slice of LeafInputs or a non-nil error.
 func (c *LogClient) GetEntries(start, end int64) ([]LeafInput, error) {
+	return c.GetEntriesCtx(context.Background(), start, end)
+}
+
+// Attempts to retrieve the entries in the sequence [|start|, |end|] from the CT
+// log server, abandoning the request if |ctx| is cancelled or its deadline
+// passes.
+// Returns a slice of LeafInputs or a non-nil error, which is a
+// TooManyEntriesError if the log returned more entries than were requested.
+func (c *LogClient) GetEntriesCtx(ctx context.Context, start, end int64) ([]LeafInput, error) {
+	raw, err := c.GetRawEntriesCtx(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]LeafInput, len(raw))
+	for i, e := range raw {
+		entries[i] = e.LeafInput
+	}
+	return entries, nil
+}
+
+// Attempts to retrieve the entries in the sequence [|start|, |end|] from the CT
+// log server along with their extra_data, as GetEntriesCtx.
+// Returns a slice of RawLogEntrys or a non-nil error.
+func (c *LogClient) GetRawEntriesCtx(ctx context.Context, start, end int64) ([]RawLogEntry, error) {
 	if end < 0 {
 		return nil, errors.New("end should be >= 0")
 	}
@@ -161,16 +381,66 @@ func (c *LogClient) GetEntries(start, end int64) ([]LeafInput, error) {
 		return nil, errors.New("start should be <= end")
 	}
 	var resp getEntriesResponse
-	err := c.fetchAndParse(fmt.Sprintf("%s%s?start=%d&end=%d", c.uri, GetEntriesPath, start, end), &resp)
+	err := c.fetchAndParse(ctx, fmt.Sprintf("%s%s?start=%d&end=%d", c.uri, GetEntriesPath, start, end), &resp)
 	if err != nil {
 		return nil, err
 	}
-	entries := make([]LeafInput, end-start+1, end-start+1)
+	if int64(len(resp.Entries)) > end-start+1 {
+		return nil, TooManyEntriesError{Start: start, End: end, Returned: len(resp.Entries)}
+	}
+	// Logs MAY return fewer entries than requested, so only the entries
+	// actually returned are included.
+	entries := make([]RawLogEntry, len(resp.Entries))
 	for index, entry := range resp.Entries {
-		entries[index], err = base64.StdEncoding.DecodeString(entry.LeafInput)
+		if entries[index].LeafInput, err = base64.StdEncoding.DecodeString(entry.LeafInput); err != nil {
+			return nil, err
+		}
+		if entries[index].ExtraData, err = base64.StdEncoding.DecodeString(entry.ExtraData); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Retrieves all of the entries in the sequence [|start|, |end|] from the CT
+// log server, splitting the range into requests for at most |maxBatch|
+// entries, and re-requesting the remainder of any batch for which the log
+// returns fewer entries than requested.
+// Returns a slice of LeafInputs, in order, or a non-nil error.
+func (c *LogClient) GetEntriesBatched(start, end int64, maxBatch int) ([]LeafInput, error) {
+	return c.GetEntriesBatchedCtx(context.Background(), start, end, maxBatch)
+}
+
+// As GetEntriesBatched, but abandons the requests if |ctx| is cancelled or its
+// deadline passes.
+func (c *LogClient) GetEntriesBatchedCtx(ctx context.Context, start, end int64, maxBatch int) ([]LeafInput, error) {
+	if maxBatch <= 0 {
+		return nil, errors.New("maxBatch should be > 0")
+	}
+	if end < start {
+		return nil, errors.New("start should be <= end")
+	}
+	// Don't preallocate more than a batch, as |end| may be far beyond what
+	// the log will actually return.
+	capacity := end - start + 1
+	if capacity > int64(maxBatch) {
+		capacity = int64(maxBatch)
+	}
+	entries := make([]LeafInput, 0, capacity)
+	for next := start; next <= end; {
+		batchEnd := next + int64(maxBatch) - 1
+		if batchEnd > end {
+			batchEnd = end
+		}
+		batch, err := c.GetEntriesCtx(ctx, next, batchEnd)
 		if err != nil {
 			return nil, err
 		}
+		if len(batch) == 0 {
+			return nil, fmt.Errorf("log returned no entries for range [%d, %d]", next, batchEnd)
+		}
+		entries = append(entries, batch...)
+		next += int64(len(batch))
 	}
 	return entries, nil
 }
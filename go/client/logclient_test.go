@@ -1,12 +1,16 @@
 package client
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -52,6 +56,107 @@ func TestGetEntriesWorks(t *testing.T) {
 	}
 }
 
+// Returns a test log server with |size| entries, which returns at most
+// |maxBatch| entries per get-entries request. Each entry's leaf_input is the
+// base64 encoding of its decimal index.
+func newBatchCappingLogServer(t *testing.T, size, maxBatch int64, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		start, err := strconv.ParseInt(r.URL.Query().Get("start"), 10, 64)
+		if err != nil {
+			// t.Fatal mustn't be called from the handler's goroutine.
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		end, err := strconv.ParseInt(r.URL.Query().Get("end"), 10, 64)
+		if err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if end >= size {
+			end = size - 1
+		}
+		if end-start+1 > maxBatch {
+			end = start + maxBatch - 1
+		}
+		var entries []string
+		for i := start; i <= end; i++ {
+			leaf := base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(i, 10)))
+			entries = append(entries, fmt.Sprintf(`{"leaf_input": "%s", "extra_data": ""}`, leaf))
+		}
+		fmt.Fprintf(w, `{"entries":[%s]}`, strings.Join(entries, ","))
+	}))
+}
+
+func TestGetEntriesBatched(t *testing.T) {
+	var requests int
+	// The log only returns 3 entries per request, fewer than the batch size.
+	ts := newBatchCappingLogServer(t, 100, 3, &requests)
+	defer ts.Close()
+
+	leaves, err := New(ts.URL).GetEntriesBatched(10, 29, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaves) != 20 {
+		t.Fatalf("Expected 20 leaves, got %d", len(leaves))
+	}
+	for i, leaf := range leaves {
+		if string(leaf) != strconv.Itoa(10+i) {
+			t.Fatalf("Expected leaf %d at position %d, got %q", 10+i, i, leaf)
+		}
+	}
+	// Each request is for at most 5 entries, but only yields 3.
+	if requests != 7 {
+		t.Fatalf("Expected 7 requests, got %d", requests)
+	}
+}
+
+func TestGetEntriesBatchedFailsOnEmptyResponse(t *testing.T) {
+	var requests int
+	ts := newBatchCappingLogServer(t, 10, 5, &requests)
+	defer ts.Close()
+
+	if _, err := New(ts.URL).GetEntriesBatched(5, 15, 5); err == nil {
+		t.Fatal("Expected error fetching beyond the end of the log")
+	}
+	if _, err := New(ts.URL).GetEntriesBatched(0, 5, 0); err == nil {
+		t.Fatal("Expected error for zero maxBatch")
+	}
+}
+
+func TestGetEntriesRejectsTooManyEntries(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"entries":[{"leaf_input": "%s", "extra_data": ""}, {"leaf_input": "%s", "extra_data": ""}]}`, PrecertEntryB64, CertEntryB64)
+	}))
+	defer ts.Close()
+
+	_, err := New(ts.URL).GetEntries(0, 0)
+	tooMany, ok := err.(TooManyEntriesError)
+	if !ok {
+		t.Fatalf("Expected TooManyEntriesError when log returns more entries than requested, got %v", err)
+	}
+	if tooMany.Start != 0 || tooMany.End != 0 || tooMany.Returned != 2 {
+		t.Fatalf("Unexpected error contents: %+v", tooMany)
+	}
+}
+
+func TestGetEntriesReturnsShortResponse(t *testing.T) {
+	var requests int
+	ts := newBatchCappingLogServer(t, 10, 2, &requests)
+	defer ts.Close()
+
+	leaves, err := New(ts.URL).GetEntries(0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaves) != 2 {
+		t.Fatalf("Expected only the 2 returned leaves, got %d", len(leaves))
+	}
+}
+
 func TestGetSTHWorks(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/ct/v1/get-sth" {
@@ -89,3 +194,169 @@ func TestGetSTHWorks(t *testing.T) {
 		t.Fatal("Invalid TreeHeadSignature")
 	}
 }
+
+func TestNewWithOptionsDisableHTTP2(t *testing.T) {
+	c := NewWithOptions("https://ct.example.com", LogClientOptions{DisableHTTP2: true})
+	transport, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("Expected an *http.Transport, got %T", c.httpClient.Transport)
+	}
+	if transport.TLSNextProto == nil {
+		t.Fatal("TLSNextProto is nil, transport will negotiate h2")
+	}
+	if len(transport.TLSNextProto) != 0 {
+		t.Fatalf("Expected no TLSNextProto handlers, got %d", len(transport.TLSNextProto))
+	}
+}
+
+func TestNewLeavesHTTP2Enabled(t *testing.T) {
+	c := New("https://ct.example.com")
+	transport, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("Expected an *http.Transport, got %T", c.httpClient.Transport)
+	}
+	if transport.TLSNextProto != nil {
+		t.Fatal("TLSNextProto unexpectedly set")
+	}
+	if transport.DialContext == nil || transport.Dial != nil {
+		t.Fatal("Expected the transport to dial with DialContext, so dials can be cancelled")
+	}
+}
+
+// Returns an STH with a timestamp |offset| from now.
+func sthAt(offset time.Duration) *SignedTreeHead {
+	return &SignedTreeHead{Timestamp: uint64(time.Now().Add(offset).UnixNano() / int64(time.Millisecond))}
+}
+
+func TestCheckSTHFreshnessAcceptsFreshSTH(t *testing.T) {
+	if err := CheckSTHFreshness(sthAt(-time.Minute), time.Minute, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSTHFreshness(sthAt(30*time.Second), time.Minute, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckSTHFreshnessRejectsFutureSTH(t *testing.T) {
+	if err := CheckSTHFreshness(sthAt(time.Hour), time.Minute, 24*time.Hour); err == nil {
+		t.Fatal("Expected error for future STH")
+	}
+}
+
+func TestCheckSTHFreshnessRejectsStaleSTH(t *testing.T) {
+	if err := CheckSTHFreshness(sthAt(-25*time.Hour), time.Minute, 24*time.Hour); err == nil {
+		t.Fatal("Expected error for stale STH")
+	}
+}
+
+func TestGetEntriesCtxCancelledMidRequest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+
+	client := New(ts.URL)
+	if _, err := client.GetEntriesCtx(ctx, 0, 1); err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestGetSTHCtxDeadlineExceeded(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+
+	client := New(ts.URL)
+	if _, err := client.GetSTHCtx(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestGetEntriesRejectsOverLargeResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"entries":[{"leaf_input": "%s", "extra_data": "%s"}]}`, CertEntryB64, strings.Repeat("A", 4096))
+	}))
+	defer ts.Close()
+
+	client := NewWithOptions(ts.URL, LogClientOptions{MaxResponseBytes: 1024})
+	_, err := client.GetEntries(0, 0)
+	tooLarge, ok := err.(ResponseTooLargeError)
+	if !ok {
+		t.Fatalf("Expected ResponseTooLargeError, got %v", err)
+	}
+	if tooLarge.Limit != 1024 {
+		t.Fatalf("Expected limit of 1024, got %d", tooLarge.Limit)
+	}
+
+	client = NewWithOptions(ts.URL, LogClientOptions{MaxResponseBytes: 1024 * 1024})
+	if _, err := client.GetEntries(0, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewUsesDefaultMaxResponseBytes(t *testing.T) {
+	if c := New("https://ct.example.com"); c.maxResponseBytes != DefaultMaxResponseBytes {
+		t.Fatalf("Expected default limit %d, got %d", DefaultMaxResponseBytes, c.maxResponseBytes)
+	}
+}
+
+func TestGetSTHReturnsHTTPError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	}))
+	defer ts.Close()
+
+	_, err := New(ts.URL).GetSTH()
+	httpErr, ok := err.(HTTPError)
+	if !ok {
+		t.Fatalf("Expected HTTPError, got %v", err)
+	}
+	if httpErr.StatusCode != http.StatusGone || httpErr.Body != "gone\n" {
+		t.Fatalf("Unexpected HTTPError %v", httpErr)
+	}
+}
+
+func TestHTTPErrorRetryAfter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	_, err := New(ts.URL).GetSTH()
+	httpErr, ok := err.(HTTPError)
+	if !ok {
+		t.Fatalf("Expected HTTPError, got %v", err)
+	}
+	if httpErr.RetryAfter != 7*time.Second {
+		t.Fatalf("Expected RetryAfter of 7s, got %s", httpErr.RetryAfter)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2016, time.June, 1, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-1", 0},
+		{"soon", 0},
+		{"Wed, 01 Jun 2016 12:00:30 GMT", 30 * time.Second},
+		{"Wed, 01 Jun 2016 11:00:00 GMT", 0},
+	} {
+		if got := parseRetryAfter(test.value, now); got != test.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+}
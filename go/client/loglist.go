@@ -0,0 +1,135 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// LogState represents the state of a log in the CT log list.
+// See https://www.gstatic.com/ct/log_list/v3/log_list_schema.json
+type LogState string
+
+const (
+	LogStatePending   LogState = "pending"
+	LogStateQualified LogState = "qualified"
+	LogStateUsable    LogState = "usable"
+	LogStateReadOnly  LogState = "readonly"
+	LogStateRetired   LogState = "retired"
+	LogStateRejected  LogState = "rejected"
+)
+
+// LogListEntry describes a single log found in the CT log list, along with a
+// LogClient which can be used to talk to it.
+type LogListEntry struct {
+	Operator    string     // Name of the organisation operating the log
+	Description string     // Human readable description of the log
+	LogID       []byte     // SHA-256 hash of the log's public key
+	Key         []byte     // DER encoded SubjectPublicKeyInfo of the log
+	URL         string     // Base URI of the log, without a trailing slash
+	MMD         int        // Maximum merge delay, in seconds
+	State       LogState   // Current state of the log
+	Client      *LogClient // Client for talking to the log
+}
+
+// InvalidLogsError is returned by ParseLogList when some of the logs in the
+// list couldn't be used, e.g. because a log's key doesn't match its log ID.
+// The remaining logs are still returned.
+type InvalidLogsError struct {
+	Errors []error // one error for each log which was skipped
+}
+
+func (e InvalidLogsError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("skipped %d invalid log(s) in log list: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// logListJSON mirrors the v2/v3 log list JSON schema. Only the fields needed
+// to talk to the logs are represented.
+type logListJSON struct {
+	Operators []struct {
+		Name string `json:"name"`
+		Logs []struct {
+			Description string                     `json:"description"`
+			LogID       string                     `json:"log_id"`
+			Key         string                     `json:"key"`
+			URL         string                     `json:"url"`
+			MMD         int                        `json:"mmd"`
+			State       map[string]json.RawMessage `json:"state"`
+		} `json:"logs"`
+	} `json:"operators"`
+}
+
+// Parses the CT log list JSON (v2 or v3 schema) in |data|, and returns an
+// entry, complete with a LogClient, for each log in one of the given |states|.
+// If no |states| are given, logs in every state are returned.
+// Returns a non-nil error if the log list couldn't be parsed. Logs with an
+// invalid key or log ID are skipped, and reported in an InvalidLogsError which
+// is returned along with the remaining entries.
+func ParseLogList(data []byte, states ...LogState) ([]LogListEntry, error) {
+	return ParseLogListWithOptions(data, LogClientOptions{}, states...)
+}
+
+// Parses the CT log list JSON in |data| as ParseLogList, but creates each
+// entry's LogClient with the options |opts|, with PublicKey set to the log's
+// key. A BandwidthLimiter in |opts| is shared by all of the LogClients.
+func ParseLogListWithOptions(data []byte, opts LogClientOptions, states ...LogState) ([]LogListEntry, error) {
+	var list logListJSON
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	wanted := make(map[LogState]bool)
+	for _, s := range states {
+		wanted[s] = true
+	}
+	var entries []LogListEntry
+	var invalid []error
+	for _, op := range list.Operators {
+		for _, l := range op.Logs {
+			var state LogState
+			for k := range l.State {
+				state = LogState(k)
+			}
+			if len(wanted) > 0 && !wanted[state] {
+				continue
+			}
+			key, err := base64.StdEncoding.DecodeString(l.Key)
+			if err != nil {
+				invalid = append(invalid, fmt.Errorf("invalid base64 encoding in key for log %q: %s", l.Description, err))
+				continue
+			}
+			logID, err := base64.StdEncoding.DecodeString(l.LogID)
+			if err != nil {
+				invalid = append(invalid, fmt.Errorf("invalid base64 encoding in log_id for log %q: %s", l.Description, err))
+				continue
+			}
+			if h := sha256.Sum256(key); !bytes.Equal(h[:], logID) {
+				invalid = append(invalid, fmt.Errorf("log_id doesn't match key for log %q", l.Description))
+				continue
+			}
+			uri := strings.TrimSuffix(l.URL, "/")
+			logOpts := opts
+			logOpts.PublicKey = key
+			entries = append(entries, LogListEntry{
+				Operator:    op.Name,
+				Description: l.Description,
+				LogID:       logID,
+				Key:         key,
+				URL:         uri,
+				MMD:         l.MMD,
+				State:       state,
+				Client:      NewWithOptions(uri, logOpts),
+			})
+		}
+	}
+	if len(invalid) > 0 {
+		return entries, InvalidLogsError{invalid}
+	}
+	return entries, nil
+}
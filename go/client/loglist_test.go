@@ -0,0 +1,124 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"testing"
+)
+
+// Returns a small v3 log list containing one usable log (with key |usableKey|)
+// and one retired log (with key |retiredKey|).
+func makeLogList(usableKey, retiredKey []byte) []byte {
+	usableID := sha256.Sum256(usableKey)
+	retiredID := sha256.Sum256(retiredKey)
+	return []byte(fmt.Sprintf(`{
+  "version": "3.0",
+  "operators": [{
+    "name": "Example Operator",
+    "email": ["ct@example.com"],
+    "logs": [{
+      "description": "Example 'Usable' log",
+      "log_id": "%s",
+      "key": "%s",
+      "url": "https://ct.example.com/usable/",
+      "mmd": 86400,
+      "state": {"usable": {"timestamp": "2020-01-01T00:00:00Z"}}
+    }, {
+      "description": "Example 'Retired' log",
+      "log_id": "%s",
+      "key": "%s",
+      "url": "https://ct.example.com/retired/",
+      "mmd": 86400,
+      "state": {"retired": {"timestamp": "2021-01-01T00:00:00Z"}}
+    }]
+  }]
+}`, base64.StdEncoding.EncodeToString(usableID[:]), base64.StdEncoding.EncodeToString(usableKey),
+		base64.StdEncoding.EncodeToString(retiredID[:]), base64.StdEncoding.EncodeToString(retiredKey)))
+}
+
+func TestParseLogListBuildsClients(t *testing.T) {
+	usableKey := []byte("usable log key")
+	retiredKey := []byte("retired log key")
+	logs, err := ParseLogList(makeLogList(usableKey, retiredKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 {
+		t.Fatalf("Expected 2 logs, got %d", len(logs))
+	}
+	l := logs[0]
+	if l.Operator != "Example Operator" {
+		t.Fatalf("Incorrect operator %q", l.Operator)
+	}
+	if l.URL != "https://ct.example.com/usable" {
+		t.Fatalf("Incorrect URL %q", l.URL)
+	}
+	if l.State != LogStateUsable {
+		t.Fatalf("Incorrect state %q", l.State)
+	}
+	if l.MMD != 86400 {
+		t.Fatalf("Incorrect MMD %d", l.MMD)
+	}
+	if l.Client == nil {
+		t.Fatal("No LogClient created")
+	}
+	if l.Client.uri != l.URL {
+		t.Fatalf("LogClient has URI %q, expected %q", l.Client.uri, l.URL)
+	}
+	if !bytes.Equal(l.Client.PublicKey(), usableKey) {
+		t.Fatal("LogClient has incorrect public key")
+	}
+	if logs[1].State != LogStateRetired {
+		t.Fatalf("Incorrect state %q", logs[1].State)
+	}
+}
+
+func TestParseLogListWithOptionsSharesBandwidthLimiter(t *testing.T) {
+	usableKey := []byte("usable log key")
+	retiredKey := []byte("retired log key")
+	limiter := NewBandwidthLimiter(1024)
+	logs, err := ParseLogListWithOptions(makeLogList(usableKey, retiredKey), LogClientOptions{Bandwidth: limiter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 {
+		t.Fatalf("Expected 2 logs, got %d", len(logs))
+	}
+	for i, key := range [][]byte{usableKey, retiredKey} {
+		if logs[i].Client.bandwidth != limiter {
+			t.Fatalf("Log %d's client doesn't use the shared BandwidthLimiter", i)
+		}
+		if !bytes.Equal(logs[i].Client.PublicKey(), key) {
+			t.Fatalf("Log %d's client has key %q, expected %q", i, logs[i].Client.PublicKey(), key)
+		}
+	}
+}
+
+func TestParseLogListFiltersByState(t *testing.T) {
+	logs, err := ParseLogList(makeLogList([]byte("usable log key"), []byte("retired log key")), LogStateUsable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("Expected 1 log, got %d", len(logs))
+	}
+	if logs[0].State != LogStateUsable {
+		t.Fatalf("Incorrect state %q", logs[0].State)
+	}
+}
+
+func TestParseLogListSkipsMismatchedLogID(t *testing.T) {
+	data := bytes.Replace(makeLogList([]byte("usable log key"), []byte("retired log key")),
+		[]byte(base64.StdEncoding.EncodeToString([]byte("usable log key"))),
+		[]byte(base64.StdEncoding.EncodeToString([]byte("another key"))), 1)
+	logs, err := ParseLogList(data)
+	invalid, ok := err.(InvalidLogsError)
+	if !ok || len(invalid.Errors) != 1 {
+		t.Fatalf("Expected an InvalidLogsError for the log_id not matching key, got %v", err)
+	}
+	if len(logs) != 1 || logs[0].State != LogStateRetired {
+		t.Fatalf("Expected only the retired log to be returned, got %+v", logs)
+	}
+}
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -9,9 +11,13 @@ import (
 
 // Variable size structure prefix-header byte lengths
 const (
-	CertificateLengthBytes    = 3
-	PreCertificateLengthBytes = 3
-	ExtensionsLengthBytes     = 2
+	CertificateLengthBytes      = 3
+	CertificateChainLengthBytes = 3
+	PreCertificateLengthBytes   = 3
+	ExtensionsLengthBytes       = 2
+	SCTListLengthBytes          = 2
+	SerializedSCTLengthBytes    = 2
+	SignatureLengthBytes        = 2
 )
 
 // Reads a variable length array of bytes from |r|. |numLenBytes| specifies the
@@ -101,3 +107,123 @@ func ReadMerkleTreeLeaf(r io.Reader) (*MerkleTreeLeaf, error) {
 	}
 	return &m, nil
 }
+
+// Parses the byte-stream representation of a SignedCertificateTimestampList
+// (as found in the SCT list X.509v3 extension) from |data|, and returns a
+// slice containing each of the serialized SCTs in the list.
+// See RFC section 3.3 for details on the format.
+// Returns a non-nil error if there was a problem.
+func ParseSCTList(data []byte) ([][]byte, error) {
+	list, err := readVarBytes(bytes.NewBuffer(data), SCTListLengthBytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(list)+SCTListLengthBytes != len(data) {
+		return nil, errors.New("trailing data after SCT list")
+	}
+	var scts [][]byte
+	r := bytes.NewBuffer(list)
+	for r.Len() > 0 {
+		sct, err := readVarBytes(r, SerializedSCTLengthBytes)
+		if err != nil {
+			return nil, err
+		}
+		scts = append(scts, sct)
+	}
+	return scts, nil
+}
+
+// Parses the extra_data of a precert entry returned by get-entries, which holds
+// a PrecertChainEntry: the precertificate as submitted, followed by the chain
+// used to verify it, immediate signer first.
+// See RFC section 4.6 for details on the format.
+// Returns the DER encoded precertificate and chain certificates, or a non-nil
+// error if there was a problem.
+func ParsePrecertChainEntry(extraData []byte) ([]byte, [][]byte, error) {
+	r := bytes.NewBuffer(extraData)
+	preCert, err := readVarBytes(r, CertificateLengthBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, err := readCertificateChain(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.Len() > 0 {
+		return nil, nil, errors.New("trailing data after PrecertChainEntry")
+	}
+	return preCert, chain, nil
+}
+
+// Parses the extra_data of an X509 entry returned by get-entries, which holds
+// the chain used to verify the certificate, immediate issuer first.
+// See RFC section 4.6 for details on the format.
+// Returns the DER encoded chain certificates, or a non-nil error if there was
+// a problem.
+func ParseCertificateChain(extraData []byte) ([][]byte, error) {
+	r := bytes.NewBuffer(extraData)
+	chain, err := readCertificateChain(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() > 0 {
+		return nil, errors.New("trailing data after certificate chain")
+	}
+	return chain, nil
+}
+
+// Reads a length-prefixed list of length-prefixed DER certificates from |r|.
+func readCertificateChain(r io.Reader) ([][]byte, error) {
+	list, err := readVarBytes(r, CertificateChainLengthBytes)
+	if err != nil {
+		return nil, err
+	}
+	var chain [][]byte
+	for b := bytes.NewBuffer(list); b.Len() > 0; {
+		cert, err := readVarBytes(b, CertificateLengthBytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	return chain, nil
+}
+
+// Parses the byte-stream representation of a SignedCertificateTimestamp from
+// |r| and returns a pointer to a new SignedCertificateTimestamp structure
+// containing the parsed data. The Signature field holds the complete
+// DigitallySigned structure (hash and signature algorithms followed by the
+// signature).
+// See RFC section 3.2 for details on the format.
+// Returns a non-nil error if there was a problem.
+func ReadSignedCertificateTimestamp(r io.Reader) (*SignedCertificateTimestamp, error) {
+	var sct SignedCertificateTimestamp
+	if err := binary.Read(r, binary.BigEndian, &sct.SCTVersion); err != nil {
+		return nil, err
+	}
+	if sct.SCTVersion != V1 {
+		return nil, fmt.Errorf("unknown SCT Version %d", sct.SCTVersion)
+	}
+	var logID [sha256.Size]byte
+	if err := binary.Read(r, binary.BigEndian, &logID); err != nil {
+		return nil, err
+	}
+	sct.LogID = logID[:]
+	if err := binary.Read(r, binary.BigEndian, &sct.Timestamp); err != nil {
+		return nil, err
+	}
+	var err error
+	if sct.Extentions, err = readVarBytes(r, ExtensionsLengthBytes); err != nil {
+		return nil, err
+	}
+	var algs [2]byte
+	if err := binary.Read(r, binary.BigEndian, &algs); err != nil {
+		return nil, err
+	}
+	sig, err := readVarBytes(r, SignatureLengthBytes)
+	if err != nil {
+		return nil, err
+	}
+	sct.Signature = append(append(algs[:], byte(len(sig)>>8), byte(len(sig))), sig...)
+	return &sct, nil
+}
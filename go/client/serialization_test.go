@@ -100,3 +100,116 @@ func TestReadTimestampedEntryIntoChecksEntryType(t *testing.T) {
 		t.Fatal("Failed to check EntryType - accepted 0x4545")
 	}
 }
+
+func TestParseSCTList(t *testing.T) {
+	// Two SCTs, of lengths 1 and 2 bytes
+	list := []byte{0x00, 0x07, 0x00, 0x01, 0xaa, 0x00, 0x02, 0xbb, 0xcc}
+	scts, err := ParseSCTList(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scts) != 2 {
+		t.Fatalf("Expected 2 SCTs, got %d", len(scts))
+	}
+	if !bytes.Equal(scts[0], []byte{0xaa}) || !bytes.Equal(scts[1], []byte{0xbb, 0xcc}) {
+		t.Fatalf("Incorrect SCTs: %v", scts)
+	}
+}
+
+func TestParseSCTListEmpty(t *testing.T) {
+	scts, err := ParseSCTList([]byte{0x00, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scts) != 0 {
+		t.Fatalf("Expected no SCTs, got %d", len(scts))
+	}
+}
+
+func TestParseSCTListRejectsTruncatedList(t *testing.T) {
+	if _, err := ParseSCTList([]byte{0x00, 0x07, 0x00, 0x01, 0xaa}); err == nil {
+		t.Fatal("Expected error for truncated SCT list")
+	}
+	if _, err := ParseSCTList([]byte{0x00, 0x03, 0x00, 0x05, 0xaa}); err == nil {
+		t.Fatal("Expected error for truncated SCT")
+	}
+	if _, err := ParseSCTList([]byte{0x00, 0x00, 0xff}); err == nil {
+		t.Fatal("Expected error for trailing data")
+	}
+}
+
+func TestReadSignedCertificateTimestamp(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteByte(0)                                // Version
+	buf.Write(bytes.Repeat([]byte{0x42}, 32))       // LogID
+	buf.Write([]byte{0, 0, 1, 0x44, 0, 0, 0, 1})    // Timestamp
+	buf.Write([]byte{0, 0})                         // Extensions
+	buf.Write([]byte{4, 3, 0, 3, 0xaa, 0xbb, 0xcc}) // DigitallySigned
+
+	sct, err := ReadSignedCertificateTimestamp(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sct.SCTVersion != V1 {
+		t.Fatalf("Incorrect version %d", sct.SCTVersion)
+	}
+	if !bytes.Equal(sct.LogID, bytes.Repeat([]byte{0x42}, 32)) {
+		t.Fatalf("Incorrect LogID %x", sct.LogID)
+	}
+	if sct.Timestamp != 0x14400000001 {
+		t.Fatalf("Incorrect Timestamp %d", sct.Timestamp)
+	}
+	if len(sct.Extentions) != 0 {
+		t.Fatalf("Unexpected extensions %x", sct.Extentions)
+	}
+	if !bytes.Equal(sct.Signature, []byte{4, 3, 0, 3, 0xaa, 0xbb, 0xcc}) {
+		t.Fatalf("Incorrect Signature %x", sct.Signature)
+	}
+}
+
+func TestReadSignedCertificateTimestampChecksVersion(t *testing.T) {
+	if _, err := ReadSignedCertificateTimestamp(bytes.NewReader([]byte{1})); err == nil || !strings.Contains(err.Error(), "unknown SCT Version") {
+		t.Fatal("Failed to check Version - accepted 1")
+	}
+}
+
+func TestParsePrecertChainEntry(t *testing.T) {
+	extraData := []byte{
+		0x00, 0x00, 0x02, 0xaa, 0xbb, // pre_certificate
+		0x00, 0x00, 0x09, // precertificate_chain
+		0x00, 0x00, 0x01, 0xcc,
+		0x00, 0x00, 0x02, 0xdd, 0xee,
+	}
+	precert, chain, err := ParsePrecertChainEntry(extraData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(precert, []byte{0xaa, 0xbb}) {
+		t.Fatalf("Incorrect precert %x", precert)
+	}
+	if len(chain) != 2 || !bytes.Equal(chain[0], []byte{0xcc}) || !bytes.Equal(chain[1], []byte{0xdd, 0xee}) {
+		t.Fatalf("Incorrect chain %x", chain)
+	}
+}
+
+func TestParsePrecertChainEntryRejectsTrailingData(t *testing.T) {
+	if _, _, err := ParsePrecertChainEntry([]byte{0x00, 0x00, 0x01, 0xaa, 0x00, 0x00, 0x00, 0xff}); err == nil {
+		t.Fatal("Expected error for trailing data")
+	}
+	if _, _, err := ParsePrecertChainEntry([]byte{0x00, 0x00, 0x01, 0xaa, 0x00, 0x00, 0x04, 0x00, 0x00, 0x01}); err == nil {
+		t.Fatal("Expected error for truncated chain")
+	}
+}
+
+func TestParseCertificateChain(t *testing.T) {
+	chain, err := ParseCertificateChain([]byte{0x00, 0x00, 0x04, 0x00, 0x00, 0x01, 0xcc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 1 || !bytes.Equal(chain[0], []byte{0xcc}) {
+		t.Fatalf("Incorrect chain %x", chain)
+	}
+	if _, err := ParseCertificateChain([]byte{0x00, 0x00, 0x00, 0xff}); err == nil {
+		t.Fatal("Expected error for trailing data")
+	}
+}
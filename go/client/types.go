@@ -69,6 +69,13 @@ type AuditPath []MerkleTreeNode
 // LeafInput represents a serialized MerkleTreeLeaf structure
 type LeafInput []byte
 
+// RawLogEntry represents an entry returned by the get-entries CT method after
+// base64 decoding (see section 4.6)
+type RawLogEntry struct {
+	LeafInput LeafInput // The serialized MerkleTreeLeaf
+	ExtraData []byte    // The chain submitted with the entry, see ParsePrecertChainEntry
+}
+
 // SignedTreeHead represents the structure returned by the get-sth CT method after
 // base64 decoding. See sections 3.5 and 4.3 in the RFC)
 type SignedTreeHead struct {
@@ -116,6 +123,12 @@ type Precertificate struct {
 	// Parsed TBSCertificate structure (held in an x509.Certificate for ease of
 	// access.
 	TBSCertificate x509.Certificate
+	// The chain submitted with the precert, immediate signer first, if it was
+	// available (see ParsePrecertChainEntry); nil otherwise.
+	IssuerChain []*x509.Certificate
+	// The DER of the precertificate as submitted with the chain, with its
+	// poison extension and signature, if it was available; nil otherwise.
+	SubmittedRaw []byte
 }
 
 // Returns the X.509 Certificate contained within the MerkleTreeLeaf.
@@ -123,3 +136,16 @@ type Precertificate struct {
 func (m *MerkleTreeLeaf) X509Certificate() (*x509.Certificate, error) {
 	return x509.ParseCertificate(m.TimestampedEntry.X509Entry)
 }
+
+// Returns a synthesized x509.Certificate view of the Precertificate, so that
+// logic written against x509.Certificate can be applied to Precertificates.
+// The returned Certificate is a shallow copy of TBSCertificate: its slices,
+// maps and pointers (e.g. Extensions, DNSNames and PublicKey) are shared with
+// the Precertificate, and must not be modified. As a Precertificate carries no
+// signature, its Raw and Signature fields are empty.
+func (p *Precertificate) AsCertificate() *x509.Certificate {
+	c := p.TBSCertificate
+	c.Raw = nil
+	c.Signature = nil
+	return &c
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/base64"
 	"strings"
 	"testing"
+
+	"github.com/google/certificate-transparency/go/x509"
 )
 
 const (
@@ -121,3 +123,47 @@ func TestReadMerkleTreeLeafChecksLeafType(t *testing.T) {
 		t.Fatal("Failed to check LeafType - accepted 0x1234")
 	}
 }
+
+func TestPrecertificateAsCertificate(t *testing.T) {
+	entry, err := base64.StdEncoding.DecodeString(PrecertEntryB64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadMerkleTreeLeaf(bytes.NewReader(entry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbs, err := x509.ParseTBSCertificate(m.TimestampedEntry.PrecertEntry.TBSCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Precertificate{
+		Raw:            tbs.RawTBSCertificate,
+		TBSCertificate: *tbs,
+		IssuerKeyHash:  m.TimestampedEntry.PrecertEntry.IssuerKeyHash,
+	}
+
+	c := p.AsCertificate()
+	if c.Subject.CommonName != "*.kitasato-u.ac.jp" {
+		t.Fatalf("Incorrect Subject CommonName %q", c.Subject.CommonName)
+	}
+	if c.Issuer.CommonName != "DigiCert High Assurance CA-3" {
+		t.Fatalf("Incorrect Issuer CommonName %q", c.Issuer.CommonName)
+	}
+	if len(c.DNSNames) != 2 || c.DNSNames[0] != "*.kitasato-u.ac.jp" || c.DNSNames[1] != "kitasato-u.ac.jp" {
+		t.Fatalf("Incorrect DNSNames %v", c.DNSNames)
+	}
+	if !bytes.Equal(c.RawTBSCertificate, p.Raw) {
+		t.Fatal("Incorrect RawTBSCertificate")
+	}
+	if c.SerialNumber.Cmp(tbs.SerialNumber) != 0 {
+		t.Fatal("Incorrect SerialNumber")
+	}
+	if c.Raw != nil || c.Signature != nil {
+		t.Fatal("Synthesized Certificate unexpectedly has a signature")
+	}
+	c.Subject.CommonName = "changed"
+	if p.TBSCertificate.Subject.CommonName == "changed" {
+		t.Fatal("AsCertificate didn't return a copy")
+	}
+}
@@ -0,0 +1,89 @@
+package scanner
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"sync"
+)
+
+// ArchiveSink is a Sink which streams matched entries into a single gzipped
+// archive, rather than writing one file per certificate. Each record holds
+// the entry's log index, its type, the DER of the certificate (or, for
+// Precertificates, the issuer key hash and DER of the TBSCertificate), and its
+// labels.
+// Use ArchiveReader to read the entries back.
+type ArchiveSink struct {
+	mu sync.Mutex
+	gz *gzip.Writer
+	// Closed along with the archive, if set
+	closer io.Closer
+}
+
+// Creates a new ArchiveSink which writes the archive to |w|.
+// Closing the ArchiveSink completes the archive, but doesn't close |w|.
+func NewArchiveSink(w io.Writer) *ArchiveSink {
+	return &ArchiveSink{gz: gzip.NewWriter(w)}
+}
+
+// Creates a new ArchiveSink which writes the archive to a new file at |path|,
+// which is closed when the ArchiveSink is.
+func CreateArchiveSink(path string) (*ArchiveSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	a := NewArchiveSink(f)
+	a.closer = f
+	return a, nil
+}
+
+// Appends |e| to the archive.
+func (a *ArchiveSink) Put(e *MatchedEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return writeSpilledEntry(a.gz, e)
+}
+
+// Completes the archive, and closes its file if it was created by
+// CreateArchiveSink.
+func (a *ArchiveSink) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.gz.Close()
+	if a.closer != nil {
+		if closeErr := a.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// ArchiveReader iterates over the entries in an archive written by
+// ArchiveSink.
+type ArchiveReader struct {
+	gz *gzip.Reader
+	r  *bufio.Reader
+}
+
+// Creates a new ArchiveReader reading the archive from |r|.
+func NewArchiveReader(r io.Reader) (*ArchiveReader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &ArchiveReader{gz: gz, r: bufio.NewReader(gz)}, nil
+}
+
+// Returns the next entry in the archive, with its certificate re-parsed.
+// Returns io.EOF once all entries have been read.
+func (a *ArchiveReader) Next() (*MatchedEntry, error) {
+	return readSpilledEntry(a.r)
+}
+
+// Releases the resources used by the ArchiveReader. It doesn't close the
+// underlying reader.
+func (a *ArchiveReader) Close() error {
+	return a.gz.Close()
+}
@@ -0,0 +1,100 @@
+package scanner
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/certificate-transparency/go/client"
+)
+
+func TestArchiveSinkRoundTrip(t *testing.T) {
+	ts := newFourEntryLogServer(t)
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:       MatchAny{[]LabelledMatcher{{"all", MatchAll{}}, {"none", MatchNone{}}, {"every", MatchAll{}}}},
+		BlockSize:     10,
+		NumWorkers:    2,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	if err := scanner.ScanSink(NewArchiveSink(&buf)); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewArchiveReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var indices int64Slice
+	precerts := 0
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		indices = append(indices, e.Index)
+		if len(e.Labels) != 2 || e.Labels[0] != "all" || e.Labels[1] != "every" {
+			t.Fatalf("Entry %d has labels %q, expected [all every]", e.Index, e.Labels)
+		}
+		switch {
+		case e.Cert != nil:
+			if len(e.Cert.Raw) == 0 || e.Cert.Subject.CommonName == "" {
+				t.Fatalf("Entry %d has an unparsed Cert", e.Index)
+			}
+		case e.Precert != nil:
+			precerts++
+			if e.Precert.TBSCertificate.Subject.CommonName == "" {
+				t.Fatalf("Entry %d has an unparsed Precert", e.Index)
+			}
+		default:
+			t.Fatalf("Entry %d has neither Cert nor Precert", e.Index)
+		}
+	}
+	sort.Sort(indices)
+	if len(indices) != 4 || indices[0] != 0 || indices[3] != 3 {
+		t.Fatalf("Expected archived entries 0-3, got %v", indices)
+	}
+	if precerts != int(scanner.Stats().PrecertsSeen) {
+		t.Fatalf("Expected %d precerts, got %d", scanner.Stats().PrecertsSeen, precerts)
+	}
+}
+
+func TestCreateArchiveSinkClosesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "matches.gz")
+
+	a, err := CreateArchiveSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewArchiveReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("Expected empty archive, got %v", err)
+	}
+}
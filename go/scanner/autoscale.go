@@ -0,0 +1,174 @@
+package scanner
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// Default time between adjustments of the matcher worker count when
+// ScannerOptions.AutoScaleWorkers is set.
+const defaultWorkerScaleInterval = time.Second
+
+// A worker added by the previous adjustment must raise throughput by at least
+// this fraction for the workerScaler to keep adding workers.
+const minScaleUpGain = 0.05
+
+// workerScaler adjusts the number of matcher workers during a scan, between
+// ScannerOptions.MinWorkers and ScannerOptions.MaxWorkers.
+//
+// The control loop runs every WorkerScaleInterval, sampling the number of
+// entries waiting in the jobs channel (the backlog) and the number of entries
+// processed since the previous sample (the throughput):
+//   - If the backlog is larger than the throughput, i.e. it would take more
+//     than an interval to clear, the matchers aren't keeping up with the
+//     fetchers, so a worker is added. However, if the previous sample also
+//     added a worker and throughput didn't rise by at least minScaleUpGain,
+//     matching is CPU-bound and more workers won't help, so the worker count
+//     is capped at its current value instead.
+//   - If the backlog was empty at both this and the previous sample, the
+//     fetchers are the bottleneck, so a worker is removed, and any cap is
+//     lifted as conditions have changed.
+//   - Otherwise the worker count is held.
+//
+// Workers are added and removed one at a time, so the count converges on the
+// point where the matchers just keep up with the fetchers.
+type workerScaler struct {
+	s     *Scanner
+	jobs  chan matcherJob
+	start func(id int)
+	// Receives one value per worker which should exit.
+	quit chan struct{}
+
+	workers       int
+	nextID        int
+	lastBacklog   int
+	lastProcessed int64
+	lastSample    time.Time
+	lastRate      float64
+	scaledUp      bool
+	// If > 0, the worker count at which adding workers stopped helping.
+	limit int
+}
+
+// Starts the initial MinWorkers matcher workers reading from |jobs|, and
+// returns a workerScaler which adjusts their number once run. |wg| counts the
+// workerScaler as well as the workers, so that workers are only ever added
+// while the count is non-zero.
+func (s *Scanner) startScaledWorkers(jobs chan matcherJob, foundCert func(int64, *x509.Certificate, []string), foundPrecert func(int64, *client.Precertificate, []string), wg *sync.WaitGroup) *workerScaler {
+	w := &workerScaler{
+		s:    s,
+		jobs: jobs,
+		quit: make(chan struct{}, s.maxWorkers()),
+	}
+	w.start = func(id int) {
+		wg.Add(1)
+		go s.matcherJob(id, jobs, w.quit, foundCert, foundPrecert, wg)
+	}
+	for w.workers < s.minWorkers() {
+		w.add()
+	}
+	w.lastProcessed = atomic.LoadInt64(&s.certsProcessed)
+	w.lastSample = time.Now()
+	wg.Add(1)
+	return w
+}
+
+func (s *Scanner) minWorkers() int {
+	if s.opts.MinWorkers <= 0 {
+		return 1
+	}
+	return s.opts.MinWorkers
+}
+
+func (s *Scanner) maxWorkers() int {
+	max := s.opts.MaxWorkers
+	if max <= 0 {
+		max = s.opts.NumWorkers
+	}
+	if max < s.minWorkers() {
+		return s.minWorkers()
+	}
+	return max
+}
+
+func (w *workerScaler) add() {
+	w.start(w.nextID)
+	w.nextID++
+	w.workers++
+	w.setWorkers()
+}
+
+func (w *workerScaler) remove() {
+	w.quit <- struct{}{}
+	w.workers--
+	w.setWorkers()
+}
+
+func (w *workerScaler) setWorkers() {
+	atomic.StoreInt64(&w.s.matcherWorkers, int64(w.workers))
+	w.s.opts.Metrics.Set(MetricMatcherWorkers, float64(w.workers))
+}
+
+// Runs the control loop until |stop| has been closed and the jobs channel
+// drained, then marks the workerScaler done in |wg|. |stop| should be closed
+// once the jobs channel is closed.
+func (w *workerScaler) run(stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	interval := w.s.opts.WorkerScaleInterval
+	if interval <= 0 {
+		interval = defaultWorkerScaleInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			// The remaining entries may still need more workers, so keep
+			// adjusting until they've all been taken.
+			stop = nil
+		case <-ticker.C:
+			w.adjust(time.Now())
+		}
+		if stop == nil && len(w.jobs) == 0 {
+			return
+		}
+	}
+}
+
+// Performs one iteration of the control loop, sampled at |now|; see
+// workerScaler.
+func (w *workerScaler) adjust(now time.Time) {
+	backlog := len(w.jobs)
+	processed := atomic.LoadInt64(&w.s.certsProcessed)
+	done := processed - w.lastProcessed
+	rate := float64(done) / now.Sub(w.lastSample).Seconds()
+	scaledUp := false
+	switch {
+	case int64(backlog) > done:
+		if w.workers >= w.s.maxWorkers() || (w.limit > 0 && w.workers >= w.limit) {
+			break
+		}
+		if w.scaledUp && rate < w.lastRate*(1+minScaleUpGain) {
+			w.limit = w.workers
+			w.s.Log(fmt.Sprintf("Holding at %d matcher workers: throughput %.0f/s didn't improve", w.workers, rate))
+			break
+		}
+		w.add()
+		scaledUp = true
+		w.s.Log(fmt.Sprintf("Backlog of %d entries, increased to %d matcher workers", backlog, w.workers))
+	case backlog == 0 && w.lastBacklog == 0 && w.workers > w.s.minWorkers():
+		w.remove()
+		w.limit = 0
+		w.s.Log(fmt.Sprintf("No backlog, decreased to %d matcher workers", w.workers))
+	}
+	w.scaledUp = scaledUp
+	w.lastBacklog = backlog
+	w.lastProcessed = processed
+	w.lastSample = now
+	w.lastRate = rate
+}
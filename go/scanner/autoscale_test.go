@@ -0,0 +1,85 @@
+package scanner
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// slowMatcher matches everything, taking |delay| over each entry.
+type slowMatcher struct {
+	delay time.Duration
+}
+
+func (m slowMatcher) CertificateMatches(*x509.Certificate) bool {
+	time.Sleep(m.delay)
+	return true
+}
+
+func (m slowMatcher) PrecertificateMatches(*client.Precertificate) bool {
+	time.Sleep(m.delay)
+	return true
+}
+
+func TestScannerAutoScaleWorkersGrowsWithBacklog(t *testing.T) {
+	source := newRepeatedMockLogSource(t, 100)
+	metrics := newStubRegistrar()
+	s := NewScanner(source, ScannerOptions{
+		Matcher:             slowMatcher{delay: 5 * time.Millisecond},
+		BlockSize:           100,
+		ParallelFetch:       2,
+		AutoScaleWorkers:    true,
+		MinWorkers:          1,
+		MaxWorkers:          4,
+		WorkerScaleInterval: 50 * time.Millisecond,
+		Quiet:               true,
+		Metrics:             metrics,
+	})
+	var found int64
+	err := s.Scan(func(int64, *x509.Certificate) {
+		atomic.AddInt64(&found, 1)
+	}, func(int64, *client.Precertificate) {
+		atomic.AddInt64(&found, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len(source.leaves)); found != want {
+		t.Errorf("Expected %d matches, got %d", want, found)
+	}
+	if got := metrics.values[MetricMatcherWorkers]; got != 4 {
+		t.Errorf("Expected the backlog to grow the matcher workers to the max of 4, peaked at %v", got)
+	}
+}
+
+func TestWorkerScalerShrinksWithoutBacklog(t *testing.T) {
+	s := NewScanner(nil, ScannerOptions{
+		AutoScaleWorkers: true,
+		MinWorkers:       1,
+		MaxWorkers:       4,
+		Quiet:            true,
+	})
+	w := &workerScaler{
+		s:     s,
+		jobs:  make(chan matcherJob, 1),
+		quit:  make(chan struct{}, 4),
+		start: func(int) {},
+	}
+	for i := 0; i < 3; i++ {
+		w.add()
+	}
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		now = now.Add(time.Second)
+		w.adjust(now)
+	}
+	if w.workers != 1 {
+		t.Errorf("Expected idle workers to be removed down to the min of 1, have %d", w.workers)
+	}
+	if len(w.quit) != 2 {
+		t.Errorf("Expected 2 workers to be told to quit, got %d", len(w.quit))
+	}
+}
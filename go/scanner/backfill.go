@@ -0,0 +1,102 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// Clients wishing to persist scan checkpoints somewhere other than a local
+// file should implement this interface:
+type CheckpointStore interface {
+	// Load returns the most recently saved ScanState, or nil if none has been
+	// saved yet.
+	Load() (*ScanState, error)
+
+	// Save persists |state|, replacing any previously saved ScanState.
+	// Calls are serialized.
+	Save(state ScanState) error
+}
+
+// FileCheckpointStore is a CheckpointStore which keeps the ScanState as JSON
+// in the file at |Path|. The file is replaced atomically on each Save, so an
+// interrupted Save leaves the previous checkpoint intact.
+type FileCheckpointStore struct {
+	Path string
+}
+
+// Reads the ScanState from the file, returning nil if it doesn't exist.
+func (f FileCheckpointStore) Load() (*ScanState, error) {
+	data, err := ioutil.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state ScanState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %s", f.Path, err.Error())
+	}
+	return &state, nil
+}
+
+// Writes |state| to a temporary file alongside the file, and renames it into
+// place.
+func (f FileCheckpointStore) Save(state ScanState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.Path), filepath.Base(f.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	// Make sure the data is on disk before it replaces the old checkpoint,
+	// so that a crash can't leave an empty or truncated checkpoint behind.
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
+
+// Scans the log from the checkpoint held in |store| (or from StartIndex, if
+// there's none) up to its current tip, and then tails it as ScanContinuous
+// does, polling every |pollInterval|, until |ctx| is done.
+//
+// Both phases save their progress to |store| as it's made, as they would to
+// ScannerOptions.Checkpoint, so that a later call resumes from where this one
+// stopped. Each new phase starts from the previous one's high-water mark, so
+// no entry is skipped at the handoff between them. Entries above a gap left
+// by a failed fetch are rescanned once the gap has been filled; set
+// DedupeWindow to suppress repeated matches.
+//
+// Returns an error if a checkpoint can't be loaded or saved, otherwise as
+// ScanContinuous.
+func (s *Scanner) BackfillAndTail(ctx context.Context, store CheckpointStore, pollInterval time.Duration, foundCert func(int64, *x509.Certificate), foundPrecert func(int64, *client.Precertificate)) error {
+	checkpoint := s.opts.Checkpoint
+	defer func() {
+		s.opts.Checkpoint = checkpoint
+	}()
+	s.opts.Checkpoint = store
+	s.checkpointLoaded = false
+	return s.ScanContinuous(ctx, pollInterval, foundCert, foundPrecert)
+}
@@ -0,0 +1,224 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// backfillLogSource is a mockLogSource which starts with two entries, grows
+// to four while the first of them is being fetched, and then cancels the scan
+// on the third poll for an STH.
+type backfillLogSource struct {
+	*mockLogSource
+	all    []client.LeafInput
+	polls  int
+	cancel context.CancelFunc
+}
+
+func newBackfillLogSource(t *testing.T, cancel context.CancelFunc) *backfillLogSource {
+	all := newMockLogSource(t).leaves
+	return &backfillLogSource{mockLogSource: &mockLogSource{leaves: all[:2]}, all: all, cancel: cancel}
+}
+
+func (b *backfillLogSource) GetSTHCtx(ctx context.Context) (*client.SignedTreeHead, error) {
+	b.polls++
+	if b.polls == 3 {
+		b.cancel()
+		return nil, ctx.Err()
+	}
+	return b.mockLogSource.GetSTHCtx(ctx)
+}
+
+func (b *backfillLogSource) GetEntriesCtx(ctx context.Context, start, end int64) ([]client.LeafInput, error) {
+	leaves, err := b.mockLogSource.GetEntriesCtx(ctx, start, end)
+	b.leaves = b.all
+	return leaves, err
+}
+
+// memoryCheckpointStore is a CheckpointStore which keeps every saved state.
+type memoryCheckpointStore struct {
+	initial *ScanState
+	saved   []ScanState
+	err     error
+}
+
+func (m *memoryCheckpointStore) Load() (*ScanState, error) {
+	return m.initial, nil
+}
+
+func (m *memoryCheckpointStore) Save(state ScanState) error {
+	m.saved = append(m.saved, state)
+	return m.err
+}
+
+func runBackfillAndTail(t *testing.T, store CheckpointStore) ([]int64, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewScanner(newBackfillLogSource(t, cancel), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     1,
+		NumWorkers:    2,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	var mu sync.Mutex
+	var indices []int64
+	record := func(index int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		indices = append(indices, index)
+	}
+	err := s.BackfillAndTail(ctx, store, time.Millisecond, func(index int64, c *x509.Certificate) {
+		record(index)
+	}, func(index int64, p *client.Precertificate) {
+		record(index)
+	})
+	sort.Sort(int64Slice(indices))
+	return indices, err
+}
+
+func TestBackfillAndTailCoversGrowingLog(t *testing.T) {
+	store := &memoryCheckpointStore{}
+	indices, err := runBackfillAndTail(t, store)
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if len(indices) != 4 {
+		t.Fatalf("Expected each of entries 0-3 exactly once, got %v", indices)
+	}
+	for i, index := range indices {
+		if index != int64(i) {
+			t.Fatalf("Expected each of entries 0-3 exactly once, got %v", indices)
+		}
+	}
+	var last int64
+	for _, state := range store.saved {
+		if state.HighWaterIndex < last {
+			t.Fatalf("Checkpoint went backwards from %d to %d", last, state.HighWaterIndex)
+		}
+		last = state.HighWaterIndex
+	}
+	if last != 4 {
+		t.Fatalf("Expected final checkpoint at index 4, got %d", last)
+	}
+}
+
+func TestBackfillAndTailResumesFromCheckpoint(t *testing.T) {
+	store := &memoryCheckpointStore{initial: &ScanState{HighWaterIndex: 1}}
+	indices, err := runBackfillAndTail(t, store)
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if len(indices) != 3 || indices[0] != 1 {
+		t.Fatalf("Expected entries 1-3, got %v", indices)
+	}
+}
+
+func TestBackfillAndTailStopsOnSaveError(t *testing.T) {
+	store := &memoryCheckpointStore{err: errors.New("disk full")}
+	if _, err := runBackfillAndTail(t, store); err == nil || err == context.Canceled {
+		t.Fatalf("Expected checkpoint error, got %v", err)
+	}
+}
+
+func TestScannerResumesFromCheckpoint(t *testing.T) {
+	store := &memoryCheckpointStore{initial: &ScanState{HighWaterIndex: 1}}
+	s := NewScanner(newMockLogSource(t), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     1,
+		NumWorkers:    2,
+		ParallelFetch: 2,
+		Quiet:         true,
+		Checkpoint:    store,
+	})
+	var mu sync.Mutex
+	var indices []int64
+	record := func(index int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		indices = append(indices, index)
+	}
+	err := s.Scan(func(index int64, c *x509.Certificate) {
+		record(index)
+	}, func(index int64, p *client.Precertificate) {
+		record(index)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Sort(int64Slice(indices))
+	if len(indices) != 3 || indices[0] != 1 {
+		t.Fatalf("Expected entries 1-3, got %v", indices)
+	}
+	// A snapshot is taken every BlockSize entries, and once at the end.
+	if len(store.saved) != 4 {
+		t.Fatalf("Expected 4 checkpoints, got %v", store.saved)
+	}
+	var last int64
+	for _, state := range store.saved {
+		if state.HighWaterIndex < last {
+			t.Fatalf("Checkpoint went backwards from %d to %d", last, state.HighWaterIndex)
+		}
+		last = state.HighWaterIndex
+	}
+	if last != 4 {
+		t.Fatalf("Expected final checkpoint at index 4, got %d", last)
+	}
+}
+
+func TestScannerStopsOnCheckpointSaveError(t *testing.T) {
+	store := &memoryCheckpointStore{err: errors.New("disk full")}
+	s := NewScanner(newMockLogSource(t), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     1,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+		Checkpoint:    store,
+	})
+	err := s.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {})
+	if err == nil || err == context.Canceled {
+		t.Fatalf("Expected checkpoint error, got %v", err)
+	}
+	if len(store.saved) != 1 {
+		t.Fatalf("Expected no further checkpoints after the first failed, got %v", store.saved)
+	}
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := FileCheckpointStore{Path: filepath.Join(dir, "state.json")}
+	if state, err := store.Load(); err != nil || state != nil {
+		t.Fatalf("Expected no checkpoint, got %v (%v)", state, err)
+	}
+	for _, index := range []int64{10, 20} {
+		if err := store.Save(ScanState{HighWaterIndex: index, STH: &client.SignedTreeHead{TreeSize: 30}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.HighWaterIndex != 20 || state.STH.TreeSize != 30 {
+		t.Fatalf("Unexpected checkpoint %+v", state)
+	}
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 1 {
+		t.Fatalf("Expected only the checkpoint file, got %v (%v)", files, err)
+	}
+}
@@ -0,0 +1,172 @@
+package scanner
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"sync"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// SerialCollision describes two entries, possibly in different logs, with the
+// same issuer and serial number but different TBSCertificates, as found by
+// MatchCertBySerialCollisionAcrossLogs.
+type SerialCollision struct {
+	// SHA-256 hash of the issuer's name and authority key identifier
+	IssuerHash   [sha256.Size]byte
+	SerialNumber *big.Int
+	// The log in which the issuer and serial number were first seen, and the
+	// hash of that TBSCertificate (without the poison or SCT list extension)
+	FirstLog     string
+	FirstTBSHash [sha256.Size]byte
+	// The log and TBSCertificate hash of the conflicting entry
+	Log     string
+	TBSHash [sha256.Size]byte
+}
+
+type serialCollisionKey struct {
+	issuer [sha256.Size]byte
+	serial string
+}
+
+type serialCollisionRecord struct {
+	log      string
+	tbsHash  [sha256.Size]byte
+	reported bool
+}
+
+// MatchCertBySerialCollisionAcrossLogs is a stateful Matcher which records the
+// TBSCertificate of each Certificate and Precertificate by issuer and serial
+// number, and matches entries whose TBSCertificate differs from that of an
+// earlier entry with the same issuer and serial number. Each such collision is
+// also reported, once per issuer and serial number, to |Callback|.
+//
+// The same certificate is commonly logged to several logs, and both as a
+// Precertificate and as a final Certificate, so TBSCertificates are compared
+// without their poison or SCT list extension (see PrecertMatchesFinal). To
+// scan several logs, pass an instance to ScanLogs, or share one between a
+// Scanner for each log, each given the Matcher returned by ForLog, so that
+// collisions name the logs involved.
+//
+// Memory bounds: a hash of each issuer and serial number is held for the
+// lifetime of the Matcher, up to |MaxEntries| (defaultMaxOrphanEntries if
+// <= 0); further serial numbers are ignored.
+//
+// Use NewMatchCertBySerialCollisionAcrossLogs to create instances of this
+// Matcher.
+type MatchCertBySerialCollisionAcrossLogs struct {
+	Callback   func(SerialCollision)
+	MaxEntries int
+
+	mu      sync.Mutex
+	serials map[serialCollisionKey]*serialCollisionRecord
+}
+
+// Creates a new MatchCertBySerialCollisionAcrossLogs which reports collisions
+// to |callback|, which may be nil.
+func NewMatchCertBySerialCollisionAcrossLogs(callback func(SerialCollision)) *MatchCertBySerialCollisionAcrossLogs {
+	return &MatchCertBySerialCollisionAcrossLogs{
+		Callback: callback,
+		serials:  make(map[serialCollisionKey]*serialCollisionRecord),
+	}
+}
+
+// Returns a Matcher which records entries in |m| as having been seen in the
+// log named |log|. Implements LogScopedMatcher.
+func (m *MatchCertBySerialCollisionAcrossLogs) ForLog(log string) Matcher {
+	return serialCollisionLogMatcher{m: m, log: log}
+}
+
+// Records |c|, returning true if it collides with an earlier entry.
+func (m *MatchCertBySerialCollisionAcrossLogs) CertificateMatches(c *x509.Certificate) bool {
+	return m.certMatches("", c)
+}
+
+// Records |p|, returning true if it collides with an earlier entry.
+func (m *MatchCertBySerialCollisionAcrossLogs) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.precertMatches("", p)
+}
+
+func (m *MatchCertBySerialCollisionAcrossLogs) certMatches(log string, c *x509.Certificate) bool {
+	tbs, err := tbsWithoutExtension(c.RawTBSCertificate, oidExtensionSCTList)
+	if err != nil {
+		return false
+	}
+	return m.record(log, c, sha256.Sum256(tbs))
+}
+
+func (m *MatchCertBySerialCollisionAcrossLogs) precertMatches(log string, p *client.Precertificate) bool {
+	rawTBS := p.TBSCertificate.RawTBSCertificate
+	if len(rawTBS) == 0 {
+		rawTBS = p.Raw
+	}
+	tbs, err := tbsWithoutExtension(rawTBS, oidExtensionCTPoison)
+	if err != nil {
+		return false
+	}
+	return m.record(log, &p.TBSCertificate, sha256.Sum256(tbs))
+}
+
+func (m *MatchCertBySerialCollisionAcrossLogs) maxEntries() int {
+	if m.MaxEntries <= 0 {
+		return defaultMaxOrphanEntries
+	}
+	return m.MaxEntries
+}
+
+// Records that the TBSCertificate of |c|, with hash |tbsHash|, was seen in
+// |log|, returning true if it differs from the first one seen with the same
+// issuer and serial number.
+func (m *MatchCertBySerialCollisionAcrossLogs) record(log string, c *x509.Certificate, tbsHash [sha256.Size]byte) bool {
+	if c.SerialNumber == nil {
+		return false
+	}
+	issuer := sha256.Sum256(append(append([]byte{}, c.RawIssuer...), c.AuthorityKeyId...))
+	key := serialCollisionKey{issuer: issuer, serial: string(c.SerialNumber.Bytes())}
+
+	m.mu.Lock()
+	r, ok := m.serials[key]
+	if !ok {
+		if len(m.serials) < m.maxEntries() {
+			m.serials[key] = &serialCollisionRecord{log: log, tbsHash: tbsHash}
+		}
+		m.mu.Unlock()
+		return false
+	}
+	if r.tbsHash == tbsHash {
+		m.mu.Unlock()
+		return false
+	}
+	report := !r.reported
+	r.reported = true
+	collision := SerialCollision{
+		IssuerHash:   issuer,
+		SerialNumber: c.SerialNumber,
+		FirstLog:     r.log,
+		FirstTBSHash: r.tbsHash,
+		Log:          log,
+		TBSHash:      tbsHash,
+	}
+	m.mu.Unlock()
+
+	if report && m.Callback != nil {
+		m.Callback(collision)
+	}
+	return true
+}
+
+// serialCollisionLogMatcher is the Matcher returned by
+// MatchCertBySerialCollisionAcrossLogs.ForLog.
+type serialCollisionLogMatcher struct {
+	m   *MatchCertBySerialCollisionAcrossLogs
+	log string
+}
+
+func (s serialCollisionLogMatcher) CertificateMatches(c *x509.Certificate) bool {
+	return s.m.certMatches(s.log, c)
+}
+
+func (s serialCollisionLogMatcher) PrecertificateMatches(p *client.Precertificate) bool {
+	return s.m.precertMatches(s.log, p)
+}
@@ -0,0 +1,136 @@
+package scanner
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+	"github.com/google/certificate-transparency/go/x509/pkix"
+)
+
+func TestMatchCertBySerialCollisionAcrossLogs(t *testing.T) {
+	ca, caKey := makeTestCA(t, "CA")
+	tmpl := newPrecertTemplate()
+	tmpl.SerialNumber = big.NewInt(1234)
+	cert := issueTestCert(t, tmpl, newPrecertKey(t), ca, caKey)
+	tmpl.Subject = pkix.Name{CommonName: "evil.example.com"}
+	tmpl.DNSNames = []string{"evil.example.com"}
+	conflicting := issueTestCert(t, tmpl, newPrecertKey(t), ca, caKey)
+	tmpl.SerialNumber = big.NewInt(5678)
+	other := issueTestCert(t, tmpl, newPrecertKey(t), ca, caKey)
+
+	logs := []struct {
+		name   string
+		source LogSource
+	}{
+		// The same certificate logged to both logs isn't a collision.
+		{"log-a", &chainLogSource{entries: []client.RawLogEntry{
+			{LeafInput: makeX509Leaf(cert.Raw)},
+			{LeafInput: makeX509Leaf(other.Raw)},
+		}}},
+		{"log-b", &chainLogSource{entries: []client.RawLogEntry{
+			{LeafInput: makeX509Leaf(cert.Raw)},
+			{LeafInput: makeX509Leaf(conflicting.Raw)},
+			{LeafInput: makeX509Leaf(conflicting.Raw)},
+		}}},
+	}
+
+	var mu sync.Mutex
+	var collisions []SerialCollision
+	m := NewMatchCertBySerialCollisionAcrossLogs(func(c SerialCollision) {
+		mu.Lock()
+		defer mu.Unlock()
+		collisions = append(collisions, c)
+	})
+	matches := make(map[string][]int64)
+	for _, log := range logs {
+		s := NewScanner(log.source, ScannerOptions{
+			Matcher:       m.ForLog(log.name),
+			BlockSize:     10,
+			NumWorkers:    1,
+			ParallelFetch: 1,
+			Quiet:         true,
+		})
+		err := s.Scan(func(index int64, c *x509.Certificate) {
+			matches[log.name] = append(matches[log.name], index)
+		}, func(int64, *client.Precertificate) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(matches["log-a"]) != 0 || len(matches["log-b"]) != 2 {
+		t.Fatalf("Expected only the conflicting entries in log-b to match, got %v", matches)
+	}
+	if len(collisions) != 1 {
+		t.Fatalf("Expected one collision to be reported, got %v", collisions)
+	}
+	c := collisions[0]
+	if c.FirstLog != "log-a" || c.Log != "log-b" || c.SerialNumber.Cmp(big.NewInt(1234)) != 0 || c.FirstTBSHash == c.TBSHash {
+		t.Fatalf("Unexpected collision: %+v", c)
+	}
+}
+
+func TestMatchCertBySerialCollisionAcrossLogsIgnoresPrecertAndFinal(t *testing.T) {
+	precert, final := makePrecertAndFinal(t, newPrecertKey(t), newPrecertTemplate())
+	m := NewMatchCertBySerialCollisionAcrossLogs(func(c SerialCollision) {
+		t.Errorf("Unexpected collision: %+v", c)
+	})
+	if m.ForLog("log-a").PrecertificateMatches(precert) || m.ForLog("log-b").CertificateMatches(final) {
+		t.Fatal("MatchCertBySerialCollisionAcrossLogs incorrectly matched a Precertificate and its final Certificate")
+	}
+}
+
+func TestMatchCertBySerialCollisionAcrossLogsBoundsMemory(t *testing.T) {
+	key := newPrecertKey(t)
+	m := NewMatchCertBySerialCollisionAcrossLogs(nil)
+	m.MaxEntries = 2
+	for i := int64(0); i < 4; i++ {
+		tmpl := newPrecertTemplate()
+		tmpl.SerialNumber = big.NewInt(i)
+		m.CertificateMatches(issueTestCert(t, tmpl, key, nil, nil))
+	}
+	if len(m.serials) > 2 {
+		t.Fatalf("Expected at most 2 tracked serial numbers, got %d", len(m.serials))
+	}
+}
+
+func TestScanLogsScopesMatcherToLog(t *testing.T) {
+	ts1 := newFourEntryLogServer(t)
+	defer ts1.Close()
+	ts2 := newFourEntryLogServer(t)
+	defer ts2.Close()
+
+	logs := []client.LogListEntry{
+		{Description: "Log One", URL: ts1.URL, Client: client.New(ts1.URL)},
+		{Description: "Log Two", URL: ts2.URL, Client: client.New(ts2.URL)},
+	}
+	m := NewMatchCertBySerialCollisionAcrossLogs(func(c SerialCollision) {
+		t.Errorf("Unexpected collision: %+v", c)
+	})
+	opts := ScannerOptions{
+		Matcher:       m,
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	}
+	err := ScanLogs(logs, opts, func(l *client.LogListEntry, index int64, c *x509.Certificate) {
+		t.Errorf("Unexpected match of entry %d in %s", index, l.Description)
+	}, func(l *client.LogListEntry, index int64, p *client.Precertificate) {
+		t.Errorf("Unexpected match of entry %d in %s", index, l.Description)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.serials) == 0 {
+		t.Fatal("Expected serial numbers to be recorded")
+	}
+	for _, r := range m.serials {
+		if r.log != ts1.URL {
+			t.Fatalf("Expected entries to be recorded as first seen in %s, got %s", ts1.URL, r.log)
+		}
+	}
+}
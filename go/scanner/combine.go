@@ -0,0 +1,176 @@
+package scanner
+
+import (
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// MatchAnd is a Matcher which matches Certificates and Precertificates which
+// match all of |Matchers|, which are tried in order until one doesn't match.
+// A MatchAnd with no Matchers matches everything.
+type MatchAnd struct {
+	Matchers []Matcher
+}
+
+// Returns true if all of the Matchers match |c|.
+func (m MatchAnd) CertificateMatches(c *x509.Certificate) bool {
+	for _, matcher := range m.Matchers {
+		if !matcher.CertificateMatches(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if all of the Matchers match |p|.
+func (m MatchAnd) PrecertificateMatches(p *client.Precertificate) bool {
+	for _, matcher := range m.Matchers {
+		if !matcher.PrecertificateMatches(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if all of the Matchers match |c|, submitted with |chain|.
+func (m MatchAnd) CertificateChainMatches(c *x509.Certificate, chain []*x509.Certificate) bool {
+	for _, matcher := range m.Matchers {
+		if !certificateChainMatches(matcher, c, chain) {
+			return false
+		}
+	}
+	return true
+}
+
+// ScanFinished passes the end of the scan on to each of the Matchers which is
+// a ScanFinisher.
+func (m MatchAnd) ScanFinished() {
+	finishScan(m.Matchers...)
+}
+
+// Returns a MatchAnd of the Matchers to use for the log |log|.
+func (m MatchAnd) ForLog(log string) Matcher {
+	return MatchAnd{matchersForLog(m.Matchers, log)}
+}
+
+// MatchOr is a Matcher which matches Certificates and Precertificates which
+// match any of |Matchers|, which are tried in order until one matches.
+// A MatchOr with no Matchers matches nothing. Use MatchAny instead to also
+// find out which of the Matchers matched.
+type MatchOr struct {
+	Matchers []Matcher
+}
+
+// Returns true if any of the Matchers match |c|.
+func (m MatchOr) CertificateMatches(c *x509.Certificate) bool {
+	for _, matcher := range m.Matchers {
+		if matcher.CertificateMatches(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if any of the Matchers match |p|.
+func (m MatchOr) PrecertificateMatches(p *client.Precertificate) bool {
+	for _, matcher := range m.Matchers {
+		if matcher.PrecertificateMatches(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if any of the Matchers match |c|, submitted with |chain|.
+func (m MatchOr) CertificateChainMatches(c *x509.Certificate, chain []*x509.Certificate) bool {
+	for _, matcher := range m.Matchers {
+		if certificateChainMatches(matcher, c, chain) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanFinished passes the end of the scan on to each of the Matchers which is
+// a ScanFinisher.
+func (m MatchOr) ScanFinished() {
+	finishScan(m.Matchers...)
+}
+
+// Returns a MatchOr of the Matchers to use for the log |log|.
+func (m MatchOr) ForLog(log string) Matcher {
+	return MatchOr{matchersForLog(m.Matchers, log)}
+}
+
+// MatchNot is a Matcher which matches Certificates and Precertificates which
+// |Matcher| doesn't match.
+type MatchNot struct {
+	Matcher Matcher
+}
+
+// Returns true if the Matcher doesn't match |c|.
+func (m MatchNot) CertificateMatches(c *x509.Certificate) bool {
+	return !m.Matcher.CertificateMatches(c)
+}
+
+// Returns true if the Matcher doesn't match |p|.
+func (m MatchNot) PrecertificateMatches(p *client.Precertificate) bool {
+	return !m.Matcher.PrecertificateMatches(p)
+}
+
+// Returns true if the Matcher doesn't match |c|, submitted with |chain|.
+func (m MatchNot) CertificateChainMatches(c *x509.Certificate, chain []*x509.Certificate) bool {
+	return !certificateChainMatches(m.Matcher, c, chain)
+}
+
+// ScanFinished passes the end of the scan on to the Matcher, if it's a
+// ScanFinisher.
+func (m MatchNot) ScanFinished() {
+	finishScan(m.Matcher)
+}
+
+// Returns a MatchNot of the Matcher to use for the log |log|.
+func (m MatchNot) ForLog(log string) Matcher {
+	return MatchNot{matcherForLog(m.Matcher, log)}
+}
+
+// Calls ScanFinished on each of |matchers| which is a ScanFinisher, so that
+// wrapping a stateful Matcher in a combinator doesn't stop it being told when
+// the scan has finished.
+func finishScan(matchers ...Matcher) {
+	for _, m := range matchers {
+		if f, ok := m.(ScanFinisher); ok {
+			f.ScanFinished()
+		}
+	}
+}
+
+// Returns whether |m| matches |c|, submitted with |chain|: by its
+// CertificateChainMatches if it's a ChainMatcher, or else its
+// CertificateMatches. This lets combinators pass the chain on to the
+// Matchers they wrap.
+func certificateChainMatches(m Matcher, c *x509.Certificate, chain []*x509.Certificate) bool {
+	if cm, ok := m.(ChainMatcher); ok {
+		return cm.CertificateChainMatches(c, chain)
+	}
+	return m.CertificateMatches(c)
+}
+
+// Returns the Matcher to use in place of |m| for the log |log|: the one
+// returned by its ForLog if it's a LogScopedMatcher, or else |m| itself.
+func matcherForLog(m Matcher, log string) Matcher {
+	if scoped, ok := m.(LogScopedMatcher); ok {
+		return scoped.ForLog(log)
+	}
+	return m
+}
+
+// Returns the Matchers to use in place of |matchers| for the log |log|, as
+// matcherForLog.
+func matchersForLog(matchers []Matcher, log string) []Matcher {
+	scoped := make([]Matcher, len(matchers))
+	for i, m := range matchers {
+		scoped[i] = matcherForLog(m, log)
+	}
+	return scoped
+}
@@ -0,0 +1,149 @@
+package scanner
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+	"github.com/google/certificate-transparency/go/x509/pkix"
+)
+
+// countingMatcher is a Matcher which matches as |match| says, and counts the
+// number of times it's called.
+type countingMatcher struct {
+	match bool
+	calls int
+}
+
+func (c *countingMatcher) CertificateMatches(*x509.Certificate) bool {
+	c.calls++
+	return c.match
+}
+
+func (c *countingMatcher) PrecertificateMatches(*client.Precertificate) bool {
+	c.calls++
+	return c.match
+}
+
+func TestMatchCombinators(t *testing.T) {
+	google := &MatchSubjectRegex{regexp.MustCompile(`\.google\.com$`), regexp.MustCompile(`\.google\.com$`)}
+	exampleCA := MatchIssuerRegex{IssuerRegex: regexp.MustCompile("^Example CA$")}
+	cert := x509.Certificate{
+		Subject:  pkix.Name{CommonName: "mail.google.com"},
+		Issuer:   pkix.Name{CommonName: "Example CA"},
+		DNSNames: []string{"mail.google.com"},
+	}
+	precert := client.Precertificate{TBSCertificate: cert}
+	for _, test := range []struct {
+		desc  string
+		m     Matcher
+		match bool
+	}{
+		{"And", MatchAnd{[]Matcher{google, exampleCA}}, true},
+		{"And with Not", MatchAnd{[]Matcher{google, MatchNot{exampleCA}}}, false},
+		{"empty And", MatchAnd{}, true},
+		{"Or", MatchOr{[]Matcher{MatchNone{}, exampleCA}}, true},
+		{"Or of Nots", MatchOr{[]Matcher{MatchNot{google}, MatchNot{MatchAll{}}}}, false},
+		{"empty Or", MatchOr{}, false},
+		{"Not", MatchNot{MatchNone{}}, true},
+	} {
+		if got := test.m.CertificateMatches(&cert); got != test.match {
+			t.Errorf("%s: CertificateMatches() = %v, want %v", test.desc, got, test.match)
+		}
+		if got := test.m.PrecertificateMatches(&precert); got != test.match {
+			t.Errorf("%s: PrecertificateMatches() = %v, want %v", test.desc, got, test.match)
+		}
+	}
+}
+
+func TestMatchCombinatorsShortCircuit(t *testing.T) {
+	var cert x509.Certificate
+	last := &countingMatcher{}
+	MatchAnd{[]Matcher{&countingMatcher{match: false}, last}}.CertificateMatches(&cert)
+	MatchOr{[]Matcher{&countingMatcher{match: true}, last}}.CertificateMatches(&cert)
+	MatchAnd{[]Matcher{&countingMatcher{match: false}, last}}.PrecertificateMatches(&client.Precertificate{})
+	MatchOr{[]Matcher{&countingMatcher{match: true}, last}}.PrecertificateMatches(&client.Precertificate{})
+	if last.calls != 0 {
+		t.Fatalf("Expected the remaining Matchers to be skipped, but they were called %d times", last.calls)
+	}
+}
+
+// finishingMatcher is a Matcher and ScanFinisher which counts the number of
+// times it's told the scan has finished.
+type finishingMatcher struct {
+	MatchAll
+	finished int
+}
+
+func (f *finishingMatcher) ScanFinished() {
+	f.finished++
+}
+
+func TestMatchCombinatorsForwardScanFinished(t *testing.T) {
+	f := &finishingMatcher{}
+	for _, test := range []struct {
+		desc string
+		m    Matcher
+	}{
+		{"And", MatchAnd{[]Matcher{MatchNone{}, f}}},
+		{"Or", MatchOr{[]Matcher{f, MatchAll{}}}},
+		{"Not", MatchNot{f}},
+		{"Labelled", LabelledMatcher{"f", f}},
+		{"Any", MatchAny{[]LabelledMatcher{{"none", MatchNone{}}, {"f", f}}}},
+		{"nested", MatchAny{[]LabelledMatcher{{"f", MatchAnd{[]Matcher{MatchNot{f}}}}}}},
+	} {
+		f.finished = 0
+		finisher, ok := test.m.(ScanFinisher)
+		if !ok {
+			t.Errorf("%s: not a ScanFinisher", test.desc)
+			continue
+		}
+		finisher.ScanFinished()
+		if f.finished != 1 {
+			t.Errorf("%s: wrapped Matcher told the scan finished %d times, want 1", test.desc, f.finished)
+		}
+	}
+}
+
+// logScopedMatcher is a LogScopedMatcher which matches only when scoped to
+// the log |want|.
+type logScopedMatcher struct {
+	want, log string
+}
+
+func (l logScopedMatcher) CertificateMatches(*x509.Certificate) bool {
+	return l.log == l.want
+}
+
+func (l logScopedMatcher) PrecertificateMatches(*client.Precertificate) bool {
+	return l.log == l.want
+}
+
+func (l logScopedMatcher) ForLog(log string) Matcher {
+	return logScopedMatcher{want: l.want, log: log}
+}
+
+func TestMatchCombinatorsForwardForLog(t *testing.T) {
+	scoped := logScopedMatcher{want: "https://log.example.com"}
+	for _, test := range []struct {
+		desc string
+		m    LogScopedMatcher
+	}{
+		{"And", MatchAnd{[]Matcher{MatchAll{}, scoped}}},
+		{"Or", MatchOr{[]Matcher{MatchNone{}, scoped}}},
+		{"Not", MatchNot{MatchNot{scoped}}},
+		{"Labelled", LabelledMatcher{"scoped", scoped}},
+		{"Any", MatchAny{[]LabelledMatcher{{"scoped", scoped}}}},
+	} {
+		if test.m.CertificateMatches(&x509.Certificate{}) {
+			t.Errorf("%s: matched before ForLog", test.desc)
+		}
+		if !test.m.ForLog("https://log.example.com").CertificateMatches(&x509.Certificate{}) {
+			t.Errorf("%s: didn't pass ForLog on to its Matchers", test.desc)
+		}
+		if test.m.ForLog("https://other.example.com").PrecertificateMatches(&client.Precertificate{}) {
+			t.Errorf("%s: matched for the wrong log", test.desc)
+		}
+	}
+}
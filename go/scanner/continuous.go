@@ -0,0 +1,135 @@
+package scanner
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// Performs a scan against the Log as ScanCtx does, and then keeps polling the
+// log every |pollInterval| and scanning any new entries, until |ctx| is done.
+// StartIndex is advanced past each completed scan.
+//
+// If ScannerOptions.DedupeWindow is > 0, matched entries whose certificate
+// was already reported among the last DedupeWindow matches (e.g. because the
+// log re-served it) aren't reported again.
+//
+// The STH fetched by each poll is checked against the previous poll's, and
+// any regression reported to ScannerOptions.OnAnomaly; if that's nil,
+// ScanContinuous stops and returns an *STHAnomalyError.
+//
+// Scanner.Stop stops the current pass, or if called between passes, prevents
+// the next one from starting.
+//
+// Returns the error from the first scan which fails, ErrScanStopped once
+// stopped, or ctx.Err() once |ctx| is done.
+func (s *Scanner) ScanContinuous(ctx context.Context, pollInterval time.Duration, foundCert func(int64, *x509.Certificate), foundPrecert func(int64, *client.Precertificate)) error {
+	if s.opts.DedupeWindow > 0 {
+		seen := newRecentHashes(s.opts.DedupeWindow)
+		innerCert, innerPrecert := foundCert, foundPrecert
+		foundCert = func(index int64, c *x509.Certificate) {
+			if seen.add(sha256.Sum256(c.Raw)) {
+				innerCert(index, c)
+			}
+		}
+		foundPrecert = func(index int64, p *client.Precertificate) {
+			if seen.add(sha256.Sum256(append(p.IssuerKeyHash[:], p.Raw...))) {
+				innerPrecert(index, p)
+			}
+		}
+	}
+	var prevSTH *client.SignedTreeHead
+	for {
+		if s.takeStop() {
+			s.Log("Continuous scan stopped")
+			return ErrScanStopped
+		}
+		if err := s.ScanCtx(ctx, foundCert, foundPrecert); err != nil {
+			return err
+		}
+		sth := s.Stats().STH
+		if err := checkSTHConsistency(prevSTH, sth); err != nil {
+			s.Log(err.Error())
+			if s.opts.OnAnomaly == nil {
+				return err
+			}
+			s.opts.OnAnomaly(err)
+		}
+		prevSTH = sth
+		s.opts.StartIndex = s.progress.highWater()
+		s.Log(fmt.Sprintf("Waiting %s for new entries after index %d", pollInterval, s.opts.StartIndex))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// STHAnomalyError is reported by ScanContinuous when the log serves an STH
+// which is inconsistent with an STH it served previously.
+type STHAnomalyError struct {
+	Previous *client.SignedTreeHead
+	Current  *client.SignedTreeHead
+	// A description of the inconsistency
+	Reason string
+}
+
+func (e *STHAnomalyError) Error() string {
+	return fmt.Sprintf("STH anomaly: %s (previous tree size %d at %d, now tree size %d at %d)",
+		e.Reason, e.Previous.TreeSize, e.Previous.Timestamp, e.Current.TreeSize, e.Current.Timestamp)
+}
+
+// Returns an *STHAnomalyError if |cur| has a smaller tree size or an earlier
+// timestamp than |prev|. |prev| may be nil.
+func checkSTHConsistency(prev, cur *client.SignedTreeHead) error {
+	switch {
+	case prev == nil:
+		return nil
+	case cur.TreeSize < prev.TreeSize:
+		return &STHAnomalyError{Previous: prev, Current: cur, Reason: "tree size decreased"}
+	case cur.Timestamp < prev.Timestamp:
+		return &STHAnomalyError{Previous: prev, Current: cur, Reason: "timestamp went backwards"}
+	}
+	return nil
+}
+
+// recentHashes is a fixed-size LRU set of content hashes.
+type recentHashes struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[[sha256.Size]byte]*list.Element
+}
+
+func newRecentHashes(size int) *recentHashes {
+	return &recentHashes{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[[sha256.Size]byte]*list.Element),
+	}
+}
+
+// Adds |hash| to the set, evicting the least recently added hash if the set
+// is full. Returns false if |hash| was already present.
+func (r *recentHashes) add(hash [sha256.Size]byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.entries[hash]; ok {
+		r.order.MoveToFront(e)
+		return false
+	}
+	r.entries[hash] = r.order.PushFront(hash)
+	if r.order.Len() > r.size {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.entries, oldest.Value.([sha256.Size]byte))
+	}
+	return true
+}
@@ -0,0 +1,222 @@
+package scanner
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// growingLogSource is a mockLogSource which calls |poll| with the number of
+// the poll before each STH is fetched, allowing the log to be changed
+// between polls.
+type growingLogSource struct {
+	*mockLogSource
+	polls int
+	poll  func(n int)
+}
+
+func (g *growingLogSource) GetSTHCtx(ctx context.Context) (*client.SignedTreeHead, error) {
+	g.polls++
+	g.poll(g.polls)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return g.mockLogSource.GetSTHCtx(ctx)
+}
+
+func TestScanContinuousDedupesAcrossPolls(t *testing.T) {
+	all := newMockLogSource(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := &growingLogSource{mockLogSource: &mockLogSource{leaves: all.leaves[:2]}}
+	source.poll = func(n int) {
+		switch n {
+		case 2:
+			// The log re-serves entry 1 at a new index.
+			source.leaves = append(source.leaves, all.leaves[1])
+		case 3:
+			cancel()
+		}
+	}
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+		DedupeWindow:  10,
+	})
+	var mu sync.Mutex
+	var indices []int64
+	record := func(index int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		indices = append(indices, index)
+	}
+	err := s.ScanContinuous(ctx, time.Millisecond, func(index int64, c *x509.Certificate) {
+		record(index)
+	}, func(index int64, p *client.Precertificate) {
+		record(index)
+	})
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if source.polls != 3 {
+		t.Fatalf("Expected 3 polls, got %d", source.polls)
+	}
+	if len(indices) != 2 {
+		t.Fatalf("Expected the duplicate at index 2 to be suppressed, got matches %v", indices)
+	}
+}
+
+func TestScanContinuousWithoutDedupe(t *testing.T) {
+	all := newMockLogSource(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := &growingLogSource{mockLogSource: &mockLogSource{leaves: all.leaves[:2]}}
+	source.poll = func(n int) {
+		switch n {
+		case 2:
+			source.leaves = append(source.leaves, all.leaves[1])
+		case 3:
+			cancel()
+		}
+	}
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	var matches int
+	s.ScanContinuous(ctx, time.Millisecond, func(int64, *x509.Certificate) {
+		matches++
+	}, func(int64, *client.Precertificate) {
+		matches++
+	})
+	if matches != 3 {
+		t.Fatalf("Expected 3 matches, got %d", matches)
+	}
+}
+
+// stoppingFinisher is a Matcher which stops |s| when told that its scan has
+// finished, i.e. between the passes of ScanContinuous.
+type stoppingFinisher struct {
+	MatchAll
+	s *Scanner
+}
+
+func (f *stoppingFinisher) ScanFinished() {
+	f.s.Stop()
+}
+
+func TestScanContinuousStopBetweenPasses(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	source := &growingLogSource{mockLogSource: newMockLogSource(t), poll: func(int) {}}
+	m := &stoppingFinisher{}
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       m,
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	m.s = s
+	err := s.ScanContinuous(ctx, time.Millisecond, func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {})
+	if err != ErrScanStopped {
+		t.Fatalf("Expected ErrScanStopped, got %v", err)
+	}
+	if source.polls != 1 {
+		t.Fatalf("Expected no pass to start after Stop, got %d polls", source.polls)
+	}
+}
+
+func TestRecentHashesEvictsOldest(t *testing.T) {
+	r := newRecentHashes(2)
+	a, b, c := sha256.Sum256([]byte("a")), sha256.Sum256([]byte("b")), sha256.Sum256([]byte("c"))
+	if !r.add(a) || !r.add(b) || r.add(a) {
+		t.Fatal("Unexpected result adding a, b, a")
+	}
+	// |b| is now the least recently seen, so is evicted by |c|.
+	if !r.add(c) || !r.add(b) || r.add(c) {
+		t.Fatal("Expected b to have been evicted")
+	}
+}
+
+// scriptedSTHLogSource is a mockLogSource which serves each of |sths| in turn,
+// cancelling the scan once they've all been served.
+type scriptedSTHLogSource struct {
+	*mockLogSource
+	sths   []client.SignedTreeHead
+	cancel context.CancelFunc
+	polls  int
+}
+
+func (s *scriptedSTHLogSource) GetSTHCtx(ctx context.Context) (*client.SignedTreeHead, error) {
+	if s.polls == len(s.sths) {
+		s.cancel()
+		return nil, ctx.Err()
+	}
+	sth := s.sths[s.polls]
+	s.polls++
+	return &sth, nil
+}
+
+func runScanContinuousWithSTHs(t *testing.T, onAnomaly func(error), sths ...client.SignedTreeHead) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := &scriptedSTHLogSource{mockLogSource: newMockLogSource(t), sths: sths, cancel: cancel}
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+		OnAnomaly:     onAnomaly,
+	})
+	return s.ScanContinuous(ctx, time.Millisecond, func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {})
+}
+
+func TestScanContinuousReportsTreeSizeDecrease(t *testing.T) {
+	var anomalies []error
+	err := runScanContinuousWithSTHs(t, func(err error) {
+		anomalies = append(anomalies, err)
+	}, client.SignedTreeHead{TreeSize: 4, Timestamp: 1}, client.SignedTreeHead{TreeSize: 3, Timestamp: 2}, client.SignedTreeHead{TreeSize: 4, Timestamp: 3})
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if len(anomalies) != 1 {
+		t.Fatalf("Expected 1 anomaly, got %v", anomalies)
+	}
+	a, ok := anomalies[0].(*STHAnomalyError)
+	if !ok || a.Previous.TreeSize != 4 || a.Current.TreeSize != 3 {
+		t.Fatalf("Expected tree size decrease from 4 to 3, got %v", anomalies[0])
+	}
+}
+
+func TestScanContinuousReturnsAnomalyWithoutCallback(t *testing.T) {
+	err := runScanContinuousWithSTHs(t, nil, client.SignedTreeHead{TreeSize: 4, Timestamp: 2}, client.SignedTreeHead{TreeSize: 4, Timestamp: 1})
+	a, ok := err.(*STHAnomalyError)
+	if !ok {
+		t.Fatalf("Expected *STHAnomalyError, got %v", err)
+	}
+	if a.Reason != "timestamp went backwards" {
+		t.Fatalf("Unexpected anomaly %v", a)
+	}
+}
+
+func TestScanContinuousAcceptsConsistentSTHs(t *testing.T) {
+	err := runScanContinuousWithSTHs(t, func(err error) {
+		t.Errorf("Unexpected anomaly %v", err)
+	}, client.SignedTreeHead{TreeSize: 3, Timestamp: 1}, client.SignedTreeHead{TreeSize: 4, Timestamp: 1}, client.SignedTreeHead{TreeSize: 4, Timestamp: 2})
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
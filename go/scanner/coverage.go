@@ -0,0 +1,145 @@
+package scanner
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// ScanFinisher may be implemented by stateful Matchers which need to be told
+// when a scan has completed, e.g. in order to report results which depend on
+// every entry having been seen. ScanFinished is only called if the scan
+// succeeded, not if it failed or was cancelled or stopped early.
+type ScanFinisher interface {
+	ScanFinished()
+}
+
+// CoverageGap describes a period during which none of the scanned
+// certificates for a dNSName were valid.
+type CoverageGap struct {
+	Name string
+	// End of the validity period before the gap (i.e. the latest NotAfter).
+	Start time.Time
+	// Start of the validity period after the gap (i.e. the next NotBefore).
+	End time.Time
+}
+
+type validityInterval struct {
+	notBefore, notAfter time.Time
+}
+
+type byNotBefore []validityInterval
+
+func (b byNotBefore) Len() int           { return len(b) }
+func (b byNotBefore) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byNotBefore) Less(i, j int) bool { return b[i].notBefore.Before(b[j].notBefore) }
+
+// MatchCertInValidityGap is a stateful Matcher which tracks the validity
+// periods of all Certificates and Precertificates seen for a fixed set of
+// dNSNames, and when the scan finishes reports, via |GapCallback|, every gap
+// in a name's coverage which is longer than |MaxGap|.
+//
+// Every validity interval seen for a tracked name is held in memory until the
+// scan finishes, so the set of names should be kept small; names outside the
+// set are ignored. Certificates match if they carry a tracked name.
+//
+// Use NewMatchCertInValidityGap to create instances of this Matcher, and don't
+// share an instance between concurrent scans.
+type MatchCertInValidityGap struct {
+	MaxGap      time.Duration
+	GapCallback func(CoverageGap)
+
+	names     map[string]bool
+	mu        sync.Mutex
+	intervals map[string][]validityInterval
+}
+
+// Normalizes |name| for comparison: lowercased, without a trailing dot.
+func normalizeDNSName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// Creates a new MatchCertInValidityGap which tracks the dNSNames in |names|
+// and reports gaps longer than |maxGap| to |callback|.
+func NewMatchCertInValidityGap(maxGap time.Duration, callback func(CoverageGap), names ...string) *MatchCertInValidityGap {
+	m := &MatchCertInValidityGap{
+		MaxGap:      maxGap,
+		GapCallback: callback,
+		names:       make(map[string]bool),
+		intervals:   make(map[string][]validityInterval),
+	}
+	for _, name := range names {
+		m.names[normalizeDNSName(name)] = true
+	}
+	return m
+}
+
+func (m *MatchCertInValidityGap) record(c *x509.Certificate) bool {
+	matched := false
+	seen := make(map[string]bool)
+	for _, name := range c.DNSNames {
+		name = normalizeDNSName(name)
+		if !m.names[name] || seen[name] {
+			continue
+		}
+		seen[name] = true
+		matched = true
+		m.mu.Lock()
+		m.intervals[name] = append(m.intervals[name], validityInterval{c.NotBefore, c.NotAfter})
+		m.mu.Unlock()
+	}
+	return matched
+}
+
+// Records the validity period of |c| against each of its tracked dNSNames.
+func (m *MatchCertInValidityGap) CertificateMatches(c *x509.Certificate) bool {
+	return m.record(c)
+}
+
+// Records the validity period of the TBSCertificate in |p| against each of its
+// tracked dNSNames.
+func (m *MatchCertInValidityGap) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.record(&p.TBSCertificate)
+}
+
+// Gaps returns the coverage gaps longer than MaxGap for every tracked name,
+// ordered by name and then by time.
+func (m *MatchCertInValidityGap) Gaps() []CoverageGap {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.intervals))
+	for name := range m.intervals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var gaps []CoverageGap
+	for _, name := range names {
+		intervals := m.intervals[name]
+		sort.Sort(byNotBefore(intervals))
+		coveredUntil := intervals[0].notAfter
+		for _, iv := range intervals[1:] {
+			if iv.notBefore.Sub(coveredUntil) > m.MaxGap {
+				gaps = append(gaps, CoverageGap{Name: name, Start: coveredUntil, End: iv.notBefore})
+			}
+			if iv.notAfter.After(coveredUntil) {
+				coveredUntil = iv.notAfter
+			}
+		}
+	}
+	return gaps
+}
+
+// ScanFinished reports each coverage gap to GapCallback, if set.
+func (m *MatchCertInValidityGap) ScanFinished() {
+	if m.GapCallback == nil {
+		return
+	}
+	for _, gap := range m.Gaps() {
+		m.GapCallback(gap)
+	}
+}
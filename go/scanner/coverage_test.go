@@ -0,0 +1,55 @@
+package scanner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+func makeCertForNames(notBefore, notAfter time.Time, names ...string) *x509.Certificate {
+	return &x509.Certificate{NotBefore: notBefore, NotAfter: notAfter, DNSNames: names}
+}
+
+func TestMatchCertInValidityGapReportsGap(t *testing.T) {
+	var gaps []CoverageGap
+	m := NewMatchCertInValidityGap(24*time.Hour, func(g CoverageGap) { gaps = append(gaps, g) }, "www.example.com")
+
+	jan := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	mar := time.Date(2016, time.March, 1, 0, 0, 0, 0, time.UTC)
+	apr := time.Date(2016, time.April, 1, 0, 0, 0, 0, time.UTC)
+	jun := time.Date(2016, time.June, 1, 0, 0, 0, 0, time.UTC)
+	if !m.CertificateMatches(makeCertForNames(apr, jun, "WWW.example.com.")) {
+		t.Fatal("MatchCertInValidityGap failed to match Cert for tracked name")
+	}
+	var precert client.Precertificate
+	precert.TBSCertificate = *makeCertForNames(jan, mar, "www.example.com")
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertInValidityGap failed to match Precert for tracked name")
+	}
+	if m.CertificateMatches(makeCertForNames(mar, apr, "mail.example.com")) {
+		t.Fatal("MatchCertInValidityGap incorrectly matched Cert for untracked name")
+	}
+
+	m.ScanFinished()
+	if len(gaps) != 1 {
+		t.Fatalf("Expected 1 gap, got %d: %v", len(gaps), gaps)
+	}
+	if gaps[0].Name != "www.example.com" || !gaps[0].Start.Equal(mar) || !gaps[0].End.Equal(apr) {
+		t.Fatalf("Incorrect gap reported: %+v", gaps[0])
+	}
+}
+
+func TestMatchCertInValidityGapIgnoresOverlapsAndShortGaps(t *testing.T) {
+	m := NewMatchCertInValidityGap(24*time.Hour, nil, "www.example.com")
+	start := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	m.CertificateMatches(makeCertForNames(start, start.Add(90*24*time.Hour), "www.example.com"))
+	m.CertificateMatches(makeCertForNames(start.Add(10*24*time.Hour), start.Add(30*24*time.Hour), "www.example.com"))
+	m.CertificateMatches(makeCertForNames(start.Add(90*24*time.Hour+time.Hour), start.Add(180*24*time.Hour), "www.example.com"))
+	if gaps := m.Gaps(); len(gaps) != 0 {
+		t.Fatalf("Expected no gaps, got %v", gaps)
+	}
+	// Must not panic without a callback.
+	m.ScanFinished()
+}
@@ -0,0 +1,146 @@
+package scanner
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// Names of the columns which a CSVSink can write. For Precertificates, the
+// values are taken from the TBSCertificate.
+const (
+	// The entry's index in the log
+	CSVColumnIndex = "index"
+	// "cert" or "precert"
+	CSVColumnType = "type"
+	// The serial number, in hex
+	CSVColumnSerial = "serial"
+	// The subject common name
+	CSVColumnCommonName = "cn"
+	// The number of DNS, email and IP subjectAltNames
+	CSVColumnSANCount = "san_count"
+	// The issuer common name
+	CSVColumnIssuer = "issuer"
+	// The validity period, in RFC 3339 format
+	CSVColumnNotBefore = "not_before"
+	CSVColumnNotAfter  = "not_after"
+	// The labels of the rules which matched the entry, separated by spaces
+	CSVColumnLabels = "labels"
+)
+
+// The columns written by a CSVSink if none are given.
+var DefaultCSVColumns = []string{CSVColumnIndex, CSVColumnSerial, CSVColumnCommonName, CSVColumnSANCount, CSVColumnIssuer, CSVColumnNotBefore, CSVColumnNotAfter}
+
+// Returns the value of each CSV column for an entry.
+var csvColumnValues = map[string]func(e *MatchedEntry, c *x509.Certificate) string{
+	CSVColumnIndex: func(e *MatchedEntry, c *x509.Certificate) string {
+		return strconv.FormatInt(e.Index, 10)
+	},
+	CSVColumnType: func(e *MatchedEntry, c *x509.Certificate) string {
+		if e.Precert != nil {
+			return "precert"
+		}
+		return "cert"
+	},
+	CSVColumnSerial: func(e *MatchedEntry, c *x509.Certificate) string {
+		if c.SerialNumber == nil {
+			return ""
+		}
+		return c.SerialNumber.Text(16)
+	},
+	CSVColumnCommonName: func(e *MatchedEntry, c *x509.Certificate) string {
+		return c.Subject.CommonName
+	},
+	CSVColumnSANCount: func(e *MatchedEntry, c *x509.Certificate) string {
+		return strconv.Itoa(len(c.DNSNames) + len(c.EmailAddresses) + len(c.IPAddresses))
+	},
+	CSVColumnIssuer: func(e *MatchedEntry, c *x509.Certificate) string {
+		return c.Issuer.CommonName
+	},
+	CSVColumnNotBefore: func(e *MatchedEntry, c *x509.Certificate) string {
+		return c.NotBefore.UTC().Format(time.RFC3339)
+	},
+	CSVColumnNotAfter: func(e *MatchedEntry, c *x509.Certificate) string {
+		return c.NotAfter.UTC().Format(time.RFC3339)
+	},
+	CSVColumnLabels: func(e *MatchedEntry, c *x509.Certificate) string {
+		return strings.Join(e.Labels, " ")
+	},
+}
+
+// Returns |value| prefixed with a single quote if it begins with a character
+// which would make a spreadsheet treat it as a formula. Certificate fields are
+// chosen by whoever requested the certificate, so mustn't be trusted not to
+// contain formulas.
+func escapeCSVFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// CSVSink is a Sink which writes a row of comma-separated values for each
+// matched entry, following a header row naming the columns. Values are quoted
+// as necessary, e.g. names containing commas, and values which a spreadsheet
+// would interpret as a formula (those beginning with "=", "+", "-", "@", a tab
+// or a carriage return) are prefixed with a single quote. It's safe for
+// concurrent use.
+type CSVSink struct {
+	mu      sync.Mutex
+	w       *csv.Writer
+	columns []string
+}
+
+// Creates a new CSVSink which writes |columns| (see the CSVColumn* constants)
+// to |w|, starting with the header row. If |columns| is empty,
+// DefaultCSVColumns are written.
+// Closing the CSVSink flushes any buffered output, but doesn't close |w|.
+// Returns an error if a column is unknown, or the header can't be written.
+func NewCSVSink(w io.Writer, columns []string) (*CSVSink, error) {
+	if len(columns) == 0 {
+		columns = DefaultCSVColumns
+	}
+	for _, c := range columns {
+		if _, ok := csvColumnValues[c]; !ok {
+			return nil, fmt.Errorf("unknown CSV column %q", c)
+		}
+	}
+	s := &CSVSink{w: csv.NewWriter(w), columns: columns}
+	s.w.Write(columns)
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Writes the row for |e|.
+func (s *CSVSink) Put(e *MatchedEntry) error {
+	c := e.Cert
+	if e.Precert != nil {
+		c = &e.Precert.TBSCertificate
+	}
+	row := make([]string, len(s.columns))
+	for i, column := range s.columns {
+		row[i] = escapeCSVFormula(csvColumnValues[column](e, c))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Write(row)
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// Flushes any buffered output.
+func (s *CSVSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Flush()
+	return s.w.Error()
+}
@@ -0,0 +1,144 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math/big"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+	"github.com/google/certificate-transparency/go/x509/pkix"
+)
+
+func TestCSVSinkScan(t *testing.T) {
+	var buf bytes.Buffer
+	sink, err := NewCSVSink(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := NewScanner(newMockLogSource(t), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    2,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	if err := scanner.ScanSink(sink); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 {
+		t.Fatalf("Expected a header and 4 rows, got %v", rows)
+	}
+	for i, column := range DefaultCSVColumns {
+		if rows[0][i] != column {
+			t.Fatalf("Expected header %v, got %v", DefaultCSVColumns, rows[0])
+		}
+	}
+	var indices int64Slice
+	for _, row := range rows[1:] {
+		index, err := strconv.ParseInt(row[0], 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		indices = append(indices, index)
+		if row[2] == "" {
+			t.Errorf("Row for entry %d has no CN: %v", index, row)
+		}
+		if _, err := time.Parse(time.RFC3339, row[5]); err != nil {
+			t.Errorf("Row for entry %d has bad not_before: %v", index, err)
+		}
+	}
+	sort.Sort(indices)
+	if indices[0] != 0 || indices[3] != 3 {
+		t.Fatalf("Expected rows for entries 0-3, got %v", indices)
+	}
+}
+
+func TestCSVSinkQuotesAndSelectsColumns(t *testing.T) {
+	var buf bytes.Buffer
+	sink, err := NewCSVSink(&buf, []string{CSVColumnType, CSVColumnCommonName, CSVColumnSerial, CSVColumnSANCount})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &x509.Certificate{
+		Subject:      pkix.Name{CommonName: `Example, "Inc."`},
+		SerialNumber: big.NewInt(255),
+		DNSNames:     []string{"a.example.com", "b.example.com"},
+	}
+	if err := sink.Put(&MatchedEntry{Index: 7, Cert: cert}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Put(&MatchedEntry{Index: 8, Precert: &client.Precertificate{TBSCertificate: *cert}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"type", "cn", "serial", "san_count"},
+		{"cert", `Example, "Inc."`, "ff", "2"},
+		{"precert", `Example, "Inc."`, "ff", "2"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("Expected rows %v, got %v", want, rows)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if rows[i][j] != want[i][j] {
+				t.Fatalf("Expected rows %v, got %v", want, rows)
+			}
+		}
+	}
+
+	if _, err := NewCSVSink(&buf, []string{"bogus"}); err == nil {
+		t.Fatal("Expected error for unknown column")
+	}
+}
+
+func TestCSVSinkEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	sink, err := NewCSVSink(&buf, []string{CSVColumnCommonName, CSVColumnIssuer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"=HYPERLINK(\"http://evil.example\")", "+1", "-1", "@SUM(A1)", "\tx", "a=b"} {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: name}, Issuer: pkix.Name{CommonName: "Example CA"}}
+		if err := sink.Put(&MatchedEntry{Cert: cert}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cn", "'=HYPERLINK(\"http://evil.example\")", "'+1", "'-1", "'@SUM(A1)", "'\tx", "a=b"}
+	if len(rows) != len(want) {
+		t.Fatalf("Expected %d rows, got %v", len(want), rows)
+	}
+	for i := range want {
+		if rows[i][0] != want[i] {
+			t.Errorf("Row %d: expected %q, got %q", i, want[i], rows[i][0])
+		}
+		if i > 0 && rows[i][1] != "Example CA" {
+			t.Errorf("Row %d: expected issuer to be unchanged, got %q", i, rows[i][1])
+		}
+	}
+}
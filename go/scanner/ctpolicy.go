@@ -0,0 +1,169 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// CTPolicy identifies a root program's Certificate Transparency policy for
+// certificates with embedded SCTs.
+type CTPolicy int
+
+const (
+	// Chrome's CT policy,
+	// https://googlechrome.github.io/CertificateTransparency/ct_policy.html
+	CTPolicyChrome CTPolicy = iota + 1
+	// Apple's CT policy, https://support.apple.com/en-us/103214
+	CTPolicyApple
+)
+
+func (p CTPolicy) String() string {
+	switch p {
+	case CTPolicyChrome:
+		return "Chrome"
+	case CTPolicyApple:
+		return "Apple"
+	}
+	return "Invalid"
+}
+
+// Certificates with lifetimes up to this need fewer SCTs under both policies.
+const shortLivedCertLifetime = 180 * 24 * time.Hour
+
+// Returns the number of SCTs from distinct logs which |p| requires to be
+// embedded in a certificate valid from |notBefore| to |notAfter|, or 0 if |p|
+// isn't a known policy.
+func (p CTPolicy) requiredSCTs(notBefore, notAfter time.Time) int {
+	short := notAfter.Sub(notBefore) <= shortLivedCertLifetime
+	switch p {
+	case CTPolicyChrome:
+		if short {
+			return 2
+		}
+		return 3
+	case CTPolicyApple:
+		// Apple's requirement grows with the lifetime, in bands of months.
+		switch {
+		case short:
+			return 2
+		case !notAfter.After(notBefore.AddDate(0, 15, 0)):
+			return 3
+		case !notAfter.After(notBefore.AddDate(0, 27, 0)):
+			return 4
+		}
+		return 5
+	}
+	return 0
+}
+
+// Both policies require SCTs from at least this many distinct log operators.
+const minCTPolicyOperators = 2
+
+// Checks whether the SCTs embedded in |c| satisfy |policy|. |operators| maps
+// base64 encoded log IDs to operator names, as for CountSCTOperators; SCTs
+// from logs not in |operators| aren't counted.
+// Returns nil if |c| complies, or a non-nil error describing why it doesn't
+// (or why its SCTs couldn't be parsed, or that |policy| is unknown).
+func CheckCTPolicy(c *x509.Certificate, operators map[string]string, policy CTPolicy) error {
+	required := policy.requiredSCTs(c.NotBefore, c.NotAfter)
+	if required == 0 {
+		return fmt.Errorf("unknown CT policy %d", policy)
+	}
+	scts, err := embeddedSCTs(c)
+	if err != nil {
+		return err
+	}
+	logs := make(map[string]bool)
+	ops := make(map[string]bool)
+	for _, raw := range scts {
+		sct, err := client.ReadSignedCertificateTimestamp(bytes.NewReader(raw))
+		if err != nil {
+			return err
+		}
+		id := base64.StdEncoding.EncodeToString(sct.LogID)
+		if op, ok := operators[id]; ok {
+			logs[id] = true
+			ops[op] = true
+		}
+	}
+	if len(logs) < required {
+		return fmt.Errorf("%s CT policy requires SCTs from %d known logs, found %d", policy, required, len(logs))
+	}
+	if len(ops) < minCTPolicyOperators {
+		return fmt.Errorf("%s CT policy requires SCTs from %d log operators, found %d", policy, minCTPolicyOperators, len(ops))
+	}
+	return nil
+}
+
+// MatchCTPolicyNonCompliant is a Matcher which matches final
+// Certificates whose embedded SCTs don't satisfy any one of |Policies|
+// (default Chrome's and Apple's), as determined by CheckCTPolicy with
+// |Operators|.
+// Precertificates never carry embedded SCTs, so are never matched.
+type MatchCTPolicyNonCompliant struct {
+	Operators map[string]string
+	Policies  []CTPolicy
+}
+
+// Returns true if |c| doesn't comply with one of the CT policies.
+func (m MatchCTPolicyNonCompliant) CertificateMatches(c *x509.Certificate) bool {
+	policies := m.Policies
+	if len(policies) == 0 {
+		policies = []CTPolicy{CTPolicyChrome, CTPolicyApple}
+	}
+	for _, p := range policies {
+		if CheckCTPolicy(c, m.Operators, p) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Always returns false.
+func (m MatchCTPolicyNonCompliant) PrecertificateMatches(p *client.Precertificate) bool {
+	return false
+}
+
+// MatchCertByEmbeddedSCTTimestampSkew is a Matcher which matches final
+// Certificates with an embedded SCT whose timestamp is before the
+// Certificate's NotBefore, or more than |MaxSkew| (default 24 hours) after it.
+// Either may indicate that the Certificate was backdated.
+// Certificates whose SCTs can't be parsed aren't matched. Precertificates
+// never carry embedded SCTs, so are never matched.
+type MatchCertByEmbeddedSCTTimestampSkew struct {
+	MaxSkew time.Duration
+}
+
+// Returns true if an SCT embedded in |c| has a timestamp outside
+// [NotBefore, NotBefore + MaxSkew].
+func (m MatchCertByEmbeddedSCTTimestampSkew) CertificateMatches(c *x509.Certificate) bool {
+	maxSkew := m.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = 24 * time.Hour
+	}
+	scts, err := embeddedSCTs(c)
+	if err != nil {
+		return false
+	}
+	for _, raw := range scts {
+		sct, err := client.ReadSignedCertificateTimestamp(bytes.NewReader(raw))
+		if err != nil {
+			return false
+		}
+		ts := time.Unix(0, int64(sct.Timestamp)*int64(time.Millisecond))
+		if ts.Before(c.NotBefore) || ts.After(c.NotBefore.Add(maxSkew)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Always returns false.
+func (m MatchCertByEmbeddedSCTTimestampSkew) PrecertificateMatches(p *client.Precertificate) bool {
+	return false
+}
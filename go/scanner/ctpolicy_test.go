@@ -0,0 +1,164 @@
+package scanner
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+	"github.com/google/certificate-transparency/go/x509/pkix"
+)
+
+func TestCheckCTPolicy(t *testing.T) {
+	logA1 := bytes.Repeat([]byte{0xa1}, 32)
+	logA2 := bytes.Repeat([]byte{0xa2}, 32)
+	logB1 := bytes.Repeat([]byte{0xb1}, 32)
+	logB2 := bytes.Repeat([]byte{0xb2}, 32)
+	logB3 := bytes.Repeat([]byte{0xb3}, 32)
+	unknown := bytes.Repeat([]byte{0xff}, 32)
+	operators := LogOperatorsFromLogList([]client.LogListEntry{
+		{LogID: logA1, Operator: "Operator A"},
+		{LogID: logA2, Operator: "Operator A"},
+		{LogID: logB1, Operator: "Operator B"},
+		{LogID: logB2, Operator: "Operator B"},
+		{LogID: logB3, Operator: "Operator B"},
+	})
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	shortLived := notBefore.AddDate(0, 0, 90)
+	oneYear := notBefore.AddDate(1, 0, 0)
+	twoYears := notBefore.AddDate(2, 0, 0)
+	threeYears := notBefore.AddDate(3, 0, 0)
+
+	tests := []struct {
+		desc     string
+		notAfter time.Time
+		logs     [][]byte
+		// Whether the cert complies with Chrome's and Apple's policies
+		chrome, apple bool
+	}{
+		{"short-lived with two operators", shortLived, [][]byte{logA1, logB1}, true, true},
+		{"short-lived with one operator", shortLived, [][]byte{logA1, logA2}, false, false},
+		{"short-lived with one known log", shortLived, [][]byte{logA1, unknown}, false, false},
+		{"short-lived with a duplicate SCT", shortLived, [][]byte{logA1, logA1}, false, false},
+		{"one year with three logs", oneYear, [][]byte{logA1, logA2, logB1}, true, true},
+		{"one year with two logs", oneYear, [][]byte{logA1, logB1}, false, false},
+		{"two years with three logs", twoYears, [][]byte{logA1, logA2, logB1}, true, false},
+		{"two years with four logs", twoYears, [][]byte{logA1, logA2, logB1, logB2}, true, true},
+		{"three years with four logs", threeYears, [][]byte{logA1, logA2, logB1, logB2}, true, false},
+		{"three years with five logs", threeYears, [][]byte{logA1, logA2, logB1, logB2, logB3}, true, true},
+		{"no SCTs", shortLived, nil, false, false},
+	}
+	for _, test := range tests {
+		var scts [][]byte
+		for _, id := range test.logs {
+			scts = append(scts, makeSCT(id))
+		}
+		cert := x509.Certificate{NotBefore: notBefore, NotAfter: test.notAfter}
+		if scts != nil {
+			cert.Extensions = []pkix.Extension{makeSCTListExtension(t, scts...)}
+		}
+		for policy, want := range map[CTPolicy]bool{CTPolicyChrome: test.chrome, CTPolicyApple: test.apple} {
+			err := CheckCTPolicy(&cert, operators, policy)
+			if compliant := err == nil; compliant != want {
+				t.Errorf("%s: CheckCTPolicy(%s) = %v, expected compliant=%v", test.desc, policy, err, want)
+			}
+		}
+	}
+}
+
+func TestCheckCTPolicyRejectsUnknownPolicy(t *testing.T) {
+	logA := bytes.Repeat([]byte{0xa1}, 32)
+	logB := bytes.Repeat([]byte{0xb0}, 32)
+	operators := LogOperatorsFromLogList([]client.LogListEntry{
+		{LogID: logA, Operator: "Operator A"},
+		{LogID: logB, Operator: "Operator B"},
+	})
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.AddDate(0, 0, 90)}
+	cert.Extensions = []pkix.Extension{makeSCTListExtension(t, makeSCT(logA), makeSCT(logB))}
+	if err := CheckCTPolicy(&cert, operators, CTPolicy(0)); err == nil {
+		t.Fatal("Expected an error for an unknown CT policy")
+	}
+}
+
+func TestScannerMatchCTPolicyNonCompliant(t *testing.T) {
+	logA := bytes.Repeat([]byte{0xa1}, 32)
+	logB := bytes.Repeat([]byte{0xb0}, 32)
+	logC := bytes.Repeat([]byte{0xc0}, 32)
+	m := MatchCTPolicyNonCompliant{Operators: LogOperatorsFromLogList([]client.LogListEntry{
+		{LogID: logA, Operator: "Operator A"},
+		{LogID: logB, Operator: "Operator B"},
+		{LogID: logC, Operator: "Operator C"},
+	})}
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.AddDate(0, 0, 90)}
+	cert.Extensions = []pkix.Extension{makeSCTListExtension(t, makeSCT(logA), makeSCT(logB))}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCTPolicyNonCompliant incorrectly matched compliant Cert")
+	}
+
+	cert.NotAfter = notBefore.AddDate(1, 0, 0)
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCTPolicyNonCompliant failed to match long-lived Cert with two SCTs")
+	}
+
+	// Two years needs 3 SCTs under Chrome's policy, but 4 under Apple's.
+	cert.NotAfter = notBefore.AddDate(2, 0, 0)
+	cert.Extensions = []pkix.Extension{makeSCTListExtension(t, makeSCT(logA), makeSCT(logB), makeSCT(logC))}
+	m.Policies = []CTPolicy{CTPolicyChrome}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCTPolicyNonCompliant matched Cert which complies with Chrome's policy")
+	}
+	m.Policies = []CTPolicy{CTPolicyApple}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCTPolicyNonCompliant failed to match Cert which doesn't comply with Apple's policy")
+	}
+	m.Policies = nil
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCTPolicyNonCompliant failed to match Cert which doesn't comply with one of the default policies")
+	}
+
+	var precert client.Precertificate
+	if m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCTPolicyNonCompliant incorrectly matched Precert")
+	}
+}
+
+func TestScannerMatchCertByEmbeddedSCTTimestampSkew(t *testing.T) {
+	logID := bytes.Repeat([]byte{0xa1}, 32)
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sctAt := func(ts time.Time) pkix.Extension {
+		return makeSCTListExtension(t, makeSCTAt(logID, uint64(ts.UnixNano()/int64(time.Millisecond))))
+	}
+	m := MatchCertByEmbeddedSCTTimestampSkew{}
+
+	cert := x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.AddDate(0, 3, 0)}
+	cert.Extensions = []pkix.Extension{sctAt(notBefore.Add(time.Minute))}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByEmbeddedSCTTimestampSkew incorrectly matched Cert with a consistent SCT")
+	}
+
+	// Backdated: the cert claims to be valid from well before it was logged.
+	cert.Extensions = []pkix.Extension{sctAt(notBefore.AddDate(0, 0, 7))}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByEmbeddedSCTTimestampSkew failed to match backdated Cert")
+	}
+	m.MaxSkew = 30 * 24 * time.Hour
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByEmbeddedSCTTimestampSkew matched Cert within MaxSkew")
+	}
+
+	cert.Extensions = []pkix.Extension{sctAt(notBefore.Add(-time.Hour))}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByEmbeddedSCTTimestampSkew failed to match Cert with an SCT before NotBefore")
+	}
+
+	cert.Extensions = nil
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByEmbeddedSCTTimestampSkew incorrectly matched Cert without SCTs")
+	}
+	if m.PrecertificateMatches(&client.Precertificate{}) {
+		t.Fatal("MatchCertByEmbeddedSCTTimestampSkew incorrectly matched Precert")
+	}
+}
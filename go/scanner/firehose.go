@@ -0,0 +1,30 @@
+package scanner
+
+import (
+	"context"
+
+	"github.com/google/certificate-transparency/go/client"
+)
+
+// Performs a scan against the Log in "firehose" mode: rather than being
+// parsed and matched, each entry is passed to |found| as fetched, along with
+// its index. This maximizes throughput for callers which parse entries
+// elsewhere. The entry's ExtraData is only set if ScannerOptions.FetchChains
+// is set. The Matcher and PreFilter aren't used.
+//
+// This method blocks until the scan is complete.
+func (s *Scanner) ScanRaw(found func(int64, client.RawLogEntry)) error {
+	return s.ScanRawCtx(context.Background(), found)
+}
+
+// Performs a scan against the Log, as ScanRaw, but stops early if |ctx| is
+// done (see ScanCtx).
+//
+// This method blocks until the scan is complete or has been stopped.
+func (s *Scanner) ScanRawCtx(ctx context.Context, found func(int64, client.RawLogEntry)) error {
+	s.foundRaw = found
+	defer func() {
+		s.foundRaw = nil
+	}()
+	return s.ScanLabelledCtx(ctx, nil, nil)
+}
@@ -0,0 +1,94 @@
+package scanner
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// panicMatcher is a Matcher which panics if it's ever called.
+type panicMatcher struct{}
+
+func (panicMatcher) CertificateMatches(*x509.Certificate) bool {
+	panic("Matcher called for a Certificate")
+}
+
+func (panicMatcher) PrecertificateMatches(*client.Precertificate) bool {
+	panic("Matcher called for a Precertificate")
+}
+
+func TestScannerScanRawDeliversUnmodifiedEntries(t *testing.T) {
+	source := &chainLogSource{}
+	for _, leaf := range newMockLogSource(t).leaves {
+		source.entries = append(source.entries, client.RawLogEntry{LeafInput: leaf, ExtraData: []byte{0x00, 0x00, 0x00}})
+	}
+	// Unparsable entries are passed through too.
+	source.entries = append(source.entries, client.RawLogEntry{LeafInput: []byte("not a leaf"), ExtraData: []byte("junk")})
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       panicMatcher{},
+		BlockSize:     2,
+		NumWorkers:    2,
+		ParallelFetch: 2,
+		Quiet:         true,
+		FetchChains:   true,
+	})
+	var mu sync.Mutex
+	got := make(map[int64]client.RawLogEntry)
+	err := s.ScanRaw(func(index int64, e client.RawLogEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := got[index]; ok {
+			t.Errorf("Entry %d delivered twice", index)
+		}
+		got[index] = e
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(source.entries) {
+		t.Fatalf("Expected %d entries, got %d", len(source.entries), len(got))
+	}
+	for i, want := range source.entries {
+		e := got[int64(i)]
+		if !bytes.Equal(e.LeafInput, want.LeafInput) || !bytes.Equal(e.ExtraData, want.ExtraData) {
+			t.Errorf("Entry %d modified: got %x/%x, want %x/%x", i, e.LeafInput, e.ExtraData, want.LeafInput, want.ExtraData)
+		}
+	}
+	if processed := s.Stats().CertsProcessed; processed != int64(len(source.entries)) {
+		t.Errorf("Expected %d entries processed, got %d", len(source.entries), processed)
+	}
+}
+
+func benchmarkScan(b *testing.B, raw bool) {
+	source := newRepeatedMockLogSource(b, 1000)
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     1000,
+		NumWorkers:    4,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if raw {
+			err = s.ScanRaw(func(int64, client.RawLogEntry) {})
+		} else {
+			err = s.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {})
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	benchmarkScan(b, false)
+}
+
+func BenchmarkScanRaw(b *testing.B) {
+	benchmarkScan(b, true)
+}
@@ -0,0 +1,111 @@
+package scanner
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// JSONMatch is the record written by a JSONSink for each matched entry. For
+// Precertificates, the values are taken from the TBSCertificate.
+type JSONMatch struct {
+	// The entry's index in the log
+	Index int64 `json:"index"`
+	// "cert" or "precert"
+	Type string `json:"type"`
+	// The subject common name
+	CommonName string `json:"cn"`
+	// The subjectAltNames
+	DNSNames       []string `json:"dns_names,omitempty"`
+	EmailAddresses []string `json:"email_addresses,omitempty"`
+	IPAddresses    []string `json:"ip_addresses,omitempty"`
+	// The issuer common name
+	Issuer string `json:"issuer"`
+	// The serial number, in hex
+	Serial string `json:"serial"`
+	// The validity period, in UTC
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	// The DER encoded certificate, or for a Precertificate the
+	// TBSCertificate, which is base64 encoded in the JSON
+	DER []byte `json:"der"`
+	// The labels of the rules which matched the entry
+	Labels []string `json:"labels,omitempty"`
+}
+
+// Returns the JSONMatch describing |e|.
+func newJSONMatch(e *MatchedEntry) *JSONMatch {
+	c := e.Cert
+	m := &JSONMatch{Index: e.Index, Type: "cert", Labels: e.Labels}
+	if e.Precert != nil {
+		c = &e.Precert.TBSCertificate
+		m.Type = "precert"
+		m.DER = e.Precert.Raw
+	} else {
+		m.DER = c.Raw
+	}
+	m.CommonName = c.Subject.CommonName
+	m.DNSNames = c.DNSNames
+	m.EmailAddresses = c.EmailAddresses
+	for _, ip := range c.IPAddresses {
+		m.IPAddresses = append(m.IPAddresses, ip.String())
+	}
+	m.Issuer = c.Issuer.CommonName
+	if c.SerialNumber != nil {
+		m.Serial = c.SerialNumber.Text(16)
+	}
+	m.NotBefore = c.NotBefore.UTC()
+	m.NotAfter = c.NotAfter.UTC()
+	return m
+}
+
+// JSONSink is a Sink which writes each matched entry to an io.Writer as a
+// JSONMatch object on a line of its own ("JSON lines"), suitable for piping
+// into jq or a log ingestion pipeline. It's safe for concurrent use.
+type JSONSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Creates a new JSONSink which writes to |w|. Closing the JSONSink doesn't
+// close |w|.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{w: w}
+}
+
+// Writes the line for |e|.
+func (s *JSONSink) Put(e *MatchedEntry) error {
+	line, err := json.Marshal(newJSONMatch(e))
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(line)
+	return err
+}
+
+// Does nothing, as the JSONSink doesn't buffer its output.
+func (s *JSONSink) Close() error {
+	return nil
+}
+
+// Returns foundCert and foundPrecert callbacks for Scan which write each
+// matched entry to |w| as a line of JSON, as a JSONSink does. They may be
+// called concurrently. Errors writing to |w| are ignored; use a JSONSink with
+// ScanSink to have them reported.
+func NewJSONMatchWriter(w io.Writer) (func(int64, *x509.Certificate), func(int64, *client.Precertificate)) {
+	s := NewJSONSink(w)
+	foundCert := func(index int64, c *x509.Certificate) {
+		s.Put(&MatchedEntry{Index: index, Cert: c})
+	}
+	foundPrecert := func(index int64, p *client.Precertificate) {
+		s.Put(&MatchedEntry{Index: index, Precert: p})
+	}
+	return foundCert, foundPrecert
+}
@@ -0,0 +1,64 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+func TestNewJSONMatchWriterScan(t *testing.T) {
+	var buf bytes.Buffer
+	foundCert, foundPrecert := NewJSONMatchWriter(&buf)
+	scanner := NewScanner(newMockLogSource(t), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    2,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	if err := scanner.Scan(foundCert, foundPrecert); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[int64]bool)
+	lines := bufio.NewScanner(&buf)
+	for lines.Scan() {
+		var m JSONMatch
+		if err := json.Unmarshal(lines.Bytes(), &m); err != nil {
+			t.Fatalf("Failed to parse line %q: %v", lines.Text(), err)
+		}
+		if m.Type != "cert" || m.Serial == "" || m.NotAfter.IsZero() {
+			t.Errorf("Unexpected record for entry %d: %+v", m.Index, m)
+		}
+		if _, err := x509.ParseCertificate(m.DER); err != nil {
+			t.Errorf("Failed to parse DER of entry %d: %v", m.Index, err)
+		}
+		seen[m.Index] = true
+	}
+	if len(seen) != 4 {
+		t.Fatalf("Expected a line for each of entries 0-3, got %v", seen)
+	}
+}
+
+func TestJSONSinkPrecert(t *testing.T) {
+	precert, _ := makePrecertAndFinal(t, newPrecertKey(t), newPrecertTemplate())
+	var buf bytes.Buffer
+	sink := NewJSONSink(&buf)
+	if err := sink.Put(&MatchedEntry{Index: 7, Precert: precert, Labels: []string{"test"}}); err != nil {
+		t.Fatal(err)
+	}
+	var m JSONMatch
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	tbs := precert.TBSCertificate
+	if m.Index != 7 || m.Type != "precert" || m.CommonName != tbs.Subject.CommonName || m.Serial != tbs.SerialNumber.Text(16) {
+		t.Fatalf("Unexpected record: %+v", m)
+	}
+	if len(m.DNSNames) != len(tbs.DNSNames) || !bytes.Equal(m.DER, precert.Raw) || len(m.Labels) != 1 {
+		t.Fatalf("Unexpected record: %+v", m)
+	}
+}
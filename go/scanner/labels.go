@@ -0,0 +1,172 @@
+package scanner
+
+import (
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// Matchers which combine several rules may also implement this interface, to
+// report which of the rules caused an entry to match:
+type Labeller interface {
+	// CertificateLabels is called by the scanner instead of
+	// CertificateMatches. The implementation should return the labels of the
+	// rules which the passed Certificate matches; an empty result means the
+	// Certificate didn't match.
+	CertificateLabels(*x509.Certificate) []string
+
+	// PrecertificateLabels is called by the scanner instead of
+	// PrecertificateMatches, and behaves in the same way as CertificateLabels.
+	PrecertificateLabels(*client.Precertificate) []string
+}
+
+// chainLabeller is a Labeller which can also label Certificates using the
+// chain submitted with them, like a ChainMatcher.
+type chainLabeller interface {
+	Labeller
+	CertificateChainLabels(c *x509.Certificate, chain []*x509.Certificate) []string
+}
+
+// LabelledMatcher is a Matcher with a label identifying it to Labellers such
+// as MatchAny.
+type LabelledMatcher struct {
+	Label string
+	Matcher
+}
+
+// Returns true if the Matcher matches |c|, submitted with |chain|.
+func (l LabelledMatcher) CertificateChainMatches(c *x509.Certificate, chain []*x509.Certificate) bool {
+	return certificateChainMatches(l.Matcher, c, chain)
+}
+
+// ScanFinished passes the end of the scan on to the Matcher, if it's a
+// ScanFinisher.
+func (l LabelledMatcher) ScanFinished() {
+	finishScan(l.Matcher)
+}
+
+// Returns the Matcher to use for the log |log|, with the same label.
+func (l LabelledMatcher) ForLog(log string) Matcher {
+	return l.forLog(log)
+}
+
+func (l LabelledMatcher) forLog(log string) LabelledMatcher {
+	return LabelledMatcher{Label: l.Label, Matcher: matcherForLog(l.Matcher, log)}
+}
+
+// MatchAny is a Matcher which matches Certificates and Precertificates which
+// match any of |Matchers|.
+// It's also a Labeller, reporting the labels of every one of |Matchers| which
+// an entry matched.
+type MatchAny struct {
+	Matchers []LabelledMatcher
+}
+
+// Returns true if any of the Matchers match |c|.
+func (m MatchAny) CertificateMatches(c *x509.Certificate) bool {
+	for _, l := range m.Matchers {
+		if l.CertificateMatches(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if any of the Matchers match |p|.
+func (m MatchAny) PrecertificateMatches(p *client.Precertificate) bool {
+	for _, l := range m.Matchers {
+		if l.PrecertificateMatches(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if any of the Matchers match |c|, submitted with |chain|.
+func (m MatchAny) CertificateChainMatches(c *x509.Certificate, chain []*x509.Certificate) bool {
+	for _, l := range m.Matchers {
+		if l.CertificateChainMatches(c, chain) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanFinished passes the end of the scan on to each of the Matchers which is
+// a ScanFinisher.
+func (m MatchAny) ScanFinished() {
+	for _, l := range m.Matchers {
+		l.ScanFinished()
+	}
+}
+
+// Returns a MatchAny of the Matchers to use for the log |log|, with the same
+// labels.
+func (m MatchAny) ForLog(log string) Matcher {
+	scoped := make([]LabelledMatcher, len(m.Matchers))
+	for i, l := range m.Matchers {
+		scoped[i] = l.forLog(log)
+	}
+	return MatchAny{scoped}
+}
+
+// Returns the labels of each of the Matchers which match |c|, in order.
+func (m MatchAny) CertificateLabels(c *x509.Certificate) []string {
+	var labels []string
+	for _, l := range m.Matchers {
+		if l.CertificateMatches(c) {
+			labels = append(labels, l.Label)
+		}
+	}
+	return labels
+}
+
+// Returns the labels of each of the Matchers which match |c|, submitted with
+// |chain|, in order.
+func (m MatchAny) CertificateChainLabels(c *x509.Certificate, chain []*x509.Certificate) []string {
+	var labels []string
+	for _, l := range m.Matchers {
+		if l.CertificateChainMatches(c, chain) {
+			labels = append(labels, l.Label)
+		}
+	}
+	return labels
+}
+
+// Returns the labels of each of the Matchers which match |p|, in order.
+func (m MatchAny) PrecertificateLabels(p *client.Precertificate) []string {
+	var labels []string
+	for _, l := range m.Matchers {
+		if l.PrecertificateMatches(p) {
+			labels = append(labels, l.Label)
+		}
+	}
+	return labels
+}
+
+// Returns whether |c|, submitted with |chain|, matches the Scanner's Matcher,
+// along with the labels of the matching rules if the Matcher is a Labeller.
+// |chain| is nil if it isn't available.
+func (s *Scanner) matchCertificate(c *x509.Certificate, chain []*x509.Certificate) ([]string, bool) {
+	if l, ok := s.opts.Matcher.(chainLabeller); ok && chain != nil {
+		labels := l.CertificateChainLabels(c, chain)
+		return labels, len(labels) > 0
+	}
+	if l, ok := s.opts.Matcher.(Labeller); ok {
+		labels := l.CertificateLabels(c)
+		return labels, len(labels) > 0
+	}
+	if m, ok := s.opts.Matcher.(ChainMatcher); ok && chain != nil {
+		return nil, m.CertificateChainMatches(c, chain)
+	}
+	return nil, s.opts.Matcher.CertificateMatches(c)
+}
+
+// Returns whether |p| matches the Scanner's Matcher, along with the labels of
+// the matching rules if the Matcher is a Labeller.
+func (s *Scanner) matchPrecertificate(p *client.Precertificate) ([]string, bool) {
+	if l, ok := s.opts.Matcher.(Labeller); ok {
+		labels := l.PrecertificateLabels(p)
+		return labels, len(labels) > 0
+	}
+	return nil, s.opts.Matcher.PrecertificateMatches(p)
+}
@@ -0,0 +1,114 @@
+package scanner
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+func newLabelledTestMatcher() MatchAny {
+	return MatchAny{Matchers: []LabelledMatcher{
+		{Label: "everything", Matcher: MatchAll{}},
+		{Label: "google", Matcher: &MatchSubjectRegex{regexp.MustCompile(`^mail\.google\.com$`), nil}},
+		{Label: "nothing", Matcher: MatchNone{}},
+	}}
+}
+
+func TestMatchAnyLabels(t *testing.T) {
+	m := newLabelledTestMatcher()
+	cert := x509.Certificate{DNSNames: []string{"mail.google.com"}}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchAny failed to match Cert")
+	}
+	if got := fmt.Sprint(m.CertificateLabels(&cert)); got != "[everything google]" {
+		t.Fatalf("Unexpected labels %s", got)
+	}
+	m.Matchers = m.Matchers[2:]
+	if m.CertificateMatches(&cert) || len(m.CertificateLabels(&cert)) != 0 {
+		t.Fatal("MatchAny of MatchNone matched Cert")
+	}
+}
+
+func TestScanLabelledReportsMatchingRules(t *testing.T) {
+	s := NewScanner(newMockLogSource(t), ScannerOptions{
+		Matcher:       newLabelledTestMatcher(),
+		BlockSize:     10,
+		NumWorkers:    2,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	var mu sync.Mutex
+	labels := make(map[int64]string)
+	var google *x509.Certificate
+	err := s.ScanLabelled(func(index int64, c *x509.Certificate, l []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		labels[index] = fmt.Sprint(l)
+		if len(l) == 2 {
+			google = c
+		}
+	}, func(index int64, p *client.Precertificate, l []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		labels[index] = fmt.Sprint(l)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 4 {
+		t.Fatalf("Expected 4 matches, got %v", labels)
+	}
+	var both int
+	for index, l := range labels {
+		switch l {
+		case "[everything google]":
+			both++
+		case "[everything]":
+		default:
+			t.Fatalf("Unexpected labels %s for entry %d", l, index)
+		}
+	}
+	if both != 1 || google.Subject.CommonName != "mail.google.com" {
+		t.Fatalf("Expected only the mail.google.com cert to match both rules, got %v", labels)
+	}
+}
+
+func TestScanSinkPassesLabels(t *testing.T) {
+	s := NewScanner(newMockLogSource(t), ScannerOptions{
+		Matcher: MatchAny{Matchers: []LabelledMatcher{
+			{Label: "google", Matcher: &MatchSubjectRegex{regexp.MustCompile(`^mail\.google\.com$`), nil}},
+		}},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	var sink labelSink
+	if err := s.ScanSink(&sink); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.entries) != 1 || fmt.Sprint(sink.entries[0].Labels) != "[google]" {
+		t.Fatalf("Expected one entry labelled google, got %v", sink.entries)
+	}
+}
+
+// labelSink is a Sink which keeps every entry it's given.
+type labelSink struct {
+	mu      sync.Mutex
+	entries []*MatchedEntry
+}
+
+func (l *labelSink) Put(e *MatchedEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, e)
+	return nil
+}
+
+func (l *labelSink) Close() error {
+	return nil
+}
@@ -0,0 +1,163 @@
+package scanner
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// LintSeverity ranks how serious a lint Finding is.
+type LintSeverity int
+
+const (
+	// Unusual, but not a compliance problem.
+	LintNotice LintSeverity = iota
+	// Likely to be a compliance problem, or deprecated practice.
+	LintWarning
+	// A violation of RFC 5280 or the CA/Browser Forum Baseline Requirements.
+	LintError
+)
+
+func (s LintSeverity) String() string {
+	switch s {
+	case LintNotice:
+		return "Notice"
+	case LintWarning:
+		return "Warning"
+	case LintError:
+		return "Error"
+	}
+	return fmt.Sprintf("LintSeverity(%d)", int(s))
+}
+
+// Finding describes a single problem found by a Linter.
+type Finding struct {
+	// Name of the Linter which produced the Finding
+	Linter   string
+	Severity LintSeverity
+	// Human readable description of the problem
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Severity, f.Linter, f.Message)
+}
+
+// Clients wishing to add their own compliance checks should implement this
+// interface, and pass instances to MatchLintFindings.
+// Lint may be called concurrently from multiple goroutines.
+type Linter interface {
+	// Name returns a short identifier for the check, e.g.
+	// "e_duplicate_extensions".
+	Name() string
+	// Lint returns the problems found in |c|, or nil if there are none.
+	Lint(c *x509.Certificate) []Finding
+}
+
+// matcherLinter is a Linter which reports a single Finding for certificates
+// matched by a Matcher.
+type matcherLinter struct {
+	name     string
+	severity LintSeverity
+	message  string
+	matcher  Matcher
+}
+
+func (l matcherLinter) Name() string {
+	return l.name
+}
+
+func (l matcherLinter) Lint(c *x509.Certificate) []Finding {
+	if !l.matcher.CertificateMatches(c) {
+		return nil
+	}
+	return []Finding{{Linter: l.name, Severity: l.severity, Message: l.message}}
+}
+
+// The built-in Linters, keyed by name.
+var builtinLinters = map[string]Linter{}
+
+// The built-in Linters, ordered by name. Computed once, as MatchLintFindings
+// runs them for every entry.
+var sortedBuiltinLinters []Linter
+
+func init() {
+	for _, l := range []matcherLinter{
+		{"e_duplicate_extensions", LintError, "certificate contains multiple instances of an extension", MatchCertWithDuplicateExtensions{}},
+		{"e_malformed_dns_name", LintError, "dNSName is too long or malformed", MatchCertWithLongSANValue{}},
+		{"e_weak_signature_algorithm", LintError, "certificate is signed with a weak algorithm", MatchWeakSignature{}},
+		{"e_special_use_tld", LintError, "dNSName is under a special-use TLD", MatchTLDCategory{Categories: []TLDCategory{TLDSpecialUse}}},
+		{"w_multiple_common_names", LintWarning, "subject contains more than one commonName", MatchCertWithMultipleCNs{}},
+		{"w_non_utf8_subject", LintWarning, "subject contains a non-UTF-8 or deprecated string type", MatchCertWithNonUTF8Subject{}},
+		{"w_leaf_any_policy", LintWarning, "leaf certificate asserts anyPolicy", MatchCertByCertificatePolicyAnyPolicy{LeafOnly: true}},
+		{"n_rsa_exponent", LintNotice, "RSA public exponent is not 65537", MatchRSAExponent{}},
+	} {
+		builtinLinters[l.name] = l
+	}
+	names := make([]string, 0, len(builtinLinters))
+	for name := range builtinLinters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sortedBuiltinLinters = make([]Linter, len(names))
+	for i, name := range names {
+		sortedBuiltinLinters[i] = builtinLinters[name]
+	}
+}
+
+// Returns the built-in Linters, ordered by name.
+func BuiltinLinters() []Linter {
+	return append([]Linter(nil), sortedBuiltinLinters...)
+}
+
+// Returns the built-in Linter called |name|, if there is one.
+func LinterByName(name string) (Linter, bool) {
+	l, ok := builtinLinters[name]
+	return l, ok
+}
+
+// Runs each of |linters| over |c| and returns all of their Findings.
+func LintCertificate(c *x509.Certificate, linters []Linter) []Finding {
+	var findings []Finding
+	for _, l := range linters {
+		findings = append(findings, l.Lint(c)...)
+	}
+	return findings
+}
+
+// MatchLintFindings is a Matcher which matches Certificates and
+// Precertificates for which any of |Linters| reports a Finding with a
+// severity of at least |MinSeverity|.
+// If |Linters| is nil, all of the BuiltinLinters are run.
+type MatchLintFindings struct {
+	Linters     []Linter
+	MinSeverity LintSeverity
+}
+
+func (m MatchLintFindings) certMatches(c *x509.Certificate) bool {
+	linters := m.Linters
+	if linters == nil {
+		linters = sortedBuiltinLinters
+	}
+	for _, l := range linters {
+		for _, f := range l.Lint(c) {
+			if f.Severity >= m.MinSeverity {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns true if linting |c| yields a sufficiently severe Finding.
+func (m MatchLintFindings) CertificateMatches(c *x509.Certificate) bool {
+	return m.certMatches(c)
+}
+
+// Returns true if linting the TBSCertificate in |p| yields a sufficiently
+// severe Finding.
+func (m MatchLintFindings) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.certMatches(&p.TBSCertificate)
+}
@@ -0,0 +1,92 @@
+package scanner
+
+import (
+	"testing"
+
+	"github.com/google/certificate-transparency/go/asn1"
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+	"github.com/google/certificate-transparency/go/x509/pkix"
+)
+
+func TestBuiltinLintersAreSortedAndNamed(t *testing.T) {
+	linters := BuiltinLinters()
+	if len(linters) == 0 {
+		t.Fatal("No built-in linters")
+	}
+	for i, l := range linters {
+		if i > 0 && linters[i-1].Name() >= l.Name() {
+			t.Fatalf("Built-in linters not sorted: %q before %q", linters[i-1].Name(), l.Name())
+		}
+		if got, ok := LinterByName(l.Name()); !ok || got.Name() != l.Name() {
+			t.Fatalf("LinterByName(%q) failed", l.Name())
+		}
+	}
+	if _, ok := LinterByName("no_such_linter"); ok {
+		t.Fatal("LinterByName found a non-existent linter")
+	}
+}
+
+func TestLintCertificate(t *testing.T) {
+	dup, _ := LinterByName("e_duplicate_extensions")
+	anyPolicy, _ := LinterByName("w_leaf_any_policy")
+	linters := []Linter{dup, anyPolicy}
+
+	var clean x509.Certificate
+	clean.DNSNames = []string{"www.example.com"}
+	clean.SignatureAlgorithm = x509.SHA256WithRSA
+	if findings := LintCertificate(&clean, linters); len(findings) != 0 {
+		t.Fatalf("Expected no findings for compliant cert, got %v", findings)
+	}
+
+	bad := clean
+	bad.Extensions = []pkix.Extension{
+		{Id: asn1.ObjectIdentifier{2, 5, 29, 19}, Value: []byte{0x30, 0x00}},
+		{Id: asn1.ObjectIdentifier{2, 5, 29, 19}, Value: []byte{0x30, 0x00}},
+	}
+	bad.PolicyIdentifiers = []asn1.ObjectIdentifier{{2, 5, 29, 32, 0}}
+	findings := LintCertificate(&bad, linters)
+	if len(findings) != 2 {
+		t.Fatalf("Expected 2 findings, got %v", findings)
+	}
+	if findings[0].Linter != "e_duplicate_extensions" || findings[0].Severity != LintError {
+		t.Fatalf("Unexpected first finding %v", findings[0])
+	}
+	if findings[1].Linter != "w_leaf_any_policy" || findings[1].Severity != LintWarning {
+		t.Fatalf("Unexpected second finding %v", findings[1])
+	}
+}
+
+func TestMatchLintFindings(t *testing.T) {
+	var cert x509.Certificate
+	cert.DNSNames = []string{"www.example.com"}
+	cert.SignatureAlgorithm = x509.SHA256WithRSA
+	cert.PolicyIdentifiers = []asn1.ObjectIdentifier{{2, 5, 29, 32, 0}}
+
+	m := MatchLintFindings{MinSeverity: LintWarning}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchLintFindings failed to match Cert with a warning")
+	}
+	var precert client.Precertificate
+	precert.TBSCertificate = cert
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchLintFindings failed to match Precert with a warning")
+	}
+
+	m.MinSeverity = LintError
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchLintFindings incorrectly matched Cert with only a warning")
+	}
+
+	cert.SignatureAlgorithm = x509.SHA1WithRSA
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchLintFindings failed to match Cert with an error")
+	}
+
+	cert.PolicyIdentifiers = nil
+	cert.SignatureAlgorithm = x509.SHA256WithRSA
+	m.MinSeverity = LintNotice
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchLintFindings incorrectly matched compliant Cert")
+	}
+}
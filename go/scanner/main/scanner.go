@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"io/ioutil"
 	"log"
+	"os"
 	"regexp"
 
 	"github.com/google/certificate-transparency/go/client"
@@ -16,12 +19,16 @@ const (
 )
 
 var logUri = flag.String("log_uri", "http://ct.googleapis.com/aviator", "CT log base URI")
+var logList = flag.String("log_list", "", "Path to a CT log list JSON file; if set, all usable logs in the list are scanned instead of --log_uri")
 var matchSubjectRegex = flag.String("match_subject_regex", ".*", "Regex to match CN/SAN")
 var precertsOnly = flag.Bool("precerts_only", false, "Only match precerts")
 var blockSize = flag.Int("block_size", 1000, "Max number of entries to request at per call to get-entries")
-var numWorkers = flag.Int("num_workers", 2, "Number of concurrent matchers")
-var parallelFetch = flag.Int("parallel_fetch", 2, "Number of concurrent GetEntries fetches")
+var numWorkers = flag.Int("num_workers", scanner.DefaultScannerOptions().NumWorkers, "Number of concurrent matchers")
+var parallelFetch = flag.Int("parallel_fetch", scanner.DefaultScannerOptions().ParallelFetch, "Number of concurrent GetEntries fetches")
 var startIndex = flag.Int64("start_index", 0, "Log index to start scanning at")
+var treeSize = flag.Int64("tree_size", 0, "If non-zero, scan the log as if its tree size were this, rather than that of the latest STH")
+var prioritizeTip = flag.Bool("prioritize_tip", false, "Fetch the newest entries first, interleaved with the oldest, rather than scanning in index order")
+var maxBandwidth = flag.Int64("max_bandwidth", 0, "If non-zero, the maximum rate in bytes per second at which to read responses from the log (or, with --log_list, from all of the logs combined)")
 var quiet = flag.Bool("quiet", false, "Don't print out extra logging messages, only matches.")
 
 // Prints out a short bit of info about |cert|, found at |index| in the
@@ -37,9 +44,24 @@ func logPrecertInfo(index int64, precert *client.Precertificate) {
 		precert.TBSCertificate.Subject.CommonName, precert.TBSCertificate.Issuer.CommonName)
 }
 
+// logSink is a Sink which logs a short bit of info about each matched entry.
+type logSink struct{}
+
+func (logSink) Put(e *scanner.MatchedEntry) error {
+	if e.Cert != nil {
+		logCertInfo(e.Index, e.Cert)
+	} else {
+		logPrecertInfo(e.Index, e.Precert)
+	}
+	return nil
+}
+
+func (logSink) Close() error {
+	return nil
+}
+
 func main() {
 	flag.Parse()
-	logClient := client.New(*logUri)
 	var certRegex *regexp.Regexp
 	precertRegex := regexp.MustCompile(*matchSubjectRegex)
 	switch *precertsOnly {
@@ -57,8 +79,42 @@ func main() {
 		NumWorkers:    *numWorkers,
 		ParallelFetch: *parallelFetch,
 		StartIndex:    *startIndex,
+		TreeSize:      *treeSize,
+		PrioritizeTip: *prioritizeTip,
 		Quiet:         *quiet,
+		Retry:         scanner.DefaultRetryConfig(),
+	}
+	var clientOpts client.LogClientOptions
+	if *maxBandwidth > 0 {
+		// With --log_list, the limit is shared between all of the logs.
+		clientOpts.Bandwidth = client.NewBandwidthLimiter(*maxBandwidth)
+	}
+	if *logList != "" {
+		data, err := ioutil.ReadFile(*logList)
+		if err != nil {
+			log.Fatal(err)
+		}
+		logs, err := client.ParseLogListWithOptions(data, clientOpts, client.LogStateUsable)
+		if _, ok := err.(client.InvalidLogsError); ok {
+			log.Print(err)
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		err = scanner.ScanLogs(logs, opts, func(l *client.LogListEntry, index int64, cert *x509.Certificate) {
+			log.Printf("[%s] Interesting cert at index %d: CN: '%s'", l.Description, index, cert.Subject.CommonName)
+		}, func(l *client.LogListEntry, index int64, precert *client.Precertificate) {
+			log.Printf("[%s] Interesting precert at index %d: CN: '%s' Issuer: %s", l.Description, index,
+				precert.TBSCertificate.Subject.CommonName, precert.TBSCertificate.Issuer.CommonName)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	logClient := client.NewWithOptions(*logUri, clientOpts)
+	s := scanner.NewScanner(logClient, opts)
+	// Ctrl-C stops the scan cleanly, printing a summary.
+	if _, err := scanner.RunWithSignalHandling(context.Background(), s, logSink{}, os.Stderr); err != nil {
+		log.Fatal(err)
 	}
-	scanner := scanner.NewScanner(logClient, opts)
-	scanner.Scan(logCertInfo, logPrecertInfo)
 }
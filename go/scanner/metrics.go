@@ -0,0 +1,47 @@
+package scanner
+
+// Names of the metrics updated by the Scanner.
+const (
+	// Counter of the number of log entries processed
+	MetricCertsProcessed = "certs_processed"
+	// Counter of the number of precertificate entries seen
+	MetricPrecertsSeen = "precerts_seen"
+	// Counter of the number of entries whose certificate couldn't be parsed
+	MetricParseErrors = "parse_errors"
+	// Counter of the number of entries whose MerkleTreeLeaf couldn't be parsed
+	MetricMalformedLeaves = "malformed_leaves"
+	// Counter of the number of entries which parsed with non-fatal errors
+	MetricNonFatalErrors = "non_fatal_errors"
+	// Counter of the number of leaf bytes fetched from the log
+	MetricBytesFetched = "bytes_fetched"
+	// Gauge of the index of the lowest log entry not yet processed
+	MetricCurrentIndex = "current_index"
+	// Counter of the number of matched entries dropped by a slow Sink
+	MetricDroppedMatches = "dropped_matches"
+	// Counter of the number of matched entries spilled to disk by a slow Sink
+	MetricSpilledMatches = "spilled_matches"
+	// Gauge of the number of matcher workers, when ScannerOptions.AutoScaleWorkers is set
+	MetricMatcherWorkers = "matcher_workers"
+	// Counter of the number of ranges of entries abandoned after repeated
+	// fetch failures (see ScannerOptions.Retry)
+	MetricFailedRanges = "failed_ranges"
+)
+
+// Clients wishing to export scanner metrics (e.g. to Prometheus) should
+// implement this interface, and set it in ScannerOptions.Metrics.
+// Methods may be called concurrently from multiple goroutines.
+type MetricsRegistrar interface {
+	// Inc increments the counter |name| by one.
+	Inc(name string)
+	// Add increments the counter |name| by |delta|.
+	Add(name string, delta float64)
+	// Set sets the gauge |name| to |value|.
+	Set(name string, value float64)
+}
+
+// nullMetrics is a MetricsRegistrar which discards all updates.
+type nullMetrics struct{}
+
+func (nullMetrics) Inc(string)          {}
+func (nullMetrics) Add(string, float64) {}
+func (nullMetrics) Set(string, float64) {}
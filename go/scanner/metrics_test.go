@@ -0,0 +1,72 @@
+package scanner
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// stubRegistrar is a MetricsRegistrar which records the total of each counter,
+// and the highest value each gauge was set to.
+type stubRegistrar struct {
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newStubRegistrar() *stubRegistrar {
+	return &stubRegistrar{values: make(map[string]float64)}
+}
+
+func (r *stubRegistrar) Inc(name string) {
+	r.Add(name, 1)
+}
+
+func (r *stubRegistrar) Add(name string, delta float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values[name] += delta
+}
+
+func (r *stubRegistrar) Set(name string, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if value > r.values[name] {
+		r.values[name] = value
+	}
+}
+
+func TestScannerUpdatesMetrics(t *testing.T) {
+	ts := newFourEntryLogServer(t)
+	defer ts.Close()
+
+	metrics := newStubRegistrar()
+	scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+		Metrics:       metrics,
+	})
+	err := scanner.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := metrics.values[MetricCertsProcessed]; got != 4 {
+		t.Errorf("Expected %s to be 4, got %v", MetricCertsProcessed, got)
+	}
+	if got := metrics.values[MetricPrecertsSeen]; got != 0 {
+		t.Errorf("Expected %s to be 0, got %v", MetricPrecertsSeen, got)
+	}
+	if got := metrics.values[MetricParseErrors]; got != 0 {
+		t.Errorf("Expected %s to be 0, got %v", MetricParseErrors, got)
+	}
+	if got := metrics.values[MetricBytesFetched]; got <= 0 {
+		t.Errorf("Expected %s to be positive, got %v", MetricBytesFetched, got)
+	}
+	if got := metrics.values[MetricCurrentIndex]; got != 4 {
+		t.Errorf("Expected %s to reach 4, got %v", MetricCurrentIndex, got)
+	}
+}
@@ -0,0 +1,178 @@
+package scanner
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// Default bound on the number of precertificates and of final certificates
+// tracked by MatchCertByPrecertWithoutCorrespondingFinal.
+const defaultMaxOrphanEntries = 1000000
+
+// OrphanPrecert describes a Precertificate for which no final certificate
+// was seen by MatchCertByPrecertWithoutCorrespondingFinal.
+type OrphanPrecert struct {
+	// SHA-256 hash of the TBSCertificate without the poison extension, which
+	// is the same as that of the final certificate without its SCT list.
+	TBSHash      [sha256.Size]byte
+	SerialNumber *big.Int
+	Subject      string
+	Issuer       string
+	DNSNames     []string
+	// When the Precertificate was seen by the Matcher
+	Seen time.Time
+}
+
+// MatchCertByPrecertWithoutCorrespondingFinal is a stateful Matcher which
+// records the identity of each Precertificate and final Certificate it sees,
+// and reports, via |Callback|, each Precertificate for which no final
+// Certificate with the same TBSCertificate (see PrecertMatchesFinal) was seen
+// within |Window| of it. This may indicate that a CA logged a precertificate
+// but never issued, or never logged, the final certificate.
+//
+// The Matcher never matches entries itself, since whether a Precertificate is
+// an orphan is only known later. Instead, orphans are reported at the end of
+// each scan (see ScanFinisher) once |Window| has passed since they were seen,
+// so that an instance can be reused across scans, e.g. by ScanContinuous, to
+// find finals logged some time after their precertificates. A final seen
+// before its Precertificate counts too, if it was seen within |Window|.
+//
+// Memory and time bounds: the hash and a summary of each Precertificate are
+// held until its final is seen or it's reported, and the hash of each
+// Certificate for |Window| after it was seen, up to |MaxEntries| of each
+// (defaultMaxOrphanEntries if <= 0). Further entries are ignored once a limit
+// is reached, which may cause false reports, so |Window| should be chosen in
+// proportion to the rate of the logs being scanned. Computing each identity
+// requires re-parsing the TBSCertificate's top level structure.
+//
+// Use NewMatchCertByPrecertWithoutCorrespondingFinal to create instances of
+// this Matcher.
+type MatchCertByPrecertWithoutCorrespondingFinal struct {
+	Window     time.Duration
+	Callback   func(OrphanPrecert)
+	MaxEntries int
+	// Returns the current time; time.Now if nil.
+	Now func() time.Time
+
+	mu       sync.Mutex
+	precerts map[[sha256.Size]byte]*OrphanPrecert
+	finals   map[[sha256.Size]byte]time.Time
+}
+
+// Creates a new MatchCertByPrecertWithoutCorrespondingFinal which reports
+// Precertificates whose final isn't seen within |window| to |callback|.
+func NewMatchCertByPrecertWithoutCorrespondingFinal(window time.Duration, callback func(OrphanPrecert)) *MatchCertByPrecertWithoutCorrespondingFinal {
+	return &MatchCertByPrecertWithoutCorrespondingFinal{
+		Window:   window,
+		Callback: callback,
+		precerts: make(map[[sha256.Size]byte]*OrphanPrecert),
+		finals:   make(map[[sha256.Size]byte]time.Time),
+	}
+}
+
+func (m *MatchCertByPrecertWithoutCorrespondingFinal) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+	return time.Now()
+}
+
+func (m *MatchCertByPrecertWithoutCorrespondingFinal) maxEntries() int {
+	if m.MaxEntries <= 0 {
+		return defaultMaxOrphanEntries
+	}
+	return m.MaxEntries
+}
+
+// Records |c| as a final certificate, resolving any pending Precertificate.
+// Always returns false.
+func (m *MatchCertByPrecertWithoutCorrespondingFinal) CertificateMatches(c *x509.Certificate) bool {
+	tbs, err := tbsWithoutExtension(c.RawTBSCertificate, oidExtensionSCTList)
+	if err != nil {
+		return false
+	}
+	hash := sha256.Sum256(tbs)
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.precerts[hash]; ok {
+		delete(m.precerts, hash)
+		return false
+	}
+	if _, ok := m.finals[hash]; ok || len(m.finals) < m.maxEntries() {
+		m.finals[hash] = now
+	}
+	return false
+}
+
+// Records |p| as pending, unless its final certificate has already been seen.
+// Always returns false.
+func (m *MatchCertByPrecertWithoutCorrespondingFinal) PrecertificateMatches(p *client.Precertificate) bool {
+	rawTBS := p.TBSCertificate.RawTBSCertificate
+	if len(rawTBS) == 0 {
+		rawTBS = p.Raw
+	}
+	tbs, err := tbsWithoutExtension(rawTBS, oidExtensionCTPoison)
+	if err != nil {
+		return false
+	}
+	hash := sha256.Sum256(tbs)
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.finals[hash]; ok {
+		return false
+	}
+	if _, ok := m.precerts[hash]; ok || len(m.precerts) >= m.maxEntries() {
+		return false
+	}
+	c := &p.TBSCertificate
+	m.precerts[hash] = &OrphanPrecert{
+		TBSHash:      hash,
+		SerialNumber: c.SerialNumber,
+		Subject:      c.Subject.CommonName,
+		Issuer:       c.Issuer.CommonName,
+		DNSNames:     c.DNSNames,
+		Seen:         now,
+	}
+	return false
+}
+
+// Returns, and forgets, the Precertificates seen more than |Window| ago whose
+// final hasn't been seen, and forgets the final certificates seen more than
+// |Window| ago.
+func (m *MatchCertByPrecertWithoutCorrespondingFinal) Expire() []OrphanPrecert {
+	cutoff := m.now().Add(-m.Window)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var orphans []OrphanPrecert
+	for hash, p := range m.precerts {
+		if p.Seen.Before(cutoff) {
+			orphans = append(orphans, *p)
+			delete(m.precerts, hash)
+		}
+	}
+	for hash, seen := range m.finals {
+		if seen.Before(cutoff) {
+			delete(m.finals, hash)
+		}
+	}
+	return orphans
+}
+
+// ScanFinished reports each expired orphan Precertificate to Callback (see
+// Expire). If Callback is nil, orphans are left to be collected by calling
+// Expire.
+func (m *MatchCertByPrecertWithoutCorrespondingFinal) ScanFinished() {
+	if m.Callback == nil {
+		return
+	}
+	for _, o := range m.Expire() {
+		m.Callback(o)
+	}
+}
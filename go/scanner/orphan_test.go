@@ -0,0 +1,73 @@
+package scanner
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestMatchCertByPrecertWithoutCorrespondingFinal(t *testing.T) {
+	key := newPrecertKey(t)
+	issuedTmpl := newPrecertTemplate()
+	issuedPrecert, issuedFinal := makePrecertAndFinal(t, key, issuedTmpl)
+	orphanTmpl := newPrecertTemplate()
+	orphanTmpl.SerialNumber = big.NewInt(43)
+	orphanPrecert, _ := makePrecertAndFinal(t, key, orphanTmpl)
+	earlyTmpl := newPrecertTemplate()
+	earlyTmpl.SerialNumber = big.NewInt(44)
+	earlyPrecert, earlyFinal := makePrecertAndFinal(t, key, earlyTmpl)
+
+	now := time.Date(2016, time.June, 1, 0, 0, 0, 0, time.UTC)
+	var orphans []OrphanPrecert
+	m := NewMatchCertByPrecertWithoutCorrespondingFinal(time.Hour, func(o OrphanPrecert) {
+		orphans = append(orphans, o)
+	})
+	m.Now = func() time.Time { return now }
+
+	// A final seen before its precert resolves it too.
+	if m.CertificateMatches(earlyFinal) {
+		t.Fatal("MatchCertByPrecertWithoutCorrespondingFinal incorrectly matched Cert")
+	}
+	m.PrecertificateMatches(issuedPrecert)
+	m.PrecertificateMatches(orphanPrecert)
+	m.PrecertificateMatches(earlyPrecert)
+
+	now = now.Add(30 * time.Minute)
+	m.CertificateMatches(issuedFinal)
+	m.ScanFinished()
+	if len(orphans) != 0 {
+		t.Fatalf("Expected no orphans within the window, got %v", orphans)
+	}
+
+	now = now.Add(time.Hour)
+	m.ScanFinished()
+	if len(orphans) != 1 || orphans[0].SerialNumber.Cmp(big.NewInt(43)) != 0 {
+		t.Fatalf("Expected only the precert without a final to be reported, got %v", orphans)
+	}
+	if orphans[0].Subject != "www.example.com" || len(orphans[0].DNSNames) != 1 {
+		t.Errorf("Orphan missing details: %+v", orphans[0])
+	}
+
+	// Orphans are only reported once.
+	now = now.Add(time.Hour)
+	m.ScanFinished()
+	if len(orphans) != 1 {
+		t.Fatalf("Expected the orphan to be reported once, got %v", orphans)
+	}
+}
+
+func TestMatchCertByPrecertWithoutCorrespondingFinalBoundsMemory(t *testing.T) {
+	key := newPrecertKey(t)
+	m := NewMatchCertByPrecertWithoutCorrespondingFinal(time.Hour, nil)
+	m.MaxEntries = 2
+	for i := int64(0); i < 4; i++ {
+		tmpl := newPrecertTemplate()
+		tmpl.SerialNumber = big.NewInt(i)
+		precert, final := makePrecertAndFinal(t, key, tmpl)
+		m.PrecertificateMatches(precert)
+		m.CertificateMatches(final)
+	}
+	if len(m.precerts) > 2 || len(m.finals) > 2 {
+		t.Fatalf("Expected at most 2 tracked entries of each kind, got %d precerts and %d finals", len(m.precerts), len(m.finals))
+	}
+}
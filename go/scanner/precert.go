@@ -0,0 +1,238 @@
+package scanner
+
+import (
+	"bytes"
+
+	"github.com/google/certificate-transparency/go/asn1"
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+	"github.com/google/certificate-transparency/go/x509/pkix"
+)
+
+// OID of the critical "poison" extension which marks a Precertificate
+// (RFC 6962 section 3.1)
+var oidExtensionCTPoison = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+
+// Context-specific tag of the extensions field of a TBSCertificate
+const tbsExtensionsTag = 3
+
+// Returns the DER encoding of the TBSCertificate |rawTBS| with any extension
+// with OID |oid| removed. The remaining fields and extensions keep their
+// original encodings.
+func tbsWithoutExtension(rawTBS []byte, oid asn1.ObjectIdentifier) ([]byte, error) {
+	var tbs asn1.RawValue
+	if rest, err := asn1.Unmarshal(rawTBS, &tbs); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, asn1.SyntaxError{Msg: "trailing data after TBSCertificate"}
+	}
+	var out bytes.Buffer
+	for fields := tbs.Bytes; len(fields) > 0; {
+		var field asn1.RawValue
+		var err error
+		if fields, err = asn1.Unmarshal(fields, &field); err != nil {
+			return nil, err
+		}
+		if field.Class != 2 || field.Tag != tbsExtensionsTag {
+			out.Write(field.FullBytes)
+			continue
+		}
+		var exts asn1.RawValue
+		if _, err := asn1.Unmarshal(field.Bytes, &exts); err != nil {
+			return nil, err
+		}
+		var kept bytes.Buffer
+		for rest := exts.Bytes; len(rest) > 0; {
+			var raw asn1.RawValue
+			if rest, err = asn1.Unmarshal(rest, &raw); err != nil {
+				return nil, err
+			}
+			var ext pkix.Extension
+			if _, err := asn1.Unmarshal(raw.FullBytes, &ext); err != nil {
+				return nil, err
+			}
+			if !ext.Id.Equal(oid) {
+				kept.Write(raw.FullBytes)
+			}
+		}
+		if kept.Len() == 0 {
+			continue
+		}
+		seq, err := asn1.Marshal(asn1.RawValue{Tag: 16, IsCompound: true, Bytes: kept.Bytes()})
+		if err != nil {
+			return nil, err
+		}
+		explicit, err := asn1.Marshal(asn1.RawValue{Class: 2, Tag: tbsExtensionsTag, IsCompound: true, Bytes: seq})
+		if err != nil {
+			return nil, err
+		}
+		out.Write(explicit)
+	}
+	return asn1.Marshal(asn1.RawValue{Tag: 16, IsCompound: true, Bytes: out.Bytes()})
+}
+
+// PrecertMatchesFinal returns true if |final| is the certificate issued for
+// |precert|, i.e. if the TBSCertificate of |final| without its embedded SCT
+// list extension is identical to the TBSCertificate of |precert| without its
+// poison extension (RFC 6962 section 3.1).
+//
+// The TBSCertificate logged for a Precertificate already has the poison
+// extension removed (and, if it was issued by a Precertificate Signing
+// Certificate, the issuer rewritten to that of the final certificate), so
+// it's normally compared as is.
+// Returns false if either TBSCertificate can't be parsed.
+func PrecertMatchesFinal(precert *client.Precertificate, final *x509.Certificate) bool {
+	rawPrecertTBS := precert.TBSCertificate.RawTBSCertificate
+	if len(rawPrecertTBS) == 0 {
+		rawPrecertTBS = precert.Raw
+	}
+	precertTBS, err := tbsWithoutExtension(rawPrecertTBS, oidExtensionCTPoison)
+	if err != nil {
+		return false
+	}
+	finalTBS, err := tbsWithoutExtension(final.RawTBSCertificate, oidExtensionSCTList)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(precertTBS, finalTBS)
+}
+
+// OID of the extended key usage which marks a Precertificate Signing
+// Certificate (RFC 6962 section 3.1)
+var oidExtKeyUsageCTPrecertSigning = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 4}
+
+// Parses the extra_data of a precert entry, returning the DER of the
+// precertificate as submitted, and its chain.
+func parsePrecertChain(extraData []byte) ([]byte, []*x509.Certificate, error) {
+	submitted, rawChain, err := client.ParsePrecertChainEntry(extraData)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, err := parseChain(rawChain)
+	if err != nil {
+		return nil, nil, err
+	}
+	return submitted, chain, nil
+}
+
+// Parses the chain from the extra_data of an X509 entry.
+func parseCertChain(extraData []byte) ([]*x509.Certificate, error) {
+	rawChain, err := client.ParseCertificateChain(extraData)
+	if err != nil {
+		return nil, err
+	}
+	return parseChain(rawChain)
+}
+
+func parseChain(rawChain [][]byte) ([]*x509.Certificate, error) {
+	chain := make([]*x509.Certificate, len(rawChain))
+	for i, der := range rawChain {
+		c, err := x509.ParseCertificate(der)
+		if _, ok := err.(x509.NonFatalErrors); err != nil && !ok {
+			return nil, err
+		}
+		chain[i] = c
+	}
+	return chain, nil
+}
+
+// Returns true if |c| carries the Precertificate Signing Certificate extended
+// key usage.
+func isPrecertSigningCert(c *x509.Certificate) bool {
+	for _, eku := range c.UnknownExtKeyUsage {
+		if eku.Equal(oidExtKeyUsageCTPrecertSigning) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrecertSigner identifies how a Precertificate was signed.
+type PrecertSigner int
+
+const (
+	// Signed directly by the CA which will issue the final certificate.
+	PrecertSignedByCA PrecertSigner = iota + 1
+	// Signed by a dedicated Precertificate Signing Certificate, carrying the
+	// precert signing extended key usage and issued by that CA.
+	PrecertSignedBySigningCert
+)
+
+// MatchCertByPrecertSigningCertUsage is a Matcher which matches
+// Precertificates signed in the way given by |Signer|, as determined from the
+// extended key usage of the immediate signer in their IssuerChain.
+// Precertificates are only matched if their chain is available, which
+// requires ScannerOptions.FetchChains. Certificates are never matched.
+type MatchCertByPrecertSigningCertUsage struct {
+	Signer PrecertSigner
+}
+
+// Always returns false.
+func (m MatchCertByPrecertSigningCertUsage) CertificateMatches(c *x509.Certificate) bool {
+	return false
+}
+
+// Returns true if |p| was signed in the way given by |Signer|.
+func (m MatchCertByPrecertSigningCertUsage) PrecertificateMatches(p *client.Precertificate) bool {
+	if len(p.IssuerChain) == 0 {
+		return false
+	}
+	switch m.Signer {
+	case PrecertSignedByCA:
+		return !isPrecertSigningCert(p.IssuerChain[0])
+	case PrecertSignedBySigningCert:
+		return isPrecertSigningCert(p.IssuerChain[0])
+	}
+	return false
+}
+
+// MatchCertBySignatureValidityAgainstIssuer is a Matcher which matches
+// entries whose signature doesn't verify against the public key of the
+// immediate issuer in their submitted chain.
+// For Certificates the issuer is the first certificate in the chain. For
+// Precertificates, the submitted precertificate is checked against the first
+// certificate in its IssuerChain, which is either the CA or a Precertificate
+// Signing Certificate; in the latter case the signing certificate is also
+// checked against the CA which issued it.
+// Entries are only matched if their chain is available, which requires
+// ScannerOptions.FetchChains. Signatures using algorithms which the x509
+// package can't verify never match.
+type MatchCertBySignatureValidityAgainstIssuer struct{}
+
+// Returns true if |c| isn't validly signed by |issuer|.
+func signatureInvalid(c, issuer *x509.Certificate) bool {
+	err := c.CheckSignatureFrom(issuer)
+	return err != nil && err != x509.ErrUnsupportedAlgorithm
+}
+
+// Always returns false, as the chain is needed; see CertificateChainMatches.
+func (m MatchCertBySignatureValidityAgainstIssuer) CertificateMatches(c *x509.Certificate) bool {
+	return false
+}
+
+// Returns true if |c| isn't validly signed by the first certificate in
+// |chain|.
+func (m MatchCertBySignatureValidityAgainstIssuer) CertificateChainMatches(c *x509.Certificate, chain []*x509.Certificate) bool {
+	if len(chain) == 0 {
+		return false
+	}
+	return signatureInvalid(c, chain[0])
+}
+
+// Returns true if the precertificate submitted for |p| isn't validly signed
+// by its signer, or the signer is a Precertificate Signing Certificate which
+// isn't validly signed by its CA.
+func (m MatchCertBySignatureValidityAgainstIssuer) PrecertificateMatches(p *client.Precertificate) bool {
+	if len(p.IssuerChain) == 0 || p.SubmittedRaw == nil {
+		return false
+	}
+	submitted, err := x509.ParseCertificate(p.SubmittedRaw)
+	if _, ok := err.(x509.NonFatalErrors); err != nil && !ok {
+		return false
+	}
+	signer := p.IssuerChain[0]
+	if signatureInvalid(submitted, signer) {
+		return true
+	}
+	return isPrecertSigningCert(signer) && len(p.IssuerChain) > 1 && signatureInvalid(signer, p.IssuerChain[1])
+}
@@ -0,0 +1,388 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency/go/asn1"
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+	"github.com/google/certificate-transparency/go/x509/pkix"
+)
+
+// Returns a final certificate with an embedded SCT list, and the
+// corresponding Precertificate as it would appear in a log, i.e. with the
+// poison extension. Both are self-signed by |key| from |template|.
+func makePrecertAndFinal(t *testing.T, key *ecdsa.PrivateKey, template x509.Certificate) (*client.Precertificate, *x509.Certificate) {
+	issue := func(ext pkix.Extension) *x509.Certificate {
+		tmpl := template
+		tmpl.ExtraExtensions = []pkix.Extension{ext}
+		der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := x509.ParseCertificate(der)
+		if _, ok := err.(x509.NonFatalErrors); err != nil && !ok {
+			t.Fatal(err)
+		}
+		return c
+	}
+	poisoned := issue(pkix.Extension{Id: oidExtensionCTPoison, Critical: true, Value: []byte{0x05, 0x00}})
+	final := issue(pkix.Extension{Id: oidExtensionSCTList, Value: []byte{0x04, 0x02, 0x00, 0x00}})
+	precert := &client.Precertificate{Raw: poisoned.RawTBSCertificate, TBSCertificate: *poisoned}
+	return precert, final
+}
+
+func newPrecertKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newPrecertTemplate() x509.Certificate {
+	return x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "www.example.com"},
+		NotBefore:    time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:     []string{"www.example.com"},
+	}
+}
+
+func TestPrecertMatchesFinal(t *testing.T) {
+	precert, final := makePrecertAndFinal(t, newPrecertKey(t), newPrecertTemplate())
+	if !PrecertMatchesFinal(precert, final) {
+		t.Fatal("PrecertMatchesFinal failed to match corresponding precert and final cert")
+	}
+
+	// The logged TBSCertificate, without the poison, must match too.
+	logged, err := tbsWithoutExtension(precert.TBSCertificate.RawTBSCertificate, oidExtensionCTPoison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !PrecertMatchesFinal(&client.Precertificate{Raw: logged}, final) {
+		t.Fatal("PrecertMatchesFinal failed to match logged precert and final cert")
+	}
+}
+
+func TestPrecertMatchesFinalDetectsTampering(t *testing.T) {
+	key := newPrecertKey(t)
+	precert, _ := makePrecertAndFinal(t, key, newPrecertTemplate())
+	tampered := newPrecertTemplate()
+	tampered.DNSNames = append(tampered.DNSNames, "evil.example.com")
+	_, final := makePrecertAndFinal(t, key, tampered)
+	if PrecertMatchesFinal(precert, final) {
+		t.Fatal("PrecertMatchesFinal matched precert with final cert for different names")
+	}
+
+	final.RawTBSCertificate = []byte{0x30, 0x01}
+	if PrecertMatchesFinal(precert, final) {
+		t.Fatal("PrecertMatchesFinal matched unparsable final cert")
+	}
+}
+
+// Returns a MerkleTreeLeaf holding a precert with the DER encoded |tbs|.
+func makePrecertLeaf(tbs []byte) client.LeafInput {
+	var buf bytes.Buffer
+	buf.Write([]byte{byte(client.V1), byte(client.TimestampedEntryLeafType)})
+	binary.Write(&buf, binary.BigEndian, uint64(1000))
+	binary.Write(&buf, binary.BigEndian, client.LogEntryType(client.PrecertLogEntryType))
+	buf.Write(make([]byte, 32))
+	buf.Write([]byte{byte(len(tbs) >> 16), byte(len(tbs) >> 8), byte(len(tbs))})
+	buf.Write(tbs)
+	buf.Write([]byte{0, 0})
+	return buf.Bytes()
+}
+
+// Returns a serialized PrecertChainEntry holding |precert| and |chain|.
+func makePrecertChainEntry(precert []byte, chain ...[]byte) []byte {
+	writeCert := func(buf *bytes.Buffer, der []byte) {
+		buf.Write([]byte{byte(len(der) >> 16), byte(len(der) >> 8), byte(len(der))})
+		buf.Write(der)
+	}
+	var list bytes.Buffer
+	for _, der := range chain {
+		writeCert(&list, der)
+	}
+	var buf bytes.Buffer
+	writeCert(&buf, precert)
+	writeCert(&buf, list.Bytes())
+	return buf.Bytes()
+}
+
+// Returns the DER of a self-signed CA certificate, which is a Precertificate
+// Signing Certificate if |precertSigning| is set.
+func makeSignerCert(t *testing.T, precertSigning bool) []byte {
+	key := newPrecertKey(t)
+	tmpl := newPrecertTemplate()
+	tmpl.BasicConstraintsValid = true
+	tmpl.IsCA = true
+	if precertSigning {
+		tmpl.UnknownExtKeyUsage = []asn1.ObjectIdentifier{oidExtKeyUsageCTPrecertSigning}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// chainLogSource is a LogSource which also serves extra_data.
+type chainLogSource struct {
+	entries []client.RawLogEntry
+}
+
+func (c *chainLogSource) GetSTH() (*client.SignedTreeHead, error) {
+	return c.GetSTHCtx(context.Background())
+}
+
+func (c *chainLogSource) GetSTHCtx(ctx context.Context) (*client.SignedTreeHead, error) {
+	return &client.SignedTreeHead{TreeSize: uint64(len(c.entries))}, nil
+}
+
+func (c *chainLogSource) GetEntries(start, end int64) ([]client.LeafInput, error) {
+	return c.GetEntriesCtx(context.Background(), start, end)
+}
+
+func (c *chainLogSource) GetEntriesCtx(ctx context.Context, start, end int64) ([]client.LeafInput, error) {
+	var leaves []client.LeafInput
+	for _, e := range c.entries[start : end+1] {
+		leaves = append(leaves, e.LeafInput)
+	}
+	return leaves, nil
+}
+
+func (c *chainLogSource) GetRawEntriesCtx(ctx context.Context, start, end int64) ([]client.RawLogEntry, error) {
+	return c.entries[start : end+1], nil
+}
+
+func TestScannerMatchCertByPrecertSigningCertUsage(t *testing.T) {
+	caSigned, signingCertSigned := MatchCertByPrecertSigningCertUsage{PrecertSignedByCA}, MatchCertByPrecertSigningCertUsage{PrecertSignedBySigningCert}
+	var precert client.Precertificate
+	if caSigned.PrecertificateMatches(&precert) || signingCertSigned.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertByPrecertSigningCertUsage matched Precert without a chain")
+	}
+
+	precert.IssuerChain = []*x509.Certificate{{IsCA: true, BasicConstraintsValid: true}}
+	if !caSigned.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertByPrecertSigningCertUsage failed to match CA-signed Precert")
+	}
+	if signingCertSigned.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertByPrecertSigningCertUsage incorrectly matched CA-signed Precert as signing cert signed")
+	}
+
+	precert.IssuerChain = []*x509.Certificate{
+		{UnknownExtKeyUsage: []asn1.ObjectIdentifier{oidExtKeyUsageCTPrecertSigning}},
+		{IsCA: true, BasicConstraintsValid: true},
+	}
+	if caSigned.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertByPrecertSigningCertUsage incorrectly matched signing cert signed Precert as CA-signed")
+	}
+	if !signingCertSigned.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertByPrecertSigningCertUsage failed to match signing cert signed Precert")
+	}
+
+	if caSigned.CertificateMatches(&x509.Certificate{}) {
+		t.Fatal("MatchCertByPrecertSigningCertUsage incorrectly matched Cert")
+	}
+}
+
+func TestScannerFetchChains(t *testing.T) {
+	precert, _ := makePrecertAndFinal(t, newPrecertKey(t), newPrecertTemplate())
+	leaf := makePrecertLeaf(precert.Raw)
+	source := &chainLogSource{entries: []client.RawLogEntry{
+		{LeafInput: leaf, ExtraData: makePrecertChainEntry(precert.TBSCertificate.Raw, makeSignerCert(t, false))},
+		{LeafInput: leaf, ExtraData: makePrecertChainEntry(precert.TBSCertificate.Raw, makeSignerCert(t, true), makeSignerCert(t, false))},
+	}}
+	for _, fetchChains := range []bool{false, true} {
+		s := NewScanner(source, ScannerOptions{
+			Matcher:       MatchCertByPrecertSigningCertUsage{PrecertSignedBySigningCert},
+			BlockSize:     10,
+			NumWorkers:    1,
+			ParallelFetch: 1,
+			Quiet:         true,
+			FetchChains:   fetchChains,
+		})
+		var matches []int64
+		err := s.Scan(func(int64, *x509.Certificate) {
+			t.Error("Unexpected Cert match")
+		}, func(index int64, p *client.Precertificate) {
+			matches = append(matches, index)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fetchChains && (len(matches) != 1 || matches[0] != 1) {
+			t.Fatalf("Expected only entry 1 to match, got %v", matches)
+		}
+		if !fetchChains && len(matches) != 0 {
+			t.Fatalf("Expected no matches without FetchChains, got %v", matches)
+		}
+	}
+}
+
+// Returns the certificate issued from |tmpl| for |key| by |parent| with
+// |parentKey|; it's self-signed if |parent| is nil.
+func issueTestCert(t *testing.T, tmpl x509.Certificate, key *ecdsa.PrivateKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+	if parent == nil {
+		parent, parentKey = &tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if _, ok := err.(x509.NonFatalErrors); err != nil && !ok {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Returns a self-signed CA certificate and its key.
+func makeTestCA(t *testing.T, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key := newPrecertKey(t)
+	tmpl := newPrecertTemplate()
+	tmpl.Subject = pkix.Name{CommonName: name}
+	tmpl.BasicConstraintsValid = true
+	tmpl.IsCA = true
+	return issueTestCert(t, tmpl, key, nil, nil), key
+}
+
+// Returns a copy of |c| whose DER has a corrupted signature.
+func tamperSignature(t *testing.T, c *x509.Certificate) *x509.Certificate {
+	der := append([]byte{}, c.Raw...)
+	der[len(der)-1] ^= 0xff
+	tampered, err := x509.ParseCertificate(der)
+	if _, ok := err.(x509.NonFatalErrors); err != nil && !ok {
+		t.Fatal(err)
+	}
+	return tampered
+}
+
+func TestScannerMatchCertBySignatureValidityAgainstIssuer(t *testing.T) {
+	m := MatchCertBySignatureValidityAgainstIssuer{}
+	ca, caKey := makeTestCA(t, "CA")
+	otherCA, _ := makeTestCA(t, "Other CA")
+	leaf := issueTestCert(t, newPrecertTemplate(), newPrecertKey(t), ca, caKey)
+
+	if m.CertificateChainMatches(leaf, []*x509.Certificate{ca}) {
+		t.Fatal("MatchCertBySignatureValidityAgainstIssuer incorrectly matched validly signed Cert")
+	}
+	if !m.CertificateChainMatches(tamperSignature(t, leaf), []*x509.Certificate{ca}) {
+		t.Fatal("MatchCertBySignatureValidityAgainstIssuer failed to match Cert with tampered signature")
+	}
+	if !m.CertificateChainMatches(leaf, []*x509.Certificate{otherCA}) {
+		t.Fatal("MatchCertBySignatureValidityAgainstIssuer failed to match Cert signed by a different issuer")
+	}
+	if m.CertificateChainMatches(leaf, nil) || m.CertificateMatches(leaf) {
+		t.Fatal("MatchCertBySignatureValidityAgainstIssuer matched Cert without a chain")
+	}
+
+	poisoned := newPrecertTemplate()
+	poisoned.ExtraExtensions = []pkix.Extension{{Id: oidExtensionCTPoison, Critical: true, Value: []byte{0x05, 0x00}}}
+	submitted := issueTestCert(t, poisoned, newPrecertKey(t), ca, caKey)
+	precert := client.Precertificate{SubmittedRaw: submitted.Raw, IssuerChain: []*x509.Certificate{ca}}
+	if m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertBySignatureValidityAgainstIssuer incorrectly matched validly signed Precert")
+	}
+	precert.SubmittedRaw = tamperSignature(t, submitted).Raw
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertBySignatureValidityAgainstIssuer failed to match Precert with tampered signature")
+	}
+
+	// A Precertificate signed by a Precertificate Signing Certificate must
+	// verify against it, and it against the CA.
+	signingTmpl := newPrecertTemplate()
+	signingTmpl.Subject = pkix.Name{CommonName: "CA Precertificate Signing"}
+	signingTmpl.BasicConstraintsValid = true
+	signingTmpl.IsCA = true
+	signingTmpl.UnknownExtKeyUsage = []asn1.ObjectIdentifier{oidExtKeyUsageCTPrecertSigning}
+	signingKey := newPrecertKey(t)
+	signing := issueTestCert(t, signingTmpl, signingKey, ca, caKey)
+	submitted = issueTestCert(t, poisoned, newPrecertKey(t), signing, signingKey)
+	precert = client.Precertificate{SubmittedRaw: submitted.Raw, IssuerChain: []*x509.Certificate{signing, ca}}
+	if m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertBySignatureValidityAgainstIssuer incorrectly matched Precert validly signed by a signing cert")
+	}
+	precert.IssuerChain = []*x509.Certificate{signing, otherCA}
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertBySignatureValidityAgainstIssuer failed to match Precert whose signing cert isn't signed by the CA")
+	}
+
+	if m.PrecertificateMatches(&client.Precertificate{}) {
+		t.Fatal("MatchCertBySignatureValidityAgainstIssuer matched Precert without a chain")
+	}
+}
+
+// Returns a MerkleTreeLeaf holding an X509 entry with the DER encoded |der|.
+func makeX509Leaf(der []byte) client.LeafInput {
+	var buf bytes.Buffer
+	buf.Write([]byte{byte(client.V1), byte(client.TimestampedEntryLeafType)})
+	binary.Write(&buf, binary.BigEndian, uint64(1000))
+	binary.Write(&buf, binary.BigEndian, client.X509LogEntryType)
+	buf.Write([]byte{byte(len(der) >> 16), byte(len(der) >> 8), byte(len(der))})
+	buf.Write(der)
+	buf.Write([]byte{0, 0})
+	return buf.Bytes()
+}
+
+// Returns the serialized extra_data of an X509 entry submitted with |chain|.
+func makeCertChain(chain ...[]byte) []byte {
+	var list bytes.Buffer
+	for _, der := range chain {
+		list.Write([]byte{byte(len(der) >> 16), byte(len(der) >> 8), byte(len(der))})
+		list.Write(der)
+	}
+	n := list.Len()
+	return append([]byte{byte(n >> 16), byte(n >> 8), byte(n)}, list.Bytes()...)
+}
+
+func TestScannerFetchChainsForChainMatcher(t *testing.T) {
+	ca, caKey := makeTestCA(t, "CA")
+	leaf := issueTestCert(t, newPrecertTemplate(), newPrecertKey(t), ca, caKey)
+	source := &chainLogSource{entries: []client.RawLogEntry{
+		{LeafInput: makeX509Leaf(leaf.Raw), ExtraData: makeCertChain(ca.Raw)},
+		{LeafInput: makeX509Leaf(tamperSignature(t, leaf).Raw), ExtraData: makeCertChain(ca.Raw)},
+	}}
+	sig := MatchCertBySignatureValidityAgainstIssuer{}
+	for _, test := range []struct {
+		desc string
+		m    Matcher
+	}{
+		{"bare", sig},
+		{"And", MatchAnd{[]Matcher{MatchAll{}, sig}}},
+		{"Or", MatchOr{[]Matcher{MatchNone{}, sig}}},
+		{"Not", MatchNot{MatchNot{sig}}},
+		{"Any", MatchAny{[]LabelledMatcher{{"sig", sig}}}},
+	} {
+		s := NewScanner(source, ScannerOptions{
+			Matcher:       test.m,
+			BlockSize:     10,
+			NumWorkers:    1,
+			ParallelFetch: 1,
+			Quiet:         true,
+			FetchChains:   true,
+		})
+		var matches []int64
+		err := s.Scan(func(index int64, c *x509.Certificate) {
+			matches = append(matches, index)
+		}, func(int64, *client.Precertificate) {
+			t.Errorf("%s: Unexpected Precert match", test.desc)
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", test.desc, err)
+		}
+		if len(matches) != 1 || matches[0] != 1 {
+			t.Errorf("%s: Expected only entry 1 to match, got %v", test.desc, matches)
+		}
+	}
+}
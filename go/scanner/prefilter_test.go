@@ -0,0 +1,80 @@
+package scanner
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+func newTargetedScanner(source LogSource, preFilter RawMatcher) *Scanner {
+	return NewScanner(source, ScannerOptions{
+		Matcher:       &MatchSubjectRegex{regexp.MustCompile(`^mail\.google\.com$`), nil},
+		PreFilter:     preFilter,
+		BlockSize:     100,
+		NumWorkers:    2,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+}
+
+func TestScannerPreFilterSkipsParsing(t *testing.T) {
+	s := newTargetedScanner(newMockLogSource(t), MatchRawBytes{Patterns: [][]byte{[]byte("mail.google.com")}})
+	var matches int
+	err := s.Scan(func(index int64, c *x509.Certificate) {
+		matches++
+	}, func(index int64, p *client.Precertificate) {
+		matches++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matches != 1 {
+		t.Fatalf("Expected 1 match, got %d", matches)
+	}
+	stats := s.Stats()
+	if stats.CertsProcessed != 4 || stats.EntriesPreFiltered != 3 {
+		t.Fatalf("Expected 3 of 4 entries to be pre-filtered, got %+v", stats)
+	}
+	var keyTypes int64
+	for _, n := range stats.KeyTypes {
+		keyTypes += n
+	}
+	if keyTypes != 1 {
+		t.Fatalf("Expected only 1 entry to be parsed, got key types %v", stats.KeyTypes)
+	}
+}
+
+// Returns a mockLogSource holding |n| copies of the entries of FourEntries.
+func newRepeatedMockLogSource(t testing.TB, n int) *mockLogSource {
+	four := newMockLogSource(t)
+	m := &mockLogSource{}
+	for i := 0; i < n; i++ {
+		m.leaves = append(m.leaves, four.leaves...)
+	}
+	return m
+}
+
+func benchmarkTargetedScan(b *testing.B, preFilter RawMatcher) {
+	source := newRepeatedMockLogSource(b, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := newTargetedScanner(source, preFilter)
+		if err := s.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {}); err != nil {
+			b.Fatal(err)
+		}
+		if i == 0 {
+			stats := s.Stats()
+			b.Logf("Parsed %d of %d entries", stats.CertsProcessed-stats.EntriesPreFiltered, stats.CertsProcessed)
+		}
+	}
+}
+
+func BenchmarkTargetedScan(b *testing.B) {
+	benchmarkTargetedScan(b, nil)
+}
+
+func BenchmarkTargetedScanWithPreFilter(b *testing.B) {
+	benchmarkTargetedScan(b, MatchRawBytes{Patterns: [][]byte{[]byte("mail.google.com")}})
+}
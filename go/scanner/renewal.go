@@ -0,0 +1,137 @@
+package scanner
+
+import (
+	"crypto/sha256"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// RenewalKey selects how MatchCertByRenewalPattern groups certificates.
+type RenewalKey int
+
+const (
+	// Group certificates by the SHA-256 hash of their SubjectPublicKeyInfo.
+	RenewalKeySPKI RenewalKey = iota
+	// Group certificates by their case-folded Subject country, organization
+	// and commonName.
+	RenewalKeySubject
+)
+
+// Default bounds on the memory used by MatchCertByRenewalPattern.
+const (
+	defaultMaxRenewalKeys = 100000
+	// Only the most recent issuances for each key are kept.
+	maxRenewalTimestamps = 1000
+)
+
+// MatchCertByRenewalPattern is a stateful, heuristic Matcher which matches
+// Certificates and Precertificates that are one of more than |MaxIssuances|
+// certificates for the same key (see RenewalKey) with NotBefore dates within
+// |Window| of each other. A tight reissuance cadence may indicate misbehaving
+// or abused automation, but is also normal for some deployments, so matches
+// should be treated as leads rather than evidence.
+//
+// NotBefore is used as the issuance time. Note that a Precertificate and its
+// corresponding final Certificate are counted as two issuances, so callers
+// may want to set ScannerOptions.PrecertOnly or halve their expectations.
+//
+// At most |MaxKeys| keys are tracked (defaultMaxRenewalKeys if <= 0);
+// certificates for further keys are ignored once the limit is reached.
+//
+// Use NewMatchCertByRenewalPattern, or a pointer to a struct literal, to
+// create instances of this Matcher, and don't share an instance between
+// concurrent scans.
+type MatchCertByRenewalPattern struct {
+	KeyBy        RenewalKey
+	MaxIssuances int
+	Window       time.Duration
+	MaxKeys      int
+
+	mu        sync.Mutex
+	issuances map[string][]time.Time
+}
+
+// Creates a new MatchCertByRenewalPattern which matches when more than
+// |maxIssuances| certificates with the same |keyBy| key are issued within
+// |window|.
+func NewMatchCertByRenewalPattern(keyBy RenewalKey, maxIssuances int, window time.Duration) *MatchCertByRenewalPattern {
+	return &MatchCertByRenewalPattern{
+		KeyBy:        keyBy,
+		MaxIssuances: maxIssuances,
+		Window:       window,
+		issuances:    make(map[string][]time.Time),
+	}
+}
+
+// Returns the key under which |c| is tracked.
+func (m *MatchCertByRenewalPattern) key(c *x509.Certificate) string {
+	if m.KeyBy == RenewalKeySubject {
+		var parts []string
+		parts = append(parts, c.Subject.Country...)
+		parts = append(parts, c.Subject.Organization...)
+		parts = append(parts, c.Subject.CommonName)
+		return strings.ToLower(strings.Join(parts, "/"))
+	}
+	hash := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+	return string(hash[:])
+}
+
+// Records the issuance of |c|, and returns true if the number of issuances
+// in some |Window| containing it now exceeds |MaxIssuances|.
+func (m *MatchCertByRenewalPattern) record(c *x509.Certificate) bool {
+	key := m.key(c)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.issuances == nil {
+		m.issuances = make(map[string][]time.Time)
+	}
+	times, ok := m.issuances[key]
+	if !ok {
+		maxKeys := m.MaxKeys
+		if maxKeys <= 0 {
+			maxKeys = defaultMaxRenewalKeys
+		}
+		if len(m.issuances) >= maxKeys {
+			return false
+		}
+	}
+	// Keep |times| sorted, since entries aren't scanned in issuance order.
+	t := c.NotBefore
+	i := sort.Search(len(times), func(i int) bool { return times[i].After(t) })
+	times = append(times, time.Time{})
+	copy(times[i+1:], times[i:])
+	times[i] = t
+	if len(times) > maxRenewalTimestamps {
+		times = times[len(times)-maxRenewalTimestamps:]
+	}
+	m.issuances[key] = times
+
+	// Slide a |Window|-long window over the issuances near |t|.
+	first := sort.Search(len(times), func(i int) bool { return !times[i].Before(t.Add(-m.Window)) })
+	last := first
+	for start := first; start < len(times) && !times[start].After(t); start++ {
+		for last < len(times) && !times[last].After(times[start].Add(m.Window)) {
+			last++
+		}
+		if last-start > m.MaxIssuances {
+			return true
+		}
+	}
+	return false
+}
+
+// Records the issuance of |c| and returns true if it's part of a burst.
+func (m *MatchCertByRenewalPattern) CertificateMatches(c *x509.Certificate) bool {
+	return m.record(c)
+}
+
+// Records the issuance of the TBSCertificate in |p| and returns true if it's
+// part of a burst.
+func (m *MatchCertByRenewalPattern) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.record(&p.TBSCertificate)
+}
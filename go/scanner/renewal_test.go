@@ -0,0 +1,83 @@
+package scanner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+func makeCertWithKey(spki []byte, notBefore time.Time) *x509.Certificate {
+	return &x509.Certificate{RawSubjectPublicKeyInfo: spki, NotBefore: notBefore}
+}
+
+func TestMatchCertByRenewalPatternFiresAboveThreshold(t *testing.T) {
+	m := NewMatchCertByRenewalPattern(RenewalKeySPKI, 3, 24*time.Hour)
+	key := []byte("key one")
+	start := time.Date(2016, time.May, 1, 0, 0, 0, 0, time.UTC)
+	// Three issuances in a day is at the threshold...
+	for _, offset := range []time.Duration{5 * time.Hour, 0, 10 * time.Hour} {
+		if m.CertificateMatches(makeCertWithKey(key, start.Add(offset))) {
+			t.Fatalf("MatchCertByRenewalPattern matched at %v with only 3 issuances", offset)
+		}
+	}
+	// ...but a different key doesn't count towards it...
+	if m.CertificateMatches(makeCertWithKey([]byte("key two"), start.Add(time.Hour))) {
+		t.Fatal("MatchCertByRenewalPattern matched issuance for a different key")
+	}
+	// ...nor does an issuance outside the window...
+	if m.CertificateMatches(makeCertWithKey(key, start.Add(48*time.Hour))) {
+		t.Fatal("MatchCertByRenewalPattern matched issuance outside the window")
+	}
+	// ...whereas a fourth within the window exceeds it.
+	var precert client.Precertificate
+	precert.TBSCertificate = *makeCertWithKey(key, start.Add(20*time.Hour))
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertByRenewalPattern failed to match the fourth issuance in a day")
+	}
+}
+
+func TestMatchCertByRenewalPatternBySubject(t *testing.T) {
+	m := NewMatchCertByRenewalPattern(RenewalKeySubject, 1, time.Hour)
+	start := time.Date(2016, time.May, 1, 0, 0, 0, 0, time.UTC)
+	var a, b x509.Certificate
+	a.Subject.CommonName = "www.example.com"
+	a.RawSubjectPublicKeyInfo = []byte("key one")
+	a.NotBefore = start
+	b.Subject.CommonName = "WWW.EXAMPLE.COM"
+	b.RawSubjectPublicKeyInfo = []byte("key two")
+	b.NotBefore = start.Add(30 * time.Minute)
+	if m.CertificateMatches(&a) {
+		t.Fatal("MatchCertByRenewalPattern matched first issuance")
+	}
+	if !m.CertificateMatches(&b) {
+		t.Fatal("MatchCertByRenewalPattern failed to match second issuance for the same subject")
+	}
+}
+
+func TestMatchCertByRenewalPatternBoundsKeys(t *testing.T) {
+	m := NewMatchCertByRenewalPattern(RenewalKeySPKI, 0, time.Hour)
+	m.MaxKeys = 1
+	now := time.Now()
+	if !m.CertificateMatches(makeCertWithKey([]byte("key one"), now)) {
+		t.Fatal("MatchCertByRenewalPattern failed to match tracked key")
+	}
+	if m.CertificateMatches(makeCertWithKey([]byte("key two"), now)) {
+		t.Fatal("MatchCertByRenewalPattern matched key beyond MaxKeys")
+	}
+	if len(m.issuances) != 1 {
+		t.Fatalf("Expected 1 tracked key, got %d", len(m.issuances))
+	}
+}
+
+func TestMatchCertByRenewalPatternStructLiteral(t *testing.T) {
+	m := &MatchCertByRenewalPattern{MaxIssuances: 1, Window: time.Hour}
+	start := time.Date(2016, time.May, 1, 0, 0, 0, 0, time.UTC)
+	if m.CertificateMatches(makeCertWithKey([]byte("key"), start)) {
+		t.Fatal("MatchCertByRenewalPattern matched the first issuance")
+	}
+	if !m.CertificateMatches(makeCertWithKey([]byte("key"), start.Add(time.Minute))) {
+		t.Fatal("MatchCertByRenewalPattern failed to match the second issuance in the window")
+	}
+}
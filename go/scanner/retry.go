@@ -0,0 +1,181 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+)
+
+// RetryConfig controls how requests to the log which fail are retried.
+type RetryConfig struct {
+	// Maximum number of attempts at each request, including the first;
+	// <= 1 disables retries.
+	MaxAttempts int
+	// Delay before the first retry. The delay doubles after each further
+	// failure, up to MaxBackoff.
+	InitialBackoff time.Duration
+	// Maximum delay between attempts; <= 0 means no maximum.
+	MaxBackoff time.Duration
+	// Fraction of each delay, between 0 and 1, by which it's randomly
+	// shortened, so that concurrent fetchers don't retry in lockstep.
+	Jitter float64
+}
+
+// Delays between retries of fetches of entries, used if the RetryConfig
+// doesn't set them, so that a failing log isn't retried in a tight loop.
+const (
+	defaultFetchInitialBackoff = 100 * time.Millisecond
+	defaultFetchMaxBackoff     = time.Minute
+)
+
+// Returns the RetryConfig used by DefaultScannerOptions.
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Jitter:         0.2,
+	}
+}
+
+// Returns the delay before the retry following failed attempt number
+// |attempt| (counting from 1).
+func (r RetryConfig) backoff(attempt int) time.Duration {
+	d := r.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if r.MaxBackoff > 0 && d >= r.MaxBackoff {
+			return r.MaxBackoff
+		}
+	}
+	if r.MaxBackoff > 0 && d > r.MaxBackoff {
+		return r.MaxBackoff
+	}
+	return d
+}
+
+// Returns the delay before retrying after failed attempt number |attempt|
+// returned |err|. If the log asked for a delay with a Retry-After header, that
+// is used, up to MaxBackoff; otherwise it's the backoff for |attempt|, less up
+// to Jitter of it.
+func (r RetryConfig) delay(attempt int, err error) time.Duration {
+	if httpErr, ok := err.(client.HTTPError); ok && httpErr.RetryAfter > 0 {
+		// Don't let a misbehaving log stall the scan indefinitely.
+		if r.MaxBackoff > 0 && httpErr.RetryAfter > r.MaxBackoff {
+			return r.MaxBackoff
+		}
+		return httpErr.RetryAfter
+	}
+	d := r.backoff(attempt)
+	if r.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * math.Min(r.Jitter, 1) * float64(d))
+	}
+	return d
+}
+
+// Returns the delay before retrying a fetch of entries after failed attempt
+// number |attempt| returned |err|, using the default fetch backoff where the
+// RetryConfig doesn't set one.
+func (s *Scanner) fetchRetryDelay(attempt int, err error) time.Duration {
+	r := s.opts.Retry
+	if r.InitialBackoff <= 0 {
+		r.InitialBackoff = defaultFetchInitialBackoff
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = defaultFetchMaxBackoff
+	}
+	return r.delay(attempt, err)
+}
+
+// Returns true if |err| means that the request will never succeed, so
+// shouldn't be retried: a 4xx HTTP status other than 408 (Request Timeout) or
+// 429 (Too Many Requests), a client.TooManyEntriesError, or a done context.
+func isTerminalError(err error) bool {
+	switch err {
+	case context.Canceled, context.DeadlineExceeded:
+		return true
+	}
+	if _, ok := err.(client.TooManyEntriesError); ok {
+		return true
+	}
+	if httpErr, ok := err.(client.HTTPError); ok {
+		code := httpErr.StatusCode
+		return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+	}
+	return false
+}
+
+// Calls |f| until it succeeds, returns a terminal error (see
+// isTerminalError), or has been attempted as many times as the RetryConfig
+// allows, backing off between attempts. |what| describes the request for
+// logging.
+// Returns the last error returned by |f|, or ctx.Err() if |ctx| is done while
+// backing off.
+func (s *Scanner) retry(ctx context.Context, what string, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || isTerminalError(err) || attempt >= s.opts.Retry.MaxAttempts {
+			return err
+		}
+		backoff := s.opts.Retry.delay(attempt, err)
+		s.Log(fmt.Sprintf("Attempt %d to %s failed, retrying in %s: %s", attempt, what, backoff, err.Error()))
+		if err := sleepCtx(ctx, backoff); err != nil {
+			return err
+		}
+	}
+}
+
+// Waits for |d|, returning early with ctx.Err() if |ctx| is done first.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// FailedRange is a range of entries which was abandoned after repeated or
+// terminal failures to fetch it (see ScannerOptions.Retry).
+type FailedRange struct {
+	// The first and last entries which weren't fetched
+	Start, End int64
+	// The error returned by the last attempt
+	Err error
+}
+
+// FetchError is returned by Scan when some ranges of entries couldn't be
+// fetched. The rest of the log was still scanned.
+type FetchError struct {
+	Ranges []FailedRange
+}
+
+func (e *FetchError) Error() string {
+	ranges := make([]string, len(e.Ranges))
+	for i, r := range e.Ranges {
+		ranges[i] = fmt.Sprintf("[%d, %d]: %s", r.Start, r.End, r.Err.Error())
+	}
+	return fmt.Sprintf("failed to fetch %d ranges of entries: %s", len(e.Ranges), strings.Join(ranges, "; "))
+}
+
+// Records that the entries [|start|, |end|] were abandoned after |attempts|
+// failed attempts, the last with the error |err|, so that the scan continues
+// without them.
+func (s *Scanner) abandonRange(start, end int64, attempts int, err error) {
+	s.Log(fmt.Sprintf("Giving up on fetching range [%d, %d] after %d attempts: %s", start, end, attempts, err.Error()))
+	s.failedMu.Lock()
+	defer s.failedMu.Unlock()
+	s.failedRanges = append(s.failedRanges, FailedRange{Start: start, End: end, Err: err})
+	s.opts.Metrics.Inc(MetricFailedRanges)
+}
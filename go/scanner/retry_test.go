@@ -0,0 +1,262 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// flakySTHLogSource is a mockLogSource whose first |failures| calls to
+// GetSTHCtx fail with |err|.
+type flakySTHLogSource struct {
+	*mockLogSource
+	failures int
+	err      error
+	attempts int
+}
+
+func (f *flakySTHLogSource) GetSTHCtx(ctx context.Context) (*client.SignedTreeHead, error) {
+	f.attempts++
+	if f.attempts <= f.failures {
+		return nil, f.err
+	}
+	return f.mockLogSource.GetSTHCtx(ctx)
+}
+
+func scanFlakySTHLog(t *testing.T, source *flakySTHLogSource) (int, error) {
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+		Retry:         RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	})
+	var matches int
+	err := s.Scan(func(int64, *x509.Certificate) {
+		matches++
+	}, func(int64, *client.Precertificate) {
+		matches++
+	})
+	return matches, err
+}
+
+func TestScannerRetriesInitialGetSTH(t *testing.T) {
+	source := &flakySTHLogSource{mockLogSource: newMockLogSource(t), failures: 2, err: errors.New("connection reset")}
+	matches, err := scanFlakySTHLog(t, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.attempts != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", source.attempts)
+	}
+	if matches != 4 {
+		t.Fatalf("Expected 4 matches, got %d", matches)
+	}
+}
+
+func TestScannerGivesUpOnInitialGetSTH(t *testing.T) {
+	source := &flakySTHLogSource{mockLogSource: newMockLogSource(t), failures: 3, err: client.HTTPError{StatusCode: http.StatusServiceUnavailable}}
+	if _, err := scanFlakySTHLog(t, source); err != source.err {
+		t.Fatalf("Expected %v, got %v", source.err, err)
+	}
+	if source.attempts != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", source.attempts)
+	}
+}
+
+func TestScannerFailsFastOnTerminalGetSTHError(t *testing.T) {
+	source := &flakySTHLogSource{mockLogSource: newMockLogSource(t), failures: 1, err: client.HTTPError{StatusCode: http.StatusGone}}
+	if _, err := scanFlakySTHLog(t, source); err != source.err {
+		t.Fatalf("Expected %v, got %v", source.err, err)
+	}
+	if source.attempts != 1 {
+		t.Fatalf("Expected 1 attempt, got %d", source.attempts)
+	}
+}
+
+func TestRetryConfigBackoff(t *testing.T) {
+	r := RetryConfig{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := r.backoff(attempt + 1); got != want {
+			t.Errorf("backoff(%d) = %s, expected %s", attempt+1, got, want)
+		}
+	}
+}
+
+// badRangeLogSource is a mockLogSource whose GetEntries fails for any range
+// including entry |bad|, with HTTP status |status| (503 if unset).
+type badRangeLogSource struct {
+	*mockLogSource
+	bad      int64
+	status   int
+	mu       sync.Mutex
+	attempts int
+}
+
+func (b *badRangeLogSource) GetEntries(start, end int64) ([]client.LeafInput, error) {
+	return b.GetEntriesCtx(context.Background(), start, end)
+}
+
+func (b *badRangeLogSource) GetEntriesCtx(ctx context.Context, start, end int64) ([]client.LeafInput, error) {
+	if start <= b.bad && b.bad <= end {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.attempts++
+		status := b.status
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		return nil, client.HTTPError{StatusCode: status}
+	}
+	return b.mockLogSource.GetEntriesCtx(ctx, start, end)
+}
+
+func TestScannerAbandonsRangeAfterMaxAttempts(t *testing.T) {
+	source := &badRangeLogSource{mockLogSource: newMockLogSource(t), bad: 2}
+	metrics := newStubRegistrar()
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     2,
+		NumWorkers:    1,
+		ParallelFetch: 2,
+		Quiet:         true,
+		Metrics:       metrics,
+		Retry:         RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	})
+	var mu sync.Mutex
+	var indices int64Slice
+	err := s.Scan(func(index int64, c *x509.Certificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		indices = append(indices, index)
+	}, func(index int64, p *client.Precertificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		indices = append(indices, index)
+	})
+	fetchErr, ok := err.(*FetchError)
+	if !ok {
+		t.Fatalf("Expected a *FetchError, got %v", err)
+	}
+	if len(fetchErr.Ranges) != 1 || fetchErr.Ranges[0].Start != 2 || fetchErr.Ranges[0].End != 3 {
+		t.Fatalf("Expected range [2, 3] to have failed, got %v", fetchErr.Ranges)
+	}
+	if source.attempts != 3 {
+		t.Fatalf("Expected 3 attempts at the failing range, got %d", source.attempts)
+	}
+	sort.Sort(indices)
+	if fmt.Sprint(indices) != "[0 1]" {
+		t.Fatalf("Expected the rest of the log to be scanned, got entries %v", indices)
+	}
+	if failed := s.Stats().FailedRanges; failed != 1 {
+		t.Fatalf("Expected 1 failed range in stats, got %d", failed)
+	}
+	if got := metrics.values[MetricFailedRanges]; got != 1 {
+		t.Fatalf("Expected %s to be 1, got %v", MetricFailedRanges, got)
+	}
+}
+
+func TestScannerProgressStaysBoundedPastAbandonedRange(t *testing.T) {
+	source := &badRangeLogSource{mockLogSource: newMockLogSource(t), bad: 0, status: http.StatusNotFound}
+	for len(source.leaves) < 400 {
+		source.leaves = append(source.leaves, source.leaves[:4]...)
+	}
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    2,
+		ParallelFetch: 2,
+		Quiet:         true,
+	})
+	err := s.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {})
+	if _, ok := err.(*FetchError); !ok {
+		t.Fatalf("Expected a *FetchError, got %v", err)
+	}
+	// The high-water mark can't pass the abandoned range [0, 9], but the
+	// entries processed beyond it should be held as a single run rather than
+	// one by one.
+	if hw := s.progress.highWater(); hw != 0 {
+		t.Fatalf("Expected high-water 0, got %d", hw)
+	}
+	if fmt.Sprint(s.progress.done) != "[{10 399}]" {
+		t.Fatalf("Expected processed entries to be held as the run [10, 399], got %v", s.progress.done)
+	}
+}
+
+func TestScannerFetchRetriesFollowRetryConfig(t *testing.T) {
+	for _, test := range []struct {
+		desc     string
+		status   int
+		retry    RetryConfig
+		attempts int
+	}{
+		{"MaxAttempts", http.StatusServiceUnavailable, RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond}, 3},
+		{"terminal error", http.StatusNotFound, RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond}, 1},
+		{"terminal error without MaxAttempts", http.StatusNotFound, RetryConfig{InitialBackoff: time.Millisecond}, 1},
+	} {
+		source := &badRangeLogSource{mockLogSource: newMockLogSource(t), bad: 2, status: test.status}
+		s := NewScanner(source, ScannerOptions{
+			Matcher:       &MatchAll{},
+			BlockSize:     2,
+			NumWorkers:    1,
+			ParallelFetch: 1,
+			Quiet:         true,
+			Retry:         test.retry,
+		})
+		err := s.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {})
+		if fetchErr, ok := err.(*FetchError); !ok || len(fetchErr.Ranges) != 1 {
+			t.Errorf("%s: Expected a *FetchError for one range, got %v", test.desc, err)
+		}
+		if source.attempts != test.attempts {
+			t.Errorf("%s: Expected %d attempts at the failing range, got %d", test.desc, test.attempts, source.attempts)
+		}
+	}
+}
+
+func TestRetryConfigDelay(t *testing.T) {
+	r := RetryConfig{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if d := r.delay(2, errors.New("connection reset")); d < time.Second || d > 2*time.Second {
+			t.Fatalf("delay(2) = %s, expected between 1s and 2s", d)
+		}
+	}
+	retryAfter := client.HTTPError{StatusCode: http.StatusTooManyRequests, RetryAfter: 42 * time.Second}
+	if d := r.delay(1, retryAfter); d != 5*time.Second {
+		t.Fatalf("Expected the Retry-After delay to be capped at MaxBackoff, got %s", d)
+	}
+	r.MaxBackoff = 0
+	if d := r.delay(1, retryAfter); d != 42*time.Second {
+		t.Fatalf("Expected the Retry-After delay to be honored, got %s", d)
+	}
+}
+
+func TestScannerBacksOffBetweenFetchRetries(t *testing.T) {
+	source := &badRangeLogSource{mockLogSource: newMockLogSource(t), bad: 0}
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+		Retry:         RetryConfig{InitialBackoff: 20 * time.Millisecond, MaxBackoff: 40 * time.Millisecond},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	s.ScanCtx(ctx, func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {})
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	// Waiting 20ms, then 40ms between each attempt allows at most 6 attempts
+	// in 200ms.
+	if source.attempts < 2 || source.attempts > 6 {
+		t.Fatalf("Expected between 2 and 6 attempts in 200ms, got %d", source.attempts)
+	}
+}
@@ -2,16 +2,33 @@ package scanner
 
 import (
 	"bytes"
-	"container/list"
+	"context"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/google/certificate-transparency/go/asn1"
 	"github.com/google/certificate-transparency/go/client"
 	"github.com/google/certificate-transparency/go/x509"
+	"github.com/google/certificate-transparency/go/x509/pkix"
 )
 
 // Clients wishing to implement their own Matchers should implement this interface:
@@ -25,6 +42,68 @@ type Matcher interface {
 	PrecertificateMatches(*client.Precertificate) bool
 }
 
+// Clients with a cheap test which can reject most entries before they're
+// parsed may implement this interface, and set it in ScannerOptions.PreFilter:
+type RawMatcher interface {
+	// RawMatches is called by the scanner with the DER encoded Certificate or
+	// Precertificate TBSCertificate of each entry, before it's parsed.
+	// The implementation should return |false| if the entry can't be
+	// interesting, in which case it's skipped without being parsed or passed
+	// to the Matcher, and |true| otherwise.
+	RawMatches(der []byte) bool
+}
+
+// Matchers which need the chain submitted with each Certificate may also
+// implement this interface. It's used in place of CertificateMatches when the
+// chain is available, which requires ScannerOptions.FetchChains:
+type ChainMatcher interface {
+	// CertificateChainMatches is called by the scanner for each X509
+	// Certificate found in the log, with the chain submitted with it,
+	// immediate issuer first.
+	// The implementation should return |true| if the passed Certificate is interesting, and |false| otherwise.
+	CertificateChainMatches(c *x509.Certificate, chain []*x509.Certificate) bool
+}
+
+// CertView is the common view of a Certificate or Precertificate passed to a
+// CommonMatcher. For Precertificates, it holds the synthesized Certificate
+// returned by client.Precertificate.AsCertificate().
+type CertView struct {
+	*x509.Certificate
+}
+
+// Clients whose matching logic is the same for Certificates and
+// Precertificates may implement this simpler interface instead of Matcher,
+// and wrap it in a MatchCommon:
+type CommonMatcher interface {
+	// Matches is called by the scanner for each Certificate and Precertificate
+	// found in the log; |isPrecert| is true for Precertificates.
+	// The implementation should return |true| if the passed entry is interesting, and |false| otherwise.
+	Matches(view CertView, isPrecert bool) bool
+}
+
+// MatchFunc is an adapter to allow the use of ordinary functions as
+// CommonMatchers.
+type MatchFunc func(view CertView, isPrecert bool) bool
+
+// Calls f(view, isPrecert).
+func (f MatchFunc) Matches(view CertView, isPrecert bool) bool {
+	return f(view, isPrecert)
+}
+
+// MatchCommon is a Matcher which passes both Certificates and Precertificates
+// to the wrapped CommonMatcher.
+type MatchCommon struct {
+	CommonMatcher
+}
+
+func (m MatchCommon) CertificateMatches(c *x509.Certificate) bool {
+	return m.Matches(CertView{c}, false)
+}
+
+func (m MatchCommon) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.Matches(CertView{p.AsCertificate()}, true)
+}
+
 // MatchAll is a Matcher which will match every possible Certificate and Precertificate.
 type MatchAll struct{}
 
@@ -36,50 +115,1531 @@ func (m MatchAll) PrecertificateMatches(_ *client.Precertificate) bool {
 	return true
 }
 
-// MatchNone is a Matcher which will never match any Certificate or Precertificate.
-type MatchNone struct{}
-
-func (m MatchNone) CertificateMatches(_ *x509.Certificate) bool {
-	return false
+// MatchNone is a Matcher which will never match any Certificate or Precertificate.
+type MatchNone struct{}
+
+func (m MatchNone) CertificateMatches(_ *x509.Certificate) bool {
+	return false
+}
+
+func (m MatchNone) PrecertificateMatches(_ *client.Precertificate) bool {
+	return false
+}
+
+// MatchSubjectRegex is a Matcher which will use |CertificateSubjectRegex| and |PrecertificateSubjectRegex|
+// to determine whether Certificates and Precertificates are interesting.
+// The two regexes are tested against Subject Common Name as well as all
+// Subject Alternative Names
+type MatchSubjectRegex struct {
+	CertificateSubjectRegex    *regexp.Regexp
+	PrecertificateSubjectRegex *regexp.Regexp
+}
+
+// Returns true if either CN or any SAN of |c| matches |CertificateSubjectRegex|.
+func (m MatchSubjectRegex) CertificateMatches(c *x509.Certificate) bool {
+	if m.CertificateSubjectRegex.FindStringIndex(c.Subject.CommonName) != nil {
+		return true
+	}
+	for _, alt := range c.DNSNames {
+		if m.CertificateSubjectRegex.FindStringIndex(alt) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if either CN or any SAN of |p| matches |PrecertificatesubjectRegex|.
+func (m MatchSubjectRegex) PrecertificateMatches(p *client.Precertificate) bool {
+	if m.PrecertificateSubjectRegex.FindStringIndex(p.TBSCertificate.Subject.CommonName) != nil {
+		return true
+	}
+	for _, alt := range p.TBSCertificate.DNSNames {
+		if m.PrecertificateSubjectRegex.FindStringIndex(alt) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchIssuerRegex is a Matcher which matches Certificates and
+// Precertificates by their issuer. |IssuerRegex| is tested against the Issuer
+// Common Name and each Issuer Organization, and |AuthorityKeyId|, if set, must
+// equal the hex encoding of the Authority Key Identifier (colons and case are
+// ignored). Either may be left unset; an entry matches if it satisfies all
+// those which are set.
+type MatchIssuerRegex struct {
+	IssuerRegex    *regexp.Regexp
+	AuthorityKeyId string
+}
+
+func (m MatchIssuerRegex) certMatches(c *x509.Certificate) bool {
+	if m.AuthorityKeyId != "" {
+		want := strings.ToLower(strings.Replace(m.AuthorityKeyId, ":", "", -1))
+		if hex.EncodeToString(c.AuthorityKeyId) != want {
+			return false
+		}
+	}
+	if m.IssuerRegex == nil {
+		return true
+	}
+	if m.IssuerRegex.FindStringIndex(c.Issuer.CommonName) != nil {
+		return true
+	}
+	for _, org := range c.Issuer.Organization {
+		if m.IssuerRegex.FindStringIndex(org) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the issuer of |c| matches.
+func (m MatchIssuerRegex) CertificateMatches(c *x509.Certificate) bool {
+	return m.certMatches(c)
+}
+
+// Returns true if the issuer of the TBSCertificate in |p| matches.
+func (m MatchIssuerRegex) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.certMatches(&p.TBSCertificate)
+}
+
+// OID of the commonName attribute type (RFC 5280, appendix A.1)
+var oidCommonName = asn1.ObjectIdentifier{2, 5, 4, 3}
+
+// Parses the DER encoded Name |rawName|.
+// Returns the RDNSequence it contains, or a non-nil error if it couldn't be
+// parsed.
+func parseName(rawName []byte) (pkix.RDNSequence, error) {
+	var rdns pkix.RDNSequence
+	rest, err := asn1.Unmarshal(rawName, &rdns)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, asn1.SyntaxError{Msg: "trailing data after Name"}
+	}
+	return rdns, nil
+}
+
+// Returns the number of commonName attributes found in the DER encoded Name
+// |rawName|, or a non-nil error if |rawName| couldn't be parsed.
+func countCommonNames(rawName []byte) (int, error) {
+	rdns, err := parseName(rawName)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, rdn := range rdns {
+		for _, atv := range rdn {
+			if atv.Type.Equal(oidCommonName) {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+// MatchCertWithMultipleCNs is a Matcher which matches Certificates and
+// Precertificates whose Subject DN contains more than one commonName
+// attribute. x509.Certificate only exposes a single CommonName, so the raw
+// Subject is parsed directly; subjects which fail to parse never match.
+type MatchCertWithMultipleCNs struct{}
+
+// Returns true if the Subject of |c| has more than one commonName.
+func (m MatchCertWithMultipleCNs) CertificateMatches(c *x509.Certificate) bool {
+	n, err := countCommonNames(c.RawSubject)
+	return err == nil && n > 1
+}
+
+// Returns true if the Subject of the TBSCertificate in |p| has more than one
+// commonName.
+func (m MatchCertWithMultipleCNs) PrecertificateMatches(p *client.Precertificate) bool {
+	n, err := countCommonNames(p.TBSCertificate.RawSubject)
+	return err == nil && n > 1
+}
+
+// OID of the X.509v3 extension holding embedded SCTs (RFC 6962, section 3.3)
+var oidExtensionSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// Returns the serialized SCTs embedded in |c|.
+// Returns nil if |c| has no SCT list extension, or a non-nil error if the
+// extension is present but malformed.
+func embeddedSCTs(c *x509.Certificate) ([][]byte, error) {
+	for _, ext := range c.Extensions {
+		if !ext.Id.Equal(oidExtensionSCTList) {
+			continue
+		}
+		var list []byte
+		rest, err := asn1.Unmarshal(ext.Value, &list)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) > 0 {
+			return nil, asn1.SyntaxError{Msg: "trailing data after SCT list"}
+		}
+		return client.ParseSCTList(list)
+	}
+	return nil, nil
+}
+
+// Returns the number of SCTs embedded in |c|.
+// Returns zero if |c| has no SCT list extension, or a non-nil error if the
+// extension is present but malformed.
+func embeddedSCTCount(c *x509.Certificate) (int, error) {
+	scts, err := embeddedSCTs(c)
+	return len(scts), err
+}
+
+// MatchCertWithoutCTSCTs is a Matcher which matches final Certificates which
+// don't carry any embedded SCTs, either because the SCT list extension is
+// absent or because it's empty (or too malformed to yield any SCTs).
+// Precertificates never carry embedded SCTs, so are never matched.
+type MatchCertWithoutCTSCTs struct{}
+
+// Returns true if |c| has no embedded SCTs.
+func (m MatchCertWithoutCTSCTs) CertificateMatches(c *x509.Certificate) bool {
+	n, err := embeddedSCTCount(c)
+	return err != nil || n == 0
+}
+
+// Always returns false.
+func (m MatchCertWithoutCTSCTs) PrecertificateMatches(p *client.Precertificate) bool {
+	return false
+}
+
+// Returns true if |exts| contains more than one extension with the same OID.
+func hasDuplicateExtensions(exts []pkix.Extension) bool {
+	for i := range exts {
+		for j := i + 1; j < len(exts); j++ {
+			if exts[i].Id.Equal(exts[j].Id) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MatchCertWithDuplicateExtensions is a Matcher which matches Certificates and
+// Precertificates containing more than one instance of the same extension,
+// which RFC 5280 section 4.2 forbids.
+type MatchCertWithDuplicateExtensions struct{}
+
+// Returns true if |c| has a repeated extension OID.
+func (m MatchCertWithDuplicateExtensions) CertificateMatches(c *x509.Certificate) bool {
+	return hasDuplicateExtensions(c.Extensions)
+}
+
+// Returns true if the TBSCertificate in |p| has a repeated extension OID.
+func (m MatchCertWithDuplicateExtensions) PrecertificateMatches(p *client.Precertificate) bool {
+	return hasDuplicateExtensions(p.TBSCertificate.Extensions)
+}
+
+// OID of the special anyPolicy certificate policy (RFC 5280 section 4.2.1.4)
+var oidAnyPolicy = asn1.ObjectIdentifier{2, 5, 29, 32, 0}
+
+// MatchCertByCertificatePolicyAnyPolicy is a Matcher which matches Certificates and
+// Precertificates asserting the anyPolicy certificate policy.
+// If |LeafOnly| is true, only leaf certificates (those without a CA basic
+// constraint) are matched, since anyPolicy is normally only asserted by CAs.
+type MatchCertByCertificatePolicyAnyPolicy struct {
+	LeafOnly bool
+}
+
+func (m MatchCertByCertificatePolicyAnyPolicy) certMatches(c *x509.Certificate) bool {
+	if m.LeafOnly && c.BasicConstraintsValid && c.IsCA {
+		return false
+	}
+	for _, policy := range c.PolicyIdentifiers {
+		if policy.Equal(oidAnyPolicy) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if |c| asserts anyPolicy.
+func (m MatchCertByCertificatePolicyAnyPolicy) CertificateMatches(c *x509.Certificate) bool {
+	return m.certMatches(c)
+}
+
+// Returns true if the TBSCertificate in |p| asserts anyPolicy.
+func (m MatchCertByCertificatePolicyAnyPolicy) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.certMatches(&p.TBSCertificate)
+}
+
+// MatchRSAExponent is a Matcher which matches Certificates and Precertificates
+// with an RSA public key whose public exponent isn't in |AllowedExponents|.
+// If |AllowedExponents| is empty, only 65537 is allowed.
+// Certificates with non-RSA keys never match.
+type MatchRSAExponent struct {
+	AllowedExponents []int
+}
+
+func (m MatchRSAExponent) keyMatches(key interface{}) bool {
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return false
+	}
+	allowed := m.AllowedExponents
+	if len(allowed) == 0 {
+		allowed = []int{65537}
+	}
+	for _, e := range allowed {
+		if rsaKey.E == e {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if |c| has an RSA key with a disallowed exponent.
+func (m MatchRSAExponent) CertificateMatches(c *x509.Certificate) bool {
+	return m.keyMatches(c.PublicKey)
+}
+
+// Returns true if the TBSCertificate in |p| has an RSA key with a disallowed
+// exponent.
+func (m MatchRSAExponent) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.keyMatches(p.TBSCertificate.PublicKey)
+}
+
+// MatchAuthorityKeyIdSet is a Matcher which matches Certificates and
+// Precertificates whose Authority Key Identifier is one of a given set,
+// allowing all certificates issued under a particular intermediate key to be
+// tracked regardless of the issuer name used.
+// Use NewMatchAuthorityKeyIdSet to create instances of this Matcher.
+type MatchAuthorityKeyIdSet struct {
+	keyIds map[string]bool
+}
+
+// Creates a new MatchAuthorityKeyIdSet which matches any of |keyIds|.
+func NewMatchAuthorityKeyIdSet(keyIds ...[]byte) *MatchAuthorityKeyIdSet {
+	m := &MatchAuthorityKeyIdSet{keyIds: make(map[string]bool)}
+	for _, id := range keyIds {
+		m.keyIds[string(id)] = true
+	}
+	return m
+}
+
+func (m MatchAuthorityKeyIdSet) keyIdMatches(keyId []byte) bool {
+	return len(keyId) > 0 && m.keyIds[string(keyId)]
+}
+
+// Returns true if the AuthorityKeyId of |c| is in the set.
+func (m MatchAuthorityKeyIdSet) CertificateMatches(c *x509.Certificate) bool {
+	return m.keyIdMatches(c.AuthorityKeyId)
+}
+
+// Returns true if the AuthorityKeyId of the TBSCertificate in |p| is in the set.
+func (m MatchAuthorityKeyIdSet) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.keyIdMatches(p.TBSCertificate.AuthorityKeyId)
+}
+
+// MatchSerialNumbers is a Matcher which matches Certificates and
+// Precertificates whose serial number is one of a given set. The set is a map
+// keyed on the canonical decimal form of each serial, so lookups take
+// constant time however many serials are listed.
+// Use NewMatchSerialNumbers, NewMatchSerials or NewMatchSerialsHex to create
+// instances of this Matcher.
+type MatchSerialNumbers struct {
+	serials map[string]bool
+}
+
+// Creates a new MatchSerialNumbers which matches any of |serials|.
+func NewMatchSerialNumbers(serials ...*big.Int) *MatchSerialNumbers {
+	m := &MatchSerialNumbers{serials: make(map[string]bool)}
+	for _, serial := range serials {
+		m.serials[serial.String()] = true
+	}
+	return m
+}
+
+// Creates a new MatchSerialNumbers which matches any of the serial numbers in
+// |inputs|, each of which is parsed with ParseSerialNumber.
+// Returns an error naming the first input which couldn't be parsed.
+func NewMatchSerials(inputs ...string) (*MatchSerialNumbers, error) {
+	serials := make([]*big.Int, 0, len(inputs))
+	for _, input := range inputs {
+		serial, err := ParseSerialNumber(input)
+		if err != nil {
+			return nil, err
+		}
+		serials = append(serials, serial)
+	}
+	return NewMatchSerialNumbers(serials...), nil
+}
+
+// Creates a new MatchSerialNumbers which matches any of |hexSerials|, each of
+// which is always parsed as hex, with an optional "0x" prefix or with bytes
+// separated by colons, such as the serial numbers listed in a CA's incident
+// report. Serials are compared by value, so "0A", "00:0a" and "a" are equal.
+// Returns an error naming the first input which couldn't be parsed.
+func NewMatchSerialsHex(hexSerials ...string) (*MatchSerialNumbers, error) {
+	serials := make([]*big.Int, 0, len(hexSerials))
+	for _, input := range hexSerials {
+		s := strings.TrimSpace(input)
+		if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+			s = s[2:]
+		}
+		s = strings.Replace(s, ":", "", -1)
+		serial, ok := new(big.Int).SetString(s, 16)
+		if !ok || serial.Sign() < 0 {
+			return nil, fmt.Errorf("invalid hex serial number %q", input)
+		}
+		serials = append(serials, serial)
+	}
+	return NewMatchSerialNumbers(serials...), nil
+}
+
+// Parses a serial number as commonly copied from browsers and openssl.
+// Accepted forms are:
+//   - hex bytes separated by colons or spaces, e.g. "2a:3f:9b" or "2a 3f 9b"
+//   - hex with a "0x" prefix, e.g. "0x2a3f9b"
+//   - decimal, e.g. "2768795"
+//
+// The base is never guessed from the digits: plain hex such as "2a3f9b" is
+// rejected, as a hex serial which happened to contain only digits would
+// otherwise be silently misread as decimal. Use NewMatchSerialsHex for lists
+// of unprefixed hex serials.
+func ParseSerialNumber(input string) (*big.Int, error) {
+	s := strings.TrimSpace(input)
+	base := 10
+	switch {
+	case strings.ContainsAny(s, ": "):
+		groups := strings.FieldsFunc(s, func(r rune) bool { return r == ':' || r == ' ' })
+		for _, group := range groups {
+			if len(group) > 2 {
+				return nil, fmt.Errorf("invalid serial number %q: hex group %q is longer than one byte", input, group)
+			}
+		}
+		s = strings.Join(groups, "")
+		base = 16
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X"):
+		s = s[2:]
+		base = 16
+	case strings.ContainsAny(s, "abcdefABCDEF"):
+		return nil, fmt.Errorf("invalid serial number %q: hex serial numbers need a \"0x\" prefix or separators", input)
+	}
+	if s == "" {
+		return nil, fmt.Errorf("invalid serial number %q: empty", input)
+	}
+	serial, ok := new(big.Int).SetString(s, base)
+	if !ok || serial.Sign() < 0 {
+		return nil, fmt.Errorf("invalid serial number %q", input)
+	}
+	return serial, nil
+}
+
+func (m MatchSerialNumbers) serialMatches(serial *big.Int) bool {
+	return serial != nil && m.serials[serial.String()]
+}
+
+// Returns true if the SerialNumber of |c| is in the set.
+func (m MatchSerialNumbers) CertificateMatches(c *x509.Certificate) bool {
+	return m.serialMatches(c.SerialNumber)
+}
+
+// Returns true if the SerialNumber of the TBSCertificate in |p| is in the set.
+func (m MatchSerialNumbers) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.serialMatches(p.TBSCertificate.SerialNumber)
+}
+
+// OIDs of public key algorithms not understood by the x509 package
+var (
+	oidPublicKeyEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+	oidPublicKeyEd448   = asn1.ObjectIdentifier{1, 3, 101, 113}
+)
+
+// Returns a canonical string describing the type of the public key in |c|,
+// e.g. "RSA-2048", "P-256" or "Ed25519". Returns "Unknown" if the key type
+// can't be determined.
+func ClassifyKeyType(c *x509.Certificate) string {
+	switch k := c.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d", k.N.BitLen())
+	case *dsa.PublicKey:
+		return fmt.Sprintf("DSA-%d", k.P.BitLen())
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().Name
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(c.RawSubjectPublicKeyInfo, &spki); err == nil {
+		switch {
+		case spki.Algorithm.Algorithm.Equal(oidPublicKeyEd25519):
+			return "Ed25519"
+		case spki.Algorithm.Algorithm.Equal(oidPublicKeyEd448):
+			return "Ed448"
+		}
+	}
+	return "Unknown"
+}
+
+// MatchKeyType is a Matcher which matches Certificates and Precertificates
+// whose public key type, as returned by ClassifyKeyType, is one of |KeyTypes|.
+type MatchKeyType struct {
+	KeyTypes []string
+}
+
+func (m MatchKeyType) keyTypeMatches(c *x509.Certificate) bool {
+	keyType := ClassifyKeyType(c)
+	for _, t := range m.KeyTypes {
+		if t == keyType {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the key type of |c| is one of |KeyTypes|.
+func (m MatchKeyType) CertificateMatches(c *x509.Certificate) bool {
+	return m.keyTypeMatches(c)
+}
+
+// Returns true if the key type of the TBSCertificate in |p| is one of |KeyTypes|.
+func (m MatchKeyType) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.keyTypeMatches(&p.TBSCertificate)
+}
+
+// MatchPublicKey is a Matcher which matches Certificates and Precertificates
+// with a public key using one of |Algorithms|, or, if |MinRSABits| is set,
+// with an RSA key whose modulus is shorter than |MinRSABits| (if |MatchBelow|
+// is set) or at least |MinRSABits| long (otherwise).
+// For example, MatchPublicKey{MinRSABits: 2048, MatchBelow: true} matches
+// RSA keys shorter than 2048 bits, and
+// MatchPublicKey{Algorithms: []x509.PublicKeyAlgorithm{x509.DSA}} matches
+// all DSA keys.
+type MatchPublicKey struct {
+	Algorithms []x509.PublicKeyAlgorithm
+	MinRSABits int
+	MatchBelow bool
+}
+
+// Returns the algorithm of the public key in |c|, from the key itself if the
+// x509 package didn't record it.
+func publicKeyAlgorithm(c *x509.Certificate) x509.PublicKeyAlgorithm {
+	if c.PublicKeyAlgorithm != x509.UnknownPublicKeyAlgorithm {
+		return c.PublicKeyAlgorithm
+	}
+	switch c.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return x509.RSA
+	case *dsa.PublicKey:
+		return x509.DSA
+	case *ecdsa.PublicKey:
+		return x509.ECDSA
+	}
+	return x509.UnknownPublicKeyAlgorithm
+}
+
+func (m MatchPublicKey) certMatches(c *x509.Certificate) bool {
+	alg := publicKeyAlgorithm(c)
+	for _, a := range m.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	if m.MinRSABits <= 0 {
+		return false
+	}
+	rsaKey, ok := c.PublicKey.(*rsa.PublicKey)
+	if !ok || rsaKey.N == nil {
+		return false
+	}
+	return (rsaKey.N.BitLen() < m.MinRSABits) == m.MatchBelow
+}
+
+// Returns true if the public key of |c| uses one of |Algorithms|, or is an
+// RSA key of the selected size.
+func (m MatchPublicKey) CertificateMatches(c *x509.Certificate) bool {
+	return m.certMatches(c)
+}
+
+// Returns true if the public key in the TBSCertificate of |p| uses one of
+// |Algorithms|, or is an RSA key of the selected size.
+func (m MatchPublicKey) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.certMatches(&p.TBSCertificate)
+}
+
+// Returns true if the DER encoded Name |rawName| contains no attributes.
+func isEmptyName(rawName []byte) bool {
+	if len(rawName) == 0 {
+		return true
+	}
+	rdns, err := parseName(rawName)
+	if err != nil {
+		return false
+	}
+	for _, rdn := range rdns {
+		if len(rdn) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchEmptySubject is a Matcher which matches Certificates and
+// Precertificates with an empty Subject DN.
+// If |RequireEmptySANs| is true, only entries which additionally have no
+// Subject Alternative Names (which is invalid, see RFC 5280 section 4.2.1.6)
+// are matched.
+type MatchEmptySubject struct {
+	RequireEmptySANs bool
+}
+
+func (m MatchEmptySubject) certMatches(c *x509.Certificate) bool {
+	if !isEmptyName(c.RawSubject) {
+		return false
+	}
+	if m.RequireEmptySANs {
+		return len(c.DNSNames) == 0 && len(c.EmailAddresses) == 0 && len(c.IPAddresses) == 0
+	}
+	return true
+}
+
+// Returns true if |c| has an empty Subject (and no SANs, if required).
+func (m MatchEmptySubject) CertificateMatches(c *x509.Certificate) bool {
+	return m.certMatches(c)
+}
+
+// Returns true if the TBSCertificate in |p| has an empty Subject (and no SANs,
+// if required).
+func (m MatchEmptySubject) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.certMatches(&p.TBSCertificate)
+}
+
+// Maximum lengths of a DNS name and of each of its labels (RFC 1035 section
+// 2.3.4), excluding any trailing dot.
+const (
+	maxDNSNameLength  = 253
+	maxDNSLabelLength = 63
+)
+
+// Returns true if |name| is too long to be a DNS name, or contains whitespace,
+// control characters or NULs.
+func isMalformedDNSName(name string) bool {
+	if len(strings.TrimSuffix(name, ".")) > maxDNSNameLength {
+		return true
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > maxDNSLabelLength {
+			return true
+		}
+	}
+	for i := 0; i < len(name); i++ {
+		if b := name[i]; b <= ' ' || b == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchCertWithLongSANValue is a Matcher which matches Certificates and
+// Precertificates with a dNSName SAN which exceeds the DNS length limits, or
+// which contains whitespace, control characters or embedded NULs. Such names
+// are typically the result of encoding bugs at the issuing CA.
+type MatchCertWithLongSANValue struct{}
+
+func (m MatchCertWithLongSANValue) namesMatch(names []string) bool {
+	for _, name := range names {
+		if isMalformedDNSName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if any dNSName SAN of |c| is over-long or malformed.
+func (m MatchCertWithLongSANValue) CertificateMatches(c *x509.Certificate) bool {
+	return m.namesMatch(c.DNSNames)
+}
+
+// Returns true if any dNSName SAN of |p| is over-long or malformed.
+func (m MatchCertWithLongSANValue) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.namesMatch(p.TBSCertificate.DNSNames)
+}
+
+// Signature algorithms using hash functions with known collision attacks
+var weakSignatureAlgorithms = []x509.SignatureAlgorithm{
+	x509.MD2WithRSA,
+	x509.MD5WithRSA,
+	x509.SHA1WithRSA,
+	x509.DSAWithSHA1,
+	x509.ECDSAWithSHA1,
+}
+
+// MatchSignatureAlgorithm is a Matcher which matches Certificates and
+// Precertificates signed using one of |Algorithms|, e.g.
+// MatchSignatureAlgorithm{Algorithms: []x509.SignatureAlgorithm{x509.SHA1WithRSA, x509.MD5WithRSA}}.
+// If |Algorithms| is empty, nothing is matched; see MatchWeakSignature for a
+// default set of deprecated algorithms.
+type MatchSignatureAlgorithm struct {
+	Algorithms []x509.SignatureAlgorithm
+}
+
+func (m MatchSignatureAlgorithm) algorithmMatches(alg x509.SignatureAlgorithm) bool {
+	for _, a := range m.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if |c| is signed with one of |Algorithms|.
+func (m MatchSignatureAlgorithm) CertificateMatches(c *x509.Certificate) bool {
+	return m.algorithmMatches(c.SignatureAlgorithm)
+}
+
+// Returns true if the signature algorithm in the TBSCertificate of |p| is one
+// of |Algorithms|.
+func (m MatchSignatureAlgorithm) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.algorithmMatches(p.TBSCertificate.SignatureAlgorithm)
+}
+
+// MatchWeakSignature is a Matcher which matches Certificates and
+// Precertificates signed using one of |Algorithms|.
+// If |Algorithms| is empty, the MD2, MD5 and SHA-1 based algorithms are
+// matched.
+type MatchWeakSignature struct {
+	Algorithms []x509.SignatureAlgorithm
+}
+
+func (m MatchWeakSignature) algorithmMatches(alg x509.SignatureAlgorithm) bool {
+	algs := m.Algorithms
+	if len(algs) == 0 {
+		algs = weakSignatureAlgorithms
+	}
+	return MatchSignatureAlgorithm{algs}.algorithmMatches(alg)
+}
+
+// Returns true if |c| is signed with one of |Algorithms|.
+func (m MatchWeakSignature) CertificateMatches(c *x509.Certificate) bool {
+	return m.algorithmMatches(c.SignatureAlgorithm)
+}
+
+// Returns true if the signature algorithm in the TBSCertificate of |p| is one
+// of |Algorithms|.
+func (m MatchWeakSignature) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.algorithmMatches(p.TBSCertificate.SignatureAlgorithm)
+}
+
+// OIDs of the Subject attributes describing an EV certificate's jurisdiction
+// of incorporation (see the CA/Browser Forum EV Guidelines, section 9.2.4),
+// and of the Subject countryName attribute.
+var (
+	oidJurisdictionLocality = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 60, 2, 1, 1}
+	oidJurisdictionProvince = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 60, 2, 1, 2}
+	oidJurisdictionCountry  = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 60, 2, 1, 3}
+	oidCountry              = asn1.ObjectIdentifier{2, 5, 4, 6}
+)
+
+// Returns the string values of all attributes of type |oid| in |rdns|.
+func nameValues(rdns pkix.RDNSequence, oid asn1.ObjectIdentifier) []string {
+	var values []string
+	for _, rdn := range rdns {
+		for _, atv := range rdn {
+			if v, ok := atv.Value.(string); ok && atv.Type.Equal(oid) {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// Returns true if |s| is equal to any of |list|, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchEVJurisdiction is a Matcher which matches Certificates and
+// Precertificates by the EV jurisdiction of incorporation attributes in their
+// Subject, which aren't exposed by the x509 package.
+// An entry matches if its jurisdictionCountryName is one of |Countries|,
+// its jurisdictionStateOrProvinceName is one of |Provinces|, or its
+// jurisdictionLocalityName is one of |Localities| (all compared
+// case-insensitively). If |MatchCountryMismatch| is true, entries whose
+// jurisdictionCountryName differs from their Subject countryName also match.
+type MatchEVJurisdiction struct {
+	Countries            []string
+	Provinces            []string
+	Localities           []string
+	MatchCountryMismatch bool
+}
+
+func (m MatchEVJurisdiction) subjectMatches(rawSubject []byte) bool {
+	rdns, err := parseName(rawSubject)
+	if err != nil {
+		return false
+	}
+	jurisdictionCountries := nameValues(rdns, oidJurisdictionCountry)
+	for _, c := range jurisdictionCountries {
+		if containsFold(m.Countries, c) {
+			return true
+		}
+		if m.MatchCountryMismatch && !containsFold(nameValues(rdns, oidCountry), c) {
+			return true
+		}
+	}
+	for _, p := range nameValues(rdns, oidJurisdictionProvince) {
+		if containsFold(m.Provinces, p) {
+			return true
+		}
+	}
+	for _, l := range nameValues(rdns, oidJurisdictionLocality) {
+		if containsFold(m.Localities, l) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the EV jurisdiction of |c| matches.
+func (m MatchEVJurisdiction) CertificateMatches(c *x509.Certificate) bool {
+	return m.subjectMatches(c.RawSubject)
+}
+
+// Returns true if the EV jurisdiction of the TBSCertificate in |p| matches.
+func (m MatchEVJurisdiction) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.subjectMatches(p.TBSCertificate.RawSubject)
+}
+
+// Returns a map from base64 encoded log ID to operator name for each of the
+// logs in |logs|, suitable for use with CountSCTOperators.
+func LogOperatorsFromLogList(logs []client.LogListEntry) map[string]string {
+	operators := make(map[string]string)
+	for _, l := range logs {
+		operators[base64.StdEncoding.EncodeToString(l.LogID)] = l.Operator
+	}
+	return operators
+}
+
+// Returns the number of distinct log operators which issued the SCTs embedded
+// in |c|. |operators| maps base64 encoded log IDs to operator names; SCTs from
+// logs not in |operators| aren't counted, as their independence can't be
+// established.
+// Returns a non-nil error if the embedded SCTs couldn't be parsed.
+func CountSCTOperators(c *x509.Certificate, operators map[string]string) (int, error) {
+	scts, err := embeddedSCTs(c)
+	if err != nil {
+		return 0, err
+	}
+	seen := make(map[string]bool)
+	for _, raw := range scts {
+		sct, err := client.ReadSignedCertificateTimestamp(bytes.NewReader(raw))
+		if err != nil {
+			return 0, err
+		}
+		if op, ok := operators[base64.StdEncoding.EncodeToString(sct.LogID)]; ok {
+			seen[op] = true
+		}
+	}
+	return len(seen), nil
+}
+
+// MatchCertLoggedToInsufficientLogs is a Matcher which matches final
+// Certificates whose embedded SCTs come from fewer than |MinOperators|
+// distinct log operators (default 2), as determined by CountSCTOperators with
+// |Operators|. Certificates with unparsable SCTs also match.
+// Precertificates never carry embedded SCTs, so are never matched.
+type MatchCertLoggedToInsufficientLogs struct {
+	Operators    map[string]string
+	MinOperators int
+}
+
+// Returns true if |c| has embedded SCTs from too few log operators.
+func (m MatchCertLoggedToInsufficientLogs) CertificateMatches(c *x509.Certificate) bool {
+	min := m.MinOperators
+	if min <= 0 {
+		min = 2
+	}
+	n, err := CountSCTOperators(c, m.Operators)
+	return err != nil || n < min
+}
+
+// Always returns false.
+func (m MatchCertLoggedToInsufficientLogs) PrecertificateMatches(p *client.Precertificate) bool {
+	return false
+}
+
+// MatchRawBytes is a Matcher which matches Certificates and Precertificates
+// whose raw DER encoded TBSCertificate contains any of |Patterns|, or matches
+// |Regexp| (which may be nil). Go regexps operate on UTF-8, so |Regexp| can't
+// match individual bytes above 0x7f; use |Patterns| for those.
+// Note that this searches every byte of every entry, which is considerably
+// more expensive than matching on parsed fields; prefer a more specific
+// Matcher where one exists.
+type MatchRawBytes struct {
+	Patterns [][]byte
+	Regexp   *regexp.Regexp
+}
+
+func (m MatchRawBytes) tbsMatches(tbs []byte) bool {
+	for _, p := range m.Patterns {
+		if bytes.Contains(tbs, p) {
+			return true
+		}
+	}
+	return m.Regexp != nil && m.Regexp.Match(tbs)
+}
+
+// Returns true if the TBSCertificate of |c| contains any of |Patterns| or
+// matches |Regexp|.
+func (m MatchRawBytes) CertificateMatches(c *x509.Certificate) bool {
+	return m.tbsMatches(c.RawTBSCertificate)
+}
+
+// Returns true if the TBSCertificate of |p| contains any of |Patterns| or
+// matches |Regexp|.
+func (m MatchRawBytes) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.tbsMatches(p.Raw)
+}
+
+// Returns true if |der| contains any of |Patterns| or matches |Regexp|, so
+// that a MatchRawBytes can also be used as the PreFilter for a scan.
+// For Certificates |der| also covers the signature, so this may accept
+// entries which CertificateMatches rejects, but never the reverse.
+func (m MatchRawBytes) RawMatches(der []byte) bool {
+	return m.tbsMatches(der)
+}
+
+// ASN.1 universal tags of the string types which may appear in a Name
+const (
+	tagUTF8String      = 12
+	tagPrintableString = 19
+	tagTeletexString   = 20
+	tagIA5String       = 22
+	tagVisibleString   = 26
+	tagUniversalString = 28
+	tagBMPString       = 30
+)
+
+// Variants of the pkix Name structures which keep attribute values undecoded,
+// so that their ASN.1 string types can be inspected. Note that the asn1
+// package relies on the "SET" suffix of the type name.
+type rawAttributeTypeAndValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type rawRelativeDistinguishedNameSET []rawAttributeTypeAndValue
+
+type rawRDNSequence []rawRelativeDistinguishedNameSET
+
+// MatchCertWithNonUTF8Subject is a Matcher which matches Certificates and
+// Precertificates whose Subject contains an attribute value which isn't valid
+// UTF-8, or which uses one of the ASN.1 string types in |DisallowedTags|.
+// If |DisallowedTags| is nil, the deprecated TeletexString, UniversalString
+// and BMPString types are disallowed.
+// Subjects which fail to parse never match.
+type MatchCertWithNonUTF8Subject struct {
+	DisallowedTags []int
+}
+
+func (m MatchCertWithNonUTF8Subject) subjectMatches(rawSubject []byte) bool {
+	var rdns rawRDNSequence
+	if rest, err := asn1.Unmarshal(rawSubject, &rdns); err != nil || len(rest) > 0 {
+		return false
+	}
+	disallowed := m.DisallowedTags
+	if disallowed == nil {
+		disallowed = []int{tagTeletexString, tagUniversalString, tagBMPString}
+	}
+	for _, rdn := range rdns {
+		for _, atv := range rdn {
+			for _, tag := range disallowed {
+				if atv.Value.Class == 0 && atv.Value.Tag == tag {
+					return true
+				}
+			}
+			switch atv.Value.Tag {
+			case tagUTF8String, tagPrintableString, tagIA5String, tagVisibleString:
+				if !utf8.Valid(atv.Value.Bytes) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Returns true if the Subject of |c| has a non-UTF-8 or disallowed value.
+func (m MatchCertWithNonUTF8Subject) CertificateMatches(c *x509.Certificate) bool {
+	return m.subjectMatches(c.RawSubject)
+}
+
+// Returns true if the Subject of the TBSCertificate in |p| has a non-UTF-8 or
+// disallowed value.
+func (m MatchCertWithNonUTF8Subject) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.subjectMatches(p.TBSCertificate.RawSubject)
+}
+
+// ASN.1 universal tags of the types which may encode an X.509 Time
+const (
+	tagUTCTime         = 23
+	tagGeneralizedTime = 24
+)
+
+// The leading fields of a TBSCertificate, with the validity times left
+// undecoded so that their ASN.1 types can be inspected.
+type rawValidityTBSCertificate struct {
+	Version            int `asn1:"optional,explicit,default:1,tag:0"`
+	SerialNumber       asn1.RawValue
+	SignatureAlgorithm asn1.RawValue
+	Issuer             asn1.RawValue
+	Validity           struct {
+		NotBefore, NotAfter asn1.RawValue
+	}
+}
+
+// Returns true if |t| doesn't use the Time encoding required by RFC 5280
+// section 4.1.2.5: UTCTime for dates up to 2049, and GeneralizedTime from
+// 2050 onwards. (A UTCTime can't represent 2050 or later, so only misuse of
+// GeneralizedTime can be detected.)
+func isMisencodedTime(t asn1.RawValue) bool {
+	if t.Class != 0 {
+		return true
+	}
+	switch t.Tag {
+	case tagUTCTime:
+		return false
+	case tagGeneralizedTime:
+		if len(t.Bytes) < 4 {
+			return true
+		}
+		year, err := strconv.Atoi(string(t.Bytes[:4]))
+		return err != nil || year < 2050
+	}
+	return true
+}
+
+// MatchCertWithInvalidValidityEncoding is a Matcher which matches Certificates
+// and Precertificates whose notBefore or notAfter time is encoded using the
+// wrong ASN.1 type (see isMisencodedTime).
+// Certificates whose TBSCertificate fails to parse never match.
+type MatchCertWithInvalidValidityEncoding struct{}
+
+func (m MatchCertWithInvalidValidityEncoding) tbsMatches(rawTBS []byte) bool {
+	var tbs rawValidityTBSCertificate
+	if _, err := asn1.Unmarshal(rawTBS, &tbs); err != nil {
+		return false
+	}
+	return isMisencodedTime(tbs.Validity.NotBefore) || isMisencodedTime(tbs.Validity.NotAfter)
+}
+
+// Returns true if the validity of |c| is misencoded.
+func (m MatchCertWithInvalidValidityEncoding) CertificateMatches(c *x509.Certificate) bool {
+	return m.tbsMatches(c.RawTBSCertificate)
+}
+
+// Returns true if the validity of the TBSCertificate in |p| is misencoded.
+func (m MatchCertWithInvalidValidityEncoding) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.tbsMatches(p.TBSCertificate.RawTBSCertificate)
+}
+
+// OID of the Subject serialNumber attribute, used for device identifiers
+var oidSubjectSerialNumber = asn1.ObjectIdentifier{2, 5, 4, 5}
+
+// MatchCertBySubjectSerialNumber is a Matcher which matches Certificates and
+// Precertificates by the serialNumber attribute of their Subject (which is
+// unrelated to the certificate's own serial number). An entry matches if any
+// Subject serialNumber is exactly one of |Values|, or matches |Regex|.
+// Either may be left unset.
+type MatchCertBySubjectSerialNumber struct {
+	Values []string
+	Regex  *regexp.Regexp
+}
+
+func (m MatchCertBySubjectSerialNumber) subjectMatches(rawSubject []byte) bool {
+	rdns, err := parseName(rawSubject)
+	if err != nil {
+		return false
+	}
+	for _, v := range nameValues(rdns, oidSubjectSerialNumber) {
+		if m.Regex != nil && m.Regex.MatchString(v) {
+			return true
+		}
+		for _, value := range m.Values {
+			if v == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns true if a Subject serialNumber of |c| matches.
+func (m MatchCertBySubjectSerialNumber) CertificateMatches(c *x509.Certificate) bool {
+	return m.subjectMatches(c.RawSubject)
+}
+
+// Returns true if a Subject serialNumber of the TBSCertificate in |p| matches.
+func (m MatchCertBySubjectSerialNumber) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.subjectMatches(p.TBSCertificate.RawSubject)
+}
+
+// MatchCertWithoutEKU is a Matcher which matches Certificates and
+// Precertificates without an extended key usage extension, which are
+// therefore valid for any purpose.
+// If |LeafOnly| is true, only leaf certificates (those without a CA basic
+// constraint) are matched, since CA certificates commonly omit EKU.
+type MatchCertWithoutEKU struct {
+	LeafOnly bool
+}
+
+func (m MatchCertWithoutEKU) certMatches(c *x509.Certificate) bool {
+	if m.LeafOnly && c.BasicConstraintsValid && c.IsCA {
+		return false
+	}
+	return len(c.ExtKeyUsage) == 0 && len(c.UnknownExtKeyUsage) == 0
+}
+
+// Returns true if |c| has no extended key usages.
+func (m MatchCertWithoutEKU) CertificateMatches(c *x509.Certificate) bool {
+	return m.certMatches(c)
+}
+
+// Returns true if the TBSCertificate in |p| has no extended key usages.
+func (m MatchCertWithoutEKU) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.certMatches(&p.TBSCertificate)
+}
+
+// SKIMethod identifies one of the methods of deriving a Subject Key
+// Identifier from a public key described in RFC 5280 section 4.2.1.2.
+type SKIMethod int
+
+const (
+	// The 160-bit SHA-1 hash of the subjectPublicKey BIT STRING.
+	SKIMethod1 SKIMethod = iota + 1
+	// The four bits 0100 followed by the least significant 60 bits of the
+	// SHA-1 hash of the subjectPublicKey BIT STRING.
+	SKIMethod2
+)
+
+// Returns the Subject Key Identifier derived from the DER encoded
+// SubjectPublicKeyInfo |rawSPKI| by |method|.
+func deriveSubjectKeyId(rawSPKI []byte, method SKIMethod) ([]byte, error) {
+	var spki struct {
+		Algorithm asn1.RawValue
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(rawSPKI, &spki); err != nil {
+		return nil, err
+	}
+	hash := sha1.Sum(spki.PublicKey.Bytes)
+	switch method {
+	case SKIMethod1:
+		return hash[:], nil
+	case SKIMethod2:
+		ski := append([]byte(nil), hash[12:]...)
+		ski[0] = 0x40 | (ski[0] & 0x0f)
+		return ski, nil
+	}
+	return nil, fmt.Errorf("unknown SKI method %d", method)
+}
+
+// MatchCertBySubjectKeyIdentifierMismatch is a Matcher which matches
+// Certificates and Precertificates with a Subject Key Identifier which
+// wasn't derived from their public key by any of |Methods|.
+// RFC 5280 only recommends these methods, so other derivations aren't
+// necessarily wrong, but they are unusual. If |Methods| is empty, both
+// SKIMethod1 and SKIMethod2 are accepted.
+// Entries without a Subject Key Identifier, or whose public key can't be
+// parsed, never match.
+type MatchCertBySubjectKeyIdentifierMismatch struct {
+	Methods []SKIMethod
+}
+
+func (m MatchCertBySubjectKeyIdentifierMismatch) certMatches(c *x509.Certificate) bool {
+	if len(c.SubjectKeyId) == 0 {
+		return false
+	}
+	methods := m.Methods
+	if len(methods) == 0 {
+		methods = []SKIMethod{SKIMethod1, SKIMethod2}
+	}
+	for _, method := range methods {
+		expected, err := deriveSubjectKeyId(c.RawSubjectPublicKeyInfo, method)
+		if err != nil || bytes.Equal(expected, c.SubjectKeyId) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if the SubjectKeyId of |c| isn't derived from its public key.
+func (m MatchCertBySubjectKeyIdentifierMismatch) CertificateMatches(c *x509.Certificate) bool {
+	return m.certMatches(c)
+}
+
+// Returns true if the SubjectKeyId of the TBSCertificate in |p| isn't derived
+// from its public key.
+func (m MatchCertBySubjectKeyIdentifierMismatch) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.certMatches(&p.TBSCertificate)
+}
+
+// MatchCertByNotBeforeAfterNotAfter is a Matcher which matches Certificates
+// and Precertificates whose NotBefore is later than their NotAfter. Such
+// certificates can never be valid, and indicate an issuance bug.
+type MatchCertByNotBeforeAfterNotAfter struct{}
+
+// Returns true if the validity period of |c| is inverted.
+func (m MatchCertByNotBeforeAfterNotAfter) CertificateMatches(c *x509.Certificate) bool {
+	return c.NotBefore.After(c.NotAfter)
+}
+
+// Returns true if the validity period of the TBSCertificate in |p| is
+// inverted.
+func (m MatchCertByNotBeforeAfterNotAfter) PrecertificateMatches(p *client.Precertificate) bool {
+	return p.TBSCertificate.NotBefore.After(p.TBSCertificate.NotAfter)
+}
+
+// OID of the subjectAltName X.509v3 extension (RFC 5280 section 4.2.1.6)
+var oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// GeneralNameType is the context-specific tag identifying the type of a
+// GeneralName (RFC 5280 section 4.2.1.6).
+type GeneralNameType int
+
+const (
+	GeneralNameOtherName                 GeneralNameType = 0
+	GeneralNameRFC822Name                GeneralNameType = 1
+	GeneralNameDNSName                   GeneralNameType = 2
+	GeneralNameX400Address               GeneralNameType = 3
+	GeneralNameDirectoryName             GeneralNameType = 4
+	GeneralNameEDIPartyName              GeneralNameType = 5
+	GeneralNameUniformResourceIdentifier GeneralNameType = 6
+	GeneralNameIPAddress                 GeneralNameType = 7
+	GeneralNameRegisteredID              GeneralNameType = 8
+)
+
+// Returns the types of the GeneralNames in the subjectAltName extension of
+// |c|, or nil if it has none.
+// Returns a non-nil error if the extension can't be parsed.
+func subjectAltNameTypes(c *x509.Certificate) ([]GeneralNameType, error) {
+	for _, ext := range c.Extensions {
+		if !ext.Id.Equal(oidExtensionSubjectAltName) {
+			continue
+		}
+		var seq asn1.RawValue
+		rest, err := asn1.Unmarshal(ext.Value, &seq)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) > 0 || seq.Class != 0 || seq.Tag != 16 || !seq.IsCompound {
+			return nil, asn1.StructuralError{Msg: "bad subjectAltName sequence"}
+		}
+		var types []GeneralNameType
+		for names := seq.Bytes; len(names) > 0; {
+			var name asn1.RawValue
+			if names, err = asn1.Unmarshal(names, &name); err != nil {
+				return nil, err
+			}
+			if name.Class != 2 {
+				return nil, asn1.StructuralError{Msg: "GeneralName isn't context-specific"}
+			}
+			types = append(types, GeneralNameType(name.Tag))
+		}
+		return types, nil
+	}
+	return nil, nil
+}
+
+// MatchCertBySubjectAltNameType is a Matcher which matches Certificates and
+// Precertificates whose subjectAltName extension holds a GeneralName of one of
+// |Types|. If |Types| is nil, the types which the x509 package doesn't expose
+// are used: otherName, x400Address, directoryName, ediPartyName and
+// registeredID.
+// Entries whose subjectAltName extension can't be parsed never match.
+type MatchCertBySubjectAltNameType struct {
+	Types []GeneralNameType
+}
+
+func (m MatchCertBySubjectAltNameType) certMatches(c *x509.Certificate) bool {
+	wanted := m.Types
+	if wanted == nil {
+		wanted = []GeneralNameType{GeneralNameOtherName, GeneralNameX400Address, GeneralNameDirectoryName, GeneralNameEDIPartyName, GeneralNameRegisteredID}
+	}
+	types, err := subjectAltNameTypes(c)
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		for _, w := range wanted {
+			if t == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns true if |c| has a SAN of one of |Types|.
+func (m MatchCertBySubjectAltNameType) CertificateMatches(c *x509.Certificate) bool {
+	return m.certMatches(c)
+}
+
+// Returns true if the TBSCertificate in |p| has a SAN of one of |Types|.
+func (m MatchCertBySubjectAltNameType) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.certMatches(&p.TBSCertificate)
+}
+
+// MatchCertByRawDERHashPrefix is a Matcher which matches the entries in shard
+// |ShardIndex| of |ShardCount|, where an entry's shard is the SHA-256 hash of
+// its DER (Certificate.Raw or Precertificate.Raw), as a big-endian integer,
+// mod ShardCount. This lets each of ShardCount scanners scan the whole log but
+// process a disjoint subset of its entries, the shards partitioning the
+// entries by content rather than by index.
+// Nothing matches if ShardCount is <= 0.
+type MatchCertByRawDERHashPrefix struct {
+	ShardIndex int
+	ShardCount int
+}
+
+func (m MatchCertByRawDERHashPrefix) rawMatches(der []byte) bool {
+	if m.ShardCount <= 0 {
+		return false
+	}
+	hash := sha256.Sum256(der)
+	shard := new(big.Int).Mod(new(big.Int).SetBytes(hash[:]), big.NewInt(int64(m.ShardCount)))
+	return shard.Int64() == int64(m.ShardIndex)
+}
+
+// Returns true if the hash of |c|'s DER falls in shard |ShardIndex|.
+func (m MatchCertByRawDERHashPrefix) CertificateMatches(c *x509.Certificate) bool {
+	return m.rawMatches(c.Raw)
+}
+
+// Returns true if the hash of |p|'s DER falls in shard |ShardIndex|.
+func (m MatchCertByRawDERHashPrefix) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.rawMatches(p.Raw)
+}
+
+// Returns true if |name| contains whitespace, or an empty label, including
+// from a leading dot or consecutive dots. A single trailing dot, denoting the
+// root, is allowed.
+func hasWhitespaceOrEmptyLabel(name string) bool {
+	if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return true
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchCertByAnyInternalWhitespaceInDNSName is a Matcher which matches
+// Certificates and Precertificates with a dNSName SAN containing whitespace,
+// a leading dot, more than one trailing dot, or an empty label between
+// consecutive dots. Such names aren't valid DNS names, and indicate an
+// encoding bug at the issuing CA.
+type MatchCertByAnyInternalWhitespaceInDNSName struct{}
+
+func (m MatchCertByAnyInternalWhitespaceInDNSName) namesMatch(names []string) bool {
+	for _, name := range names {
+		if hasWhitespaceOrEmptyLabel(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if any dNSName SAN of |c| is malformed.
+func (m MatchCertByAnyInternalWhitespaceInDNSName) CertificateMatches(c *x509.Certificate) bool {
+	return m.namesMatch(c.DNSNames)
+}
+
+// Returns true if any dNSName SAN of the TBSCertificate in |p| is malformed.
+func (m MatchCertByAnyInternalWhitespaceInDNSName) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.namesMatch(p.TBSCertificate.DNSNames)
+}
+
+// MatchCertByIssuerAndSubjectCNEqualButNotSelfSigned is a Matcher which
+// matches Certificates and Precertificates whose issuer and subject have the
+// same, non-empty, commonName, but differ elsewhere, so that the certificate
+// looks self-issued without being so. This can indicate a name collision between
+// CAs or a misconfigured issuer.
+type MatchCertByIssuerAndSubjectCNEqualButNotSelfSigned struct{}
+
+func (m MatchCertByIssuerAndSubjectCNEqualButNotSelfSigned) certMatches(c *x509.Certificate) bool {
+	return c.Subject.CommonName != "" && c.Issuer.CommonName == c.Subject.CommonName && !bytes.Equal(c.RawIssuer, c.RawSubject)
+}
+
+// Returns true if |c|'s issuer and subject share a commonName but differ.
+func (m MatchCertByIssuerAndSubjectCNEqualButNotSelfSigned) CertificateMatches(c *x509.Certificate) bool {
+	return m.certMatches(c)
+}
+
+// Returns true if the issuer and subject of the TBSCertificate in |p| share a
+// commonName but differ.
+func (m MatchCertByIssuerAndSubjectCNEqualButNotSelfSigned) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.certMatches(&p.TBSCertificate)
+}
+
+// Returns true if |t| is a UTCTime or GeneralizedTime which doesn't end in
+// "Z", i.e. is encoded with a time zone offset (or none at all).
+func hasTimeZoneOffset(t asn1.RawValue) bool {
+	if t.Class != 0 || (t.Tag != tagUTCTime && t.Tag != tagGeneralizedTime) {
+		return false
+	}
+	return !bytes.HasSuffix(t.Bytes, []byte("Z"))
+}
+
+// MatchCertByValidityStraddlingLeapSecondOrDSTBoundary is a Matcher which
+// matches Certificates and Precertificates whose notBefore or notAfter is
+// encoded with a time zone offset rather than as UTC ("Z"), as RFC 5280
+// section 4.1.2.5 requires. Such times are typically the result of a CA
+// mishandling local time, and may be interpreted differently by different
+// parsers, e.g. around daylight saving time changes.
+// Certificates whose TBSCertificate fails to parse never match.
+type MatchCertByValidityStraddlingLeapSecondOrDSTBoundary struct{}
+
+func (m MatchCertByValidityStraddlingLeapSecondOrDSTBoundary) tbsMatches(rawTBS []byte) bool {
+	var tbs rawValidityTBSCertificate
+	if _, err := asn1.Unmarshal(rawTBS, &tbs); err != nil {
+		return false
+	}
+	return hasTimeZoneOffset(tbs.Validity.NotBefore) || hasTimeZoneOffset(tbs.Validity.NotAfter)
+}
+
+// Returns true if |c|'s validity includes a time which isn't encoded as UTC.
+func (m MatchCertByValidityStraddlingLeapSecondOrDSTBoundary) CertificateMatches(c *x509.Certificate) bool {
+	return m.tbsMatches(c.RawTBSCertificate)
+}
+
+// Returns true if the validity of the TBSCertificate in |p| includes a time
+// which isn't encoded as UTC.
+func (m MatchCertByValidityStraddlingLeapSecondOrDSTBoundary) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.tbsMatches(p.TBSCertificate.RawTBSCertificate)
+}
+
+// ExtensionCriticalityRule is the criticality which RFC 5280 requires or
+// recommends for an extension, as checked by
+// MatchCertByExtensionCriticalityViolation.
+type ExtensionCriticalityRule struct {
+	// Short description of the rule, e.g. "basicConstraints critical on CA"
+	Name string
+	Id   asn1.ObjectIdentifier
+	// Whether the extension should be marked critical
+	Critical bool
+	// If set, the rule only applies to CA certificates
+	CAOnly bool
+}
+
+// The criticality rules checked by MatchCertByExtensionCriticalityViolation
+// if none are given. See RFC 5280 section 4.2.
+var DefaultExtensionCriticalityRules = []ExtensionCriticalityRule{
+	{Name: "basicConstraints critical on CA", Id: asn1.ObjectIdentifier{2, 5, 29, 19}, Critical: true, CAOnly: true},
+	{Name: "keyUsage critical", Id: asn1.ObjectIdentifier{2, 5, 29, 15}, Critical: true},
+	{Name: "nameConstraints critical", Id: asn1.ObjectIdentifier{2, 5, 29, 30}, Critical: true},
+	{Name: "certificatePolicies non-critical", Id: asn1.ObjectIdentifier{2, 5, 29, 32}, Critical: false},
+	{Name: "authorityKeyIdentifier non-critical", Id: asn1.ObjectIdentifier{2, 5, 29, 35}, Critical: false},
+	{Name: "subjectKeyIdentifier non-critical", Id: asn1.ObjectIdentifier{2, 5, 29, 14}, Critical: false},
+	{Name: "authorityInfoAccess non-critical", Id: asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 1}, Critical: false},
+}
+
+// MatchCertByExtensionCriticalityViolation is a Matcher which matches
+// Certificates and Precertificates with an extension whose critical flag
+// violates one of |Rules| (DefaultExtensionCriticalityRules if nil). To turn
+// off individual checks, pass a copy of DefaultExtensionCriticalityRules
+// without them.
+// The CT poison extension of a Precertificate is never checked, since its
+// criticality is mandated by RFC 6962 rather than RFC 5280.
+type MatchCertByExtensionCriticalityViolation struct {
+	Rules []ExtensionCriticalityRule
+}
+
+// Returns the first rule violated by |c|, or nil if there is none.
+func (m MatchCertByExtensionCriticalityViolation) Violation(c *x509.Certificate) *ExtensionCriticalityRule {
+	rules := m.Rules
+	if rules == nil {
+		rules = DefaultExtensionCriticalityRules
+	}
+	for _, ext := range c.Extensions {
+		if ext.Id.Equal(oidExtensionCTPoison) {
+			continue
+		}
+		for i := range rules {
+			r := &rules[i]
+			if !ext.Id.Equal(r.Id) || (r.CAOnly && !c.IsCA) {
+				continue
+			}
+			if ext.Critical != r.Critical {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+// Returns true if an extension of |c| violates a criticality rule.
+func (m MatchCertByExtensionCriticalityViolation) CertificateMatches(c *x509.Certificate) bool {
+	return m.Violation(c) != nil
 }
 
-func (m MatchNone) PrecertificateMatches(_ *client.Precertificate) bool {
-	return false
+// Returns true if an extension of the TBSCertificate in |p| violates a
+// criticality rule.
+func (m MatchCertByExtensionCriticalityViolation) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.Violation(&p.TBSCertificate) != nil
 }
 
-// MatchSubjectRegex is a Matcher which will use |CertificateSubjectRegex| and |PrecertificateSubjectRegex|
-// to determine whether Certificates and Precertificates are interesting.
-// The two regexes are tested against Subject Common Name as well as all
-// Subject Alternative Names
-type MatchSubjectRegex struct {
-	CertificateSubjectRegex    *regexp.Regexp
-	PrecertificateSubjectRegex *regexp.Regexp
+// MatchValidity is a Matcher which matches Certificates and Precertificates
+// by their validity period. Each bound which is set (non-zero) must be
+// satisfied for an entry to match:
+//   - NotAfterBefore / NotAfterAfter: notAfter is before / after the time,
+//     e.g. to find certificates expiring within a window;
+//   - NotBeforeBefore / NotBeforeAfter: notBefore is before / after the time,
+//     e.g. to find certificates issued after a date;
+//   - MaxValidityDuration: the validity period (notAfter - notBefore) is
+//     longer than the duration, e.g. 398 days to find certificates with a
+//     suspiciously long validity.
+//
+// A MatchValidity with no bounds set matches everything.
+type MatchValidity struct {
+	NotAfterBefore      time.Time
+	NotAfterAfter       time.Time
+	NotBeforeBefore     time.Time
+	NotBeforeAfter      time.Time
+	MaxValidityDuration time.Duration
 }
 
-// Returns true if either CN or any SAN of |c| matches |CertificateSubjectRegex|.
-func (m MatchSubjectRegex) CertificateMatches(c *x509.Certificate) bool {
-	if m.CertificateSubjectRegex.FindStringIndex(c.Subject.CommonName) != nil {
-		return true
+func (m MatchValidity) certMatches(c *x509.Certificate) bool {
+	if !m.NotAfterBefore.IsZero() && !c.NotAfter.Before(m.NotAfterBefore) {
+		return false
 	}
-	for _, alt := range c.DNSNames {
-		if m.CertificateSubjectRegex.FindStringIndex(alt) != nil {
-			return true
-		}
+	if !m.NotAfterAfter.IsZero() && !c.NotAfter.After(m.NotAfterAfter) {
+		return false
 	}
-	return false
-}
-
-// Returns true if either CN or any SAN of |p| matches |PrecertificatesubjectRegex|.
-func (m MatchSubjectRegex) PrecertificateMatches(p *client.Precertificate) bool {
-	if m.PrecertificateSubjectRegex.FindStringIndex(p.TBSCertificate.Subject.CommonName) != nil {
-		return true
+	if !m.NotBeforeBefore.IsZero() && !c.NotBefore.Before(m.NotBeforeBefore) {
+		return false
 	}
-	for _, alt := range p.TBSCertificate.DNSNames {
-		if m.PrecertificateSubjectRegex.FindStringIndex(alt) != nil {
-			return true
-		}
+	if !m.NotBeforeAfter.IsZero() && !c.NotBefore.After(m.NotBeforeAfter) {
+		return false
 	}
-	return false
+	if m.MaxValidityDuration > 0 && c.NotAfter.Sub(c.NotBefore) <= m.MaxValidityDuration {
+		return false
+	}
+	return true
+}
+
+// Returns true if the validity period of |c| satisfies every bound set.
+func (m MatchValidity) CertificateMatches(c *x509.Certificate) bool {
+	return m.certMatches(c)
+}
+
+// Returns true if the validity period of the TBSCertificate in |p| satisfies
+// every bound set.
+func (m MatchValidity) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.certMatches(&p.TBSCertificate)
 }
 
 // ScannerOptions holds configuration options for the Scanner
@@ -97,33 +1657,229 @@ type ScannerOptions struct {
 	// Number of concurrent matchers to run
 	NumWorkers int
 
+	// Vary the number of concurrent matchers during the scan, between
+	// MinWorkers and MaxWorkers, according to how well they're keeping up
+	// with the fetchers, rather than running NumWorkers matchers. See
+	// workerScaler for the details.
+	AutoScaleWorkers bool
+
+	// Bounds on the number of concurrent matchers when AutoScaleWorkers is
+	// set. MinWorkers defaults to 1, and MaxWorkers to NumWorkers.
+	MinWorkers int
+	MaxWorkers int
+
+	// Time between adjustments of the number of matchers when
+	// AutoScaleWorkers is set. Defaults to defaultWorkerScaleInterval if <= 0.
+	WorkerScaleInterval time.Duration
+
 	// Number of concurrent fethers to run
 	ParallelFetch int
 
 	// Log entry index to start fetching & matching at
 	StartIndex int64
 
+	// Fetch the ranges nearest the tip of the log first, alternating between
+	// the newest and oldest remaining ranges, so that recent entries are seen
+	// early while older entries are still covered.
+	PrioritizeTip bool
+
+	// Fetch the ranges from the tip of the log (or EndIndex) downwards, so
+	// that the newest entries are seen first. Takes precedence over
+	// PrioritizeTip. As entries below the last one processed remain, a
+	// reverse scan which is stopped early resumes from StartIndex.
+	Reverse bool
+
+	// Fetch the extra_data of each entry, and parse the submitted chain of
+	// each precert into its IssuerChain, and of each cert for a ChainMatcher.
+	// Requires a LogSource which implements ExtraDataSource; ignored
+	// otherwise.
+	FetchChains bool
+
+	// If non-nil, entries which PreFilter rejects are skipped before they're
+	// parsed, saving the cost of parsing entries which can't match. Skipped
+	// entries are counted in ScanStats.EntriesPreFiltered, and aren't
+	// included in ScanStats.KeyTypes.
+	PreFilter RawMatcher
+
+	// How failed requests to the log are retried, backing off between
+	// attempts as set by InitialBackoff, MaxBackoff and Jitter. A delay
+	// requested by the log with a Retry-After header takes precedence, but is
+	// capped at the maximum backoff. Requests which fail with a terminal error
+	// (see isTerminalError), such as a 404, aren't retried.
+	//
+	// For the STH fetched at the start of a scan, the zero value disables
+	// retries. A range of entries is abandoned after a terminal error or
+	// once MaxAttempts attempts at it have failed, so that the rest of the
+	// log can still be scanned; Scan then returns a *FetchError listing the
+	// abandoned ranges rather than nil. If MaxAttempts is <= 0, as in the
+	// zero value, fetches of entries are retried indefinitely, backing off by
+	// defaultFetchInitialBackoff and defaultFetchMaxBackoff where
+	// InitialBackoff and MaxBackoff are unset.
+	//
+	// Note that DefaultScannerOptions uses DefaultRetryConfig, so scans
+	// configured from it abandon a range of entries after 5 failed attempts,
+	// where previously they retried it indefinitely. Set MaxAttempts to 0 to
+	// keep retrying.
+	Retry RetryConfig
+
+	// If > 0, stop the scan before entry EndIndex, or at the current STH's
+	// tree size if that's smaller, so that only the entries in
+	// [StartIndex, EndIndex) are scanned.
+	EndIndex int64
+
+	// If non-zero, scan the log as if its tree size were TreeSize rather than
+	// the size given by the current STH, i.e. stop before entry TreeSize.
+	// This allows a fixed prefix of the log to be deterministically
+	// re-scanned. Must not exceed the current STH's tree size.
+	TreeSize int64
+
+	// If set, the scan is pinned to this previously obtained STH instead of
+	// fetching the latest one, so that it's reproducible and can be
+	// coordinated between Scanners. StartIndex must not exceed its tree size.
+	// Note that ScanContinuous will then never find new entries.
+	STH *client.SignedTreeHead
+
 	// Don't print any status messages to stdout
 	Quiet bool
+
+	// If set, called every second with the progress of the scan, instead of
+	// the progress being logged.
+	ProgressCallback func(ScanProgress)
+
+	// If set, called with each entry which couldn't be parsed, or was parsed
+	// with an x509.NonFatalErrors, along with its raw MerkleTreeLeaf so that
+	// it can be kept for analysis. If the MerkleTreeLeaf itself couldn't be
+	// parsed, |err| is a MerkleTreeLeafError and |entryType| is meaningless.
+	// May be called concurrently from multiple goroutines.
+	OnParseError func(err error, entryType client.LogEntryType, index int64, rawLeaf client.LeafInput)
+
+	// Receives updates to the scan metrics (see the Metric* constants) as the
+	// scan progresses. May be nil.
+	Metrics MetricsRegistrar
+
+	// If set, called with a snapshot of the scan's progress every
+	// StateEntryInterval processed entries and/or every StateInterval, and
+	// once more when the scan completes.
+	StateCallback func(ScanState)
+
+	// Number of processed entries between calls to StateCallback; <= 0 to
+	// disable.
+	StateEntryInterval int64
+
+	// Time between calls to StateCallback; <= 0 to disable.
+	StateInterval time.Duration
+
+	// If set, the first scan by a Scanner resumes from the ScanState loaded
+	// from Checkpoint, if any, rather than from StartIndex, and each snapshot
+	// which would be passed to StateCallback is saved to it. If neither
+	// StateEntryInterval nor StateInterval is set, a snapshot is taken every
+	// BlockSize entries. The scan is stopped, and returns an error, if a
+	// snapshot can't be saved.
+	Checkpoint CheckpointStore
+
+	// Time at the start of a scan which is excluded from the throughput used
+	// to estimate the remaining time in progress messages, since it's
+	// dominated by connection setup; the ETA is reported as "calculating"
+	// until it's passed. Defaults to defaultThroughputWarmUp if 0; < 0 to
+	// disable.
+	ThroughputWarmUp time.Duration
+
+	// What ScanSink should do when the Sink can't keep up with the matched
+	// entries. Defaults to SlowSinkBlock.
+	SlowSinkPolicy SlowSinkPolicy
+
+	// Number of matched entries which may be queued for the Sink before the
+	// SlowSinkPolicy applies. Unused by SlowSinkBlock unless SinkWorkers is
+	// set. Defaults to defaultSinkQueueSize if <= 0.
+	SinkQueueSize int
+
+	// Number of goroutines which ScanSink uses to pass queued entries to the
+	// Sink, independently of NumWorkers, so that the Sink's concurrency can
+	// be tuned separately from matching. If <= 0, the Sink is called from the
+	// matcher goroutines under SlowSinkBlock, and from a single goroutine
+	// under the other policies.
+	SinkWorkers int
+
+	// Directory in which SlowSinkSpillToDisk stages overflowing entries.
+	// Defaults to the system temporary directory.
+	SpillDir string
+
+	// Number of recently matched certificates remembered by ScanContinuous in
+	// order to suppress repeated deliveries of the same certificate; <= 0 to
+	// disable.
+	DedupeWindow int
+
+	// If > 0, once a scan has completed, re-fetch a random sample of
+	// VerifySampleSize of the scanned entries, and check that each is
+	// identical to the entry which was processed, to catch corruption in
+	// transit or a log serving inconsistent data.
+	VerifySampleSize int
+
+	// Called with a *VerificationError for each entry which differs when
+	// re-fetched by the verification pass. If nil, the scan instead returns
+	// the first such error.
+	OnVerificationFailure func(error)
+
+	// Called by ScanContinuous with an *STHAnomalyError when a poll's STH has
+	// a smaller tree size or an earlier timestamp than the previous poll's,
+	// which may indicate a fork or rollback of the log. Scanning continues
+	// afterwards. If nil, ScanContinuous returns the error instead.
+	OnAnomaly func(error)
 }
 
-// Creates a new ScannerOptions struct with sensible defaults
+// Upper limit on the default number of concurrent fetchers, to avoid hammering
+// logs from machines with many cores.
+const maxDefaultParallelFetch = 4
+
+// Creates a new ScannerOptions struct with sensible defaults.
+// The default NumWorkers is GOMAXPROCS, so that matching uses every available
+// core, and the default ParallelFetch is GOMAXPROCS capped at
+// maxDefaultParallelFetch. Callers wanting to limit CPU or network usage
+// should set these explicitly.
 func DefaultScannerOptions() *ScannerOptions {
+	procs := runtime.GOMAXPROCS(0)
 	return &ScannerOptions{
 		Matcher:       &MatchAll{},
 		PrecertOnly:   false,
 		BlockSize:     1000,
-		NumWorkers:    1,
-		ParallelFetch: 1,
+		NumWorkers:    procs,
+		ParallelFetch: int(min(int64(procs), maxDefaultParallelFetch)),
 		StartIndex:    0,
 		Quiet:         false,
+		Retry:         DefaultRetryConfig(),
 	}
 }
 
+// LogSource is the subset of the log client API used by the Scanner.
+// *client.LogClient implements it; other implementations may wrap a
+// LogClient (e.g. to add caching or rate limiting) or fake a log for tests.
+// Methods may be called concurrently from multiple goroutines.
+type LogSource interface {
+	// GetSTH returns the current STH of the log.
+	GetSTH() (*client.SignedTreeHead, error)
+	// GetSTHCtx is as GetSTH, but abandons the request if |ctx| is done.
+	GetSTHCtx(ctx context.Context) (*client.SignedTreeHead, error)
+	// GetEntries returns the leaves in the sequence [|start|, |end|]. It may
+	// return fewer leaves than requested, but never more.
+	GetEntries(start, end int64) ([]client.LeafInput, error)
+	// GetEntriesCtx is as GetEntries, but abandons the request if |ctx| is
+	// done.
+	GetEntriesCtx(ctx context.Context, start, end int64) ([]client.LeafInput, error)
+}
+
+// LogSources which can also return the extra_data of each entry may implement
+// this interface; *client.LogClient does. It's used when
+// ScannerOptions.FetchChains is set.
+type ExtraDataSource interface {
+	// GetRawEntriesCtx is as GetEntriesCtx, but also returns the extra_data
+	// of each entry.
+	GetRawEntriesCtx(ctx context.Context, start, end int64) ([]client.RawLogEntry, error)
+}
+
 // Scanner is a tool to scan all the entries in a CT Log.
 type Scanner struct {
-	// Client used to talk to the CT log instance
-	logClient *client.LogClient
+	// Source of the CT log's STH and entries
+	source LogSource
 
 	// Configuration options for this Scanner instance
 	opts ScannerOptions
@@ -136,6 +1892,211 @@ type Scanner struct {
 
 	unparsableEntries         int64
 	entriesWithNonFatalErrors int64
+
+	// Counter of the entries whose MerkleTreeLeaf couldn't be parsed
+	malformedLeaves int64
+
+	// Counters of matched entries which a slow Sink caused to be dropped or
+	// spilled to disk.
+	droppedMatches int64
+	spilledMatches int64
+
+	// Counter of the entries skipped by the PreFilter
+	entriesPreFiltered int64
+
+	// When the current scan started and, once it's finished, how long it
+	// took, in nanoseconds, and the index it stops before
+	scanStart    int64
+	scanElapsed  int64
+	scanTreeSize int64
+
+	// Ranges of entries abandoned during the current scan, guarded by
+	// failedMu.
+	failedRanges []FailedRange
+	failedMu     sync.Mutex
+
+	// Current number of matcher workers when AutoScaleWorkers is set
+	matcherWorkers int64
+
+	// The entries to re-fetch after the scan, if VerifySampleSize is set
+	sample *leafSample
+
+	// If set, entries are passed to foundRaw unparsed instead of being
+	// matched; see ScanRawCtx.
+	foundRaw func(int64, client.RawLogEntry)
+
+	// Number of parsed entries with each type of public key (see
+	// ClassifyKeyType), guarded by keyTypesMu.
+	keyTypes   map[string]int64
+	keyTypesMu sync.Mutex
+	// The STH being scanned towards, guarded by sthMu.
+	sth   *client.SignedTreeHead
+	sthMu sync.Mutex
+
+	// Tracks the contiguous range of processed entries
+	progress *progressTracker
+
+	// Serializes calls to the StateCallback
+	stateMu sync.Mutex
+
+	// Whether the Checkpoint has been loaded, and the first error saving to
+	// it during the current scan, guarded by stateMu.
+	checkpointLoaded bool
+	checkpointErr    error
+
+	// Cancels the current scan, and whether Stop has been called since a scan
+	// last observed it, guarded by stopMu.
+	cancelScan context.CancelFunc
+	stopped    bool
+	stopMu     sync.Mutex
+	// Closed once the current scan is stopping, after which the matchers
+	// drop any queued entries.
+	scanDone <-chan struct{}
+
+	// The first panic recovered from a worker during the current scan,
+	// guarded by panicMu.
+	panicErr *PanicError
+	panicMu  sync.Mutex
+}
+
+// PanicError is returned by Scan when a Matcher, callback or Sink panics.
+// The scan is stopped, and ResumeIndex records where it can safely be
+// restarted from: every entry below ResumeIndex was fully processed.
+type PanicError struct {
+	// The value passed to panic()
+	Value interface{}
+	// Index of the entry being processed when the panic occurred, or -1 if
+	// the panic didn't occur while processing an entry.
+	Index int64
+	// Index from which the scan can be resumed
+	ResumeIndex int64
+	// Stack trace of the panicking goroutine
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic processing entry %d: %v (resume from index %d)", e.Index, e.Value, e.ResumeIndex)
+}
+
+// Records the panic |value| which occurred while processing the entry at
+// |index|, and stops the scan.
+func (s *Scanner) recordPanic(value interface{}, index int64) {
+	s.Log(fmt.Sprintf("Recovered from panic at index %d: %v", index, value))
+	s.panicMu.Lock()
+	defer s.panicMu.Unlock()
+	if s.panicErr == nil {
+		s.panicErr = &PanicError{Value: value, Index: index, Stack: debug.Stack()}
+	}
+	s.cancelScan()
+}
+
+// ErrScanStopped is returned by a scan which was stopped by Scanner.Stop.
+var ErrScanStopped = errors.New("scan stopped")
+
+// Stops the current scan as soon as possible: entries which have been
+// fetched but not yet matched are dropped, and the scan returns
+// ErrScanStopped. May be called from the foundCert and foundPrecert callbacks,
+// e.g. once a sought certificate has been found, or from any other goroutine.
+// If no scan is running, e.g. between the passes of ScanContinuous, the next
+// scan returns ErrScanStopped as soon as it starts, so a Stop is never lost.
+func (s *Scanner) Stop() {
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
+	s.stopped = true
+	if s.cancelScan != nil {
+		s.cancelScan()
+	}
+}
+
+// Returns true, and clears the request, if Stop has been called since a scan
+// last observed it.
+func (s *Scanner) takeStop() bool {
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
+	stopped := s.stopped
+	s.stopped = false
+	return stopped
+}
+
+// ScanStats holds statistics gathered during a scan.
+type ScanStats struct {
+	// Number of entries processed, including those which couldn't be parsed,
+	// but not those with a malformed MerkleTreeLeaf
+	CertsProcessed int64
+	// Number of precertificate entries seen
+	PrecertsSeen int64
+	// Number of entries whose certificate or TBSCertificate couldn't be parsed
+	UnparsableEntries int64
+	// Number of entries whose MerkleTreeLeaf couldn't be parsed, so that
+	// CertsProcessed + MalformedLeaves is the number of entries scanned
+	MalformedLeaves int64
+	// Number of entries which parsed with non-fatal errors
+	EntriesWithNonFatalErrors int64
+	// Number of matched entries discarded because the Sink couldn't keep up
+	// (see SlowSinkDropAndCount)
+	DroppedMatches int64
+	// Number of matched entries staged on disk because the Sink couldn't
+	// keep up (see SlowSinkSpillToDisk)
+	SpilledMatches int64
+	// Number of entries skipped without being parsed because the PreFilter
+	// rejected them
+	EntriesPreFiltered int64
+	// Number of ranges of entries which couldn't be fetched (see
+	// ScannerOptions.Retry)
+	FailedRanges int64
+	// Number of parsed entries with each type of public key, keyed by the
+	// strings returned by ClassifyKeyType.
+	KeyTypes map[string]int64
+	// Time taken by the scan, or so far if it's still running
+	Elapsed time.Duration
+	// Index of the entry the scan stops before: the STH's tree size, unless
+	// bounded by TreeSize or EndIndex
+	TreeSize int64
+	// The STH fetched at the start of the scan, which the results correspond
+	// to (up to TreeSize), or nil if it hasn't been fetched yet. Omitted from
+	// JSON, as a ScanState records it alongside its Stats.
+	STH *client.SignedTreeHead `json:"-"`
+}
+
+// Returns the statistics gathered by the most recent call to Scan, or so far
+// if a scan is running. Use ScanWithStats to get those of a particular scan.
+func (s *Scanner) Stats() ScanStats {
+	stats := ScanStats{
+		CertsProcessed:            atomic.LoadInt64(&s.certsProcessed),
+		PrecertsSeen:              atomic.LoadInt64(&s.precertsSeen),
+		UnparsableEntries:         atomic.LoadInt64(&s.unparsableEntries),
+		MalformedLeaves:           atomic.LoadInt64(&s.malformedLeaves),
+		EntriesWithNonFatalErrors: atomic.LoadInt64(&s.entriesWithNonFatalErrors),
+		DroppedMatches:            atomic.LoadInt64(&s.droppedMatches),
+		SpilledMatches:            atomic.LoadInt64(&s.spilledMatches),
+		EntriesPreFiltered:        atomic.LoadInt64(&s.entriesPreFiltered),
+		KeyTypes:                  make(map[string]int64),
+		Elapsed:                   time.Duration(atomic.LoadInt64(&s.scanElapsed)),
+		TreeSize:                  atomic.LoadInt64(&s.scanTreeSize),
+	}
+	if start := atomic.LoadInt64(&s.scanStart); stats.Elapsed == 0 && start != 0 {
+		stats.Elapsed = time.Since(time.Unix(0, start))
+	}
+	s.sthMu.Lock()
+	stats.STH = s.sth
+	s.sthMu.Unlock()
+	s.failedMu.Lock()
+	stats.FailedRanges = int64(len(s.failedRanges))
+	s.failedMu.Unlock()
+	s.keyTypesMu.Lock()
+	defer s.keyTypesMu.Unlock()
+	for k, v := range s.keyTypes {
+		stats.KeyTypes[k] = v
+	}
+	return stats
+}
+
+// Adds the key type of |c| to the scan statistics.
+func (s *Scanner) tallyKeyType(c *x509.Certificate) {
+	keyType := ClassifyKeyType(c)
+	s.keyTypesMu.Lock()
+	defer s.keyTypesMu.Unlock()
+	s.keyTypes[keyType]++
 }
 
 // matcherJob represents the context for an individual matcher job.
@@ -144,6 +2105,8 @@ type matcherJob struct {
 	leaf client.LeafInput
 	// The index of the entry containing the LeafInput in the log
 	index int64
+	// The extra_data of the entry, if ScannerOptions.FetchChains is set
+	extraData []byte
 }
 
 // fetchRange represents a range of certs to fetch from a CT log
@@ -152,6 +2115,16 @@ type fetchRange struct {
 	end   int64
 }
 
+// MerkleTreeLeafError is passed to ScannerOptions.OnParseError for an entry
+// whose MerkleTreeLeaf couldn't be parsed, so that its type isn't known.
+type MerkleTreeLeafError struct {
+	Err error
+}
+
+func (e MerkleTreeLeafError) Error() string {
+	return fmt.Sprintf("failed to parse MerkleTreeLeaf: %s", e.Err.Error())
+}
+
 // Takes the error returned by either x509.ParseCertificate() or
 // x509.ParseTBSCertificate() and determines if it's non-fatal or otherwise.
 // In the case of non-fatal errors, the error will be logged,
@@ -159,93 +2132,249 @@ type fetchRange struct {
 // nil.
 // Fatal errors will be logged, unparsableEntires will be incremented, and the
 // fatal error itself will be returned.
+// Either way, the error is passed to OnParseError along with |leafInput|.
 // When |err| is nil, this method does nothing.
-func (s *Scanner) handleParseEntryError(err error, entryType client.LogEntryType, index int64) error {
+func (s *Scanner) handleParseEntryError(err error, entryType client.LogEntryType, index int64, leafInput client.LeafInput) error {
 	if err == nil {
 		// No error to handle
 		return nil
 	}
+	if s.opts.OnParseError != nil {
+		s.opts.OnParseError(err, entryType, index, leafInput)
+	}
 	switch err.(type) {
 	case x509.NonFatalErrors:
-		s.entriesWithNonFatalErrors++
+		atomic.AddInt64(&s.entriesWithNonFatalErrors, 1)
+		s.opts.Metrics.Inc(MetricNonFatalErrors)
 		// We'll make a note, but continue.
 		s.Log(fmt.Sprintf("Non-fatal error in %+v at index %d: %s", entryType, index, err.Error()))
 	default:
-		s.unparsableEntries++
+		atomic.AddInt64(&s.unparsableEntries, 1)
+		s.opts.Metrics.Inc(MetricParseErrors)
 		s.Log(fmt.Sprintf("Failed to parse in %+v at index %d : %s", entryType, index, err.Error()))
 		return err
 	}
 	return nil
 }
 
+// Returns false, and counts the entry as pre-filtered, if the PreFilter
+// rejects the DER encoded certificate or TBSCertificate |der|.
+func (s *Scanner) preFilterPasses(der []byte) bool {
+	if s.opts.PreFilter == nil || s.opts.PreFilter.RawMatches(der) {
+		return true
+	}
+	atomic.AddInt64(&s.entriesPreFiltered, 1)
+	return false
+}
+
 // Processes the given |leafInput| found at |index| in the specified log.
-func (s *Scanner) processEntry(index int64, leafInput client.LeafInput, foundCert func(int64, *x509.Certificate), foundPrecert func(int64, *client.Precertificate)) {
+func (s *Scanner) processEntry(index int64, leafInput client.LeafInput, extraData []byte, foundCert func(int64, *x509.Certificate, []string), foundPrecert func(int64, *client.Precertificate, []string)) {
 	leaf, err := client.ReadMerkleTreeLeaf(bytes.NewBuffer(leafInput))
 	if err != nil {
 		s.Log(fmt.Sprintf("Failed to parse MerkleTreeLeaf at index %d : %s", index, err.Error()))
+		atomic.AddInt64(&s.malformedLeaves, 1)
+		s.opts.Metrics.Inc(MetricMalformedLeaves)
+		if s.opts.OnParseError != nil {
+			s.opts.OnParseError(MerkleTreeLeafError{err}, client.X509LogEntryType, index, leafInput)
+		}
 		return
 	}
 	atomic.AddInt64(&s.certsProcessed, 1)
+	s.opts.Metrics.Inc(MetricCertsProcessed)
 	switch leaf.TimestampedEntry.EntryType {
 	case client.X509LogEntryType:
 		if s.opts.PrecertOnly {
 			// Only interested in precerts and this is an X.509 cert, early-out.
 			return
 		}
-		cert, err := x509.ParseCertificate(leaf.TimestampedEntry.X509Entry)
-		if err = s.handleParseEntryError(err, leaf.TimestampedEntry.EntryType, index); err != nil {
+		if !s.preFilterPasses(leaf.TimestampedEntry.X509Entry) {
+			return
+		}
+		cert, err := parseAllowingTrailingData(leaf.TimestampedEntry.X509Entry, x509.ParseCertificate)
+		if err = s.handleParseEntryError(err, leaf.TimestampedEntry.EntryType, index, leafInput); err != nil {
 			// We hit an unparseable entry, already logged inside handleParseEntryError()
 			return
 		}
-		if s.opts.Matcher.CertificateMatches(cert) {
-			foundCert(index, cert)
+		s.tallyKeyType(cert)
+		var chain []*x509.Certificate
+		if _, ok := s.opts.Matcher.(ChainMatcher); ok && len(extraData) > 0 {
+			if chain, err = parseCertChain(extraData); err != nil {
+				s.Log(fmt.Sprintf("Failed to parse cert chain at index %d : %s", index, err.Error()))
+			}
+		}
+		if labels, ok := s.matchCertificate(cert, chain); ok {
+			foundCert(index, cert, labels)
 		}
 	case client.PrecertLogEntryType:
-		c, err := x509.ParseTBSCertificate(leaf.TimestampedEntry.PrecertEntry.TBSCertificate)
-		if err = s.handleParseEntryError(err, leaf.TimestampedEntry.EntryType, index); err != nil {
+		if !s.preFilterPasses(leaf.TimestampedEntry.PrecertEntry.TBSCertificate) {
+			atomic.AddInt64(&s.precertsSeen, 1)
+			s.opts.Metrics.Inc(MetricPrecertsSeen)
+			return
+		}
+		c, err := parseAllowingTrailingData(leaf.TimestampedEntry.PrecertEntry.TBSCertificate, x509.ParseTBSCertificate)
+		if err = s.handleParseEntryError(err, leaf.TimestampedEntry.EntryType, index, leafInput); err != nil {
 			// We hit an unparseable entry, already logged inside handleParseEntryError()
 			return
 		}
+		s.tallyKeyType(c)
 		precert := &client.Precertificate{
-			Raw:            c.RawTBSCertificate,
+			Raw:            leaf.TimestampedEntry.PrecertEntry.TBSCertificate,
 			TBSCertificate: *c,
 			IssuerKeyHash:  leaf.TimestampedEntry.PrecertEntry.IssuerKeyHash}
-		if s.opts.Matcher.PrecertificateMatches(precert) {
-			foundPrecert(index, precert)
+		if len(extraData) > 0 {
+			if precert.SubmittedRaw, precert.IssuerChain, err = parsePrecertChain(extraData); err != nil {
+				s.Log(fmt.Sprintf("Failed to parse precert chain at index %d : %s", index, err.Error()))
+			}
+		}
+		if labels, ok := s.matchPrecertificate(precert); ok {
+			foundPrecert(index, precert, labels)
 		}
-		s.precertsSeen++
+		atomic.AddInt64(&s.precertsSeen, 1)
+		s.opts.Metrics.Inc(MetricPrecertsSeen)
 	}
 }
 
 // Worker function to match certs.
 // Accepts MatcherJobs over the |entries| channel, and processes them.
 // Returns true over the |done| channel when the |entries| channel is closed.
-func (s *Scanner) matcherJob(id int, entries <-chan matcherJob, foundCert func(int64, *x509.Certificate), foundPrecert func(int64, *client.Precertificate), wg *sync.WaitGroup) {
-	for e := range entries {
-		s.processEntry(e.index, e.leaf, foundCert, foundPrecert)
+// The worker also exits on receiving from |quit|, which may be nil.
+func (s *Scanner) matcherJob(id int, entries <-chan matcherJob, quit <-chan struct{}, foundCert func(int64, *x509.Certificate, []string), foundPrecert func(int64, *client.Precertificate, []string), wg *sync.WaitGroup) {
+loop:
+	for {
+		var e matcherJob
+		select {
+		case j, ok := <-entries:
+			if !ok {
+				break loop
+			}
+			e = j
+		case <-quit:
+			break loop
+		}
+		select {
+		case <-s.scanDone:
+			// The scan is stopping; leave the entry unprocessed, and keep
+			// draining |entries| so that no fetcher is left blocked.
+			continue
+		default:
+		}
+		if s.sample != nil {
+			s.sample.add(e.index, e.leaf)
+		}
+		if !s.processEntrySafely(e, foundCert, foundPrecert) {
+			// Don't mark the entry as done, so the scan can be resumed from it.
+			continue
+		}
+		snapshot := s.progress.entryDone(e.index, s.stateEntryInterval())
+		// Report the high-water mark rather than this entry's index, which
+		// jumps around when ranges are fetched in parallel.
+		s.opts.Metrics.Set(MetricCurrentIndex, float64(s.progress.highWater()))
+		if snapshot {
+			s.emitState()
+		}
 	}
 	s.Log(fmt.Sprintf("Matcher %d finished", id))
 	wg.Done()
 }
 
+// Processes |e|, recovering from any panic in the Matcher or callbacks.
+// Returns false if a panic occurred.
+func (s *Scanner) processEntrySafely(e matcherJob, foundCert func(int64, *x509.Certificate, []string), foundPrecert func(int64, *client.Precertificate, []string)) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.recordPanic(r, e.index)
+			ok = false
+		}
+	}()
+	if s.foundRaw != nil {
+		s.foundRaw(e.index, client.RawLogEntry{LeafInput: e.leaf, ExtraData: e.extraData})
+		atomic.AddInt64(&s.certsProcessed, 1)
+		s.opts.Metrics.Inc(MetricCertsProcessed)
+		return true
+	}
+	s.processEntry(e.index, e.leaf, e.extraData, foundCert, foundPrecert)
+	return true
+}
+
+// Fetches the entries in the sequence [|start|, |end|] from the LogSource,
+// along with their extra_data if FetchChains is set and the LogSource
+// supports it.
+func (s *Scanner) fetchEntries(ctx context.Context, start, end int64) ([]client.RawLogEntry, error) {
+	if src, ok := s.source.(ExtraDataSource); ok && s.opts.FetchChains {
+		return src.GetRawEntriesCtx(ctx, start, end)
+	}
+	leaves, err := s.source.GetEntriesCtx(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]client.RawLogEntry, len(leaves))
+	for i, leaf := range leaves {
+		entries[i].LeafInput = leaf
+	}
+	return entries, nil
+}
+
 // Worker function for fetcher jobs.
 // Accepts cert ranges to fetch over the |ranges| channel, and if the fetch is
 // successful sends the individual LeafInputs out (as MatcherJobs) into the
 // |entries| channel for the matchers to chew on.
-// Will retry failed attempts to retrieve ranges indefinitely.
+// Will retry failed attempts to retrieve ranges, backing off between attempts,
+// until |ctx| is done, an attempt fails with a terminal error, or as many
+// consecutive attempts as the RetryConfig allows have failed.
 // Sends true over the |done| channel when the |ranges| channel is closed.
-func (s *Scanner) fetcherJob(id int, ranges <-chan fetchRange, entries chan<- matcherJob, wg *sync.WaitGroup) {
+func (s *Scanner) fetcherJob(ctx context.Context, id int, ranges <-chan fetchRange, entries chan<- matcherJob, wg *sync.WaitGroup) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.recordPanic(r, -1)
+			// Keep draining |ranges| so that Scan doesn't block.
+			for _ = range ranges {
+			}
+			s.Log(fmt.Sprintf("Fetcher %d finished", id))
+			wg.Done()
+		}
+	}()
 	for r := range ranges {
 		success := false
-		// TODO(alcutter): give up after a while:
-		for !success {
-			leaves, err := s.logClient.GetEntries(r.start, r.end)
+		failures := 0
+	fetch:
+		for !success && ctx.Err() == nil {
+			leaves, err := s.fetchEntries(ctx, r.start, r.end)
+			if err == nil && len(leaves) == 0 {
+				err = fmt.Errorf("log returned no entries for range [%d, %d]", r.start, r.end)
+			}
+			if want := r.end - r.start + 1; err == nil && int64(len(leaves)) > want {
+				// The log is misbehaving; none of the leaves can be trusted
+				// to have the indices they'd appear to have. LogClient
+				// reports this itself, and other LogSources are treated the
+				// same way.
+				err = client.TooManyEntriesError{Start: r.start, End: r.end, Returned: len(leaves)}
+			}
 			if err != nil {
-				s.Log(fmt.Sprintf("Problem fetching from log: %s", err.Error()))
+				if ctx.Err() != nil {
+					break
+				}
+				failures++
+				if max := s.opts.Retry.MaxAttempts; isTerminalError(err) || (max > 0 && failures >= max) {
+					s.abandonRange(r.start, r.end, failures, err)
+					break
+				}
+				delay := s.fetchRetryDelay(failures, err)
+				s.Log(fmt.Sprintf("Problem fetching from log, retrying in %s: %s", delay, err.Error()))
+				sleepCtx(ctx, delay)
 				continue
 			}
+			failures = 0
+			// get-entries responses don't carry indices, so the leaves are
+			// taken to start at r.start, the next index not yet delivered.
 			for _, leaf := range leaves {
-				entries <- matcherJob{leaf, r.start}
+				select {
+				case entries <- matcherJob{leaf.LeafInput, r.start, leaf.ExtraData}:
+				case <-ctx.Done():
+					// The scan is stopping, so the rest of the range needn't
+					// be processed.
+					break fetch
+				}
+				s.opts.Metrics.Add(MetricBytesFetched, float64(len(leaf.LeafInput)+len(leaf.ExtraData)))
 				r.start++
 			}
 			if r.start > r.end {
@@ -260,6 +2389,56 @@ func (s *Scanner) fetcherJob(id int, ranges <-chan fetchRange, entries chan<- ma
 	wg.Done()
 }
 
+// Returns the number of fetchRanges of up to |blockSize| entries needed to
+// cover the entries in the sequence [|start|, |end|).
+func numRanges(start, end int64, blockSize int) int64 {
+	if start >= end {
+		return 0
+	}
+	return (end - start + int64(blockSize) - 1) / int64(blockSize)
+}
+
+// Returns the |n|th fetchRange of up to |blockSize| entries covering the
+// entries in the sequence [|start|, |end|).
+func nthRange(start, end int64, blockSize int, n int64) fetchRange {
+	first := start + n*int64(blockSize)
+	return fetchRange{first, min(first+int64(blockSize), end) - 1}
+}
+
+// Returns the index of the range to fetch |k|th out of |count| ranges. If
+// |reverse| is set, the newest range is fetched first. Otherwise, if
+// |tipFirst| is set, the order alternates between the newest and the oldest
+// remaining range, so that the entries nearest the tip of the log are fetched
+// early without starving the rest.
+func rangeOrder(k, count int64, tipFirst, reverse bool) int64 {
+	if reverse {
+		return count - 1 - k
+	}
+	if !tipFirst {
+		return k
+	}
+	if k%2 == 0 {
+		return count - 1 - k/2
+	}
+	return k / 2
+}
+
+// Sends the fetchRanges covering the entries in the sequence [|start|,
+// |end|) to |fetches|, generating each one only once there's room for it, so
+// that memory use doesn't grow with the size of the log. Returns early if
+// |ctx| is done.
+func (s *Scanner) feedRanges(ctx context.Context, start, end int64, fetches chan<- fetchRange) {
+	count := numRanges(start, end, s.opts.BlockSize)
+	for k := int64(0); k < count; k++ {
+		r := nthRange(start, end, s.opts.BlockSize, rangeOrder(k, count, s.opts.PrioritizeTip, s.opts.Reverse))
+		select {
+		case fetches <- r:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 // Returns the smaller of |a| and |b|
 func min(a int64, b int64) int64 {
 	if a < b {
@@ -300,7 +2479,7 @@ func humanTime(seconds int) string {
 	return s
 }
 
-func (s Scanner) Log(msg string) {
+func (s *Scanner) Log(msg string) {
 	if !s.opts.Quiet {
 		log.Print(msg)
 	}
@@ -314,74 +2493,441 @@ func (s Scanner) Log(msg string) {
 //
 // This method blocks until the scan is complete.
 func (s *Scanner) Scan(foundCert func(int64, *x509.Certificate), foundPrecert func(int64, *client.Precertificate)) error {
-	s.Log("Starting up...\n")
-	s.certsProcessed = 0
-	s.precertsSeen = 0
-	s.unparsableEntries = 0
-	s.entriesWithNonFatalErrors = 0
+	return s.ScanCtx(context.Background(), foundCert, foundPrecert)
+}
 
-	latestSth, err := s.logClient.GetSTH()
-	if err != nil {
+// Performs a scan against the Log, as Scan, but stops early if |ctx| is
+// cancelled or its deadline passes. In that case no further entries are
+// fetched, the entries already fetched are drained through the matchers, and
+// ctx.Err() is returned.
+// If the Matcher or either callback panics, the scan is stopped in the same
+// way and a *PanicError holding the index to resume from is returned.
+//
+// This method blocks until the scan is complete or has been stopped.
+func (s *Scanner) ScanCtx(ctx context.Context, foundCert func(int64, *x509.Certificate), foundPrecert func(int64, *client.Precertificate)) error {
+	return s.ScanLabelledCtx(ctx, func(index int64, c *x509.Certificate, _ []string) {
+		foundCert(index, c)
+	}, func(index int64, p *client.Precertificate, _ []string) {
+		foundPrecert(index, p)
+	})
+}
+
+// Performs a scan against the Log, as Scan, but additionally passes the
+// labels of the rules which matched each entry to |foundCert| and
+// |foundPrecert|. Labels are only reported if the Matcher is a Labeller, such
+// as MatchAny; otherwise they're nil.
+//
+// This method blocks until the scan is complete.
+func (s *Scanner) ScanLabelled(foundCert func(int64, *x509.Certificate, []string), foundPrecert func(int64, *client.Precertificate, []string)) error {
+	return s.ScanLabelledCtx(context.Background(), foundCert, foundPrecert)
+}
+
+// Performs a scan against the Log, as Scan, and returns the statistics
+// gathered by the scan along with any error. Unlike those returned by Stats,
+// the statistics are a snapshot taken as the scan finishes, so they aren't
+// affected by a later scan.
+//
+// This method blocks until the scan is complete.
+func (s *Scanner) ScanWithStats(foundCert func(int64, *x509.Certificate), foundPrecert func(int64, *client.Precertificate)) (*ScanStats, error) {
+	return s.ScanWithStatsCtx(context.Background(), foundCert, foundPrecert)
+}
+
+// Performs a scan against the Log, as ScanWithStats, but stops early if |ctx|
+// is done (see ScanCtx). The statistics cover the entries processed before
+// the scan stopped.
+//
+// This method blocks until the scan is complete or has been stopped.
+func (s *Scanner) ScanWithStatsCtx(ctx context.Context, foundCert func(int64, *x509.Certificate), foundPrecert func(int64, *client.Precertificate)) (*ScanStats, error) {
+	var stats ScanStats
+	err := s.scan(ctx, func(index int64, c *x509.Certificate, _ []string) {
+		foundCert(index, c)
+	}, func(index int64, p *client.Precertificate, _ []string) {
+		foundPrecert(index, p)
+	}, &stats)
+	return &stats, err
+}
+
+// Performs a scan against the Log, as ScanLabelled, but stops early if |ctx|
+// is done (see ScanCtx).
+//
+// This method blocks until the scan is complete or has been stopped.
+func (s *Scanner) ScanLabelledCtx(ctx context.Context, foundCert func(int64, *x509.Certificate, []string), foundPrecert func(int64, *client.Precertificate, []string)) error {
+	return s.scan(ctx, foundCert, foundPrecert, nil)
+}
+
+// Performs a scan as ScanLabelledCtx. If |result| is non-nil, it's set to the
+// statistics gathered by the scan once it has finished.
+func (s *Scanner) scan(ctx context.Context, foundCert func(int64, *x509.Certificate, []string), foundPrecert func(int64, *client.Precertificate, []string), result *ScanStats) (err error) {
+	if result != nil {
+		// Deferred first, so that it runs once the scan has finished with the
+		// Scanner.
+		defer func() {
+			*result = s.Stats()
+		}()
+	}
+	if s.takeStop() {
+		s.Log("Scan stopped before starting")
+		return ErrScanStopped
+	}
+	defer func() {
+		// A scan which failed has observed any Stop made during it. Only one
+		// made after a successful scan is kept for the next.
+		if err != nil {
+			s.takeStop()
+		}
+	}()
+	s.Log("Starting up...\n")
+	scanStart := time.Now()
+	atomic.StoreInt64(&s.scanStart, scanStart.UnixNano())
+	atomic.StoreInt64(&s.scanElapsed, 0)
+	atomic.StoreInt64(&s.scanTreeSize, 0)
+	defer func() {
+		atomic.StoreInt64(&s.scanElapsed, int64(time.Since(scanStart)))
+	}()
+	s.stopMu.Lock()
+	ctx, s.cancelScan = context.WithCancel(ctx)
+	s.stopMu.Unlock()
+	defer s.cancelScan()
+	s.scanDone = ctx.Done()
+	s.panicErr = nil
+	atomic.StoreInt64(&s.certsProcessed, 0)
+	atomic.StoreInt64(&s.precertsSeen, 0)
+	atomic.StoreInt64(&s.unparsableEntries, 0)
+	atomic.StoreInt64(&s.malformedLeaves, 0)
+	atomic.StoreInt64(&s.entriesWithNonFatalErrors, 0)
+	atomic.StoreInt64(&s.droppedMatches, 0)
+	atomic.StoreInt64(&s.spilledMatches, 0)
+	atomic.StoreInt64(&s.entriesPreFiltered, 0)
+	s.failedMu.Lock()
+	s.failedRanges = nil
+	s.failedMu.Unlock()
+	s.keyTypes = make(map[string]int64)
+	// Not set until the STH has been fetched.
+	s.progress = nil
+	s.sthMu.Lock()
+	s.sth = nil
+	s.sthMu.Unlock()
+	s.sample = nil
+	if s.opts.VerifySampleSize > 0 {
+		s.sample = newLeafSample(s.opts.VerifySampleSize)
+	}
+	if err := s.loadCheckpoint(); err != nil {
 		return err
 	}
-	s.Log(fmt.Sprintf("Got STH with %d certs", latestSth.TreeSize))
+
+	latestSth := s.opts.STH
+	if latestSth == nil {
+		err := s.retry(ctx, "get STH", func() error {
+			var err error
+			latestSth, err = s.source.GetSTHCtx(ctx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		s.Log(fmt.Sprintf("Got STH with %d certs", latestSth.TreeSize))
+	} else {
+		if s.opts.StartIndex > int64(latestSth.TreeSize) {
+			return fmt.Errorf("StartIndex %d is beyond the pinned STH's tree size %d", s.opts.StartIndex, latestSth.TreeSize)
+		}
+		s.Log(fmt.Sprintf("Scanning to pinned STH with %d certs", latestSth.TreeSize))
+	}
+	treeSize := int64(latestSth.TreeSize)
+	if s.opts.TreeSize != 0 {
+		if s.opts.TreeSize < 0 || s.opts.TreeSize > treeSize {
+			return fmt.Errorf("TreeSize %d is outside the current tree size %d", s.opts.TreeSize, treeSize)
+		}
+		treeSize = s.opts.TreeSize
+		s.Log(fmt.Sprintf("Scanning to overridden tree size %d", treeSize))
+	}
+	if s.opts.EndIndex > 0 && s.opts.EndIndex < treeSize {
+		treeSize = s.opts.EndIndex
+		s.Log(fmt.Sprintf("Scanning up to index %d", treeSize))
+	}
+	s.sthMu.Lock()
+	s.sth = latestSth
+	s.sthMu.Unlock()
+	atomic.StoreInt64(&s.scanTreeSize, treeSize)
+	if s.opts.Reverse {
+		s.progress = newReverseProgressTracker(s.opts.StartIndex, treeSize)
+	} else {
+		s.progress = newProgressTracker(s.opts.StartIndex)
+	}
+	if (s.opts.StateCallback != nil || s.opts.Checkpoint != nil) && s.opts.StateInterval > 0 {
+		stopState := make(chan struct{})
+		defer close(stopState)
+		go func() {
+			stateTicker := time.NewTicker(s.opts.StateInterval)
+			defer stateTicker.Stop()
+			for {
+				select {
+				case <-stateTicker.C:
+					s.emitState()
+				case <-stopState:
+					return
+				}
+			}
+		}()
+	}
 
 	ticker := time.NewTicker(time.Second)
 	startTime := time.Now()
-	fetches := make(chan fetchRange, 1000)
+	fetches := make(chan fetchRange, s.opts.ParallelFetch)
 	jobs := make(chan matcherJob, 100000)
+	warmUp := s.opts.ThroughputWarmUp
+	if warmUp == 0 {
+		warmUp = defaultThroughputWarmUp
+	}
+	meter := newThroughputMeter(startTime, warmUp)
+	stopProgress := make(chan struct{})
+	defer close(stopProgress)
+	defer ticker.Stop()
 	go func() {
-		for _ = range ticker.C {
-			throughput := float64(s.certsProcessed) / time.Since(startTime).Seconds()
-			remainingCerts := int64(latestSth.TreeSize) - int64(s.opts.StartIndex) - s.certsProcessed
-			remainingSeconds := int(float64(remainingCerts) / throughput)
-			remainingString := humanTime(remainingSeconds)
-			s.Log(fmt.Sprintf("Processed: %d certs (to index %d). Throughput: %3.2f ETA: %s\n", s.certsProcessed,
-				s.opts.StartIndex+int64(s.certsProcessed), throughput, remainingString))
+		for {
+			select {
+			case now := <-ticker.C:
+				p := s.progressAt(meter, now, treeSize)
+				if s.opts.ProgressCallback != nil {
+					s.opts.ProgressCallback(p)
+				} else {
+					s.Log(s.formatProgress(p))
+				}
+			case <-stopProgress:
+				return
+			}
 		}
 	}()
 
-	var ranges list.List
-	for start := s.opts.StartIndex; start < int64(latestSth.TreeSize); {
-		end := min(start+int64(s.opts.BlockSize), int64(latestSth.TreeSize)) - 1
-		ranges.PushBack(fetchRange{start, end})
-		start = end + 1
-	}
 	var fetcherWG sync.WaitGroup
 	var matcherWG sync.WaitGroup
 	// Start matcher workers
-	for w := 0; w < s.opts.NumWorkers; w++ {
-		matcherWG.Add(1)
-		go s.matcherJob(w, jobs, foundCert, foundPrecert, &matcherWG)
+	stopScaler := make(chan struct{})
+	if s.opts.AutoScaleWorkers {
+		scaler := s.startScaledWorkers(jobs, foundCert, foundPrecert, &matcherWG)
+		go scaler.run(stopScaler, &matcherWG)
+	} else {
+		for w := 0; w < s.opts.NumWorkers; w++ {
+			matcherWG.Add(1)
+			go s.matcherJob(w, jobs, nil, foundCert, foundPrecert, &matcherWG)
+		}
 	}
 	// Start fetcher workers
 	for w := 0; w < s.opts.ParallelFetch; w++ {
 		fetcherWG.Add(1)
-		go s.fetcherJob(w, fetches, jobs, &fetcherWG)
-	}
-	for r := ranges.Front(); r != nil; r = r.Next() {
-		fetches <- r.Value.(fetchRange)
+		go s.fetcherJob(ctx, w, fetches, jobs, &fetcherWG)
 	}
+	s.feedRanges(ctx, s.opts.StartIndex, treeSize, fetches)
 	close(fetches)
 	fetcherWG.Wait()
 	close(jobs)
+	close(stopScaler)
 	matcherWG.Wait()
+	s.emitState()
 
-	s.Log(fmt.Sprintf("Completed %d certs in %s", s.certsProcessed, humanTime(int(time.Since(startTime).Seconds()))))
-	s.Log(fmt.Sprintf("Saw %d precerts", s.precertsSeen))
-	s.Log(fmt.Sprintf("%d unparsable entries, %d non-fatal errors", s.unparsableEntries, s.entriesWithNonFatalErrors))
+	stats := s.Stats()
+	s.Log(fmt.Sprintf("Completed %d certs in %s", stats.CertsProcessed, humanTime(int(time.Since(startTime).Seconds()))))
+	s.Log(fmt.Sprintf("Saw %d precerts", stats.PrecertsSeen))
+	s.Log(fmt.Sprintf("%d malformed leaves, %d unparsable entries, %d non-fatal errors", stats.MalformedLeaves, stats.UnparsableEntries, stats.EntriesWithNonFatalErrors))
+	if s.checkpointErr != nil {
+		return fmt.Errorf("failed to save checkpoint: %s", s.checkpointErr.Error())
+	}
+	// A Sink's workers may still be running, and may record a panic.
+	s.panicMu.Lock()
+	panicErr := s.panicErr
+	if panicErr != nil {
+		panicErr.ResumeIndex = s.progress.highWater()
+	}
+	s.panicMu.Unlock()
+	if panicErr != nil {
+		s.Log(fmt.Sprintf("Scan stopped early: %s", panicErr.Error()))
+		return panicErr
+	}
+	if err := ctx.Err(); err != nil {
+		if s.takeStop() {
+			s.Log("Scan stopped")
+			return ErrScanStopped
+		}
+		s.Log(fmt.Sprintf("Scan stopped early: %s", err.Error()))
+		return err
+	}
+	if len(s.failedRanges) > 0 {
+		// The scan covered the rest of the log, but can't be considered
+		// complete.
+		fetchErr := &FetchError{Ranges: s.failedRanges}
+		s.Log(fmt.Sprintf("Scan incomplete: %s", fetchErr.Error()))
+		return fetchErr
+	}
+	if s.sample != nil {
+		if err := s.verifySample(ctx); err != nil {
+			return err
+		}
+	}
+	// Only now is it known that every entry was seen.
+	if f, ok := s.opts.Matcher.(ScanFinisher); ok && s.foundRaw == nil {
+		f.ScanFinished()
+	}
 	return nil
 }
 
-// Creates a new Scanner instance using |client| to talk to the log, and taking
-// configuration options from |opts|.
-func NewScanner(client *client.LogClient, opts ScannerOptions) *Scanner {
+// Default ScannerOptions.ThroughputWarmUp
+const defaultThroughputWarmUp = 5 * time.Second
+
+// throughputMeter measures the throughput of a scan for progress messages,
+// ignoring the warm-up period at its start, during which throughput is
+// dominated by the latency of setting up connections.
+type throughputMeter struct {
+	start  time.Time
+	warmUp time.Duration
+	// The time and number of processed entries at the first sample after the
+	// warm-up period, from which throughput is measured; zero until then.
+	base          time.Time
+	baseProcessed int64
+}
+
+// Returns a throughputMeter for a scan starting at |start|, ignoring its first
+// |warmUp|.
+func newThroughputMeter(start time.Time, warmUp time.Duration) *throughputMeter {
+	m := &throughputMeter{start: start, warmUp: warmUp}
+	if warmUp <= 0 {
+		m.base = start
+	}
+	return m
+}
+
+// Returns the throughput since the end of the warm-up period, given that
+// |processed| entries had been processed at |now|, or false if it isn't known
+// yet.
+func (m *throughputMeter) rate(now time.Time, processed int64) (float64, bool) {
+	if m.base.IsZero() {
+		if now.Sub(m.start) < m.warmUp {
+			return 0, false
+		}
+		m.base, m.baseProcessed = now, processed
+	}
+	elapsed := now.Sub(m.base).Seconds()
+	if elapsed <= 0 || processed == m.baseProcessed {
+		return 0, false
+	}
+	return float64(processed-m.baseProcessed) / elapsed, true
+}
+
+// ScanProgress is a snapshot of the progress of a running scan, as passed to
+// ScannerOptions.ProgressCallback.
+type ScanProgress struct {
+	// Number of entries processed so far
+	CertsProcessed int64
+	// Every entry with an index lower than this has been processed, or for a
+	// Reverse scan, every entry with a higher index. With parallel fetching
+	// entries may be processed out of order, so entries beyond it may have
+	// been processed too.
+	CurrentIndex int64
+	// Index of the entry the scan stops before (see ScanStats.TreeSize)
+	TreeSize int64
+	// Entries processed per second since the warm-up period (see
+	// ScannerOptions.ThroughputWarmUp), or 0 if it isn't known yet.
+	Throughput float64
+	// Estimated time until the scan completes, or 0 if the Throughput isn't
+	// known yet.
+	ETA time.Duration
+}
+
+// Returns the progress at |now| of a scan which is working towards
+// |treeSize|, with throughput measured by |meter|.
+func (s *Scanner) progressAt(meter *throughputMeter, now time.Time, treeSize int64) ScanProgress {
+	processed := atomic.LoadInt64(&s.certsProcessed)
+	p := ScanProgress{
+		CertsProcessed: processed,
+		CurrentIndex:   s.progress.frontier(),
+		TreeSize:       treeSize,
+	}
+	if throughput, ok := meter.rate(now, processed); ok {
+		remainingCerts := treeSize - s.opts.StartIndex - processed
+		p.Throughput = throughput
+		p.ETA = time.Duration(float64(remainingCerts) / throughput * float64(time.Second))
+	}
+	return p
+}
+
+// Returns a line describing the progress |p|. The throughput and ETA are
+// reported as "calculating" until the throughput is known.
+func (s *Scanner) formatProgress(p ScanProgress) string {
+	total := p.TreeSize - s.opts.StartIndex
+	percent := 100.0
+	if total > 0 {
+		percent = 100 * float64(p.CertsProcessed) / float64(total)
+	}
+	direction := "to"
+	if s.opts.Reverse {
+		direction = "down to"
+	}
+	if p.Throughput == 0 {
+		return fmt.Sprintf("Processed: %d certs (%s index %d) of %d (%.1f%%). Throughput: calculating ETA: calculating\n", p.CertsProcessed,
+			direction, p.CurrentIndex, total, percent)
+	}
+	return fmt.Sprintf("Processed: %d certs (%s index %d) of %d (%.1f%%). Throughput: %3.2f ETA: %s\n", p.CertsProcessed,
+		direction, p.CurrentIndex, total, percent, p.Throughput, humanTime(int(p.ETA.Seconds())))
+}
+
+// Returns a line describing the progress at |now| of a scan which is working
+// towards |treeSize| (or EndIndex), with throughput measured by |meter|.
+func (s *Scanner) progressMessage(meter *throughputMeter, now time.Time, treeSize int64) string {
+	return s.formatProgress(s.progressAt(meter, now, treeSize))
+}
+
+// Creates a new Scanner instance using |source| (typically a
+// *client.LogClient) to talk to the log, and taking configuration options
+// from |opts|.
+func NewScanner(source LogSource, opts ScannerOptions) *Scanner {
 	var scanner Scanner
-	scanner.logClient = client
+	scanner.source = source
 	// Set a default match-everything regex if none was provided:
 	if opts.Matcher == nil {
 		opts.Matcher = &MatchAll{}
 	}
+	if opts.Metrics == nil {
+		opts.Metrics = nullMetrics{}
+	}
 	scanner.opts = opts
 	return &scanner
 }
+
+// LogScopedMatcher is implemented by Matchers which are shared between the
+// Scanners of several logs, and need to know which log each entry is from.
+type LogScopedMatcher interface {
+	Matcher
+	// Returns the Matcher to use for the log with base URI |log|.
+	ForLog(log string) Matcher
+}
+
+// Scans each of the logs in |logs| in turn, using a new Scanner configured
+// with |opts| for each one. Matches are reported to |foundCert| and
+// |foundPrecert| along with the log they were found in. If opts.Matcher is a
+// LogScopedMatcher, each log is matched by the Matcher returned by its ForLog.
+// A failure to scan one log doesn't prevent the remaining logs from being
+// scanned; if any logs failed, an error naming them is returned once all logs
+// have been attempted.
+func ScanLogs(logs []client.LogListEntry, opts ScannerOptions, foundCert func(*client.LogListEntry, int64, *x509.Certificate), foundPrecert func(*client.LogListEntry, int64, *client.Precertificate)) error {
+	var failed []string
+	for i := range logs {
+		l := &logs[i]
+		logOpts := opts
+		if m, ok := opts.Matcher.(LogScopedMatcher); ok {
+			logOpts.Matcher = m.ForLog(l.URL)
+		}
+		s := NewScanner(l.Client, logOpts)
+		s.Log(fmt.Sprintf("Scanning %s (%s)", l.Description, l.URL))
+		err := s.Scan(func(index int64, c *x509.Certificate) {
+			foundCert(l, index, c)
+		}, func(index int64, p *client.Precertificate) {
+			foundPrecert(l, index, p)
+		})
+		if err != nil {
+			s.Log(fmt.Sprintf("Failed to scan %s: %s", l.URL, err.Error()))
+			failed = append(failed, fmt.Sprintf("%s: %s", l.URL, err.Error()))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to scan %d of %d logs: %s", len(failed), len(logs), strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -1,15 +1,35 @@
 package scanner
 
 import (
+	"bytes"
 	"container/list"
+	"context"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
 	"log"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/google/certificate-transparency/go/asn1"
 	"github.com/google/certificate-transparency/go/client"
 	"github.com/google/certificate-transparency/go/x509"
+	"github.com/google/certificate-transparency/go/x509/pkix"
 )
 
 func CertMatchesRegex(r *regexp.Regexp, cert *x509.Certificate) bool {
@@ -39,6 +59,44 @@ func TestScannerMatchNone(t *testing.T) {
 	}
 }
 
+func TestScannerMatchCommonDrivesCertAndPrecertPaths(t *testing.T) {
+	var calls []bool
+	m := MatchCommon{MatchFunc(func(view CertView, isPrecert bool) bool {
+		calls = append(calls, isPrecert)
+		return view.Subject.CommonName == "www.example.com"
+	})}
+
+	var cert x509.Certificate
+	cert.Subject.CommonName = "www.example.com"
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCommon failed to match Cert")
+	}
+	cert.Subject.CommonName = "www.google.com"
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCommon incorrectly matched Cert")
+	}
+
+	var precert client.Precertificate
+	precert.TBSCertificate.Subject.CommonName = "www.example.com"
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCommon failed to match Precert")
+	}
+	precert.TBSCertificate.Subject.CommonName = "www.google.com"
+	if m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCommon incorrectly matched Precert")
+	}
+
+	expected := []bool{false, false, true, true}
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected %d calls, got %d", len(expected), len(calls))
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("Call %d had isPrecert %v, expected %v", i, calls[i], expected[i])
+		}
+	}
+}
+
 func TestScannerMatchSubjectRegexMatchesCertificateCommonName(t *testing.T) {
 	const SubjectName = "www.example.com"
 	const SubjectRegEx = ".*example.com"
@@ -151,90 +209,2142 @@ func TestScannerMatchSubjectRegexMatchesPrecertificateSAN(t *testing.T) {
 	}
 }
 
-func TestScannerEndToEnd(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.URL.Path {
-		case "/ct/v1/get-sth":
-			log.Printf("GetSTH")
-			if _, err := w.Write([]byte(FourEntrySTH)); err != nil {
-				t.Fatal("Failed to write get-sth response")
-			}
-		case "/ct/v1/get-entries":
-			log.Printf("GetEntries %s", r.URL.RawQuery)
-			if _, err := w.Write([]byte(FourEntries)); err != nil {
-				t.Fatal("Failed to write get-sth response")
-			}
-		default:
-			t.Fatal("Unexpected request")
+// Returns the DER encoding of a Subject containing one commonName attribute
+// per entry in |cns|.
+func makeRawSubject(t *testing.T, cns ...string) []byte {
+	rdns := pkix.RDNSequence{
+		pkix.RelativeDistinguishedNameSET{
+			pkix.AttributeTypeAndValue{Type: asn1.ObjectIdentifier{2, 5, 4, 6}, Value: "US"},
+		},
+	}
+	for _, cn := range cns {
+		rdns = append(rdns, pkix.RelativeDistinguishedNameSET{
+			pkix.AttributeTypeAndValue{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: cn},
+		})
+	}
+	raw, err := asn1.Marshal(rdns)
+	if err != nil {
+		t.Fatalf("Failed to marshal Subject: %v", err)
+	}
+	return raw
+}
+
+func TestScannerMatchCertWithMultipleCNsMatchesMultipleCNs(t *testing.T) {
+	var cert x509.Certificate
+	cert.RawSubject = makeRawSubject(t, "www.example.com", "evil.example.com")
+	var precert client.Precertificate
+	precert.TBSCertificate.RawSubject = cert.RawSubject
+
+	m := MatchCertWithMultipleCNs{}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithMultipleCNs failed to match Cert with two CNs")
+	}
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertWithMultipleCNs failed to match Precert with two CNs")
+	}
+}
+
+func TestScannerMatchCertWithMultipleCNsIgnoresSingleCN(t *testing.T) {
+	var cert x509.Certificate
+	cert.RawSubject = makeRawSubject(t, "www.example.com")
+	var precert client.Precertificate
+	precert.TBSCertificate.RawSubject = cert.RawSubject
+
+	m := MatchCertWithMultipleCNs{}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithMultipleCNs incorrectly matched Cert with one CN")
+	}
+	if m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertWithMultipleCNs incorrectly matched Precert with one CN")
+	}
+}
+
+// Returns an SCT list extension containing the serialized SCTs |scts|.
+func makeSCTListExtension(t *testing.T, scts ...[]byte) pkix.Extension {
+	var list []byte
+	for _, sct := range scts {
+		list = append(list, byte(len(sct)>>8), byte(len(sct)))
+		list = append(list, sct...)
+	}
+	list = append([]byte{byte(len(list) >> 8), byte(len(list))}, list...)
+	value, err := asn1.Marshal(list)
+	if err != nil {
+		t.Fatalf("Failed to marshal SCT list: %v", err)
+	}
+	return pkix.Extension{Id: oidExtensionSCTList, Value: value}
+}
+
+func TestScannerMatchCertWithoutCTSCTsMatchesCertWithoutSCTs(t *testing.T) {
+	m := MatchCertWithoutCTSCTs{}
+	var cert x509.Certificate
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithoutCTSCTs failed to match Cert without SCT list extension")
+	}
+	cert.Extensions = []pkix.Extension{makeSCTListExtension(t)}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithoutCTSCTs failed to match Cert with empty SCT list")
+	}
+}
+
+func TestScannerMatchCertWithoutCTSCTsIgnoresCertWithSCTs(t *testing.T) {
+	m := MatchCertWithoutCTSCTs{}
+	var cert x509.Certificate
+	cert.Extensions = []pkix.Extension{makeSCTListExtension(t, []byte("an SCT"), []byte("another SCT"))}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithoutCTSCTs incorrectly matched Cert with embedded SCTs")
+	}
+	var precert client.Precertificate
+	if m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertWithoutCTSCTs incorrectly matched Precert")
+	}
+}
+
+func TestScannerMatchRSAExponentMatchesNonStandardExponent(t *testing.T) {
+	var cert x509.Certificate
+	cert.PublicKey = &rsa.PublicKey{N: big.NewInt(3233), E: 3}
+	var precert client.Precertificate
+	precert.TBSCertificate.PublicKey = cert.PublicKey
+
+	m := MatchRSAExponent{}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchRSAExponent failed to match Cert with exponent 3")
+	}
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchRSAExponent failed to match Precert with exponent 3")
+	}
+	m.AllowedExponents = []int{3, 65537}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchRSAExponent incorrectly matched Cert with allowed exponent 3")
+	}
+}
+
+func TestScannerMatchRSAExponentIgnoresStandardExponent(t *testing.T) {
+	var cert x509.Certificate
+	cert.PublicKey = &rsa.PublicKey{N: big.NewInt(3233), E: 65537}
+	m := MatchRSAExponent{}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchRSAExponent incorrectly matched Cert with exponent 65537")
+	}
+	cert.PublicKey = &ecdsa.PublicKey{}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchRSAExponent incorrectly matched Cert with ECDSA key")
+	}
+}
+
+func TestScannerMatchAuthorityKeyIdSet(t *testing.T) {
+	knownAKI := []byte{0xbf, 0xc0, 0x30, 0xeb, 0xf5, 0x43, 0x11, 0x3e}
+	otherAKI := []byte{0x01, 0x02, 0x03, 0x04}
+	m := NewMatchAuthorityKeyIdSet(otherAKI, knownAKI)
+
+	var cert x509.Certificate
+	cert.AuthorityKeyId = knownAKI
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchAuthorityKeyIdSet failed to match Cert with known AKI")
+	}
+	cert.AuthorityKeyId = []byte{0xff}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchAuthorityKeyIdSet incorrectly matched Cert with unknown AKI")
+	}
+	cert.AuthorityKeyId = nil
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchAuthorityKeyIdSet incorrectly matched Cert without AKI")
+	}
+
+	var precert client.Precertificate
+	precert.TBSCertificate.AuthorityKeyId = knownAKI
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchAuthorityKeyIdSet failed to match Precert with known AKI")
+	}
+}
+
+func TestClassifyKeyType(t *testing.T) {
+	var cert x509.Certificate
+	cert.PublicKey = &rsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), 2047), E: 65537}
+	if got := ClassifyKeyType(&cert); got != "RSA-2048" {
+		t.Errorf("Expected RSA-2048, got %s", got)
+	}
+	cert.PublicKey = &ecdsa.PublicKey{Curve: elliptic.P384()}
+	if got := ClassifyKeyType(&cert); got != "P-384" {
+		t.Errorf("Expected P-384, got %s", got)
+	}
+	cert.PublicKey = nil
+	cert.RawSubjectPublicKeyInfo = makeRawSPKI(t, asn1.ObjectIdentifier{1, 3, 101, 112})
+	if got := ClassifyKeyType(&cert); got != "Ed25519" {
+		t.Errorf("Expected Ed25519, got %s", got)
+	}
+	cert.RawSubjectPublicKeyInfo = nil
+	if got := ClassifyKeyType(&cert); got != "Unknown" {
+		t.Errorf("Expected Unknown, got %s", got)
+	}
+}
+
+// Returns a SubjectPublicKeyInfo with algorithm |oid| and a dummy key.
+func makeRawSPKI(t *testing.T, oid asn1.ObjectIdentifier) []byte {
+	spki := struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oid},
+		PublicKey: asn1.BitString{Bytes: make([]byte, 32), BitLength: 256},
+	}
+	raw, err := asn1.Marshal(spki)
+	if err != nil {
+		t.Fatalf("Failed to marshal SubjectPublicKeyInfo: %v", err)
+	}
+	return raw
+}
+
+func TestScannerMatchKeyType(t *testing.T) {
+	m := MatchKeyType{KeyTypes: []string{"P-256", "RSA-4096"}}
+	var cert x509.Certificate
+	cert.PublicKey = &ecdsa.PublicKey{Curve: elliptic.P256()}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchKeyType failed to match Cert with P-256 key")
+	}
+	cert.PublicKey = &ecdsa.PublicKey{Curve: elliptic.P384()}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchKeyType incorrectly matched Cert with P-384 key")
+	}
+	var precert client.Precertificate
+	precert.TBSCertificate.PublicKey = &rsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), 4095), E: 65537}
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchKeyType failed to match Precert with RSA-4096 key")
+	}
+	precert.TBSCertificate.PublicKey = &rsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), 2047), E: 65537}
+	if m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchKeyType incorrectly matched Precert with RSA-2048 key")
+	}
+}
+
+func TestScannerMatchPublicKey(t *testing.T) {
+	rsa1024 := &rsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), 1023), E: 65537}
+	rsa2048 := &rsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), 2047), E: 65537}
+	p256 := &ecdsa.PublicKey{Curve: elliptic.P256()}
+	weak := MatchPublicKey{Algorithms: []x509.PublicKeyAlgorithm{x509.DSA}, MinRSABits: 2048, MatchBelow: true}
+	for _, test := range []struct {
+		desc  string
+		m     MatchPublicKey
+		key   interface{}
+		match bool
+	}{
+		{"RSA-1024 below 2048", weak, rsa1024, true},
+		{"RSA-2048 below 2048", weak, rsa2048, false},
+		{"DSA", weak, &dsa.PublicKey{}, true},
+		{"ECDSA", weak, p256, false},
+		{"RSA-2048 at least 2048", MatchPublicKey{MinRSABits: 2048}, rsa2048, true},
+		{"RSA-1024 at least 2048", MatchPublicKey{MinRSABits: 2048}, rsa1024, false},
+		{"ECDSA algorithm", MatchPublicKey{Algorithms: []x509.PublicKeyAlgorithm{x509.ECDSA}}, p256, true},
+		{"no criteria", MatchPublicKey{}, rsa1024, false},
+	} {
+		var cert x509.Certificate
+		cert.PublicKey = test.key
+		if got := test.m.CertificateMatches(&cert); got != test.match {
+			t.Errorf("%s: CertificateMatches() = %v, want %v", test.desc, got, test.match)
 		}
-	}))
+		precert := client.Precertificate{TBSCertificate: cert}
+		if got := test.m.PrecertificateMatches(&precert); got != test.match {
+			t.Errorf("%s: PrecertificateMatches() = %v, want %v", test.desc, got, test.match)
+		}
+	}
+}
+
+func TestScannerStatsTalliesKeyTypes(t *testing.T) {
+	ts := newFourEntryLogServer(t)
 	defer ts.Close()
 
-	logClient := client.New(ts.URL)
-	opts := ScannerOptions{
-		Matcher:       &MatchSubjectRegex{regexp.MustCompile(".*\\.google\\.com"), nil},
+	scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:       &MatchNone{},
 		BlockSize:     10,
-		NumWorkers:    1,
+		NumWorkers:    2,
 		ParallelFetch: 1,
-		StartIndex:    0,
+		Quiet:         true,
+	})
+	if err := scanner.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {}); err != nil {
+		t.Fatal(err)
 	}
-	scanner := NewScanner(logClient, opts)
+	stats := scanner.Stats()
+	if stats.CertsProcessed != 4 {
+		t.Fatalf("Expected 4 certs processed, got %d", stats.CertsProcessed)
+	}
+	if len(stats.KeyTypes) != 2 || stats.KeyTypes["RSA-1024"] != 1 || stats.KeyTypes["RSA-2048"] != 3 {
+		t.Fatalf("Expected 1 RSA-1024 and 3 RSA-2048 keys, got %v", stats.KeyTypes)
+	}
+	if stats.TreeSize != 4 {
+		t.Fatalf("Expected tree size 4, got %d", stats.TreeSize)
+	}
+	if stats.STH == nil || stats.STH.TreeSize != 4 {
+		t.Fatalf("Expected the scanned STH with tree size 4, got %+v", stats.STH)
+	}
+	if stats.Elapsed <= 0 {
+		t.Fatalf("Expected a positive elapsed time, got %s", stats.Elapsed)
+	}
+	if again := scanner.Stats(); again.Elapsed != stats.Elapsed {
+		t.Fatalf("Expected the elapsed time of a finished scan to be fixed, got %s then %s", stats.Elapsed, again.Elapsed)
+	}
+}
 
-	var matchedCerts list.List
-	var matchedPrecerts list.List
+func TestScanWithStatsReturnsEachScansStats(t *testing.T) {
+	ts := newFourEntryRangeLogServer(t, nil)
+	defer ts.Close()
 
-	err := scanner.Scan(func(index int64, c *x509.Certificate) {
-		// Annoyingly we can't t.Fatal() in here, as this is run in another go
-		// routine
-		matchedCerts.PushBack(*c)
-	}, func(index int64, p *client.Precertificate) {
-		matchedPrecerts.PushBack(p)
+	s := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:       &MatchNone{},
+		BlockSize:     10,
+		NumWorkers:    2,
+		ParallelFetch: 1,
+		Quiet:         true,
 	})
-
+	first, err := s.ScanWithStats(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.opts.TreeSize = 2
+	second, err := s.ScanWithStats(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {})
 	if err != nil {
 		t.Fatal(err)
 	}
+	if first.CertsProcessed != 4 || first.TreeSize != 4 || first.Elapsed <= 0 {
+		t.Fatalf("Expected the first scan's stats to cover 4 certs, got %+v", first)
+	}
+	if second.CertsProcessed != 2 || second.TreeSize != 2 {
+		t.Fatalf("Expected the second scan's stats to cover 2 certs, got %+v", second)
+	}
+}
 
-	if matchedPrecerts.Len() != 0 {
-		t.Fatal("Found unexpected Precert")
+func TestScannerStatsCountExactlyWithConcurrentWorkers(t *testing.T) {
+	precert, _ := makePrecertAndFinal(t, newPrecertKey(t), newPrecertTemplate())
+	ca, caKey := makeTestCA(t, "CA")
+	cert := issueTestCert(t, newPrecertTemplate(), newPrecertKey(t), ca, caKey)
+	source := &chainLogSource{}
+	const n = 50
+	for i := 0; i < n; i++ {
+		source.entries = append(source.entries,
+			client.RawLogEntry{LeafInput: makePrecertLeaf(precert.Raw)},
+			client.RawLogEntry{LeafInput: makeX509Leaf([]byte{0x30, 0x03, 0x02, 0x01, 0x01})},
+			client.RawLogEntry{LeafInput: makeX509Leaf(cert.Raw)})
+	}
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     7,
+		NumWorkers:    8,
+		ParallelFetch: 4,
+		Quiet:         true,
+	})
+	if err := s.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {}); err != nil {
+		t.Fatal(err)
 	}
+	stats := s.Stats()
+	// Each precert has a non-fatal error for its unhandled critical poison
+	// extension.
+	if stats.CertsProcessed != 3*n || stats.PrecertsSeen != n || stats.UnparsableEntries != n || stats.EntriesWithNonFatalErrors != n {
+		t.Fatalf("Expected %d entries, of which %d precerts with non-fatal errors and %d unparsable, got %+v", 3*n, n, n, stats)
+	}
+}
 
-	switch matchedCerts.Len() {
-	case 0:
-		t.Fatal("Failed to find mail.google.com cert")
-	case 1:
-		if matchedCerts.Front().Value.(x509.Certificate).Subject.CommonName != "mail.google.com" {
-			t.Fatal("Matched unexpected cert")
+func TestScannerOnParseError(t *testing.T) {
+	precert, _ := makePrecertAndFinal(t, newPrecertKey(t), newPrecertTemplate())
+	badCert := makeX509Leaf([]byte{0x30, 0x03, 0x02, 0x01, 0x01})
+	badLeaf := client.LeafInput{0x00, 0x00}
+	source := &chainLogSource{entries: []client.RawLogEntry{
+		{LeafInput: makePrecertLeaf(precert.Raw)},
+		{LeafInput: badCert},
+		{LeafInput: badLeaf},
+		{LeafInput: newMockLogSource(t).leaves[0]},
+	}}
+	var mu sync.Mutex
+	leaves := make(map[int64]client.LeafInput)
+	errs := make(map[int64]error)
+	metrics := newStubRegistrar()
+	s := NewScanner(source, ScannerOptions{
+		Metrics:       metrics,
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    2,
+		ParallelFetch: 1,
+		Quiet:         true,
+		OnParseError: func(err error, entryType client.LogEntryType, index int64, rawLeaf client.LeafInput) {
+			mu.Lock()
+			defer mu.Unlock()
+			leaves[index] = rawLeaf
+			errs[index] = err
+		},
+	})
+	if err := s.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {}); err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 3 {
+		t.Fatalf("Expected parse errors for entries 0-2, got %v", errs)
+	}
+	if _, ok := errs[0].(x509.NonFatalErrors); !ok {
+		t.Errorf("Expected a non-fatal error for entry 0, got %v", errs[0])
+	}
+	if !bytes.Equal(leaves[1], badCert) {
+		t.Errorf("Expected the raw leaf of entry 1, got %x", leaves[1])
+	}
+	if _, ok := errs[2].(MerkleTreeLeafError); !ok || !bytes.Equal(leaves[2], badLeaf) {
+		t.Errorf("Expected a MerkleTreeLeafError with the raw leaf for entry 2, got %v (%x)", errs[2], leaves[2])
+	}
+	stats := s.Stats()
+	if stats.MalformedLeaves != 1 || stats.UnparsableEntries != 1 || stats.CertsProcessed != 3 {
+		t.Errorf("Expected 1 malformed leaf, 1 unparsable entry and 3 certs processed, got %+v", stats)
+	}
+	if metrics.values[MetricMalformedLeaves] != 1 || metrics.values[MetricParseErrors] != 1 {
+		t.Errorf("Expected the metrics to match the stats, got %v", metrics.values)
+	}
+}
+
+func TestScannerMatchEmptySubject(t *testing.T) {
+	emptySubject := []byte{0x30, 0x00}
+
+	var withSAN, withoutSAN x509.Certificate
+	withSAN.RawSubject = emptySubject
+	withSAN.DNSNames = []string{"www.example.com"}
+	withoutSAN.RawSubject = emptySubject
+
+	m := MatchEmptySubject{}
+	if !m.CertificateMatches(&withSAN) {
+		t.Fatal("MatchEmptySubject failed to match Cert with empty Subject and a SAN")
+	}
+	if !m.CertificateMatches(&withoutSAN) {
+		t.Fatal("MatchEmptySubject failed to match Cert with empty Subject and no SANs")
+	}
+
+	m.RequireEmptySANs = true
+	if m.CertificateMatches(&withSAN) {
+		t.Fatal("MatchEmptySubject incorrectly matched Cert with empty Subject and a SAN")
+	}
+	if !m.CertificateMatches(&withoutSAN) {
+		t.Fatal("MatchEmptySubject failed to match Cert with empty Subject and no SANs")
+	}
+	var precert client.Precertificate
+	precert.TBSCertificate = withoutSAN
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchEmptySubject failed to match Precert with empty Subject and no SANs")
+	}
+}
+
+func TestScannerMatchEmptySubjectIgnoresNonEmptySubject(t *testing.T) {
+	var cert x509.Certificate
+	cert.RawSubject = makeRawSubject(t, "www.example.com")
+	var precert client.Precertificate
+	precert.TBSCertificate.RawSubject = cert.RawSubject
+
+	m := MatchEmptySubject{}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchEmptySubject incorrectly matched Cert with non-empty Subject")
+	}
+	if m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchEmptySubject incorrectly matched Precert with non-empty Subject")
+	}
+}
+
+func TestScannerMatchCertWithLongSANValue(t *testing.T) {
+	label := strings.Repeat("a", 60)
+	longName := strings.Join([]string{label, label, label, label, label}, ".") + ".com"
+	tests := []struct {
+		name    string
+		matches bool
+	}{
+		{"www.example.com", false},
+		{"www.example.com.", false},
+		{longName, true},
+		{strings.Repeat("a", 64) + ".example.com", true},
+		{"www.example.com\x00.evil.com", true},
+		{"www.exa mple.com", true},
+		{"www.example.com\n", true},
+	}
+	m := MatchCertWithLongSANValue{}
+	for _, test := range tests {
+		var cert x509.Certificate
+		cert.DNSNames = []string{"ok.example.com", test.name}
+		var precert client.Precertificate
+		precert.TBSCertificate.DNSNames = cert.DNSNames
+		if got := m.CertificateMatches(&cert); got != test.matches {
+			t.Errorf("CertificateMatches(%q) = %v, expected %v", test.name, got, test.matches)
+		}
+		if got := m.PrecertificateMatches(&precert); got != test.matches {
+			t.Errorf("PrecertificateMatches(%q) = %v, expected %v", test.name, got, test.matches)
 		}
-	default:
-		t.Fatal("Found unexpected number of certs")
 	}
 }
 
-func TestDefaultScannerOptions(t *testing.T) {
-	opts := DefaultScannerOptions()
-	switch opts.Matcher.(type) {
-	case *MatchAll:
-		// great
-	default:
-		t.Fatalf("Default Matcher is a %T, expected MatchAll.", opts.Matcher)
+func TestScannerMatchSignatureAlgorithm(t *testing.T) {
+	m := MatchSignatureAlgorithm{Algorithms: []x509.SignatureAlgorithm{x509.SHA1WithRSA, x509.MD5WithRSA}}
+	var cert x509.Certificate
+	cert.SignatureAlgorithm = x509.MD5WithRSA
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchSignatureAlgorithm failed to match MD5WithRSA Cert")
 	}
-	if opts.PrecertOnly {
-		t.Fatal("Expected PrecertOnly to be false.")
+	cert.SignatureAlgorithm = x509.ECDSAWithSHA1
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchSignatureAlgorithm incorrectly matched ECDSAWithSHA1 Cert")
 	}
-	if opts.BlockSize < 1 {
-		t.Fatalf("Insane BlockSize %d", opts.BlockSize)
+
+	var precert client.Precertificate
+	precert.TBSCertificate.SignatureAlgorithm = x509.SHA1WithRSA
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchSignatureAlgorithm failed to match SHA1WithRSA Precert")
 	}
-	if opts.NumWorkers < 1 {
-		t.Fatalf("Insane NumWorkers %d", opts.NumWorkers)
+	if (MatchSignatureAlgorithm{}).PrecertificateMatches(&precert) {
+		t.Fatal("MatchSignatureAlgorithm with no Algorithms incorrectly matched Precert")
 	}
-	if opts.ParallelFetch < 1 {
-		t.Fatalf("Insane ParallelFetch %d", opts.ParallelFetch)
+}
+
+func TestScannerMatchWeakSignature(t *testing.T) {
+	m := MatchWeakSignature{}
+	var cert x509.Certificate
+	cert.SignatureAlgorithm = x509.SHA1WithRSA
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchWeakSignature failed to match SHA1WithRSA Cert")
 	}
-	if opts.StartIndex != 0 {
-		t.Fatalf("Expected StartIndex to be 0, but was %d", opts.StartIndex)
+	cert.SignatureAlgorithm = x509.MD5WithRSA
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchWeakSignature failed to match MD5WithRSA Cert")
 	}
-	if opts.Quiet {
-		t.Fatal("Expected Quiet to be false.")
+	cert.SignatureAlgorithm = x509.SHA256WithRSA
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchWeakSignature incorrectly matched SHA256WithRSA Cert")
+	}
+
+	var precert client.Precertificate
+	precert.TBSCertificate.SignatureAlgorithm = x509.ECDSAWithSHA1
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchWeakSignature failed to match ECDSAWithSHA1 Precert")
+	}
+
+	m.Algorithms = []x509.SignatureAlgorithm{x509.MD5WithRSA}
+	if m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchWeakSignature incorrectly matched ECDSAWithSHA1 Precert when only MD5WithRSA selected")
+	}
+}
+
+// Returns the DER encoding of a Subject with the given attributes.
+func makeRawSubjectWithAttributes(t *testing.T, atvs ...pkix.AttributeTypeAndValue) []byte {
+	var rdns pkix.RDNSequence
+	for _, atv := range atvs {
+		rdns = append(rdns, pkix.RelativeDistinguishedNameSET{atv})
+	}
+	raw, err := asn1.Marshal(rdns)
+	if err != nil {
+		t.Fatalf("Failed to marshal Subject: %v", err)
+	}
+	return raw
+}
+
+func TestScannerMatchEVJurisdiction(t *testing.T) {
+	var cert x509.Certificate
+	cert.RawSubject = makeRawSubjectWithAttributes(t,
+		pkix.AttributeTypeAndValue{Type: oidJurisdictionCountry, Value: "US"},
+		pkix.AttributeTypeAndValue{Type: oidJurisdictionProvince, Value: "Delaware"},
+		pkix.AttributeTypeAndValue{Type: oidCountry, Value: "US"},
+		pkix.AttributeTypeAndValue{Type: oidCommonName, Value: "www.example.com"})
+	var precert client.Precertificate
+	precert.TBSCertificate.RawSubject = cert.RawSubject
+
+	m := MatchEVJurisdiction{Countries: []string{"us"}}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchEVJurisdiction failed to match Cert with jurisdiction country US")
+	}
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchEVJurisdiction failed to match Precert with jurisdiction country US")
+	}
+	m = MatchEVJurisdiction{Provinces: []string{"Delaware"}}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchEVJurisdiction failed to match Cert with jurisdiction province Delaware")
+	}
+	m = MatchEVJurisdiction{Countries: []string{"GB"}, MatchCountryMismatch: true}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchEVJurisdiction incorrectly matched Cert with jurisdiction country US")
+	}
+}
+
+func TestScannerMatchEVJurisdictionCountryMismatch(t *testing.T) {
+	var cert x509.Certificate
+	cert.RawSubject = makeRawSubjectWithAttributes(t,
+		pkix.AttributeTypeAndValue{Type: oidJurisdictionCountry, Value: "KY"},
+		pkix.AttributeTypeAndValue{Type: oidCountry, Value: "US"})
+	m := MatchEVJurisdiction{MatchCountryMismatch: true}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchEVJurisdiction failed to match Cert with mismatched jurisdiction country")
+	}
+	cert.RawSubject = makeRawSubject(t, "www.example.com")
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchEVJurisdiction incorrectly matched non-EV Cert")
+	}
+}
+
+// Returns a serialized SCT from the log with ID |logID|.
+func makeSCT(logID []byte) []byte {
+	return makeSCTAt(logID, 0x14400000001)
+}
+
+// Returns a serialized SCT from the log with ID |logID|, with the given
+// |timestamp| in milliseconds since the epoch.
+func makeSCTAt(logID []byte, timestamp uint64) []byte {
+	sct := []byte{0}
+	sct = append(sct, logID...)
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], timestamp)
+	sct = append(sct, ts[:]...)         // Timestamp
+	sct = append(sct, 0, 0)             // Extensions
+	sct = append(sct, 4, 3, 0, 1, 0xaa) // DigitallySigned
+	return sct
+}
+
+func TestScannerMatchCertLoggedToInsufficientLogs(t *testing.T) {
+	logA1 := bytes.Repeat([]byte{0xa1}, 32)
+	logA2 := bytes.Repeat([]byte{0xa2}, 32)
+	logB := bytes.Repeat([]byte{0xb0}, 32)
+	m := MatchCertLoggedToInsufficientLogs{Operators: LogOperatorsFromLogList([]client.LogListEntry{
+		{LogID: logA1, Operator: "Operator A"},
+		{LogID: logA2, Operator: "Operator A"},
+		{LogID: logB, Operator: "Operator B"},
+	})}
+
+	var cert x509.Certificate
+	cert.Extensions = []pkix.Extension{makeSCTListExtension(t, makeSCT(logA1), makeSCT(logA2))}
+	if n, err := CountSCTOperators(&cert, m.Operators); err != nil || n != 1 {
+		t.Fatalf("Expected 1 operator, got %d (%v)", n, err)
+	}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertLoggedToInsufficientLogs failed to match Cert with SCTs from a single operator")
+	}
+
+	cert.Extensions = []pkix.Extension{makeSCTListExtension(t, makeSCT(logA1), makeSCT(logB))}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertLoggedToInsufficientLogs incorrectly matched Cert with SCTs from two operators")
+	}
+	m.MinOperators = 3
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertLoggedToInsufficientLogs failed to match Cert with SCTs from two of three required operators")
+	}
+
+	var precert client.Precertificate
+	if m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertLoggedToInsufficientLogs incorrectly matched Precert")
+	}
+}
+
+func TestScannerMatchRawBytes(t *testing.T) {
+	// DER encoding of the OID 1.3.6.1.4.1.11129.2.4.3 (CT poison)
+	poison := []byte{0x06, 0x0a, 0x2b, 0x06, 0x01, 0x04, 0x01, 0xd6, 0x79, 0x02, 0x04, 0x03}
+	var cert x509.Certificate
+	cert.RawTBSCertificate = append(append([]byte{0x30, 0x82, 0x01, 0x00}, poison...), 0x05, 0x00)
+	var precert client.Precertificate
+	precert.Raw = cert.RawTBSCertificate
+
+	m := MatchRawBytes{Patterns: [][]byte{[]byte("marker"), poison}}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchRawBytes failed to match Cert containing pattern")
+	}
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchRawBytes failed to match Precert containing pattern")
+	}
+
+	m = MatchRawBytes{Patterns: [][]byte{[]byte("marker")}}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchRawBytes incorrectly matched Cert not containing pattern")
+	}
+	m.Regexp = regexp.MustCompile("\\x06\\x0a\\x2b\\x06\\x01\\x04\\x01")
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchRawBytes failed to match Cert against Regexp")
+	}
+}
+
+// Returns the DER encoding of a Subject containing an O attribute with the
+// given ASN.1 |tag| and |value|, and a UTF8String CN.
+func makeRawSubjectWithRawOrganization(t *testing.T, tag int, value []byte) []byte {
+	rdns := rawRDNSequence{
+		rawRelativeDistinguishedNameSET{rawAttributeTypeAndValue{
+			Type:  asn1.ObjectIdentifier{2, 5, 4, 10},
+			Value: asn1.RawValue{Tag: tag, Bytes: value},
+		}},
+		rawRelativeDistinguishedNameSET{rawAttributeTypeAndValue{
+			Type:  oidCommonName,
+			Value: asn1.RawValue{Tag: tagUTF8String, Bytes: []byte("www.example.com")},
+		}},
+	}
+	raw, err := asn1.Marshal(rdns)
+	if err != nil {
+		t.Fatalf("Failed to marshal Subject: %v", err)
+	}
+	return raw
+}
+
+func TestScannerMatchCertWithNonUTF8Subject(t *testing.T) {
+	m := MatchCertWithNonUTF8Subject{}
+	var cert x509.Certificate
+	cert.RawSubject = makeRawSubjectWithRawOrganization(t, tagUTF8String, []byte("Caf\xe9 Ltd"))
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithNonUTF8Subject failed to match Cert with invalid UTF-8 O")
+	}
+	var precert client.Precertificate
+	precert.TBSCertificate.RawSubject = cert.RawSubject
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertWithNonUTF8Subject failed to match Precert with invalid UTF-8 O")
+	}
+
+	cert.RawSubject = makeRawSubjectWithRawOrganization(t, tagTeletexString, []byte("Example Ltd"))
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithNonUTF8Subject failed to match Cert with TeletexString O")
+	}
+	m.DisallowedTags = []int{}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithNonUTF8Subject incorrectly matched Cert with TeletexString O when allowed")
+	}
+}
+
+func TestScannerMatchCertWithNonUTF8SubjectIgnoresCleanSubject(t *testing.T) {
+	m := MatchCertWithNonUTF8Subject{}
+	var cert x509.Certificate
+	cert.RawSubject = makeRawSubjectWithRawOrganization(t, tagUTF8String, []byte("Caf\xc3\xa9 Ltd"))
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithNonUTF8Subject incorrectly matched Cert with valid UTF-8 O")
+	}
+	cert.RawSubject = makeRawSubject(t, "www.example.com")
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithNonUTF8Subject incorrectly matched Cert with PrintableString Subject")
+	}
+}
+
+func TestParseSerialNumber(t *testing.T) {
+	expected := big.NewInt(2768795)
+	for _, input := range []string{"2a:3f:9b", "2A:3F:9B", "2a 3f 9b", "0x2a3f9b", "2768795", " 2768795\n"} {
+		serial, err := ParseSerialNumber(input)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", input, err)
+		}
+		if serial.Cmp(expected) != 0 {
+			t.Fatalf("Parsed %q as %v, expected %v", input, serial, expected)
+		}
+	}
+}
+
+func TestParseSerialNumberRejectsInvalidInput(t *testing.T) {
+	for _, input := range []string{"", "0x", "2a:3fg:9b", "2a3f:9b", "xyz", "-5", "12.5", "2a3f9b"} {
+		if _, err := ParseSerialNumber(input); err == nil {
+			t.Fatalf("Expected error parsing %q", input)
+		}
+	}
+}
+
+func TestScannerMatchSerialNumbers(t *testing.T) {
+	m, err := NewMatchSerials("2a:3f:9b", "12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cert x509.Certificate
+	cert.SerialNumber = big.NewInt(2768795)
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchSerialNumbers failed to match Cert with listed serial")
+	}
+	var precert client.Precertificate
+	precert.TBSCertificate.SerialNumber = big.NewInt(12345)
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchSerialNumbers failed to match Precert with listed serial")
+	}
+	cert.SerialNumber = big.NewInt(54321)
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchSerialNumbers incorrectly matched Cert with unlisted serial")
+	}
+
+	if _, err := NewMatchSerials("2a:3f:9b", "not-a-serial"); err == nil || !strings.Contains(err.Error(), "not-a-serial") {
+		t.Fatalf("Expected error naming invalid input, got %v", err)
+	}
+}
+
+func TestScannerMatchSerialsHex(t *testing.T) {
+	m, err := NewMatchSerialsHex("0A", "10", "0x00ff", "0a:1b:2c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cert x509.Certificate
+	for _, serial := range []int64{0x0a, 0x10, 0xff, 0x0a1b2c} {
+		cert.SerialNumber = big.NewInt(serial)
+		if !m.CertificateMatches(&cert) {
+			t.Fatalf("MatchSerialNumbers failed to match Cert with listed serial %x", serial)
+		}
+	}
+	var precert client.Precertificate
+	precert.TBSCertificate.SerialNumber = big.NewInt(0x0b)
+	if m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchSerialNumbers incorrectly matched Precert with unlisted serial")
+	}
+
+	if _, err := NewMatchSerialsHex("0a", "2a:3g"); err == nil || !strings.Contains(err.Error(), "2a:3g") {
+		t.Fatalf("Expected error naming invalid input, got %v", err)
+	}
+}
+
+func TestScannerMatchCertWithDuplicateExtensions(t *testing.T) {
+	m := MatchCertWithDuplicateExtensions{}
+	oidBasicConstraints := asn1.ObjectIdentifier{2, 5, 29, 19}
+	oidKeyUsage := asn1.ObjectIdentifier{2, 5, 29, 15}
+	var cert x509.Certificate
+	cert.Extensions = []pkix.Extension{
+		{Id: oidBasicConstraints, Value: []byte{0x30, 0x00}},
+		{Id: oidKeyUsage, Value: []byte{0x03, 0x02, 0x05, 0xa0}},
+		{Id: asn1.ObjectIdentifier{2, 5, 29, 19}, Value: []byte{0x30, 0x03, 0x01, 0x01, 0xff}},
+	}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithDuplicateExtensions failed to match Cert with duplicated extension")
+	}
+	var precert client.Precertificate
+	precert.TBSCertificate.Extensions = cert.Extensions
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertWithDuplicateExtensions failed to match Precert with duplicated extension")
+	}
+
+	cert.Extensions = cert.Extensions[:2]
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithDuplicateExtensions incorrectly matched Cert with distinct extensions")
+	}
+}
+
+func TestScannerMatchCertByCertificatePolicyAnyPolicy(t *testing.T) {
+	m := MatchCertByCertificatePolicyAnyPolicy{LeafOnly: true}
+	var cert x509.Certificate
+	cert.PolicyIdentifiers = []asn1.ObjectIdentifier{{2, 23, 140, 1, 2, 1}, {2, 5, 29, 32, 0}}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByCertificatePolicyAnyPolicy failed to match leaf Cert asserting anyPolicy")
+	}
+	var precert client.Precertificate
+	precert.TBSCertificate.PolicyIdentifiers = cert.PolicyIdentifiers
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertByCertificatePolicyAnyPolicy failed to match leaf Precert asserting anyPolicy")
+	}
+
+	cert.BasicConstraintsValid = true
+	cert.IsCA = true
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByCertificatePolicyAnyPolicy incorrectly matched CA Cert with LeafOnly set")
+	}
+	m.LeafOnly = false
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByCertificatePolicyAnyPolicy failed to match CA Cert asserting anyPolicy")
+	}
+
+	cert.PolicyIdentifiers = []asn1.ObjectIdentifier{{2, 23, 140, 1, 2, 1}}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByCertificatePolicyAnyPolicy incorrectly matched Cert with only a DV policy")
+	}
+}
+
+// Returns the DER encoding of a minimal TBSCertificate whose validity times
+// have the given ASN.1 |tag|s and |values|.
+func makeRawTBSWithValidity(t *testing.T, notBeforeTag int, notBefore string, notAfterTag int, notAfter string) []byte {
+	tbs := struct {
+		Version            int `asn1:"explicit,tag:0"`
+		SerialNumber       int
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		Issuer             asn1.RawValue
+		Validity           struct {
+			NotBefore, NotAfter asn1.RawValue
+		}
+		Subject asn1.RawValue
+	}{
+		Version:            2,
+		SerialNumber:       1,
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}},
+		Issuer:             asn1.RawValue{FullBytes: makeRawSubject(t, "Test CA")},
+		Subject:            asn1.RawValue{FullBytes: makeRawSubject(t, "www.example.com")},
+	}
+	tbs.Validity.NotBefore = asn1.RawValue{Tag: notBeforeTag, Bytes: []byte(notBefore)}
+	tbs.Validity.NotAfter = asn1.RawValue{Tag: notAfterTag, Bytes: []byte(notAfter)}
+	raw, err := asn1.Marshal(tbs)
+	if err != nil {
+		t.Fatalf("Failed to marshal TBSCertificate: %v", err)
+	}
+	return raw
+}
+
+func TestScannerMatchCertWithInvalidValidityEncoding(t *testing.T) {
+	m := MatchCertWithInvalidValidityEncoding{}
+	var cert x509.Certificate
+	cert.RawTBSCertificate = makeRawTBSWithValidity(t, tagGeneralizedTime, "20160101000000Z", tagUTCTime, "170101000000Z")
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithInvalidValidityEncoding failed to match Cert with pre-2050 GeneralizedTime")
+	}
+	var precert client.Precertificate
+	precert.TBSCertificate.RawTBSCertificate = cert.RawTBSCertificate
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertWithInvalidValidityEncoding failed to match Precert with pre-2050 GeneralizedTime")
+	}
+}
+
+func TestScannerMatchCertWithInvalidValidityEncodingIgnoresCorrectEncoding(t *testing.T) {
+	m := MatchCertWithInvalidValidityEncoding{}
+	var cert x509.Certificate
+	cert.RawTBSCertificate = makeRawTBSWithValidity(t, tagUTCTime, "160101000000Z", tagGeneralizedTime, "20510101000000Z")
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithInvalidValidityEncoding incorrectly matched correctly encoded Cert")
+	}
+	cert.RawTBSCertificate = []byte{0x30, 0x01}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithInvalidValidityEncoding incorrectly matched unparsable Cert")
+	}
+}
+
+func TestScannerMatchCertBySubjectSerialNumber(t *testing.T) {
+	var cert x509.Certificate
+	cert.SerialNumber = big.NewInt(12345)
+	cert.RawSubject = makeRawSubjectWithAttributes(t,
+		pkix.AttributeTypeAndValue{Type: oidSubjectSerialNumber, Value: "DEV-0042-A"},
+		pkix.AttributeTypeAndValue{Type: oidCommonName, Value: "sensor.example.com"})
+	var precert client.Precertificate
+	precert.TBSCertificate.RawSubject = cert.RawSubject
+
+	m := MatchCertBySubjectSerialNumber{Values: []string{"DEV-0042-A"}}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySubjectSerialNumber failed to match Cert with listed serialNumber")
+	}
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertBySubjectSerialNumber failed to match Precert with listed serialNumber")
+	}
+	m = MatchCertBySubjectSerialNumber{Regex: regexp.MustCompile("^DEV-[0-9]{4}-")}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySubjectSerialNumber failed to match Cert with serialNumber matching regex")
+	}
+	m = MatchCertBySubjectSerialNumber{Values: []string{"12345"}, Regex: regexp.MustCompile("^SN-")}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySubjectSerialNumber incorrectly matched Cert with other serialNumber")
+	}
+
+	cert.RawSubject = makeRawSubject(t, "sensor.example.com")
+	m = MatchCertBySubjectSerialNumber{Regex: regexp.MustCompile(".*")}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySubjectSerialNumber incorrectly matched Cert without serialNumber")
+	}
+}
+
+func TestScannerMatchCertWithoutEKU(t *testing.T) {
+	m := MatchCertWithoutEKU{LeafOnly: true}
+	var cert x509.Certificate
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithoutEKU failed to match leaf Cert without EKU")
+	}
+	var precert client.Precertificate
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertWithoutEKU failed to match leaf Precert without EKU")
+	}
+
+	cert.BasicConstraintsValid = true
+	cert.IsCA = true
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithoutEKU incorrectly matched CA Cert with LeafOnly set")
+	}
+	m.LeafOnly = false
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithoutEKU failed to match CA Cert without EKU")
+	}
+
+	cert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithoutEKU incorrectly matched serverAuth Cert")
+	}
+	cert.ExtKeyUsage = nil
+	cert.UnknownExtKeyUsage = []asn1.ObjectIdentifier{{1, 2, 3, 4}}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertWithoutEKU incorrectly matched Cert with an unknown EKU")
+	}
+}
+
+func TestScannerMatchCertByNotBeforeAfterNotAfter(t *testing.T) {
+	m := MatchCertByNotBeforeAfterNotAfter{}
+	notBefore := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.AddDate(1, 0, 0)}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByNotBeforeAfterNotAfter incorrectly matched Cert with normal validity")
+	}
+	precert := client.Precertificate{TBSCertificate: cert}
+	if m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertByNotBeforeAfterNotAfter incorrectly matched Precert with normal validity")
+	}
+
+	cert.NotAfter = notBefore.Add(-time.Second)
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByNotBeforeAfterNotAfter failed to match Cert with inverted validity")
+	}
+	precert.TBSCertificate = cert
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertByNotBeforeAfterNotAfter failed to match Precert with inverted validity")
+	}
+}
+
+// Returns a subjectAltName extension holding each of |names|, which are DER
+// encoded GeneralNames.
+func makeSANExtension(t *testing.T, names ...[]byte) pkix.Extension {
+	value, err := asn1.Marshal(asn1.RawValue{Class: 0, Tag: 16, IsCompound: true, Bytes: bytes.Join(names, nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: oidExtensionSubjectAltName, Value: value}
+}
+
+func TestScannerMatchCertBySubjectAltNameType(t *testing.T) {
+	dnsName := []byte{0x82, 0x0b, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm'}
+	// otherName holding a UPN: [0] { 1.3.6.1.4.1.311.20.2.3, [0] UTF8String "a" }
+	otherName := []byte{0xa0, 0x11, 0x06, 0x0a, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x14, 0x02, 0x03, 0xa0, 0x03, 0x0c, 0x01, 'a'}
+	m := MatchCertBySubjectAltNameType{}
+
+	var cert x509.Certificate
+	cert.Extensions = []pkix.Extension{makeSANExtension(t, dnsName)}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySubjectAltNameType incorrectly matched Cert with only a dNSName SAN")
+	}
+	cert.Extensions = []pkix.Extension{makeSANExtension(t, dnsName, otherName)}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySubjectAltNameType failed to match Cert with an otherName SAN")
+	}
+
+	var precert client.Precertificate
+	precert.TBSCertificate.Extensions = cert.Extensions
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertBySubjectAltNameType failed to match Precert with an otherName SAN")
+	}
+
+	m.Types = []GeneralNameType{GeneralNameDNSName}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySubjectAltNameType failed to match Cert with a dNSName SAN")
+	}
+	m.Types = []GeneralNameType{GeneralNameRegisteredID}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySubjectAltNameType incorrectly matched Cert without a registeredID SAN")
+	}
+
+	cert.Extensions = []pkix.Extension{{Id: oidExtensionSubjectAltName, Value: []byte{0x30, 0x02, 0x04, 0x00}}}
+	m.Types = nil
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySubjectAltNameType incorrectly matched Cert with a malformed SAN")
+	}
+}
+
+func TestScannerMatchCertByRawDERHashPrefix(t *testing.T) {
+	const shardCount = 3
+	var certs []x509.Certificate
+	var precerts []client.Precertificate
+	for i := 0; i < 50; i++ {
+		raw := []byte(fmt.Sprintf("entry %d", i))
+		certs = append(certs, x509.Certificate{Raw: raw})
+		precerts = append(precerts, client.Precertificate{Raw: raw})
+	}
+	certMatches := make([]int, len(certs))
+	precertMatches := make([]int, len(precerts))
+	for shard := 0; shard < shardCount; shard++ {
+		m := MatchCertByRawDERHashPrefix{ShardIndex: shard, ShardCount: shardCount}
+		matched := 0
+		for i := range certs {
+			if m.CertificateMatches(&certs[i]) {
+				certMatches[i]++
+				matched++
+			}
+			if m.PrecertificateMatches(&precerts[i]) {
+				precertMatches[i]++
+			}
+		}
+		if matched == 0 {
+			t.Errorf("Shard %d of %d matched none of %d Certs", shard, shardCount, len(certs))
+		}
+	}
+	for i := range certs {
+		if certMatches[i] != 1 {
+			t.Errorf("Expected Cert %d to be matched by exactly 1 shard, got %d", i, certMatches[i])
+		}
+		if precertMatches[i] != 1 {
+			t.Errorf("Expected Precert %d to be matched by exactly 1 shard, got %d", i, precertMatches[i])
+		}
+	}
+
+	if (MatchCertByRawDERHashPrefix{}).CertificateMatches(&certs[0]) {
+		t.Fatal("MatchCertByRawDERHashPrefix with no shards incorrectly matched Cert")
+	}
+}
+
+func TestScannerMatchCertByAnyInternalWhitespaceInDNSName(t *testing.T) {
+	m := MatchCertByAnyInternalWhitespaceInDNSName{}
+	for _, test := range []struct {
+		name  string
+		match bool
+	}{
+		{"example.com", false},
+		{"www.example.com.", false},
+		{"*.example.com", false},
+		{"bad .example.com", true},
+		{"bad\t.example.com", true},
+		{"a..b.com", true},
+		{".example.com.", true},
+		{".example.com", true},
+		{"example.com..", true},
+		{"", true},
+	} {
+		cert := x509.Certificate{DNSNames: []string{"ok.example.com", test.name}}
+		if got := m.CertificateMatches(&cert); got != test.match {
+			t.Errorf("CertificateMatches(%q) = %v, want %v", test.name, got, test.match)
+		}
+		precert := client.Precertificate{TBSCertificate: cert}
+		if got := m.PrecertificateMatches(&precert); got != test.match {
+			t.Errorf("PrecertificateMatches(%q) = %v, want %v", test.name, got, test.match)
+		}
+	}
+}
+
+func TestScannerMatchCertByIssuerAndSubjectCNEqualButNotSelfSigned(t *testing.T) {
+	m := MatchCertByIssuerAndSubjectCNEqualButNotSelfSigned{}
+	name := func(o, cn string) ([]byte, pkix.Name) {
+		n := pkix.Name{Organization: []string{o}, CommonName: cn}
+		raw, err := asn1.Marshal(n.ToRDNSequence())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw, n
+	}
+	rawA, a := name("Org A", "Example CA")
+	rawB, b := name("Org B", "Example CA")
+	rawC, c := name("Org A", "www.example.com")
+	for _, test := range []struct {
+		desc                  string
+		issuer, subject       pkix.Name
+		rawIssuer, rawSubject []byte
+		match                 bool
+	}{
+		{"CN equal, DNs differ", a, b, rawA, rawB, true},
+		{"self-signed", a, a, rawA, rawA, false},
+		{"normal", a, c, rawA, rawC, false},
+	} {
+		cert := x509.Certificate{Issuer: test.issuer, Subject: test.subject, RawIssuer: test.rawIssuer, RawSubject: test.rawSubject}
+		if got := m.CertificateMatches(&cert); got != test.match {
+			t.Errorf("%s: CertificateMatches = %v, want %v", test.desc, got, test.match)
+		}
+		precert := client.Precertificate{TBSCertificate: cert}
+		if got := m.PrecertificateMatches(&precert); got != test.match {
+			t.Errorf("%s: PrecertificateMatches = %v, want %v", test.desc, got, test.match)
+		}
+	}
+}
+
+func TestScannerMatchCertByValidityStraddlingLeapSecondOrDSTBoundary(t *testing.T) {
+	m := MatchCertByValidityStraddlingLeapSecondOrDSTBoundary{}
+	for _, test := range []struct {
+		notBefore, notAfter string
+		match               bool
+	}{
+		{"160101000000Z", "170101000000Z", false},
+		{"160101000000+0100", "170101000000Z", true},
+		{"160101000000Z", "1701010000-0500", true},
+	} {
+		cert := x509.Certificate{RawTBSCertificate: makeRawTBSWithValidity(t, tagUTCTime, test.notBefore, tagUTCTime, test.notAfter)}
+		if got := m.CertificateMatches(&cert); got != test.match {
+			t.Errorf("CertificateMatches(%s, %s) = %v, want %v", test.notBefore, test.notAfter, got, test.match)
+		}
+		precert := client.Precertificate{TBSCertificate: cert}
+		if got := m.PrecertificateMatches(&precert); got != test.match {
+			t.Errorf("PrecertificateMatches(%s, %s) = %v, want %v", test.notBefore, test.notAfter, got, test.match)
+		}
+	}
+
+	// A certificate encoded by the x509 package uses UTC.
+	_, final := makePrecertAndFinal(t, newPrecertKey(t), newPrecertTemplate())
+	if m.CertificateMatches(final) {
+		t.Fatal("MatchCertByValidityStraddlingLeapSecondOrDSTBoundary incorrectly matched Cert with UTC validity")
+	}
+	if m.CertificateMatches(&x509.Certificate{RawTBSCertificate: []byte{0x30, 0x00}}) {
+		t.Fatal("MatchCertByValidityStraddlingLeapSecondOrDSTBoundary incorrectly matched Cert with malformed TBSCertificate")
+	}
+}
+
+func TestScannerMatchCertByExtensionCriticalityViolation(t *testing.T) {
+	ca, _ := makeTestCA(t, "CA")
+	if !ca.IsCA {
+		t.Fatal("Test CA isn't a CA")
+	}
+	oidBasicConstraints := asn1.ObjectIdentifier{2, 5, 29, 19}
+	withCriticality := func(c *x509.Certificate, oid asn1.ObjectIdentifier, critical bool) *x509.Certificate {
+		modified := *c
+		modified.Extensions = nil
+		for _, ext := range c.Extensions {
+			if ext.Id.Equal(oid) {
+				ext.Critical = critical
+			}
+			modified.Extensions = append(modified.Extensions, ext)
+		}
+		return &modified
+	}
+
+	m := MatchCertByExtensionCriticalityViolation{}
+	if m.CertificateMatches(ca) {
+		t.Fatalf("MatchCertByExtensionCriticalityViolation incorrectly matched compliant CA: %v", m.Violation(ca))
+	}
+	nonCritical := withCriticality(ca, oidBasicConstraints, false)
+	if r := m.Violation(nonCritical); r == nil || r.Name != "basicConstraints critical on CA" {
+		t.Fatalf("Expected basicConstraints violation, got %v", r)
+	}
+
+	// basicConstraints may be non-critical on a leaf.
+	leaf := *nonCritical
+	leaf.IsCA = false
+	if m.CertificateMatches(&leaf) {
+		t.Fatal("MatchCertByExtensionCriticalityViolation incorrectly matched leaf with non-critical basicConstraints")
+	}
+
+	// Rules can be turned off.
+	var rules []ExtensionCriticalityRule
+	for _, r := range DefaultExtensionCriticalityRules {
+		if !r.Id.Equal(oidBasicConstraints) {
+			rules = append(rules, r)
+		}
+	}
+	if (MatchCertByExtensionCriticalityViolation{Rules: rules}).CertificateMatches(nonCritical) {
+		t.Fatal("MatchCertByExtensionCriticalityViolation applied a disabled rule")
+	}
+
+	// The poison extension of a Precertificate is critical, but isn't checked
+	// even by a rule requiring it not to be.
+	precert, _ := makePrecertAndFinal(t, newPrecertKey(t), newPrecertTemplate())
+	poisonRule := MatchCertByExtensionCriticalityViolation{Rules: []ExtensionCriticalityRule{{Id: oidExtensionCTPoison}}}
+	if poisonRule.PrecertificateMatches(precert) || m.PrecertificateMatches(precert) {
+		t.Fatal("MatchCertByExtensionCriticalityViolation incorrectly matched Precertificate poison extension")
+	}
+	precert.TBSCertificate = *nonCritical
+	if !m.PrecertificateMatches(precert) {
+		t.Fatal("MatchCertByExtensionCriticalityViolation failed to match Precertificate")
+	}
+}
+
+func TestScannerMatchIssuerRegex(t *testing.T) {
+	cert := x509.Certificate{
+		Issuer:         pkix.Name{CommonName: "Example Issuing CA 1", Organization: []string{"Example Trust, Inc."}},
+		AuthorityKeyId: []byte{0xab, 0xcd, 0x01},
+	}
+	precert := client.Precertificate{TBSCertificate: cert}
+	for _, test := range []struct {
+		desc  string
+		m     MatchIssuerRegex
+		match bool
+	}{
+		{"CN", MatchIssuerRegex{IssuerRegex: regexp.MustCompile("Issuing CA")}, true},
+		{"Organization", MatchIssuerRegex{IssuerRegex: regexp.MustCompile("^Example Trust")}, true},
+		{"other issuer", MatchIssuerRegex{IssuerRegex: regexp.MustCompile("Other CA")}, false},
+		{"AKI", MatchIssuerRegex{AuthorityKeyId: "AB:CD:01"}, true},
+		{"other AKI", MatchIssuerRegex{AuthorityKeyId: "abcd02"}, false},
+		{"CN and AKI", MatchIssuerRegex{IssuerRegex: regexp.MustCompile("Issuing CA"), AuthorityKeyId: "abcd01"}, true},
+		{"CN and other AKI", MatchIssuerRegex{IssuerRegex: regexp.MustCompile("Issuing CA"), AuthorityKeyId: "abcd02"}, false},
+	} {
+		if got := test.m.CertificateMatches(&cert); got != test.match {
+			t.Errorf("%s: CertificateMatches() = %v, want %v", test.desc, got, test.match)
+		}
+		if got := test.m.PrecertificateMatches(&precert); got != test.match {
+			t.Errorf("%s: PrecertificateMatches() = %v, want %v", test.desc, got, test.match)
+		}
+	}
+}
+
+func TestScannerMatchValidity(t *testing.T) {
+	day := 24 * time.Hour
+	notBefore := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	cert := x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.Add(825 * day)}
+	precert := client.Precertificate{TBSCertificate: cert}
+	for _, test := range []struct {
+		desc  string
+		m     MatchValidity
+		match bool
+	}{
+		{"no bounds", MatchValidity{}, true},
+		{"expires within window", MatchValidity{NotAfterAfter: notBefore.Add(800 * day), NotAfterBefore: notBefore.Add(850 * day)}, true},
+		{"expires after window", MatchValidity{NotAfterBefore: notBefore.Add(800 * day)}, false},
+		{"expires before window", MatchValidity{NotAfterAfter: notBefore.Add(850 * day)}, false},
+		{"issued after", MatchValidity{NotBeforeAfter: notBefore.Add(-day)}, true},
+		{"issued before", MatchValidity{NotBeforeBefore: notBefore.Add(-day)}, false},
+		{"too long", MatchValidity{MaxValidityDuration: 398 * day}, true},
+		{"not too long", MatchValidity{MaxValidityDuration: 825 * day}, false},
+		{"issued after but not too long", MatchValidity{NotBeforeAfter: notBefore.Add(-day), MaxValidityDuration: 1000 * day}, false},
+	} {
+		if got := test.m.CertificateMatches(&cert); got != test.match {
+			t.Errorf("%s: CertificateMatches() = %v, want %v", test.desc, got, test.match)
+		}
+		if got := test.m.PrecertificateMatches(&precert); got != test.match {
+			t.Errorf("%s: PrecertificateMatches() = %v, want %v", test.desc, got, test.match)
+		}
+	}
+}
+
+func TestScannerMatchCertBySubjectKeyIdentifierMismatch(t *testing.T) {
+	var cert x509.Certificate
+	cert.RawSubjectPublicKeyInfo = makeRawSPKI(t, oidPublicKeyEd25519)
+	hash := sha1.Sum(make([]byte, 32))
+	method2 := append([]byte{0x40 | (hash[12] & 0x0f)}, hash[13:]...)
+
+	m := MatchCertBySubjectKeyIdentifierMismatch{}
+	cert.SubjectKeyId = []byte{1, 2, 3, 4}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySubjectKeyIdentifierMismatch failed to match Cert with arbitrary SKI")
+	}
+	var precert client.Precertificate
+	precert.TBSCertificate = cert
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertBySubjectKeyIdentifierMismatch failed to match Precert with arbitrary SKI")
+	}
+
+	for _, ski := range [][]byte{hash[:], method2} {
+		cert.SubjectKeyId = ski
+		if m.CertificateMatches(&cert) {
+			t.Fatalf("MatchCertBySubjectKeyIdentifierMismatch incorrectly matched Cert with derived SKI %x", ski)
+		}
+	}
+
+	m.Methods = []SKIMethod{SKIMethod1}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySubjectKeyIdentifierMismatch failed to match method 2 SKI when only method 1 allowed")
+	}
+
+	cert.SubjectKeyId = nil
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySubjectKeyIdentifierMismatch incorrectly matched Cert without SKI")
+	}
+}
+
+func TestScannerEndToEnd(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ct/v1/get-sth":
+			log.Printf("GetSTH")
+			if _, err := w.Write([]byte(FourEntrySTH)); err != nil {
+				t.Fatal("Failed to write get-sth response")
+			}
+		case "/ct/v1/get-entries":
+			log.Printf("GetEntries %s", r.URL.RawQuery)
+			if _, err := w.Write([]byte(FourEntries)); err != nil {
+				t.Fatal("Failed to write get-sth response")
+			}
+		default:
+			t.Fatal("Unexpected request")
+		}
+	}))
+	defer ts.Close()
+
+	logClient := client.New(ts.URL)
+	opts := ScannerOptions{
+		Matcher:       &MatchSubjectRegex{regexp.MustCompile(".*\\.google\\.com"), nil},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		StartIndex:    0,
+	}
+	scanner := NewScanner(logClient, opts)
+
+	var matchedCerts list.List
+	var matchedPrecerts list.List
+
+	err := scanner.Scan(func(index int64, c *x509.Certificate) {
+		// Annoyingly we can't t.Fatal() in here, as this is run in another go
+		// routine
+		matchedCerts.PushBack(*c)
+	}, func(index int64, p *client.Precertificate) {
+		matchedPrecerts.PushBack(p)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if matchedPrecerts.Len() != 0 {
+		t.Fatal("Found unexpected Precert")
+	}
+
+	switch matchedCerts.Len() {
+	case 0:
+		t.Fatal("Failed to find mail.google.com cert")
+	case 1:
+		if matchedCerts.Front().Value.(x509.Certificate).Subject.CommonName != "mail.google.com" {
+			t.Fatal("Matched unexpected cert")
+		}
+	default:
+		t.Fatal("Found unexpected number of certs")
+	}
+}
+
+// Returns a test server which serves the four entry test log.
+func newFourEntryLogServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ct/v1/get-sth":
+			w.Write([]byte(FourEntrySTH))
+		case "/ct/v1/get-entries":
+			w.Write([]byte(FourEntries))
+		default:
+			t.Fatal("Unexpected request")
+		}
+	}))
+}
+
+// Returns a test log server serving FourEntrySTH which, unlike
+// newFourEntryLogServer, only returns the requested range of FourEntries. Each
+// requested [start, end] range is sent to |requests| if non-nil.
+func newFourEntryRangeLogServer(t *testing.T, requests chan<- [2]int64) *httptest.Server {
+	var all struct {
+		Entries []json.RawMessage `json:"entries"`
+	}
+	if err := json.Unmarshal([]byte(FourEntries), &all); err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ct/v1/get-sth":
+			w.Write([]byte(FourEntrySTH))
+		case "/ct/v1/get-entries":
+			start, err := strconv.ParseInt(r.URL.Query().Get("start"), 10, 64)
+			if err != nil {
+				t.Errorf("Invalid start: %v", err)
+			}
+			end, err := strconv.ParseInt(r.URL.Query().Get("end"), 10, 64)
+			if err != nil {
+				t.Errorf("Invalid end: %v", err)
+			}
+			if requests != nil {
+				requests <- [2]int64{start, end}
+			}
+			end = min(end, int64(len(all.Entries))-1)
+			resp := all
+			resp.Entries = all.Entries[start : end+1]
+			json.NewEncoder(w).Encode(resp)
+		default:
+			t.Fatal("Unexpected request")
+		}
+	}))
+}
+
+func TestScannerTreeSizeOverride(t *testing.T) {
+	requests := make(chan [2]int64, 10)
+	ts := newFourEntryRangeLogServer(t, requests)
+	defer ts.Close()
+
+	opts := ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     1,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		TreeSize:      2,
+		Quiet:         true,
+	}
+	s := NewScanner(client.New(ts.URL), opts)
+	var indices int64Slice
+	var mu sync.Mutex
+	err := s.Scan(func(index int64, c *x509.Certificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		indices = append(indices, index)
+	}, func(index int64, p *client.Precertificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		indices = append(indices, index)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(requests)
+	for r := range requests {
+		if r[1] >= 2 {
+			t.Fatalf("Requested entries [%d, %d] beyond overridden tree size", r[0], r[1])
+		}
+	}
+	sort.Sort(indices)
+	if len(indices) != 2 || indices[0] != 0 || indices[1] != 1 {
+		t.Fatalf("Expected entries 0 and 1 to be scanned, got %v", indices)
+	}
+}
+
+func TestScannerTreeSizeOverrideTooLarge(t *testing.T) {
+	ts := newFourEntryRangeLogServer(t, nil)
+	defer ts.Close()
+
+	opts := *DefaultScannerOptions()
+	opts.Quiet = true
+	opts.TreeSize = 5
+	s := NewScanner(client.New(ts.URL), opts)
+	err := s.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {})
+	if err == nil || !strings.Contains(err.Error(), "TreeSize 5") {
+		t.Fatalf("Expected error for TreeSize larger than the STH, got %v", err)
+	}
+}
+
+func TestScannerPinnedSTH(t *testing.T) {
+	source := newMockLogSource(t)
+	sth := &client.SignedTreeHead{TreeSize: 3, Timestamp: 1234}
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		STH:           sth,
+		Quiet:         true,
+	})
+	var mu sync.Mutex
+	var indices int64Slice
+	err := s.Scan(func(index int64, c *x509.Certificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		indices = append(indices, index)
+	}, func(index int64, p *client.Precertificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		indices = append(indices, index)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Sort(indices)
+	if fmt.Sprint(indices) != "[0 1 2]" {
+		t.Fatalf("Expected entries 0-2 to be scanned, got %v", indices)
+	}
+	if calls := atomic.LoadInt64(&source.calls); calls != 1 {
+		t.Fatalf("Expected a single call to get entries and none to get the STH, got %d calls", calls)
+	}
+	if s.Stats().STH != sth {
+		t.Fatalf("Expected the pinned STH in the stats, got %+v", s.Stats().STH)
+	}
+}
+
+func TestScannerPinnedSTHBeforeStartIndex(t *testing.T) {
+	s := NewScanner(newMockLogSource(t), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		StartIndex:    4,
+		STH:           &client.SignedTreeHead{TreeSize: 3},
+		Quiet:         true,
+	})
+	err := s.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {})
+	if err == nil || !strings.Contains(err.Error(), "StartIndex 4") {
+		t.Fatalf("Expected error for StartIndex beyond the pinned STH, got %v", err)
+	}
+}
+
+// mockLogSource is a LogSource serving the entries of FourEntries, which
+// counts the calls made to it.
+type mockLogSource struct {
+	leaves []client.LeafInput
+	calls  int64
+}
+
+func newMockLogSource(t testing.TB) *mockLogSource {
+	var resp struct {
+		Entries []struct {
+			LeafInput []byte `json:"leaf_input"`
+		} `json:"entries"`
+	}
+	if err := json.Unmarshal([]byte(FourEntries), &resp); err != nil {
+		t.Fatal(err)
+	}
+	m := &mockLogSource{}
+	for _, e := range resp.Entries {
+		m.leaves = append(m.leaves, e.LeafInput)
+	}
+	return m
+}
+
+func (m *mockLogSource) GetSTH() (*client.SignedTreeHead, error) {
+	return m.GetSTHCtx(context.Background())
+}
+
+func (m *mockLogSource) GetSTHCtx(ctx context.Context) (*client.SignedTreeHead, error) {
+	atomic.AddInt64(&m.calls, 1)
+	return &client.SignedTreeHead{TreeSize: uint64(len(m.leaves))}, nil
+}
+
+func (m *mockLogSource) GetEntries(start, end int64) ([]client.LeafInput, error) {
+	return m.GetEntriesCtx(context.Background(), start, end)
+}
+
+func (m *mockLogSource) GetEntriesCtx(ctx context.Context, start, end int64) ([]client.LeafInput, error) {
+	atomic.AddInt64(&m.calls, 1)
+	return m.leaves[start : end+1], nil
+}
+
+func TestScannerWithMockLogSource(t *testing.T) {
+	source := newMockLogSource(t)
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       &MatchSubjectRegex{regexp.MustCompile(".*\\.google\\.com"), nil},
+		BlockSize:     1,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	var matches int64
+	err := s.Scan(func(index int64, c *x509.Certificate) {
+		atomic.AddInt64(&matches, 1)
+	}, func(index int64, p *client.Precertificate) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matches != 1 {
+		t.Fatalf("Expected 1 match, got %d", matches)
+	}
+	// One get-sth, and one get-entries per entry.
+	if source.calls != 5 {
+		t.Fatalf("Expected 5 calls to the LogSource, got %d", source.calls)
+	}
+}
+
+// orderRecordingLogSource is a mockLogSource which records the start of each
+// range requested.
+type orderRecordingLogSource struct {
+	*mockLogSource
+	mu     sync.Mutex
+	starts []int64
+}
+
+func (o *orderRecordingLogSource) GetEntriesCtx(ctx context.Context, start, end int64) ([]client.LeafInput, error) {
+	o.mu.Lock()
+	o.starts = append(o.starts, start)
+	o.mu.Unlock()
+	return o.mockLogSource.GetEntriesCtx(ctx, start, end)
+}
+
+func TestScannerPrioritizeTip(t *testing.T) {
+	source := &orderRecordingLogSource{mockLogSource: newMockLogSource(t)}
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     1,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		PrioritizeTip: true,
+		Quiet:         true,
+	})
+	var mu sync.Mutex
+	var indices []int64
+	err := s.Scan(func(index int64, c *x509.Certificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		indices = append(indices, index)
+	}, func(index int64, p *client.Precertificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		indices = append(indices, index)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []int64{3, 0, 2, 1}
+	if fmt.Sprint(source.starts) != fmt.Sprint(expected) {
+		t.Fatalf("Expected ranges to be fetched in order %v, got %v", expected, source.starts)
+	}
+	if len(indices) != 4 || indices[0] != 3 {
+		t.Fatalf("Expected all entries to be scanned, starting with the tip, got %v", indices)
+	}
+}
+
+func TestFeedRanges(t *testing.T) {
+	for _, test := range []struct {
+		start, end        int64
+		tipFirst, reverse bool
+		expected          string
+	}{
+		{0, 10, false, false, "[{0 2} {3 5} {6 8} {9 9}]"},
+		{4, 10, false, false, "[{4 6} {7 9}]"},
+		{0, 10, true, false, "[{9 9} {0 2} {6 8} {3 5}]"},
+		{0, 9, true, false, "[{6 8} {0 2} {3 5}]"},
+		{10, 10, true, false, "[]"},
+		{0, 10, false, true, "[{9 9} {6 8} {3 5} {0 2}]"},
+		{0, 10, true, true, "[{9 9} {6 8} {3 5} {0 2}]"},
+	} {
+		s := NewScanner(newMockLogSource(t), ScannerOptions{BlockSize: 3, PrioritizeTip: test.tipFirst, Reverse: test.reverse, Quiet: true})
+		fetches := make(chan fetchRange, 10)
+		s.feedRanges(context.Background(), test.start, test.end, fetches)
+		close(fetches)
+		var ranges []fetchRange
+		for r := range fetches {
+			ranges = append(ranges, r)
+		}
+		if got := fmt.Sprint(ranges); got != test.expected {
+			t.Errorf("feedRanges(%d, %d, tipFirst=%v, reverse=%v) = %s, want %s", test.start, test.end, test.tipFirst, test.reverse, got, test.expected)
+		}
+	}
+}
+
+func TestFeedRangesStopsWhenDone(t *testing.T) {
+	s := NewScanner(newMockLogSource(t), ScannerOptions{BlockSize: 1, Quiet: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// Nothing reads from |fetches|, so this would block on a 500M entry log
+	// if the ranges weren't generated lazily.
+	s.feedRanges(ctx, 0, 500000000, make(chan fetchRange))
+}
+
+func TestScannerReverse(t *testing.T) {
+	source := &orderRecordingLogSource{mockLogSource: newMockLogSource(t)}
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     1,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		StartIndex:    1,
+		Reverse:       true,
+		Quiet:         true,
+	})
+	var mu sync.Mutex
+	var indices []int64
+	err := s.Scan(func(index int64, c *x509.Certificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		indices = append(indices, index)
+	}, func(index int64, p *client.Precertificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		indices = append(indices, index)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(source.starts) != "[3 2 1]" {
+		t.Fatalf("Expected ranges to be fetched newest first, got %v", source.starts)
+	}
+	if fmt.Sprint(indices) != "[3 2 1]" {
+		t.Fatalf("Expected entries 3 down to 1 to be scanned, got %v", indices)
+	}
+	if hw := s.progress.highWater(); hw != 4 {
+		t.Fatalf("Expected high-water 4 after a complete reverse scan, got %d", hw)
+	}
+}
+
+func TestScannerStopFromCallback(t *testing.T) {
+	before := runtime.NumGoroutine()
+	var s *Scanner
+	s = NewScanner(newMockLogSource(t), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     1,
+		NumWorkers:    1,
+		ParallelFetch: 2,
+		Quiet:         true,
+	})
+	var found []int64
+	err := s.Scan(func(index int64, c *x509.Certificate) {
+		found = append(found, index)
+		s.Stop()
+	}, func(index int64, p *client.Precertificate) {
+		found = append(found, index)
+		s.Stop()
+	})
+	if err != ErrScanStopped {
+		t.Fatalf("Expected ErrScanStopped, got %v", err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("Expected the scan to stop after the first match, got %v", found)
+	}
+	waitForGoroutines(t, before)
+}
+
+func TestScannerStopWithoutScan(t *testing.T) {
+	s := NewScanner(newMockLogSource(t), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     1,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	s.Stop()
+	noCert, noPrecert := func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {}
+	if err := s.Scan(noCert, noPrecert); err != ErrScanStopped {
+		t.Fatalf("Expected the scan following Stop to return ErrScanStopped, got %v", err)
+	}
+	// The Stop has been observed, so doesn't affect later scans.
+	if err := s.Scan(noCert, noPrecert); err != nil {
+		t.Fatal(err)
+	}
+	if processed := s.Stats().CertsProcessed; processed != 4 {
+		t.Fatalf("Expected 4 entries processed, got %d", processed)
+	}
+}
+
+func TestScannerOnlyFinishesSuccessfulScans(t *testing.T) {
+	f := &finishingMatcher{}
+	var s *Scanner
+	s = NewScanner(newMockLogSource(t), ScannerOptions{
+		Matcher:       f,
+		BlockSize:     1,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	if err := s.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {}); err != nil {
+		t.Fatal(err)
+	}
+	if f.finished != 1 {
+		t.Fatalf("Expected the Matcher to be told the scan finished once, got %d", f.finished)
+	}
+	err := s.Scan(func(int64, *x509.Certificate) { s.Stop() }, func(int64, *client.Precertificate) { s.Stop() })
+	if err != ErrScanStopped {
+		t.Fatalf("Expected ErrScanStopped, got %v", err)
+	}
+	if f.finished != 1 {
+		t.Fatal("Matcher was told that a stopped scan had finished")
+	}
+}
+
+// overlongLogSource is a mockLogSource which claims a tree size of |treeSize|,
+// but returns every leaf from |start| onwards for each request.
+type overlongLogSource struct {
+	*mockLogSource
+	treeSize uint64
+	requests int64
+}
+
+func (o *overlongLogSource) GetSTHCtx(ctx context.Context) (*client.SignedTreeHead, error) {
+	return &client.SignedTreeHead{TreeSize: o.treeSize}, nil
+}
+
+func (o *overlongLogSource) GetEntriesCtx(ctx context.Context, start, end int64) ([]client.LeafInput, error) {
+	atomic.AddInt64(&o.requests, 1)
+	return o.leaves[start:], nil
+}
+
+// Checks that |err| is a FetchError for exactly the ranges |want|, each of
+// which was abandoned because of a client.TooManyEntriesError.
+func checkTooManyEntriesRanges(t *testing.T, err error, want ...[2]int64) {
+	fetchErr, ok := err.(*FetchError)
+	if !ok {
+		t.Fatalf("Expected a FetchError, got %v", err)
+	}
+	if len(fetchErr.Ranges) != len(want) {
+		t.Fatalf("Expected %d failed ranges, got %v", len(want), fetchErr.Ranges)
+	}
+	for _, r := range fetchErr.Ranges {
+		found := false
+		for _, w := range want {
+			found = found || (r.Start == w[0] && r.End == w[1])
+		}
+		if !found {
+			t.Fatalf("Unexpected failed range [%d, %d]", r.Start, r.End)
+		}
+		if _, ok := r.Err.(client.TooManyEntriesError); !ok {
+			t.Fatalf("Expected range [%d, %d] to fail with TooManyEntriesError, got %v", r.Start, r.End, r.Err)
+		}
+	}
+}
+
+func TestScannerAbandonsRangesWithTooManyEntries(t *testing.T) {
+	// The range [0, 1] gets all four leaves, and [2, 3] exactly its two.
+	source := &overlongLogSource{mockLogSource: newMockLogSource(t), treeSize: 4}
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     2,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	var mu sync.Mutex
+	var indices int64Slice
+	err := s.Scan(func(index int64, c *x509.Certificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		indices = append(indices, index)
+	}, func(index int64, p *client.Precertificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		indices = append(indices, index)
+	})
+	checkTooManyEntriesRanges(t, err, [2]int64{0, 1})
+	sort.Sort(indices)
+	if fmt.Sprint(indices) != "[2 3]" {
+		t.Fatalf("Expected exactly entries 2-3 to be processed, got %v", indices)
+	}
+	if requests := atomic.LoadInt64(&source.requests); requests != 2 {
+		t.Fatalf("Expected the oversized response not to be retried, got %d requests", requests)
+	}
+}
+
+func TestScannerAbandonsRangesWithTooManyEntriesFromLogClient(t *testing.T) {
+	// The server returns all four entries for every request, so every range
+	// of two is oversized.
+	var requests int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ct/v1/get-sth":
+			w.Write([]byte(FourEntrySTH))
+		case "/ct/v1/get-entries":
+			atomic.AddInt64(&requests, 1)
+			w.Write([]byte(FourEntries))
+		default:
+			t.Errorf("Unexpected request for %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	s := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     2,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	var processed int64
+	err := s.Scan(func(int64, *x509.Certificate) {
+		atomic.AddInt64(&processed, 1)
+	}, func(int64, *client.Precertificate) {
+		atomic.AddInt64(&processed, 1)
+	})
+	checkTooManyEntriesRanges(t, err, [2]int64{0, 1}, [2]int64{2, 3})
+	if processed != 0 {
+		t.Fatalf("Expected no entries from oversized responses to be processed, got %d", processed)
+	}
+	if got := atomic.LoadInt64(&requests); got != 2 {
+		t.Fatalf("Expected one get-entries request per range, got %d", got)
+	}
+}
+
+// choppyLogSource is a mockLogSource which alternately returns a single leaf
+// and every requested leaf for each request.
+type choppyLogSource struct {
+	*mockLogSource
+	requests int64
+}
+
+func (c *choppyLogSource) GetEntriesCtx(ctx context.Context, start, end int64) ([]client.LeafInput, error) {
+	if atomic.AddInt64(&c.requests, 1)%2 == 1 {
+		return c.leaves[start : start+1], nil
+	}
+	return c.leaves[start : end+1], nil
+}
+
+func TestScannerDeliversEachIndexOnceWithShortBatches(t *testing.T) {
+	source := &choppyLogSource{mockLogSource: newMockLogSource(t)}
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     3,
+		NumWorkers:    2,
+		ParallelFetch: 2,
+		Quiet:         true,
+	})
+	var mu sync.Mutex
+	seen := make(map[int64]int)
+	err := s.Scan(func(index int64, c *x509.Certificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[index]++
+	}, func(index int64, p *client.Precertificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[index]++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 4 {
+		t.Fatalf("Expected entries 0-3 to be processed, got %v", seen)
+	}
+	for index, n := range seen {
+		if n != 1 {
+			t.Errorf("Entry %d processed %d times", index, n)
+		}
+	}
+	if processed := s.Stats().CertsProcessed; processed != 4 {
+		t.Fatalf("Expected 4 entries processed, got %d", processed)
+	}
+}
+
+// failingLogSource is a mockLogSource whose GetEntries always fails.
+type failingLogSource struct {
+	*mockLogSource
+}
+
+func (f *failingLogSource) GetEntries(start, end int64) ([]client.LeafInput, error) {
+	return f.GetEntriesCtx(context.Background(), start, end)
+}
+
+func (f *failingLogSource) GetEntriesCtx(ctx context.Context, start, end int64) ([]client.LeafInput, error) {
+	atomic.AddInt64(&f.calls, 1)
+	return nil, fmt.Errorf("log unavailable")
+}
+
+func TestScanCtxStopsRetryingWhenDone(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	s := NewScanner(&failingLogSource{newMockLogSource(t)}, ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     1,
+		NumWorkers:    2,
+		ParallelFetch: 2,
+		Quiet:         true,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ScanCtx(ctx, func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {})
+	}()
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("Expected %v, got %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("ScanCtx didn't return after its context was done")
+	}
+
+	waitForGoroutines(t, goroutines)
+}
+
+// Fails the test unless the number of goroutines falls to at most |n|, i.e.
+// the fetchers, matchers and progress ticker of a scan have all stopped.
+func waitForGoroutines(t *testing.T, n int) {
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > n {
+		t.Fatalf("Expected at most %d goroutines after the scan returned, got %d", n, got)
+	}
+}
+
+func TestScannerStopsAtEndIndex(t *testing.T) {
+	for _, test := range []struct {
+		endIndex int64
+		want     string
+	}{
+		{3, "[1 2]"},
+		{0, "[1 2 3]"},
+		{100, "[1 2 3]"},
+	} {
+		s := NewScanner(newMockLogSource(t), ScannerOptions{
+			Matcher:       &MatchAll{},
+			BlockSize:     1,
+			NumWorkers:    1,
+			ParallelFetch: 2,
+			Quiet:         true,
+			StartIndex:    1,
+			EndIndex:      test.endIndex,
+		})
+		var mu sync.Mutex
+		var indices int64Slice
+		record := func(index int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			indices = append(indices, index)
+		}
+		err := s.Scan(func(index int64, c *x509.Certificate) {
+			record(index)
+		}, func(index int64, p *client.Precertificate) {
+			record(index)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Sort(indices)
+		if fmt.Sprint(indices) != test.want {
+			t.Errorf("EndIndex %d: expected entries %s, got %v", test.endIndex, test.want, indices)
+		}
+	}
+}
+
+func TestScanLogsTagsMatchesWithLog(t *testing.T) {
+	ts1 := newFourEntryLogServer(t)
+	defer ts1.Close()
+	ts2 := newFourEntryLogServer(t)
+	defer ts2.Close()
+
+	logs := []client.LogListEntry{
+		{Description: "Log One", URL: ts1.URL, Client: client.New(ts1.URL)},
+		{Description: "Log Two", URL: ts2.URL, Client: client.New(ts2.URL)},
+	}
+	opts := ScannerOptions{
+		Matcher:       &MatchSubjectRegex{regexp.MustCompile(".*\\.google\\.com"), nil},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	}
+	var mu sync.Mutex
+	found := make(map[string]int)
+	err := ScanLogs(logs, opts, func(l *client.LogListEntry, index int64, c *x509.Certificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		found[l.Description]++
+	}, func(l *client.LogListEntry, index int64, p *client.Precertificate) {
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found["Log One"] != 1 || found["Log Two"] != 1 {
+		t.Fatalf("Expected one match in each log, got %v", found)
+	}
+}
+
+func TestScanLogsReportsFailedLogs(t *testing.T) {
+	ts := newFourEntryLogServer(t)
+	defer ts.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	logs := []client.LogListEntry{
+		{Description: "Dead Log", URL: dead.URL, Client: client.New(dead.URL)},
+		{Description: "Live Log", URL: ts.URL, Client: client.New(ts.URL)},
+	}
+	opts := ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	}
+	var mu sync.Mutex
+	seen := 0
+	err := ScanLogs(logs, opts, func(l *client.LogListEntry, index int64, c *x509.Certificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen++
+	}, func(l *client.LogListEntry, index int64, p *client.Precertificate) {
+	})
+	if err == nil {
+		t.Fatal("Expected error for unreachable log")
+	}
+	if seen == 0 {
+		t.Fatal("Live log wasn't scanned after dead log failed")
+	}
+}
+
+func TestDefaultScannerOptions(t *testing.T) {
+	opts := DefaultScannerOptions()
+	switch opts.Matcher.(type) {
+	case *MatchAll:
+		// great
+	default:
+		t.Fatalf("Default Matcher is a %T, expected MatchAll.", opts.Matcher)
+	}
+	if opts.PrecertOnly {
+		t.Fatal("Expected PrecertOnly to be false.")
+	}
+	if opts.BlockSize < 1 {
+		t.Fatalf("Insane BlockSize %d", opts.BlockSize)
+	}
+	if opts.NumWorkers < 1 {
+		t.Fatalf("Insane NumWorkers %d", opts.NumWorkers)
+	}
+	if opts.ParallelFetch < 1 {
+		t.Fatalf("Insane ParallelFetch %d", opts.ParallelFetch)
+	}
+	if opts.StartIndex != 0 {
+		t.Fatalf("Expected StartIndex to be 0, but was %d", opts.StartIndex)
+	}
+	if opts.Quiet {
+		t.Fatal("Expected Quiet to be false.")
+	}
+}
+
+func TestDefaultScannerOptionsScaleWithGOMAXPROCS(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	opts := DefaultScannerOptions()
+	if opts.NumWorkers != 1 {
+		t.Fatalf("Expected NumWorkers 1 with GOMAXPROCS=1, got %d", opts.NumWorkers)
+	}
+	if opts.ParallelFetch != 1 {
+		t.Fatalf("Expected ParallelFetch 1 with GOMAXPROCS=1, got %d", opts.ParallelFetch)
+	}
+
+	runtime.GOMAXPROCS(16)
+	opts = DefaultScannerOptions()
+	if opts.NumWorkers != 16 {
+		t.Fatalf("Expected NumWorkers 16 with GOMAXPROCS=16, got %d", opts.NumWorkers)
+	}
+	if opts.ParallelFetch != maxDefaultParallelFetch {
+		t.Fatalf("Expected ParallelFetch %d with GOMAXPROCS=16, got %d", maxDefaultParallelFetch, opts.ParallelFetch)
 	}
 }
@@ -0,0 +1,233 @@
+// Package scannertest provides an in-memory fake CT log, for testing code
+// built on the scanner package without talking to a real log.
+package scannertest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	"github.com/google/certificate-transparency/go/client"
+)
+
+// FakeLog is a deterministic, in-memory CT log holding the leaves added to
+// it. It implements scanner.LogSource, so can be passed directly to
+// scanner.NewScanner, and can also be served over the CT HTTP API by
+// NewServer.
+// All methods are safe to call concurrently.
+type FakeLog struct {
+	// If > 0, GetEntries returns at most MaxBatch entries per call, as real
+	// logs may do.
+	MaxBatch int
+	// Timestamp reported in STHs
+	Timestamp uint64
+
+	mu          sync.Mutex
+	leaves      []client.LeafInput
+	failures    int
+	failure     error
+	entriesReqs int
+}
+
+// Creates a new FakeLog containing |leaves|.
+func NewFakeLog(leaves ...client.LeafInput) *FakeLog {
+	f := &FakeLog{}
+	f.AddLeaves(leaves...)
+	return f
+}
+
+// Appends |leaves| to the log.
+func (f *FakeLog) AddLeaves(leaves ...client.LeafInput) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.leaves = append(f.leaves, leaves...)
+}
+
+// Makes the next |n| calls to GetEntries fail with |err|. The server started
+// by NewServer reports them as 503 Service Unavailable, so that they're
+// retried as transient failures.
+func (f *FakeLog) InjectErrors(n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = n
+	f.failure = err
+}
+
+// Returns the number of calls made to GetEntries, including failed ones.
+func (f *FakeLog) EntriesRequests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.entriesReqs
+}
+
+// Returns an STH for the current contents of the log. The root hash is
+// computed as described in RFC 6962 section 2.1; the STH isn't signed.
+func (f *FakeLog) GetSTH() (*client.SignedTreeHead, error) {
+	return f.GetSTHCtx(context.Background())
+}
+
+// As GetSTH, but returns ctx.Err() if |ctx| is already done.
+func (f *FakeLog) GetSTHCtx(ctx context.Context) (*client.SignedTreeHead, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	root := merkleTreeHash(f.leaves)
+	return &client.SignedTreeHead{
+		TreeSize:       uint64(len(f.leaves)),
+		Timestamp:      f.Timestamp,
+		SHA256RootHash: root[:],
+	}, nil
+}
+
+// Returns the leaves in the sequence [|start|, |end|], truncated to MaxBatch
+// entries and to the size of the log.
+func (f *FakeLog) GetEntries(start, end int64) ([]client.LeafInput, error) {
+	return f.GetEntriesCtx(context.Background(), start, end)
+}
+
+// As GetEntries, but returns ctx.Err() if |ctx| is already done.
+func (f *FakeLog) GetEntriesCtx(ctx context.Context, start, end int64) ([]client.LeafInput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entriesReqs++
+	if f.failures > 0 {
+		f.failures--
+		return nil, f.failure
+	}
+	if start < 0 || end < start {
+		return nil, rangeError(fmt.Sprintf("invalid range [%d, %d]", start, end))
+	}
+	if start >= int64(len(f.leaves)) {
+		return nil, rangeError(fmt.Sprintf("start %d is beyond tree size %d", start, len(f.leaves)))
+	}
+	if end >= int64(len(f.leaves)) {
+		end = int64(len(f.leaves)) - 1
+	}
+	if f.MaxBatch > 0 && end-start+1 > int64(f.MaxBatch) {
+		end = start + int64(f.MaxBatch) - 1
+	}
+	leaves := make([]client.LeafInput, end-start+1)
+	copy(leaves, f.leaves[start:end+1])
+	return leaves, nil
+}
+
+// rangeError is returned by GetEntries for a range it can never serve.
+type rangeError string
+
+func (e rangeError) Error() string {
+	return string(e)
+}
+
+// Returns a new httptest.Server serving the log's get-sth and get-entries
+// methods, for use with client.New. The caller must Close it.
+func (f *FakeLog) NewServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+}
+
+func (f *FakeLog) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var resp interface{}
+	switch r.URL.Path {
+	case client.GetSTHPath:
+		sth, err := f.GetSTHCtx(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp = map[string]interface{}{
+			"tree_size":           sth.TreeSize,
+			"timestamp":           sth.Timestamp,
+			"sha256_root_hash":    base64.StdEncoding.EncodeToString(sth.SHA256RootHash),
+			"tree_head_signature": "",
+		}
+	case client.GetEntriesPath:
+		start, startErr := strconv.ParseInt(r.URL.Query().Get("start"), 10, 64)
+		end, endErr := strconv.ParseInt(r.URL.Query().Get("end"), 10, 64)
+		if startErr != nil || endErr != nil {
+			http.Error(w, "invalid start or end", http.StatusBadRequest)
+			return
+		}
+		leaves, err := f.GetEntriesCtx(r.Context(), start, end)
+		if err != nil {
+			status := http.StatusServiceUnavailable
+			if _, ok := err.(rangeError); ok {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		entries := make([]map[string]string, len(leaves))
+		for i, leaf := range leaves {
+			entries[i] = map[string]string{
+				"leaf_input": base64.StdEncoding.EncodeToString(leaf),
+				"extra_data": "",
+			}
+		}
+		resp = map[string]interface{}{"entries": entries}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Returns the RFC 6962 Merkle Tree Hash of |leaves|.
+func merkleTreeHash(leaves []client.LeafInput) [sha256.Size]byte {
+	switch len(leaves) {
+	case 0:
+		return sha256.Sum256(nil)
+	case 1:
+		return sha256.Sum256(append([]byte{0}, leaves[0]...))
+	}
+	// Split at the largest power of two smaller than the number of leaves.
+	k := 1
+	for k*2 < len(leaves) {
+		k *= 2
+	}
+	left := merkleTreeHash(leaves[:k])
+	right := merkleTreeHash(leaves[k:])
+	return sha256.Sum256(append(append([]byte{1}, left[:]...), right[:]...))
+}
+
+// Returns a MerkleTreeLeaf holding the X.509 certificate |der|, logged at
+// |timestamp|.
+func CertLeaf(der []byte, timestamp uint64) client.LeafInput {
+	return makeLeaf(timestamp, client.X509LogEntryType, nil, der)
+}
+
+// Returns a MerkleTreeLeaf holding a precertificate with the given
+// |issuerKeyHash| and DER encoded |tbs| certificate, logged at |timestamp|.
+func PrecertLeaf(issuerKeyHash [sha256.Size]byte, tbs []byte, timestamp uint64) client.LeafInput {
+	return makeLeaf(timestamp, client.PrecertLogEntryType, issuerKeyHash[:], tbs)
+}
+
+// Serializes a MerkleTreeLeaf as described in RFC 6962 section 3.4.
+func makeLeaf(timestamp uint64, entryType client.LogEntryType, issuerKeyHash, der []byte) client.LeafInput {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(client.V1))
+	buf.WriteByte(byte(client.TimestampedEntryLeafType))
+	binary.Write(&buf, binary.BigEndian, timestamp)
+	binary.Write(&buf, binary.BigEndian, entryType)
+	buf.Write(issuerKeyHash)
+	if len(der) >= 1<<24 {
+		panic(errors.New("certificate too large for a MerkleTreeLeaf"))
+	}
+	buf.Write([]byte{byte(len(der) >> 16), byte(len(der) >> 8), byte(len(der))})
+	buf.Write(der)
+	// No extensions
+	buf.Write([]byte{0, 0})
+	return buf.Bytes()
+}
@@ -0,0 +1,163 @@
+package scannertest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"regexp"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/scanner"
+	"github.com/google/certificate-transparency/go/x509"
+	"github.com/google/certificate-transparency/go/x509/pkix"
+)
+
+// Returns a self-signed certificate for |cn|.
+func makeCert(t *testing.T, key *ecdsa.PrivateKey, serial int64, cn string) *x509.Certificate {
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:     []string{cn},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Returns a FakeLog holding 5 certificates and a precertificate, of which
+// the entries at indices 1, 4 and 5 are for example.com names.
+func newTestLog(t *testing.T) *FakeLog {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewFakeLog()
+	for i, cn := range []string{"a.example.org", "b.example.com", "c.example.net", "d.example.org", "e.example.com"} {
+		f.AddLeaves(CertLeaf(makeCert(t, key, int64(i), cn).Raw, uint64(i)))
+	}
+	precert := makeCert(t, key, 5, "f.example.com")
+	f.AddLeaves(PrecertLeaf(sha256.Sum256([]byte("issuer")), precert.RawTBSCertificate, 5))
+	return f
+}
+
+func TestFakeLogGetEntriesHonoursMaxBatch(t *testing.T) {
+	f := newTestLog(t)
+	f.MaxBatch = 4
+	leaves, err := f.GetEntries(1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaves) != 4 {
+		t.Fatalf("Expected 4 leaves, got %d", len(leaves))
+	}
+	f.MaxBatch = 0
+	if leaves, err = f.GetEntries(3, 100); err != nil || len(leaves) != 3 {
+		t.Fatalf("Expected 3 leaves up to the end of the log, got %d (%v)", len(leaves), err)
+	}
+	if _, err := f.GetEntries(6, 7); err == nil {
+		t.Fatal("Expected error fetching beyond the end of the log")
+	}
+}
+
+func TestFakeLogInjectErrors(t *testing.T) {
+	f := newTestLog(t)
+	injected := errors.New("log unavailable")
+	f.InjectErrors(2, injected)
+	for i := 0; i < 2; i++ {
+		if _, err := f.GetEntries(0, 0); err != injected {
+			t.Fatalf("Expected injected error, got %v", err)
+		}
+	}
+	if _, err := f.GetEntries(0, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFakeLogSTH(t *testing.T) {
+	f := NewFakeLog([]byte("one"), []byte("two"))
+	sth, err := f.GetSTH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sth.TreeSize != 2 {
+		t.Fatalf("Expected tree size 2, got %d", sth.TreeSize)
+	}
+	one := sha256.Sum256([]byte("\x00one"))
+	two := sha256.Sum256([]byte("\x00two"))
+	root := sha256.Sum256(append(append([]byte{1}, one[:]...), two[:]...))
+	if string(sth.SHA256RootHash) != string(root[:]) {
+		t.Fatalf("Incorrect root hash %x, expected %x", sth.SHA256RootHash, root)
+	}
+}
+
+var _ scanner.LogSource = &FakeLog{}
+
+// Runs a scan of |source|, which holds the entries of newTestLog, and checks
+// the matches.
+func checkScanOfTestLog(t *testing.T, source scanner.LogSource) {
+	exampleCom := regexp.MustCompile(`\.example\.com$`)
+	s := scanner.NewScanner(source, scanner.ScannerOptions{
+		Matcher:       scanner.MatchSubjectRegex{CertificateSubjectRegex: exampleCom, PrecertificateSubjectRegex: exampleCom},
+		BlockSize:     3,
+		NumWorkers:    2,
+		ParallelFetch: 2,
+		Quiet:         true,
+	})
+	var mu sync.Mutex
+	var matched []int
+	precerts := 0
+	err := s.Scan(func(index int64, c *x509.Certificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		matched = append(matched, int(index))
+	}, func(index int64, p *client.Precertificate) {
+		mu.Lock()
+		defer mu.Unlock()
+		matched = append(matched, int(index))
+		precerts++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(matched)
+	if len(matched) != 3 || matched[0] != 1 || matched[1] != 4 || matched[2] != 5 {
+		t.Fatalf("Expected entries 1, 4 and 5 to match, got %v", matched)
+	}
+	if precerts != 1 {
+		t.Fatalf("Expected 1 matching precert, got %d", precerts)
+	}
+	if stats := s.Stats(); stats.CertsProcessed != 6 {
+		t.Fatalf("Expected 6 entries processed, got %d", stats.CertsProcessed)
+	}
+}
+
+func TestFakeLogDrivesScan(t *testing.T) {
+	f := newTestLog(t)
+	f.MaxBatch = 2
+	f.InjectErrors(1, errors.New("transient failure"))
+	checkScanOfTestLog(t, f)
+}
+
+func TestFakeLogServerDrivesScan(t *testing.T) {
+	f := newTestLog(t)
+	f.MaxBatch = 2
+	f.InjectErrors(1, errors.New("transient failure"))
+	ts := f.NewServer()
+	defer ts.Close()
+	checkScanOfTestLog(t, client.New(ts.URL))
+}
@@ -0,0 +1,49 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Runs a scan with |s|, passing matched entries to |sink|, and stopping the
+// scan cleanly if the process receives SIGINT or SIGTERM or |ctx| is done.
+// Once the scan has finished or been stopped, a summary of the scan (including
+// the index from which an interrupted scan can be resumed) is written to
+// |summary|.
+// Returns the statistics gathered by the scan, along with any error from the
+// scan; an interrupted scan returns context.Canceled.
+func RunWithSignalHandling(ctx context.Context, s *Scanner, sink Sink, summary io.Writer) (ScanStats, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			s.Log(fmt.Sprintf("Received %s, stopping scan", sig))
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := s.ScanSinkCtx(ctx, sink)
+	stats := s.Stats()
+	status := "completed"
+	if err != nil {
+		status = fmt.Sprintf("stopped (%s)", err.Error())
+	}
+	resumeIndex := s.opts.StartIndex
+	// There's no progress if the scan failed before fetching the STH.
+	if s.progress != nil {
+		resumeIndex = s.progress.highWater()
+	}
+	fmt.Fprintf(summary, "Scan %s: %d certs processed, %d precerts seen, %d malformed leaves, %d unparsable entries, %d non-fatal errors; resume from index %d\n",
+		status, stats.CertsProcessed, stats.PrecertsSeen, stats.MalformedLeaves, stats.UnparsableEntries, stats.EntriesWithNonFatalErrors, resumeIndex)
+	return stats, err
+}
@@ -0,0 +1,115 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/certificate-transparency/go/client"
+)
+
+func TestRunWithSignalHandlingStopsCleanlyOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ct/v1/get-sth":
+			w.Write([]byte(FourEntrySTH))
+		case "/ct/v1/get-entries":
+			// Simulate the signal arriving while entries are being fetched.
+			cancel()
+			<-r.Context().Done()
+		default:
+			t.Fatal("Unexpected request")
+		}
+	}))
+	defer ts.Close()
+
+	scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	var sink recordingSink
+	var summary bytes.Buffer
+	_, err := RunWithSignalHandling(ctx, scanner, &sink, &summary)
+	if err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
+	}
+	if !sink.closed {
+		t.Fatal("Sink wasn't closed")
+	}
+	if !strings.Contains(summary.String(), "Scan stopped") || !strings.Contains(summary.String(), "resume from index 0") {
+		t.Fatalf("Unexpected summary %q", summary.String())
+	}
+}
+
+func TestRunWithSignalHandlingCompletes(t *testing.T) {
+	ts := newFourEntryLogServer(t)
+	defer ts.Close()
+
+	scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	var sink recordingSink
+	var summary bytes.Buffer
+	stats, err := RunWithSignalHandling(context.Background(), scanner, &sink, &summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.CertsProcessed != 4 {
+		t.Fatalf("Expected 4 certs processed, got %d", stats.CertsProcessed)
+	}
+	if !strings.Contains(summary.String(), "Scan completed: 4 certs processed") {
+		t.Fatalf("Unexpected summary %q", summary.String())
+	}
+}
+
+func TestRunWithSignalHandlingDoesNotReportStaleProgress(t *testing.T) {
+	sthFails := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ct/v1/get-sth":
+			if sthFails {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(FourEntrySTH))
+		case "/ct/v1/get-entries":
+			w.Write([]byte(FourEntries))
+		default:
+			t.Error("Unexpected request")
+		}
+	}))
+	defer ts.Close()
+
+	scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	var summary bytes.Buffer
+	if _, err := RunWithSignalHandling(context.Background(), scanner, &recordingSink{}, &summary); err != nil {
+		t.Fatal(err)
+	}
+	// A second scan which can't fetch the STH must not report the progress of
+	// the first.
+	sthFails = true
+	summary.Reset()
+	if _, err := RunWithSignalHandling(context.Background(), scanner, &recordingSink{}, &summary); err == nil {
+		t.Fatal("Expected an error fetching the STH")
+	}
+	if !strings.Contains(summary.String(), "resume from index 0") {
+		t.Fatalf("Unexpected summary %q", summary.String())
+	}
+}
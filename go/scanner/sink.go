@@ -0,0 +1,537 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// MatchedEntry holds an entry which was matched during a scan.
+type MatchedEntry struct {
+	// Index of the entry in the log
+	Index int64
+	// The matched Certificate, set for X509LogEntryType entries only.
+	Cert *x509.Certificate
+	// The matched Precertificate, set for PrecertLogEntryType entries only.
+	Precert *client.Precertificate
+	// The labels of the rules which matched the entry, if the Matcher is a
+	// Labeller.
+	Labels []string
+}
+
+// Clients wishing to send matched entries somewhere other than the Scan
+// callbacks should implement this interface:
+type Sink interface {
+	// Put is called for each entry matched during the scan. It may be called
+	// concurrently from multiple goroutines.
+	// A non-nil error is reported at the end of the scan.
+	Put(*MatchedEntry) error
+
+	// Close is called once the scan has finished and no further calls to Put
+	// will be made. Implementations should flush any buffered output and
+	// release any resources here.
+	Close() error
+}
+
+// Tee is a Sink which fans each entry out to each of |Sinks|, in order.
+type Tee struct {
+	Sinks []Sink
+
+	// If false, Put stops at the first Sink which returns an error.
+	// If true, every Sink is always called, and the errors are combined.
+	ContinueOnError bool
+}
+
+// Returns a Tee which sends each entry to all of |sinks|, stopping at the
+// first error.
+func TeeSink(sinks ...Sink) *Tee {
+	return &Tee{Sinks: sinks}
+}
+
+// Passes |e| to each Sink in turn.
+// Returns a non-nil error identifying the failing Sink(s) if any Sink failed.
+func (t *Tee) Put(e *MatchedEntry) error {
+	var errs []string
+	for i, s := range t.Sinks {
+		if err := s.Put(e); err != nil {
+			errs = append(errs, fmt.Sprintf("sink %d: %s", i, err.Error()))
+			if !t.ContinueOnError {
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to put entry %d: %s", e.Index, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Closes each of the Sinks, even if some of them fail to close.
+// Returns a non-nil error identifying the failing Sink(s) if any Sink failed.
+func (t *Tee) Close() error {
+	var errs []string
+	for i, s := range t.Sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("sink %d: %s", i, err.Error()))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to close: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// BufferedSink is a Sink which buffers entries, passing them to an inner Sink
+// in batches once |maxEntries| have accumulated, every |flushInterval|, and on
+// Close. It's safe for concurrent use.
+type BufferedSink struct {
+	sink       Sink
+	maxEntries int
+
+	// Guards buf, err and closed
+	mu  sync.Mutex
+	buf []*MatchedEntry
+	// First error returned by the inner Sink during a timed flush, reported by
+	// the next call to Put or Close.
+	err    error
+	closed bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Returns a BufferedSink wrapping |sink|. If |maxEntries| is <= 0 entries are
+// only flushed by time; if |flushInterval| is <= 0 they're only flushed by
+// size.
+func NewBufferedSink(sink Sink, maxEntries int, flushInterval time.Duration) *BufferedSink {
+	b := &BufferedSink{sink: sink, maxEntries: maxEntries, stop: make(chan struct{}), done: make(chan struct{})}
+	if flushInterval <= 0 {
+		close(b.done)
+		return b
+	}
+	go func() {
+		defer close(b.done)
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				b.mu.Lock()
+				if err := b.flushLocked(); err != nil && b.err == nil {
+					b.err = err
+				}
+				b.mu.Unlock()
+			case <-b.stop:
+				return
+			}
+		}
+	}()
+	return b
+}
+
+// Passes the buffered entries to the inner Sink, stopping at the first error.
+// The entry which failed and those after it stay buffered, so that the next
+// flush retries them.
+func (b *BufferedSink) flushLocked() error {
+	for i, e := range b.buf {
+		if err := b.sink.Put(e); err != nil {
+			b.buf = b.buf[i:]
+			return err
+		}
+	}
+	b.buf = nil
+	return nil
+}
+
+// Returns, and clears, any error from a timed flush.
+func (b *BufferedSink) takeErrLocked() error {
+	err := b.err
+	b.err = nil
+	return err
+}
+
+// Buffers |e|, flushing the buffer if it's full.
+// Returns an error from this or an earlier timed flush, if there was one.
+func (b *BufferedSink) Put(e *MatchedEntry) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, e)
+	if b.maxEntries > 0 && len(b.buf) >= b.maxEntries {
+		if err := b.flushLocked(); err != nil {
+			return err
+		}
+	}
+	return b.takeErrLocked()
+}
+
+// Passes any buffered entries to the inner Sink immediately.
+func (b *BufferedSink) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.flushLocked(); err != nil {
+		return err
+	}
+	return b.takeErrLocked()
+}
+
+// Stops timed flushing, flushes any buffered entries and closes the inner
+// Sink, which is closed even if the flush failed. Calls after the first do
+// nothing.
+func (b *BufferedSink) Close() error {
+	b.mu.Lock()
+	closed := b.closed
+	b.closed = true
+	b.mu.Unlock()
+	if closed {
+		return nil
+	}
+	close(b.stop)
+	<-b.done
+	flushErr := b.Flush()
+	closeErr := b.sink.Close()
+	if flushErr != nil {
+		return flushErr
+	}
+	return closeErr
+}
+
+// SlowSinkPolicy determines what ScanSink does with matched entries when the
+// Sink can't accept them as quickly as they're found.
+type SlowSinkPolicy int
+
+const (
+	// Matchers wait for the Sink, which in turn slows down fetching from the
+	// log. This is the default.
+	SlowSinkBlock SlowSinkPolicy = iota
+	// Entries which don't fit in the queue are discarded, and counted in
+	// ScanStats.DroppedMatches.
+	SlowSinkDropAndCount
+	// Entries which don't fit in the queue are staged in a temporary file in
+	// ScannerOptions.SpillDir, and passed to the Sink once the scan has
+	// finished. They're counted in ScanStats.SpilledMatches.
+	SlowSinkSpillToDisk
+)
+
+// Default number of matched entries queued for a Sink by the non-blocking
+// SlowSinkPolicy values.
+const defaultSinkQueueSize = 1000
+
+// sinkQueue passes matched entries to a Sink according to the Scanner's
+// SlowSinkPolicy. For policies other than SlowSinkBlock, or if
+// ScannerOptions.SinkWorkers is set, entries are queued and passed to the Sink
+// from a pool of SinkWorkers goroutines (or a single goroutine if unset).
+type sinkQueue struct {
+	s       *Scanner
+	sink    Sink
+	policy  SlowSinkPolicy
+	entries chan *MatchedEntry
+	workers sync.WaitGroup
+
+	// Guards err and the spill file
+	mu     sync.Mutex
+	err    error
+	spill  *os.File
+	spillW *bufio.Writer
+}
+
+func (s *Scanner) newSinkQueue(sink Sink) *sinkQueue {
+	q := &sinkQueue{s: s, sink: sink, policy: s.opts.SlowSinkPolicy}
+	if q.policy != SlowSinkBlock || s.opts.SinkWorkers > 0 {
+		size := s.opts.SinkQueueSize
+		if size <= 0 {
+			size = defaultSinkQueueSize
+		}
+		q.entries = make(chan *MatchedEntry, size)
+		workers := s.opts.SinkWorkers
+		if workers <= 0 {
+			workers = 1
+		}
+		for i := 0; i < workers; i++ {
+			q.workers.Add(1)
+			go q.run()
+		}
+	}
+	return q
+}
+
+// Remembers |err| if it's the first error seen.
+func (q *sinkQueue) recordError(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err == nil {
+		q.err = err
+	}
+}
+
+func (q *sinkQueue) run() {
+	defer q.workers.Done()
+	for e := range q.entries {
+		q.putSafely(e)
+	}
+}
+
+// Passes |e| to the Sink, recovering from any panic in it, which stops the
+// scan as a panic in the Matcher or callbacks would.
+func (q *sinkQueue) putSafely(e *MatchedEntry) {
+	defer func() {
+		if r := recover(); r != nil {
+			q.s.recordPanic(r, e.Index)
+		}
+	}()
+	if err := q.sink.Put(e); err != nil {
+		q.recordError(err)
+	}
+}
+
+// Passes |e| to the Sink, or queues, drops or spills it according to the
+// SlowSinkPolicy.
+func (q *sinkQueue) put(e *MatchedEntry) {
+	if q.entries == nil {
+		if err := q.sink.Put(e); err != nil {
+			q.recordError(err)
+		}
+		return
+	}
+	select {
+	case q.entries <- e:
+		return
+	default:
+	}
+	switch q.policy {
+	case SlowSinkDropAndCount:
+		atomic.AddInt64(&q.s.droppedMatches, 1)
+		q.s.opts.Metrics.Inc(MetricDroppedMatches)
+	case SlowSinkSpillToDisk:
+		if err := q.spillEntry(e); err != nil {
+			q.recordError(err)
+			return
+		}
+		atomic.AddInt64(&q.s.spilledMatches, 1)
+		q.s.opts.Metrics.Inc(MetricSpilledMatches)
+	default:
+		q.entries <- e
+	}
+}
+
+// Appends |e| to the spill file, creating it if necessary.
+func (q *sinkQueue) spillEntry(e *MatchedEntry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.spill == nil {
+		f, err := ioutil.TempFile(q.s.opts.SpillDir, "ct-scanner-spill-")
+		if err != nil {
+			return err
+		}
+		q.spill = f
+		q.spillW = bufio.NewWriter(f)
+	}
+	return writeSpilledEntry(q.spillW, e)
+}
+
+// Waits for the queued entries to be passed to the Sink, followed by any
+// spilled entries, and removes the spill file.
+// Returns the first error returned by the Sink or encountered while
+// spilling, if any.
+func (q *sinkQueue) finish() error {
+	if q.entries != nil {
+		close(q.entries)
+		q.workers.Wait()
+	}
+	if q.spill != nil {
+		defer os.Remove(q.spill.Name())
+		defer q.spill.Close()
+		if err := q.replaySpill(); err != nil {
+			q.recordError(err)
+		}
+	}
+	return q.err
+}
+
+// Passes each of the entries in the spill file to the Sink.
+func (q *sinkQueue) replaySpill() error {
+	if err := q.spillW.Flush(); err != nil {
+		return err
+	}
+	if _, err := q.spill.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(q.spill)
+	for {
+		e, err := readSpilledEntry(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		q.putSafely(e)
+	}
+}
+
+// Serializes |e| to |w| as its index, entry type, (for Precertificates) issuer
+// key hash, the length-prefixed DER of the certificate or TBSCertificate, and
+// the number of labels followed by each length-prefixed label.
+// This format is used both for spill files and by ArchiveSink.
+func writeSpilledEntry(w io.Writer, e *MatchedEntry) error {
+	entryType := client.X509LogEntryType
+	var der []byte
+	if e.Precert != nil {
+		entryType = client.PrecertLogEntryType
+		der = e.Precert.Raw
+	} else {
+		der = e.Cert.Raw
+	}
+	if err := binary.Write(w, binary.BigEndian, e.Index); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, entryType); err != nil {
+		return err
+	}
+	if e.Precert != nil {
+		if _, err := w.Write(e.Precert.IssuerKeyHash[:]); err != nil {
+			return err
+		}
+	}
+	if err := writeLengthPrefixed(w, der); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(len(e.Labels))); err != nil {
+		return err
+	}
+	for _, label := range e.Labels {
+		if err := writeLengthPrefixed(w, []byte(label)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes |b| to |w|, preceded by its length.
+func writeLengthPrefixed(w io.Writer, b []byte) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// Reads a byte string written by writeLengthPrefixed from |r|.
+func readLengthPrefixed(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Reads an entry written by writeSpilledEntry from |r|, re-parsing the
+// certificate. Returns io.EOF if |r| has no more entries.
+func readSpilledEntry(r io.Reader) (*MatchedEntry, error) {
+	var e MatchedEntry
+	if err := binary.Read(r, binary.BigEndian, &e.Index); err != nil {
+		return nil, err
+	}
+	var entryType client.LogEntryType
+	if err := binary.Read(r, binary.BigEndian, &entryType); err != nil {
+		return nil, err
+	}
+	var issuerKeyHash [32]byte
+	if entryType == client.PrecertLogEntryType {
+		if _, err := io.ReadFull(r, issuerKeyHash[:]); err != nil {
+			return nil, err
+		}
+	}
+	der, err := readLengthPrefixed(r)
+	if err != nil {
+		return nil, err
+	}
+	var numLabels uint32
+	if err := binary.Read(r, binary.BigEndian, &numLabels); err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < numLabels; i++ {
+		label, err := readLengthPrefixed(r)
+		if err != nil {
+			return nil, err
+		}
+		e.Labels = append(e.Labels, string(label))
+	}
+	switch entryType {
+	case client.X509LogEntryType:
+		c, err := parseAllowingTrailingData(der, x509.ParseCertificate)
+		if _, ok := err.(x509.NonFatalErrors); err != nil && !ok {
+			return nil, err
+		}
+		e.Cert = c
+	case client.PrecertLogEntryType:
+		c, err := parseAllowingTrailingData(der, x509.ParseTBSCertificate)
+		if _, ok := err.(x509.NonFatalErrors); err != nil && !ok {
+			return nil, err
+		}
+		e.Precert = &client.Precertificate{Raw: der, TBSCertificate: *c, IssuerKeyHash: issuerKeyHash}
+	default:
+		return nil, fmt.Errorf("unknown spilled entry type %d", entryType)
+	}
+	return &e, nil
+}
+
+// Performs a scan against the Log, passing each matched entry to |sink|, and
+// closing |sink| once the scan has finished.
+// Returns the error from the scan itself if there was one, otherwise the first
+// error returned by |sink|, if any.
+//
+// This method blocks until the scan is complete.
+func (s *Scanner) ScanSink(sink Sink) error {
+	return s.ScanSinkCtx(context.Background(), sink)
+}
+
+// Performs a scan against the Log, as ScanSink, but stops early if |ctx| is
+// done (see ScanCtx).
+//
+// How |sink| is called when it can't keep up with the scan is controlled by
+// ScannerOptions.SlowSinkPolicy.
+//
+// This method blocks until the scan is complete or has been stopped.
+func (s *Scanner) ScanSinkCtx(ctx context.Context, sink Sink) error {
+	q := s.newSinkQueue(sink)
+	err := s.ScanLabelledCtx(ctx, func(index int64, c *x509.Certificate, labels []string) {
+		q.put(&MatchedEntry{Index: index, Cert: c, Labels: labels})
+	}, func(index int64, p *client.Precertificate, labels []string) {
+		q.put(&MatchedEntry{Index: index, Precert: p, Labels: labels})
+	})
+	sinkErr := q.finish()
+	s.panicMu.Lock()
+	if p := s.panicErr; p != nil && p.Index >= 0 && (err == nil || p.ResumeIndex > p.Index) {
+		// The Sink panicked on a queued entry, possibly after the scan had
+		// finished or counted the entry as processed; resume from it.
+		p.ResumeIndex = p.Index
+		err = p
+	}
+	s.panicMu.Unlock()
+	if dropped := atomic.LoadInt64(&s.droppedMatches); dropped > 0 {
+		s.Log(fmt.Sprintf("Dropped %d matches because the sink couldn't keep up", dropped))
+	}
+	closeErr := sink.Close()
+	switch {
+	case err != nil:
+		return err
+	case sinkErr != nil:
+		return sinkErr
+	}
+	return closeErr
+}
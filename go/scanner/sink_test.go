@@ -0,0 +1,615 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+)
+
+// recordingSink is a Sink which remembers the indices of the entries it's
+// given, and optionally fails.
+type recordingSink struct {
+	mu       sync.Mutex
+	indices  []int64
+	err      error
+	closed   bool
+	closeErr error
+}
+
+func (r *recordingSink) Close() error {
+	r.closed = true
+	return r.closeErr
+}
+
+func (r *recordingSink) Put(e *MatchedEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.indices = append(r.indices, e.Index)
+	return r.err
+}
+
+func (r *recordingSink) sortedIndices() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sort.Sort(int64Slice(r.indices))
+	return r.indices
+}
+
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func TestTeeSinkSendsEveryEntryToAllSinks(t *testing.T) {
+	ts := newFourEntryLogServer(t)
+	defer ts.Close()
+
+	var a, b recordingSink
+	scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    2,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	if err := scanner.ScanSink(TeeSink(&a, &b)); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*recordingSink{&a, &b} {
+		got := r.sortedIndices()
+		if len(got) != 4 {
+			t.Fatalf("Expected 4 entries, got %v", got)
+		}
+		for i, index := range got {
+			if index != int64(i) {
+				t.Fatalf("Expected entries 0-3, got %v", got)
+			}
+		}
+	}
+}
+
+func TestTeeSinkStopsOnError(t *testing.T) {
+	var a, b recordingSink
+	a.err = errors.New("disk full")
+	tee := TeeSink(&a, &b)
+	if err := tee.Put(&MatchedEntry{Index: 7}); err == nil {
+		t.Fatal("Expected error from failing sink")
+	}
+	if len(b.indices) != 0 {
+		t.Fatal("Tee called second sink after first failed")
+	}
+}
+
+func TestTeeSinkContinueOnError(t *testing.T) {
+	var a, b recordingSink
+	a.err = errors.New("disk full")
+	tee := TeeSink(&a, &b)
+	tee.ContinueOnError = true
+	if err := tee.Put(&MatchedEntry{Index: 7}); err == nil {
+		t.Fatal("Expected error from failing sink")
+	}
+	if len(b.indices) != 1 || b.indices[0] != 7 {
+		t.Fatalf("Expected second sink to receive entry 7, got %v", b.indices)
+	}
+}
+
+func TestScanSinkReportsSinkError(t *testing.T) {
+	ts := newFourEntryLogServer(t)
+	defer ts.Close()
+
+	sink := recordingSink{err: errors.New("disk full")}
+	scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	if err := scanner.ScanSink(&sink); err == nil {
+		t.Fatal("Expected ScanSink to return sink error")
+	}
+}
+
+// flushOnCloseSink is a Sink which writes the index of each entry to a buffered
+// writer, only flushing it on Close.
+type flushOnCloseSink struct {
+	mu  sync.Mutex
+	out bytes.Buffer
+	w   *bufio.Writer
+}
+
+func newFlushOnCloseSink() *flushOnCloseSink {
+	b := &flushOnCloseSink{}
+	b.w = bufio.NewWriter(&b.out)
+	return b
+}
+
+func (b *flushOnCloseSink) Put(e *MatchedEntry) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err := fmt.Fprintf(b.w, "%d\n", e.Index)
+	return err
+}
+
+func (b *flushOnCloseSink) Close() error {
+	return b.w.Flush()
+}
+
+func TestScanSinkClosesSink(t *testing.T) {
+	ts := newFourEntryLogServer(t)
+	defer ts.Close()
+
+	sink := newFlushOnCloseSink()
+	sink.Put(&MatchedEntry{Index: 99})
+	if sink.out.Len() != 0 {
+		t.Fatal("flushOnCloseSink wrote output before Close")
+	}
+	scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	if err := scanner.ScanSink(sink); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.out.String(); got != "99\n0\n1\n2\n3\n" {
+		t.Fatalf("Unexpected sink output %q", got)
+	}
+}
+
+func TestScanSinkReportsCloseError(t *testing.T) {
+	ts := newFourEntryLogServer(t)
+	defer ts.Close()
+
+	sink := recordingSink{closeErr: errors.New("close failed")}
+	scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	if err := scanner.ScanSink(&sink); err != sink.closeErr {
+		t.Fatalf("Expected close error, got %v", err)
+	}
+	if !sink.closed {
+		t.Fatal("ScanSink didn't close sink")
+	}
+}
+
+func TestTeeSinkClosesAllSinks(t *testing.T) {
+	var a, b recordingSink
+	a.closeErr = errors.New("close failed")
+	if err := TeeSink(&a, &b).Close(); err == nil {
+		t.Fatal("Expected error from failing sink")
+	}
+	if !a.closed || !b.closed {
+		t.Fatal("Tee didn't close all sinks")
+	}
+}
+
+// panickingSink is a Sink which panics when given the entry at |index|.
+type panickingSink struct {
+	recordingSink
+	index int64
+}
+
+func (p *panickingSink) Put(e *MatchedEntry) error {
+	if e.Index == p.index {
+		panic("sink exploded")
+	}
+	return p.recordingSink.Put(e)
+}
+
+func TestScanSinkRecoversFromSinkPanic(t *testing.T) {
+	ts := newFourEntryLogServer(t)
+	defer ts.Close()
+
+	sink := panickingSink{index: 2}
+	scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    2,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	err := scanner.ScanSink(&sink)
+	panicErr, ok := err.(*PanicError)
+	if !ok {
+		t.Fatalf("Expected *PanicError, got %v", err)
+	}
+	if panicErr.Index != 2 {
+		t.Fatalf("Expected panic at index 2, got %d", panicErr.Index)
+	}
+	if panicErr.ResumeIndex > 2 {
+		t.Fatalf("Expected resume index <= 2, got %d", panicErr.ResumeIndex)
+	}
+	if panicErr.Value != "sink exploded" {
+		t.Fatalf("Unexpected panic value %v", panicErr.Value)
+	}
+	if !sink.closed {
+		t.Fatal("Sink wasn't closed after panic")
+	}
+}
+
+func TestScanSinkRecoversFromQueuedSinkPanic(t *testing.T) {
+	for _, policy := range []SlowSinkPolicy{SlowSinkDropAndCount, SlowSinkSpillToDisk} {
+		ts := newFourEntryLogServer(t)
+		dir, err := ioutil.TempDir("", "spill")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sink := panickingSink{index: 2}
+		scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+			Matcher:        &MatchAll{},
+			BlockSize:      10,
+			NumWorkers:     2,
+			ParallelFetch:  1,
+			Quiet:          true,
+			SlowSinkPolicy: policy,
+			SinkQueueSize:  1,
+			SinkWorkers:    2,
+			SpillDir:       dir,
+		})
+		err = scanner.ScanSink(&sink)
+		ts.Close()
+		os.RemoveAll(dir)
+		panicErr, ok := err.(*PanicError)
+		if !ok {
+			t.Fatalf("policy %d: Expected *PanicError, got %v", policy, err)
+		}
+		if panicErr.Index != 2 || panicErr.ResumeIndex > 2 {
+			t.Fatalf("policy %d: Expected panic at index 2 resuming no later than it, got %+v", policy, panicErr)
+		}
+		if !sink.closed {
+			t.Fatalf("policy %d: Sink wasn't closed after panic", policy)
+		}
+	}
+}
+
+// gatedSink is a recordingSink whose Put blocks until |release| is closed.
+type gatedSink struct {
+	recordingSink
+	release chan struct{}
+}
+
+func (g *gatedSink) Put(e *MatchedEntry) error {
+	<-g.release
+	return g.recordingSink.Put(e)
+}
+
+// Closes |release| once |ready| returns true, or after a timeout.
+func releaseWhen(t *testing.T, release chan struct{}, ready func() bool) {
+	go func() {
+		defer close(release)
+		deadline := time.Now().Add(10 * time.Second)
+		for !ready() {
+			if time.Now().After(deadline) {
+				t.Error("Timed out waiting to release sink")
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+}
+
+func TestScanSinkSlowSinkBlock(t *testing.T) {
+	ts := newFourEntryLogServer(t)
+	defer ts.Close()
+
+	sink := gatedSink{release: make(chan struct{})}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(sink.release)
+	}()
+	scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	if err := scanner.ScanSink(&sink); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.sortedIndices(); len(got) != 4 {
+		t.Fatalf("Expected all 4 entries to reach the sink, got %v", got)
+	}
+	if dropped := scanner.Stats().DroppedMatches; dropped != 0 {
+		t.Fatalf("Expected no dropped matches, got %d", dropped)
+	}
+}
+
+func TestScanSinkSlowSinkDropAndCount(t *testing.T) {
+	ts := newFourEntryLogServer(t)
+	defer ts.Close()
+
+	metrics := newStubRegistrar()
+	sink := gatedSink{release: make(chan struct{})}
+	scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:        &MatchAll{},
+		BlockSize:      10,
+		NumWorkers:     1,
+		ParallelFetch:  1,
+		Quiet:          true,
+		Metrics:        metrics,
+		SlowSinkPolicy: SlowSinkDropAndCount,
+		SinkQueueSize:  1,
+	})
+	// At most one entry can be blocked in Put and one queued, so at least
+	// two must be dropped.
+	releaseWhen(t, sink.release, func() bool { return atomic.LoadInt64(&scanner.droppedMatches) >= 2 })
+	if err := scanner.ScanSink(&sink); err != nil {
+		t.Fatal(err)
+	}
+	dropped := scanner.Stats().DroppedMatches
+	delivered := len(sink.sortedIndices())
+	if dropped < 2 || int64(delivered)+dropped != 4 {
+		t.Fatalf("Expected 4 matches split between sink and drops, got %d delivered and %d dropped", delivered, dropped)
+	}
+	metrics.mu.Lock()
+	got := metrics.values[MetricDroppedMatches]
+	metrics.mu.Unlock()
+	if got != float64(dropped) {
+		t.Fatalf("Expected %s metric of %d, got %v", MetricDroppedMatches, dropped, got)
+	}
+}
+
+func TestScanSinkSlowSinkSpillToDisk(t *testing.T) {
+	ts := newFourEntryLogServer(t)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink := gatedSink{release: make(chan struct{})}
+	scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:        &MatchAll{},
+		BlockSize:      10,
+		NumWorkers:     1,
+		ParallelFetch:  1,
+		Quiet:          true,
+		SlowSinkPolicy: SlowSinkSpillToDisk,
+		SinkQueueSize:  1,
+		SpillDir:       dir,
+	})
+	releaseWhen(t, sink.release, func() bool { return atomic.LoadInt64(&scanner.spilledMatches) >= 2 })
+	if err := scanner.ScanSink(&sink); err != nil {
+		t.Fatal(err)
+	}
+	got := sink.sortedIndices()
+	if len(got) != 4 {
+		t.Fatalf("Expected all 4 entries to reach the sink, got %v", got)
+	}
+	for i, index := range got {
+		if index != int64(i) {
+			t.Fatalf("Expected entries 0-3, got %v", got)
+		}
+	}
+	if spilled := scanner.Stats().SpilledMatches; spilled < 2 {
+		t.Fatalf("Expected at least 2 spilled matches, got %d", spilled)
+	}
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 0 {
+		t.Fatalf("Expected spill file to be removed, got %v (%v)", files, err)
+	}
+}
+
+func TestBufferedSinkFlushesOnThreshold(t *testing.T) {
+	var inner recordingSink
+	b := NewBufferedSink(&inner, 3, 0)
+	for i := int64(0); i < 5; i++ {
+		if err := b.Put(&MatchedEntry{Index: i}); err != nil {
+			t.Fatal(err)
+		}
+		want := 0
+		if i >= 2 {
+			want = 3
+		}
+		if got := len(inner.sortedIndices()); got != want {
+			t.Fatalf("After %d entries, expected %d flushed, got %d", i+1, want, got)
+		}
+	}
+	if inner.closed {
+		t.Fatal("BufferedSink closed inner sink early")
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := inner.sortedIndices(); len(got) != 5 {
+		t.Fatalf("Expected all 5 entries after Close, got %v", got)
+	}
+	if !inner.closed {
+		t.Fatal("BufferedSink didn't close inner sink")
+	}
+}
+
+func TestBufferedSinkFlushesOnInterval(t *testing.T) {
+	var inner recordingSink
+	b := NewBufferedSink(&inner, 100, 10*time.Millisecond)
+	defer b.Close()
+	if err := b.Put(&MatchedEntry{Index: 7}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for len(inner.sortedIndices()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for timed flush")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBufferedSinkReportsErrors(t *testing.T) {
+	inner := recordingSink{err: errors.New("disk full"), closeErr: errors.New("close failed")}
+	b := NewBufferedSink(&inner, 2, 0)
+	if err := b.Put(&MatchedEntry{Index: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(&MatchedEntry{Index: 2}); err != inner.err {
+		t.Fatalf("Expected flush error, got %v", err)
+	}
+	// The failed entries are still buffered, so Close's flush fails too, but
+	// the inner Sink is still closed.
+	if err := b.Close(); err != inner.err {
+		t.Fatalf("Expected flush error, got %v", err)
+	}
+	if !inner.closed {
+		t.Fatal("BufferedSink didn't close inner sink after failed flush")
+	}
+	inner = recordingSink{closeErr: errors.New("close failed")}
+	b = NewBufferedSink(&inner, 2, 0)
+	if err := b.Close(); err != inner.closeErr {
+		t.Fatalf("Expected close error, got %v", err)
+	}
+}
+
+// flakySink is a Sink which fails to accept the entry with index |failAt|
+// the first time it's given it, and counts how often it's closed.
+type flakySink struct {
+	failAt int64
+	failed bool
+	puts   []int64
+	closes int
+}
+
+func (f *flakySink) Put(e *MatchedEntry) error {
+	if e.Index == f.failAt && !f.failed {
+		f.failed = true
+		return errors.New("transient failure")
+	}
+	f.puts = append(f.puts, e.Index)
+	return nil
+}
+
+func (f *flakySink) Close() error {
+	f.closes++
+	return nil
+}
+
+func TestBufferedSinkRetriesEntriesAfterFailedPut(t *testing.T) {
+	inner := flakySink{failAt: 2}
+	b := NewBufferedSink(&inner, 5, 0)
+	for i := int64(0); i < 4; i++ {
+		if err := b.Put(&MatchedEntry{Index: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Put(&MatchedEntry{Index: 4}); err == nil {
+		t.Fatal("Expected error from failed Put")
+	}
+	if fmt.Sprint(inner.puts) != "[0 1]" {
+		t.Fatalf("Expected entries 0-1 to be flushed before the failure, got %v", inner.puts)
+	}
+	if err := b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(inner.puts) != "[0 1 2 3 4]" {
+		t.Fatalf("Expected the failed and unattempted entries to be retried, got %v", inner.puts)
+	}
+}
+
+func TestBufferedSinkCloseTwice(t *testing.T) {
+	inner := flakySink{failAt: -1}
+	b := NewBufferedSink(&inner, 5, time.Hour)
+	if err := b.Put(&MatchedEntry{Index: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if inner.closes != 1 || fmt.Sprint(inner.puts) != "[1]" {
+		t.Fatalf("Expected one flush and one close of the inner sink, got entries %v and %d closes", inner.puts, inner.closes)
+	}
+}
+
+func TestScanSinkWithBufferedSink(t *testing.T) {
+	var inner recordingSink
+	s := NewScanner(newMockLogSource(t), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    2,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	if err := s.ScanSink(NewBufferedSink(&inner, 3, time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got := inner.sortedIndices(); len(got) != 4 || !inner.closed {
+		t.Fatalf("Expected 4 entries and a closed sink, got %v (closed %v)", got, inner.closed)
+	}
+}
+
+// concurrencySink is a Sink which records the largest number of concurrent
+// calls to Put, each of which takes |delay|.
+type concurrencySink struct {
+	delay   time.Duration
+	active  int64
+	maxSeen int64
+	puts    int64
+}
+
+func (c *concurrencySink) Put(*MatchedEntry) error {
+	n := atomic.AddInt64(&c.active, 1)
+	for {
+		max := atomic.LoadInt64(&c.maxSeen)
+		if n <= max || atomic.CompareAndSwapInt64(&c.maxSeen, max, n) {
+			break
+		}
+	}
+	time.Sleep(c.delay)
+	atomic.AddInt64(&c.active, -1)
+	atomic.AddInt64(&c.puts, 1)
+	return nil
+}
+
+func (c *concurrencySink) Close() error {
+	return nil
+}
+
+func TestScanSinkWorkers(t *testing.T) {
+	for _, test := range []struct {
+		numWorkers, sinkWorkers int
+	}{
+		{1, 3},
+		{4, 1},
+	} {
+		source := newRepeatedMockLogSource(t, 25)
+		sink := &concurrencySink{delay: 2 * time.Millisecond}
+		s := NewScanner(source, ScannerOptions{
+			Matcher:       &MatchAll{},
+			BlockSize:     10,
+			NumWorkers:    test.numWorkers,
+			ParallelFetch: 1,
+			SinkWorkers:   test.sinkWorkers,
+			Quiet:         true,
+		})
+		if err := s.ScanSink(sink); err != nil {
+			t.Fatal(err)
+		}
+		if sink.puts != int64(len(source.leaves)) {
+			t.Errorf("%d sink workers: expected %d entries, got %d", test.sinkWorkers, len(source.leaves), sink.puts)
+		}
+		if sink.maxSeen != int64(test.sinkWorkers) {
+			t.Errorf("%d matchers, %d sink workers: expected %d concurrent Puts, saw %d", test.numWorkers, test.sinkWorkers, test.sinkWorkers, sink.maxSeen)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package scanner
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/google/certificate-transparency/go/client"
+)
+
+// ScanState is a snapshot of the progress of a scan, suitable for persisting
+// (e.g. as JSON) so that an interrupted scan can later be resumed.
+type ScanState struct {
+	// Every entry with an index lower than this has been processed, so a
+	// resumed scan should start here.
+	HighWaterIndex int64 `json:"high_water_index"`
+	// The STH the scan is working towards.
+	STH *client.SignedTreeHead `json:"sth"`
+	// Statistics gathered so far.
+	Stats ScanStats `json:"stats"`
+}
+
+// progressTracker tracks which entries have been processed in order to
+// determine the contiguous high-water mark of the scan.
+type progressTracker struct {
+	mu sync.Mutex
+	// Index of the next entry not yet processed: the lowest, or for a reverse
+	// scan, the highest.
+	next int64
+	// 1, or -1 for a reverse scan of the entries in [|start|, |end|).
+	step       int64
+	start, end int64
+	// Runs of processed entries beyond |next|, sorted by index and disjoint.
+	// Entries are processed roughly in order, so there are few runs even if
+	// an abandoned range stops |next| from advancing for the rest of the
+	// scan.
+	done []indexRun
+	// Number of entries processed since the last state snapshot.
+	sinceSnapshot int64
+}
+
+// A run of consecutive entries, [first, last].
+type indexRun struct {
+	first, last int64
+}
+
+func newProgressTracker(start int64) *progressTracker {
+	return &progressTracker{next: start, step: 1, start: start}
+}
+
+// Creates a progressTracker for a scan of the entries in [|start|, |end|)
+// from the newest entry downwards.
+func newReverseProgressTracker(start, end int64) *progressTracker {
+	return &progressTracker{next: end - 1, step: -1, start: start, end: end}
+}
+
+// Adds |index| to the runs of processed entries, merging it with any runs it
+// adjoins.
+func (p *progressTracker) addDone(index int64) {
+	// The first run which |index| could fall in or extend.
+	i := sort.Search(len(p.done), func(i int) bool { return p.done[i].last >= index-1 })
+	if i == len(p.done) || index < p.done[i].first-1 {
+		p.done = append(p.done, indexRun{})
+		copy(p.done[i+1:], p.done[i:])
+		p.done[i] = indexRun{index, index}
+		return
+	}
+	run := &p.done[i]
+	if index < run.first {
+		run.first = index
+	}
+	if index > run.last {
+		run.last = index
+		if i+1 < len(p.done) && p.done[i+1].first == index+1 {
+			run.last = p.done[i+1].last
+			p.done = append(p.done[:i+1], p.done[i+2:]...)
+		}
+	}
+}
+
+// Records that the entry at |index| has been processed.
+// Returns true if at least |snapshotEvery| entries have been processed since
+// this last returned true; |snapshotEvery| <= 0 means never.
+func (p *progressTracker) entryDone(index int64, snapshotEvery int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.addDone(index)
+	// Runs are merged, so at most one can start at |next|.
+	if n := len(p.done); n > 0 {
+		if p.step > 0 && p.done[0].first == p.next {
+			p.next = p.done[0].last + 1
+			p.done = append(p.done[:0], p.done[1:]...)
+		} else if p.step < 0 && p.done[n-1].last == p.next {
+			p.next = p.done[n-1].first - 1
+			p.done = p.done[:n-1]
+		}
+	}
+	p.sinceSnapshot++
+	if snapshotEvery > 0 && p.sinceSnapshot >= snapshotEvery {
+		p.sinceSnapshot = 0
+		return true
+	}
+	return false
+}
+
+// Returns the index of the lowest entry not yet processed. For a reverse
+// scan, that's its start until every entry has been processed.
+func (p *progressTracker) highWater() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.step < 0 {
+		if p.next < p.start {
+			return p.end
+		}
+		return p.start
+	}
+	return p.next
+}
+
+// Returns the index of the next entry not yet processed in the direction of
+// the scan: every entry before it (or for a reverse scan, after it) has been
+// processed.
+func (p *progressTracker) frontier() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.next
+}
+
+// Passes a snapshot of the current scan state to the StateCallback, and saves
+// it to the Checkpoint, if either is set. Calls are serialized, so both see a
+// non-decreasing HighWaterIndex. If the snapshot can't be saved, the scan is
+// stopped.
+func (s *Scanner) emitState() {
+	if s.opts.StateCallback == nil && s.opts.Checkpoint == nil {
+		return
+	}
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	stats := s.Stats()
+	state := ScanState{
+		HighWaterIndex: s.progress.highWater(),
+		STH:            stats.STH,
+		Stats:          stats,
+	}
+	if s.opts.StateCallback != nil {
+		s.opts.StateCallback(state)
+	}
+	if s.opts.Checkpoint == nil || s.checkpointErr != nil {
+		return
+	}
+	if err := s.opts.Checkpoint.Save(state); err != nil {
+		s.Log(fmt.Sprintf("Failed to save checkpoint: %s", err.Error()))
+		s.checkpointErr = err
+		// There's no point carrying on if progress can't be recorded.
+		s.cancelScan()
+	}
+}
+
+// Returns the number of processed entries between state snapshots.
+func (s *Scanner) stateEntryInterval() int64 {
+	if s.opts.Checkpoint != nil && s.opts.StateEntryInterval <= 0 && s.opts.StateInterval <= 0 {
+		return int64(s.opts.BlockSize)
+	}
+	return s.opts.StateEntryInterval
+}
+
+// Resumes from the ScanState saved in the Checkpoint, if there is one and
+// it hasn't already been loaded by an earlier scan, by moving StartIndex to
+// its HighWaterIndex. Also clears any error saving to the Checkpoint.
+func (s *Scanner) loadCheckpoint() error {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.checkpointErr = nil
+	if s.opts.Checkpoint == nil || s.checkpointLoaded {
+		return nil
+	}
+	state, err := s.opts.Checkpoint.Load()
+	if err != nil {
+		return err
+	}
+	s.checkpointLoaded = true
+	if state != nil {
+		s.opts.StartIndex = state.HighWaterIndex
+		s.Log(fmt.Sprintf("Resuming from checkpoint at index %d", s.opts.StartIndex))
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+func TestProgressTrackerHighWater(t *testing.T) {
+	p := newProgressTracker(10)
+	p.entryDone(12, 0)
+	p.entryDone(11, 0)
+	if hw := p.highWater(); hw != 10 {
+		t.Fatalf("Expected high-water 10, got %d", hw)
+	}
+	p.entryDone(10, 0)
+	if hw := p.highWater(); hw != 13 {
+		t.Fatalf("Expected high-water 13, got %d", hw)
+	}
+}
+
+func TestProgressTrackerMergesRuns(t *testing.T) {
+	p := newProgressTracker(0)
+	for _, index := range []int64{5, 3, 9, 4, 7, 8, 6, 1} {
+		p.entryDone(index, 0)
+	}
+	if fmt.Sprint(p.done) != "[{1 1} {3 9}]" {
+		t.Fatalf("Expected runs [1, 1] and [3, 9], got %v", p.done)
+	}
+	p.entryDone(0, 0)
+	p.entryDone(2, 0)
+	if hw := p.highWater(); hw != 10 || len(p.done) != 0 {
+		t.Fatalf("Expected high-water 10 and no runs, got %d and %v", hw, p.done)
+	}
+}
+
+func TestReverseProgressTracker(t *testing.T) {
+	p := newReverseProgressTracker(10, 15)
+	p.entryDone(13, 0)
+	if f, hw := p.frontier(), p.highWater(); f != 14 || hw != 10 {
+		t.Fatalf("Expected frontier 14 and high-water 10, got %d and %d", f, hw)
+	}
+	p.entryDone(14, 0)
+	p.entryDone(11, 0)
+	if f, hw := p.frontier(), p.highWater(); f != 12 || hw != 10 {
+		t.Fatalf("Expected frontier 12 and high-water 10, got %d and %d", f, hw)
+	}
+	p.entryDone(12, 0)
+	p.entryDone(10, 0)
+	if hw := p.highWater(); hw != 15 {
+		t.Fatalf("Expected high-water 15 once all entries are processed, got %d", hw)
+	}
+}
+
+func TestProgressMessageReportsHighWater(t *testing.T) {
+	s := NewScanner(nil, ScannerOptions{StartIndex: 10})
+	s.progress = newProgressTracker(10)
+	// The second block [20, 29] completes before the first [10, 19] does.
+	for i := int64(20); i < 30; i++ {
+		s.progress.entryDone(i, 0)
+		s.certsProcessed++
+	}
+	for i := int64(10); i < 15; i++ {
+		s.progress.entryDone(i, 0)
+		s.certsProcessed++
+	}
+	now := time.Now()
+	msg := s.progressMessage(newThroughputMeter(now.Add(-time.Second), -1), now, 100)
+	if !strings.Contains(msg, "Processed: 15 certs (to index 15)") {
+		t.Fatalf("Expected progress to index 15, got %q", msg)
+	}
+}
+
+func TestProgressMessageWarmUp(t *testing.T) {
+	s := NewScanner(nil, ScannerOptions{})
+	s.progress = newProgressTracker(0)
+	start := time.Now()
+	meter := newThroughputMeter(start, 5*time.Second)
+	// Startup latency makes the early throughput unrepresentative.
+	s.certsProcessed = 1
+	for _, elapsed := range []time.Duration{time.Second, 2 * time.Second, 5 * time.Second} {
+		if msg := s.progressMessage(meter, start.Add(elapsed), 1001); !strings.Contains(msg, "ETA: calculating") {
+			t.Fatalf("Expected ETA to be calculating after %s, got %q", elapsed, msg)
+		}
+	}
+	s.certsProcessed = 101
+	msg := s.progressMessage(meter, start.Add(6*time.Second), 1001)
+	if !strings.Contains(msg, "Throughput: 100.00 ETA: 9 seconds") {
+		t.Fatalf("Expected throughput measured after the warm-up, got %q", msg)
+	}
+}
+
+// slowLogSource is a mockLogSource which takes |delay| to return entries.
+type slowLogSource struct {
+	*mockLogSource
+	delay time.Duration
+}
+
+func (s *slowLogSource) GetEntries(start, end int64) ([]client.LeafInput, error) {
+	return s.GetEntriesCtx(context.Background(), start, end)
+}
+
+func (s *slowLogSource) GetEntriesCtx(ctx context.Context, start, end int64) ([]client.LeafInput, error) {
+	time.Sleep(s.delay)
+	return s.mockLogSource.GetEntriesCtx(ctx, start, end)
+}
+
+func TestScannerProgressCallback(t *testing.T) {
+	source := &slowLogSource{mockLogSource: newMockLogSource(t), delay: 600 * time.Millisecond}
+	var mu sync.Mutex
+	var progress []ScanProgress
+	s := NewScanner(source, ScannerOptions{
+		Matcher:          &MatchAll{},
+		BlockSize:        1,
+		NumWorkers:       1,
+		ParallelFetch:    1,
+		ThroughputWarmUp: -1,
+		ProgressCallback: func(p ScanProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			progress = append(progress, p)
+		},
+	})
+	if err := s.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	estimated := false
+	for _, p := range progress {
+		if p.TreeSize != 4 || p.CurrentIndex > p.CertsProcessed {
+			t.Fatalf("Unexpected progress %+v", p)
+		}
+		if p.Throughput > 0 && p.ETA > 0 {
+			estimated = true
+		}
+	}
+	if !estimated {
+		t.Fatalf("Expected a throughput and ETA to be reported, got %+v", progress)
+	}
+}
+
+func TestProgressMessageBoundedByEndIndex(t *testing.T) {
+	s := NewScanner(nil, ScannerOptions{StartIndex: 1000})
+	s.progress = newProgressTracker(1000)
+	s.certsProcessed = 50
+	now := time.Now()
+	// The scan of [1000, 1200) is a quarter done, and will take as long again
+	// as it has so far.
+	msg := s.progressMessage(newThroughputMeter(now.Add(-10*time.Second), -1), now, 1200)
+	if !strings.Contains(msg, "of 200 (25.0%)") || !strings.Contains(msg, "ETA: 30 seconds") {
+		t.Fatalf("Expected progress through a bounded range, got %q", msg)
+	}
+}
+
+func TestScannerStateCallbackHighWaterNonDecreasing(t *testing.T) {
+	ts := newFourEntryLogServer(t)
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var states []ScanState
+	scanner := NewScanner(client.New(ts.URL), ScannerOptions{
+		Matcher:       &MatchAll{},
+		BlockSize:     10,
+		NumWorkers:    2,
+		ParallelFetch: 1,
+		Quiet:         true,
+		StateCallback: func(state ScanState) {
+			mu.Lock()
+			defer mu.Unlock()
+			states = append(states, state)
+		},
+		StateEntryInterval: 1,
+		StateInterval:      time.Millisecond,
+	})
+	if err := scanner.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) < 5 {
+		t.Fatalf("Expected at least 5 state callbacks, got %d", len(states))
+	}
+	for i := 1; i < len(states); i++ {
+		if states[i].HighWaterIndex < states[i-1].HighWaterIndex {
+			t.Fatalf("High-water mark went backwards: %d then %d", states[i-1].HighWaterIndex, states[i].HighWaterIndex)
+		}
+	}
+	final := states[len(states)-1]
+	if final.HighWaterIndex != 4 {
+		t.Fatalf("Expected final high-water mark 4, got %d", final.HighWaterIndex)
+	}
+	if final.STH == nil || final.STH.TreeSize != 4 {
+		t.Fatalf("Expected final state to hold STH with tree size 4, got %+v", final.STH)
+	}
+	if final.Stats.CertsProcessed != 4 {
+		t.Fatalf("Expected final state to have processed 4 certs, got %d", final.Stats.CertsProcessed)
+	}
+	if _, err := json.Marshal(final); err != nil {
+		t.Fatalf("Failed to marshal ScanState: %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package scanner
+
+import (
+	"strings"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// TLDCategory represents the category a top-level domain belongs to.
+type TLDCategory int
+
+const (
+	// The TLD couldn't be determined (e.g. empty name, or invalid characters).
+	TLDUnknown TLDCategory = iota
+	// Two letter country-code TLDs, as assigned from ISO 3166-1 alpha-2
+	// (e.g. "uk", "de", "jp").
+	TLDCountryCode
+	// The generic and sponsored TLDs which predate the 2012 new gTLD programme
+	// (e.g. "com", "org", "edu"), plus the "arpa" infrastructure TLD.
+	TLDGeneric
+	// Any other syntactically valid TLD; in practice these were delegated via
+	// ICANN's new gTLD programme (e.g. "app", "dev", "xn--p1acf").
+	TLDNewGeneric
+	// Special-use names reserved by RFC 2606, RFC 6761, RFC 6762 and RFC 7686
+	// (e.g. "test", "localhost", "onion"), which should never appear in
+	// publicly trusted certificates.
+	TLDSpecialUse
+)
+
+func (c TLDCategory) String() string {
+	switch c {
+	case TLDUnknown:
+		return "Unknown"
+	case TLDCountryCode:
+		return "CountryCode"
+	case TLDGeneric:
+		return "Generic"
+	case TLDNewGeneric:
+		return "NewGeneric"
+	case TLDSpecialUse:
+		return "SpecialUse"
+	}
+	return "Invalid"
+}
+
+// Generic TLDs delegated before the 2012 new gTLD programme.
+// Source: IANA Root Zone Database, https://www.iana.org/domains/root/db
+var legacyGenericTLDs = map[string]bool{
+	"aero": true, "arpa": true, "asia": true, "biz": true, "cat": true,
+	"com": true, "coop": true, "edu": true, "gov": true, "info": true,
+	"int": true, "jobs": true, "mil": true, "mobi": true, "museum": true,
+	"name": true, "net": true, "org": true, "post": true, "pro": true,
+	"tel": true, "travel": true, "xxx": true,
+}
+
+// Special-use TLDs.
+// Source: IANA Special-Use Domain Names registry,
+// https://www.iana.org/assignments/special-use-domain-names
+var specialUseTLDs = map[string]bool{
+	"example": true, "invalid": true, "local": true, "localhost": true,
+	"onion": true, "test": true,
+}
+
+// Returns the category of the top-level domain of the DNS name |name|.
+// Matching is case-insensitive, and a trailing dot on |name| is ignored.
+func ClassifyTLD(name string) TLDCategory {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	tld := name[strings.LastIndex(name, ".")+1:]
+	if tld == "" {
+		return TLDUnknown
+	}
+	for _, r := range tld {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return TLDUnknown
+		}
+	}
+	switch {
+	case specialUseTLDs[tld]:
+		return TLDSpecialUse
+	case legacyGenericTLDs[tld]:
+		return TLDGeneric
+	case len(tld) == 2 && tld[0] >= 'a' && tld[1] >= 'a':
+		return TLDCountryCode
+	case strings.Trim(tld, "0123456789") == "":
+		// All-numeric "TLD", most likely an IP address
+		return TLDUnknown
+	}
+	return TLDNewGeneric
+}
+
+// MatchTLDCategory is a Matcher which matches Certificates and Precertificates
+// with at least one DNS Subject Alternative Name whose TLD falls into one of
+// |Categories|.
+type MatchTLDCategory struct {
+	Categories []TLDCategory
+}
+
+func (m MatchTLDCategory) namesMatch(names []string) bool {
+	for _, name := range names {
+		c := ClassifyTLD(name)
+		for _, want := range m.Categories {
+			if c == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns true if any SAN of |c| has a TLD in one of |Categories|.
+func (m MatchTLDCategory) CertificateMatches(c *x509.Certificate) bool {
+	return m.namesMatch(c.DNSNames)
+}
+
+// Returns true if any SAN of |p| has a TLD in one of |Categories|.
+func (m MatchTLDCategory) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.namesMatch(p.TBSCertificate.DNSNames)
+}
+
+// PublicSuffixFunc returns the public suffix of |domain|, and whether it's an
+// ICANN-managed suffix. Its signature matches PublicSuffix from
+// golang.org/x/net/publicsuffix, which can be used here for full coverage of
+// the Public Suffix List.
+type PublicSuffixFunc func(domain string) (suffix string, icann bool)
+
+// Second-level labels commonly used as registry suffixes under ccTLDs, as in
+// "co.uk", "com.au" or "ac.jp".
+var ccSecondLevelLabels = map[string]bool{
+	"ac": true, "co": true, "com": true, "edu": true, "go": true,
+	"gob": true, "gov": true, "ltd": true, "mil": true, "ne": true,
+	"net": true, "nom": true, "or": true, "org": true, "plc": true,
+	"sch": true,
+}
+
+// A conservative approximation of the Public Suffix List: the TLD of
+// |domain|, or for a ccTLD with a common registry second-level label, the last
+// two labels.
+func defaultPublicSuffix(domain string) (string, bool) {
+	labels := strings.Split(domain, ".")
+	n := len(labels)
+	if n >= 2 && ClassifyTLD(domain) == TLDCountryCode && ccSecondLevelLabels[labels[n-2]] {
+		return labels[n-2] + "." + labels[n-1], true
+	}
+	return labels[n-1], true
+}
+
+// MatchCertBySANWildcardAndSpecificConflict is a Matcher which matches
+// Certificates and Precertificates with a wildcard DNS SAN directly under a
+// public suffix (e.g. "*.co.uk"), which covers every domain registered under
+// that suffix.
+// If |Redundant| is true, it also matches those which list both a wildcard
+// and a name the wildcard already covers (e.g. "*.example.com" and
+// "a.example.com").
+type MatchCertBySANWildcardAndSpecificConflict struct {
+	// Used to find public suffixes; if nil, a built-in approximation which
+	// only knows about TLDs and common ccTLD second-level registries is used.
+	PublicSuffix PublicSuffixFunc
+	Redundant    bool
+}
+
+func (m MatchCertBySANWildcardAndSpecificConflict) namesMatch(names []string) bool {
+	publicSuffix := m.PublicSuffix
+	if publicSuffix == nil {
+		publicSuffix = defaultPublicSuffix
+	}
+	var bases []string
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if !strings.HasPrefix(name, "*.") {
+			continue
+		}
+		base := name[2:]
+		if suffix, _ := publicSuffix(base); suffix == base {
+			return true
+		}
+		bases = append(bases, base)
+	}
+	if !m.Redundant {
+		return false
+	}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		for _, base := range bases {
+			if label := strings.TrimSuffix(name, "."+base); label != name && label != "*" && !strings.Contains(label, ".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns true if a wildcard SAN of |c| is directly under a public suffix, or
+// if |Redundant| is set and a wildcard SAN covers another SAN.
+func (m MatchCertBySANWildcardAndSpecificConflict) CertificateMatches(c *x509.Certificate) bool {
+	return m.namesMatch(c.DNSNames)
+}
+
+// Returns true if a wildcard SAN of the TBSCertificate in |p| is directly
+// under a public suffix, or if |Redundant| is set and a wildcard SAN covers
+// another SAN.
+func (m MatchCertBySANWildcardAndSpecificConflict) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.namesMatch(p.TBSCertificate.DNSNames)
+}
+
+// Returns the ccTLD corresponding to the ISO 3166-1 alpha-2 country code
+// |country|, which is the lowercased code except for the United Kingdom.
+func countryCCTLD(country string) string {
+	country = strings.ToLower(country)
+	if country == "gb" {
+		return "uk"
+	}
+	return country
+}
+
+// MatchCertByCountryMismatch is a Matcher which matches Certificates and
+// Precertificates whose subject country (C) differs from the ccTLD of their
+// DNS Subject Alternative Names, e.g. C=US with names only under ".cn".
+//
+// By default an entry only matches if every DNS SAN is under a ccTLD and none
+// of those ccTLDs is the subject country's; if |AnySAN| is true, a single SAN
+// under another country's ccTLD is enough. Entries without a subject country
+// or without ccTLD SANs never match.
+//
+// This is a heuristic for spotting possible fraud, and has many false
+// positives: multinationals legitimately hold names under many ccTLDs, and
+// some ccTLDs (e.g. ".io", ".co", ".tv") are mostly used generically.
+type MatchCertByCountryMismatch struct {
+	AnySAN bool
+}
+
+func (m MatchCertByCountryMismatch) certMatches(c *x509.Certificate) bool {
+	if len(c.Subject.Country) == 0 {
+		return false
+	}
+	countries := make(map[string]bool)
+	for _, country := range c.Subject.Country {
+		countries[countryCCTLD(country)] = true
+	}
+	var mismatched int
+	for _, name := range c.DNSNames {
+		if ClassifyTLD(name) != TLDCountryCode {
+			if !m.AnySAN {
+				return false
+			}
+			continue
+		}
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if countries[name[strings.LastIndex(name, ".")+1:]] {
+			if !m.AnySAN {
+				return false
+			}
+			continue
+		}
+		mismatched++
+	}
+	return mismatched > 0
+}
+
+// Returns true if the subject country of |c| disagrees with the ccTLDs of its
+// SANs.
+func (m MatchCertByCountryMismatch) CertificateMatches(c *x509.Certificate) bool {
+	return m.certMatches(c)
+}
+
+// Returns true if the subject country of the TBSCertificate in |p| disagrees
+// with the ccTLDs of its SANs.
+func (m MatchCertByCountryMismatch) PrecertificateMatches(p *client.Precertificate) bool {
+	return m.certMatches(&p.TBSCertificate)
+}
@@ -0,0 +1,153 @@
+package scanner
+
+import (
+	"testing"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+func TestClassifyTLD(t *testing.T) {
+	tests := []struct {
+		name     string
+		category TLDCategory
+	}{
+		{"www.example.com", TLDGeneric},
+		{"WWW.EXAMPLE.COM.", TLDGeneric},
+		{"www.example.co.uk", TLDCountryCode},
+		{"example.app", TLDNewGeneric},
+		{"xn--80ak6aa92e.xn--p1ai", TLDNewGeneric},
+		{"printer.local", TLDSpecialUse},
+		{"localhost", TLDSpecialUse},
+		{"10.0.0.1", TLDUnknown},
+		{"", TLDUnknown},
+		{"example.c_m", TLDUnknown},
+	}
+	for _, test := range tests {
+		if c := ClassifyTLD(test.name); c != test.category {
+			t.Errorf("ClassifyTLD(%q) = %s, expected %s", test.name, c, test.category)
+		}
+	}
+}
+
+func TestScannerMatchTLDCategory(t *testing.T) {
+	m := MatchTLDCategory{Categories: []TLDCategory{TLDCountryCode, TLDSpecialUse}}
+
+	var cert x509.Certificate
+	cert.DNSNames = []string{"www.example.com", "example.app"}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchTLDCategory incorrectly matched Cert with only gTLD names")
+	}
+	cert.DNSNames = append(cert.DNSNames, "www.example.co.uk")
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchTLDCategory failed to match Cert with ccTLD name")
+	}
+
+	var precert client.Precertificate
+	precert.TBSCertificate.DNSNames = []string{"www.example.com"}
+	if m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchTLDCategory incorrectly matched Precert with only gTLD names")
+	}
+	precert.TBSCertificate.DNSNames = append(precert.TBSCertificate.DNSNames, "intranet.test")
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchTLDCategory failed to match Precert with special-use name")
+	}
+}
+
+func TestScannerMatchCertBySANWildcardAndSpecificConflict(t *testing.T) {
+	m := MatchCertBySANWildcardAndSpecificConflict{}
+
+	var cert x509.Certificate
+	cert.DNSNames = []string{"*.example.com", "a.example.com"}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySANWildcardAndSpecificConflict incorrectly matched *.example.com")
+	}
+	cert.DNSNames = []string{"example.co.uk", "*.co.uk"}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySANWildcardAndSpecificConflict failed to match *.co.uk")
+	}
+	cert.DNSNames = []string{"*.COM."}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySANWildcardAndSpecificConflict failed to match *.COM.")
+	}
+
+	var precert client.Precertificate
+	precert.TBSCertificate.DNSNames = []string{"*.example.co.uk"}
+	if m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertBySANWildcardAndSpecificConflict incorrectly matched Precert for *.example.co.uk")
+	}
+	precert.TBSCertificate.DNSNames = []string{"*.com.au"}
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertBySANWildcardAndSpecificConflict failed to match Precert for *.com.au")
+	}
+
+	m.PublicSuffix = func(domain string) (string, bool) {
+		if domain == "example.com" {
+			return domain, false
+		}
+		return defaultPublicSuffix(domain)
+	}
+	cert.DNSNames = []string{"*.example.com"}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySANWildcardAndSpecificConflict ignored PublicSuffix")
+	}
+}
+
+func TestScannerMatchCertBySANWildcardAndSpecificConflictRedundant(t *testing.T) {
+	m := MatchCertBySANWildcardAndSpecificConflict{Redundant: true}
+	var cert x509.Certificate
+	cert.DNSNames = []string{"*.example.com", "example.com", "a.b.example.com"}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySANWildcardAndSpecificConflict incorrectly matched names not covered by the wildcard")
+	}
+	cert.DNSNames = append(cert.DNSNames, "A.example.com")
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySANWildcardAndSpecificConflict failed to match redundant a.example.com")
+	}
+	m.Redundant = false
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertBySANWildcardAndSpecificConflict matched redundant name without Redundant set")
+	}
+}
+
+func TestScannerMatchCertByCountryMismatch(t *testing.T) {
+	m := MatchCertByCountryMismatch{}
+
+	var cert x509.Certificate
+	cert.Subject.Country = []string{"US"}
+	cert.DNSNames = []string{"shop.example.cn", "www.example.cn"}
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByCountryMismatch failed to match C=US Cert with .cn SANs")
+	}
+	cert.DNSNames = append(cert.DNSNames, "www.example.com")
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByCountryMismatch incorrectly matched Cert with a gTLD SAN")
+	}
+	m.AnySAN = true
+	if !m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByCountryMismatch failed to match Cert with a .cn SAN with AnySAN set")
+	}
+	m.AnySAN = false
+
+	cert.Subject.Country = []string{"GB"}
+	cert.DNSNames = []string{"www.example.co.uk", "www.example.us"}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByCountryMismatch incorrectly matched C=GB Cert with a .uk SAN")
+	}
+	cert.Subject.Country = nil
+	cert.DNSNames = []string{"www.example.cn"}
+	if m.CertificateMatches(&cert) {
+		t.Fatal("MatchCertByCountryMismatch incorrectly matched Cert without a subject country")
+	}
+
+	var precert client.Precertificate
+	precert.TBSCertificate.Subject.Country = []string{"CN"}
+	precert.TBSCertificate.DNSNames = []string{"www.example.cn"}
+	if m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertByCountryMismatch incorrectly matched consistent Precert")
+	}
+	precert.TBSCertificate.Subject.Country = []string{"US"}
+	if !m.PrecertificateMatches(&precert) {
+		t.Fatal("MatchCertByCountryMismatch failed to match C=US Precert with a .cn SAN")
+	}
+}
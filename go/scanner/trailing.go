@@ -0,0 +1,72 @@
+package scanner
+
+import (
+	"fmt"
+
+	"github.com/google/certificate-transparency/go/asn1"
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// TrailingDataError is the non-fatal parse error reported for an entry whose
+// DER encoded certificate (or TBSCertificate) is followed by extra bytes.
+type TrailingDataError struct {
+	// Number of bytes following the DER encoded structure
+	Length int
+}
+
+func (e TrailingDataError) Error() string {
+	return fmt.Sprintf("%d bytes of trailing data after DER", e.Length)
+}
+
+// Returns the number of bytes in |der| following its first ASN.1 element, or
+// -1 if it doesn't start with a valid element.
+func trailingDataLen(der []byte) int {
+	var v asn1.RawValue
+	rest, err := asn1.Unmarshal(der, &v)
+	if err != nil {
+		return -1
+	}
+	return len(rest)
+}
+
+// Parses |der| with |parse|. If |der| can't be parsed only because of the
+// bytes following its DER encoded structure, the structure is parsed on its
+// own instead, and the trailing data reported as a TrailingDataError within
+// x509.NonFatalErrors, so that the entry can still be matched. The returned
+// Certificate's Raw then holds the whole of |der|, as logged.
+func parseAllowingTrailingData(der []byte, parse func([]byte) (*x509.Certificate, error)) (*x509.Certificate, error) {
+	c, err := parse(der)
+	if _, ok := err.(asn1.SyntaxError); !ok {
+		return c, err
+	}
+	n := trailingDataLen(der)
+	if n <= 0 {
+		return c, err
+	}
+	salvaged, salvageErr := parse(der[:len(der)-n])
+	nfe, ok := salvageErr.(x509.NonFatalErrors)
+	if salvageErr != nil && !ok {
+		return c, err
+	}
+	salvaged.Raw = der
+	nfe.AddError(TrailingDataError{Length: n})
+	return salvaged, nfe
+}
+
+// MatchCertByTrailingGarbageAfterDER is a Matcher which matches Certificates
+// and Precertificates whose DER encoding, as logged, is followed by extra
+// bytes. Such entries can't be parsed strictly, so the Scanner parses the
+// DER structure alone and reports the trailing data as a non-fatal error; see
+// TrailingDataError.
+type MatchCertByTrailingGarbageAfterDER struct{}
+
+// Returns true if |c| was logged with trailing data.
+func (m MatchCertByTrailingGarbageAfterDER) CertificateMatches(c *x509.Certificate) bool {
+	return trailingDataLen(c.Raw) > 0
+}
+
+// Returns true if the TBSCertificate of |p| was logged with trailing data.
+func (m MatchCertByTrailingGarbageAfterDER) PrecertificateMatches(p *client.Precertificate) bool {
+	return trailingDataLen(p.Raw) > 0
+}
@@ -0,0 +1,66 @@
+package scanner
+
+import (
+	"testing"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+func TestScannerMatchCertByTrailingGarbageAfterDER(t *testing.T) {
+	precert, final := makePrecertAndFinal(t, newPrecertKey(t), newPrecertTemplate())
+	garbage := []byte{0xde, 0xad}
+	source := &chainLogSource{entries: []client.RawLogEntry{
+		{LeafInput: makeX509Leaf(final.Raw)},
+		{LeafInput: makeX509Leaf(append(append([]byte{}, final.Raw...), garbage...))},
+		{LeafInput: makePrecertLeaf(precert.Raw)},
+		{LeafInput: makePrecertLeaf(append(append([]byte{}, precert.Raw...), garbage...))},
+	}}
+	s := NewScanner(source, ScannerOptions{
+		Matcher:       MatchCertByTrailingGarbageAfterDER{},
+		BlockSize:     10,
+		NumWorkers:    1,
+		ParallelFetch: 1,
+		Quiet:         true,
+	})
+	var certMatches, precertMatches []int64
+	err := s.Scan(func(index int64, c *x509.Certificate) {
+		certMatches = append(certMatches, index)
+	}, func(index int64, p *client.Precertificate) {
+		precertMatches = append(precertMatches, index)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certMatches) != 1 || certMatches[0] != 1 {
+		t.Errorf("Expected only Cert entry 1 to match, got %v", certMatches)
+	}
+	if len(precertMatches) != 1 || precertMatches[0] != 3 {
+		t.Errorf("Expected only Precert entry 3 to match, got %v", precertMatches)
+	}
+	if unparsable := s.Stats().UnparsableEntries; unparsable != 0 {
+		t.Errorf("Expected padded entries to be parsed, got %d unparsable entries", unparsable)
+	}
+}
+
+func TestParseAllowingTrailingData(t *testing.T) {
+	_, final := makePrecertAndFinal(t, newPrecertKey(t), newPrecertTemplate())
+	padded := append(append([]byte{}, final.Raw...), 0x00)
+	c, err := parseAllowingTrailingData(padded, x509.ParseCertificate)
+	nfe, ok := err.(x509.NonFatalErrors)
+	if !ok {
+		t.Fatalf("Expected NonFatalErrors, got %v", err)
+	}
+	if last := nfe.Errors[len(nfe.Errors)-1]; last != (TrailingDataError{Length: 1}) {
+		t.Errorf("Expected TrailingDataError{1}, got %v", last)
+	}
+	if string(c.Raw) != string(padded) || string(c.RawTBSCertificate) != string(final.RawTBSCertificate) {
+		t.Error("Salvaged Certificate doesn't hold the padded DER and original TBSCertificate")
+	}
+
+	if _, err := parseAllowingTrailingData([]byte{0x30, 0x00, 0x01}, x509.ParseCertificate); err == nil {
+		t.Fatal("Expected error for unparsable DER with trailing data")
+	} else if _, ok := err.(x509.NonFatalErrors); ok {
+		t.Fatalf("Expected fatal error for unparsable DER with trailing data, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package scanner
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/google/certificate-transparency/go/client"
+)
+
+// VerificationError is reported when an entry re-fetched by the verification
+// pass (see ScannerOptions.VerifySampleSize) differs from the entry which was
+// processed during the scan.
+type VerificationError struct {
+	// Index of the entry in the log
+	Index int64
+	// SHA-256 hashes of the LeafInput processed during the scan and of the
+	// LeafInput re-fetched afterwards. Refetched is nil if the log didn't
+	// return the entry at all.
+	Scanned   []byte
+	Refetched []byte
+}
+
+func (e *VerificationError) Error() string {
+	if e.Refetched == nil {
+		return fmt.Sprintf("verification failed: log didn't return entry %d on re-fetch", e.Index)
+	}
+	return fmt.Sprintf("verification failed: entry %d had leaf hash %x when scanned, but %x on re-fetch", e.Index, e.Scanned, e.Refetched)
+}
+
+// leafSample is a uniformly random sample, of fixed size, of the leaves
+// processed during a scan, maintained by reservoir sampling so that only the
+// sampled leaves' hashes are held however large the log is.
+type leafSample struct {
+	mu     sync.Mutex
+	rand   *rand.Rand
+	seen   int64
+	index  []int64
+	hashes [][sha256.Size]byte
+}
+
+func newLeafSample(size int) *leafSample {
+	return &leafSample{
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		index:  make([]int64, 0, size),
+		hashes: make([][sha256.Size]byte, 0, size),
+	}
+}
+
+// Offers |leaf|, found at |index|, for inclusion in the sample.
+func (l *leafSample) add(index int64, leaf client.LeafInput) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seen++
+	if len(l.index) < cap(l.index) {
+		l.index = append(l.index, index)
+		l.hashes = append(l.hashes, sha256.Sum256(leaf))
+		return
+	}
+	if i := l.rand.Int63n(l.seen); i < int64(len(l.index)) {
+		l.index[i] = index
+		l.hashes[i] = sha256.Sum256(leaf)
+	}
+}
+
+// Re-fetches each of the sampled entries from the log and compares it with the
+// entry processed during the scan, reporting each mismatch as a
+// *VerificationError to ScannerOptions.OnVerificationFailure.
+// Returns an error if an entry couldn't be re-fetched, or the first mismatch if
+// OnVerificationFailure is nil.
+func (s *Scanner) verifySample(ctx context.Context) error {
+	sample := s.sample
+	s.Log(fmt.Sprintf("Verifying a sample of %d entries", len(sample.index)))
+	var mismatches int
+	for i, index := range sample.index {
+		var entries []client.RawLogEntry
+		err := s.retry(ctx, "re-fetch entry", func() error {
+			var err error
+			entries, err = s.fetchEntries(ctx, index, index)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to re-fetch entry %d for verification: %s", index, err)
+		}
+		scanned := sample.hashes[i]
+		verr := &VerificationError{Index: index, Scanned: scanned[:]}
+		if len(entries) > 0 {
+			refetched := sha256.Sum256(entries[0].LeafInput)
+			if refetched == scanned {
+				continue
+			}
+			verr.Refetched = refetched[:]
+		}
+		mismatches++
+		s.Log(verr.Error())
+		if s.opts.OnVerificationFailure == nil {
+			return verr
+		}
+		s.opts.OnVerificationFailure(verr)
+	}
+	s.Log(fmt.Sprintf("Verified %d entries, %d mismatches", len(sample.index), mismatches))
+	return nil
+}
@@ -0,0 +1,109 @@
+package scanner
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"testing"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// changingLogSource is a mockLogSource which returns different bytes for each
+// entry after the first time it's fetched.
+type changingLogSource struct {
+	*mockLogSource
+	mu      sync.Mutex
+	fetched map[int64]bool
+}
+
+func (m *changingLogSource) GetEntries(start, end int64) ([]client.LeafInput, error) {
+	return m.GetEntriesCtx(context.Background(), start, end)
+}
+
+func (m *changingLogSource) GetEntriesCtx(ctx context.Context, start, end int64) ([]client.LeafInput, error) {
+	leaves, err := m.mockLogSource.GetEntriesCtx(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var changed []client.LeafInput
+	for i, leaf := range leaves {
+		index := start + int64(i)
+		if m.fetched[index] {
+			leaf = append(client.LeafInput{}, leaf...)
+			leaf[len(leaf)-1] ^= 0xff
+		}
+		m.fetched[index] = true
+		changed = append(changed, leaf)
+	}
+	return changed, nil
+}
+
+func TestScannerVerifySample(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		source     LogSource
+		wantFailed int
+	}{
+		{"consistent", newMockLogSource(t), 0},
+		{"changing", &changingLogSource{mockLogSource: newMockLogSource(t), fetched: make(map[int64]bool)}, 4},
+	} {
+		var failures []*VerificationError
+		s := NewScanner(test.source, ScannerOptions{
+			Matcher:          &MatchAll{},
+			BlockSize:        10,
+			NumWorkers:       1,
+			ParallelFetch:    1,
+			VerifySampleSize: 10,
+			OnVerificationFailure: func(err error) {
+				failures = append(failures, err.(*VerificationError))
+			},
+			Quiet: true,
+		})
+		if err := s.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {}); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(failures) != test.wantFailed {
+			t.Errorf("%s: expected %d verification failures, got %v", test.name, test.wantFailed, failures)
+		}
+		for _, f := range failures {
+			if f.Refetched == nil || string(f.Scanned) == string(f.Refetched) {
+				t.Errorf("%s: expected differing hashes, got %v", test.name, f)
+			}
+		}
+	}
+}
+
+func TestScannerVerifySampleReturnsMismatch(t *testing.T) {
+	source := &changingLogSource{mockLogSource: newMockLogSource(t), fetched: make(map[int64]bool)}
+	s := NewScanner(source, ScannerOptions{
+		Matcher:          &MatchAll{},
+		BlockSize:        10,
+		NumWorkers:       1,
+		ParallelFetch:    1,
+		VerifySampleSize: 2,
+		Quiet:            true,
+	})
+	err := s.Scan(func(int64, *x509.Certificate) {}, func(int64, *client.Precertificate) {})
+	if _, ok := err.(*VerificationError); !ok {
+		t.Fatalf("Expected a *VerificationError, got %v", err)
+	}
+}
+
+func TestLeafSampleIsBounded(t *testing.T) {
+	sample := newLeafSample(5)
+	for i := int64(0); i < 100; i++ {
+		sample.add(i, client.LeafInput{byte(i)})
+	}
+	if len(sample.index) != 5 {
+		t.Fatalf("Expected a sample of 5 entries, got %d", len(sample.index))
+	}
+	for i, index := range sample.index {
+		if want := sha256.Sum256([]byte{byte(index)}); sample.hashes[i] != want {
+			t.Errorf("Sampled hash for entry %d doesn't match its leaf", index)
+		}
+	}
+}